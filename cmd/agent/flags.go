@@ -80,6 +80,12 @@ type rawCliOptions struct {
 	HashKey        string     `json:"hash_key"`
 	RateLimit      int64      `json:"rate_limit"`
 	CryptoKey      string     `json:"crypto_key"`
+	TLSCert        string     `json:"tls_cert"`
+	TLSKey         string     `json:"tls_key"`
+	TLSCA          string     `json:"tls_ca"`
+	AuthToken      string     `json:"token"`
+	GRPCAddress    string     `json:"grpc_address"`
+	MetricsAddress string     `json:"metrics_address"`
 }
 
 type CliOptions struct {
@@ -89,6 +95,20 @@ type CliOptions struct {
 	HashKey        string        `json:"hash_key"`
 	RateLimit      int64         `json:"rate_limit"`
 	CryptoKey      string        `json:"crypto_key"`
+	TLSCert        string        `json:"tls_cert"`
+	TLSKey         string        `json:"tls_key"`
+	TLSCA          string        `json:"tls_ca"`
+	AuthToken      string        `json:"token"`
+	GRPCAddress    string        `json:"grpc_address"`
+	MetricsAddress string        `json:"metrics_address"`
+}
+
+// redact скрывает значение секрета, оставляя видимым только факт, что он задан.
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "***"
 }
 
 func (o *CliOptions) String() string {
@@ -98,13 +118,25 @@ func (o *CliOptions) String() string {
 			"pollInterval:%s, "+
 			"hashKey:%s, "+
 			"rateLimit: %d, "+
-			"CryptoKey: %s",
+			"CryptoKey: %s, "+
+			"TLSCert: %s, "+
+			"TLSKey: %s, "+
+			"TLSCA: %s, "+
+			"AuthToken: %s, "+
+			"GRPCAddress: %s, "+
+			"MetricsAddress: %s",
 		o.NetAddr.String(),
 		o.ReportInterval,
 		o.PollInterval,
-		o.HashKey,
+		redact(o.HashKey),
 		o.RateLimit,
 		o.CryptoKey,
+		o.TLSCert,
+		o.TLSKey,
+		o.TLSCA,
+		redact(o.AuthToken),
+		o.GRPCAddress,
+		o.MetricsAddress,
 	)
 }
 
@@ -144,6 +176,30 @@ func (o *CliOptions) ReadArgv(argv CliOptions, pInt int64, rInt int64) error {
 	if argv.CryptoKey != "" {
 		o.CryptoKey = argv.CryptoKey
 	}
+
+	if argv.TLSCert != "" {
+		o.TLSCert = argv.TLSCert
+	}
+
+	if argv.TLSKey != "" {
+		o.TLSKey = argv.TLSKey
+	}
+
+	if argv.TLSCA != "" {
+		o.TLSCA = argv.TLSCA
+	}
+
+	if argv.AuthToken != "" {
+		o.AuthToken = argv.AuthToken
+	}
+
+	if argv.GRPCAddress != "" {
+		o.GRPCAddress = argv.GRPCAddress
+	}
+
+	if argv.MetricsAddress != "" {
+		o.MetricsAddress = argv.MetricsAddress
+	}
 	return nil
 }
 
@@ -206,7 +262,7 @@ func (o *CliOptions) FromRaw(raw *rawCliOptions) error {
 		return err
 	}
 
-	o.SetN(raw.NetAddr, rt, pt, raw.HashKey, raw.RateLimit, raw.CryptoKey)
+	o.SetN(raw.NetAddr, rt, pt, raw.HashKey, raw.RateLimit, raw.CryptoKey, raw.TLSCert, raw.TLSKey, raw.TLSCA, raw.AuthToken, raw.GRPCAddress, raw.MetricsAddress)
 	return nil
 }
 
@@ -215,13 +271,25 @@ func (o *CliOptions) SetN(netAddress NetAddress,
 	pollInterval time.Duration,
 	hashKey string,
 	rateLimit int64,
-	cryptoKey string) {
+	cryptoKey string,
+	tlsCert string,
+	tlsKey string,
+	tlsCA string,
+	authToken string,
+	grpcAddress string,
+	metricsAddress string) {
 	o.NetAddr = netAddress
 	o.ReportInterval = reportInterval
 	o.PollInterval = pollInterval
 	o.HashKey = hashKey
 	o.RateLimit = rateLimit
 	o.CryptoKey = cryptoKey
+	o.TLSCert = tlsCert
+	o.TLSKey = tlsKey
+	o.TLSCA = tlsCA
+	o.AuthToken = authToken
+	o.GRPCAddress = grpcAddress
+	o.MetricsAddress = metricsAddress
 }
 
 func (o *CliOptions) Copy(another *CliOptions) {
@@ -231,6 +299,12 @@ func (o *CliOptions) Copy(another *CliOptions) {
 	o.HashKey = another.HashKey
 	o.RateLimit = another.RateLimit
 	o.CryptoKey = another.CryptoKey
+	o.TLSCert = another.TLSCert
+	o.TLSKey = another.TLSKey
+	o.TLSCA = another.TLSCA
+	o.AuthToken = another.AuthToken
+	o.GRPCAddress = another.GRPCAddress
+	o.MetricsAddress = another.MetricsAddress
 }
 
 func (o *CliOptions) LoadENV() error {
@@ -285,6 +359,30 @@ func (o *CliOptions) LoadENV() error {
 			o.CryptoKey = envCryptoKey
 		}
 	}
+
+	if envTLSCert := os.Getenv("TLS_CERT"); envTLSCert != "" {
+		o.TLSCert = envTLSCert
+	}
+
+	if envTLSKey := os.Getenv("TLS_KEY"); envTLSKey != "" {
+		o.TLSKey = envTLSKey
+	}
+
+	if envTLSCA := os.Getenv("TLS_CA"); envTLSCA != "" {
+		o.TLSCA = envTLSCA
+	}
+
+	if envAuthToken := os.Getenv("TOKEN"); envAuthToken != "" {
+		o.AuthToken = envAuthToken
+	}
+
+	if envGRPCAddress := os.Getenv("GRPC_ADDRESS"); envGRPCAddress != "" {
+		o.GRPCAddress = envGRPCAddress
+	}
+
+	if envMetricsAddress := os.Getenv("METRICS_ADDRESS"); envMetricsAddress != "" {
+		o.MetricsAddress = envMetricsAddress
+	}
 	return nil
 }
 
@@ -306,6 +404,12 @@ func parseFlags() error {
 	flag.StringVar(&cli.HashKey, "k", "", "key for hash")
 	flag.Int64Var(&cli.RateLimit, "l", 0, "rate limit")
 	flag.StringVar(&cli.CryptoKey, "crypto-key", "", "Path to private key file")
+	flag.StringVar(&cli.TLSCert, "tls-cert", "", "Path to agent TLS client certificate (enables mTLS with -tls-key and -tls-ca)")
+	flag.StringVar(&cli.TLSKey, "tls-key", "", "Path to agent TLS client private key")
+	flag.StringVar(&cli.TLSCA, "tls-ca", "", "Path to CA certificate used to verify the server")
+	flag.StringVar(&cli.AuthToken, "token", "", "API token sent to the server")
+	flag.StringVar(&cli.GRPCAddress, "grpc-address", "", "ip and port of server gRPC API; metrics are sent over gRPC instead of HTTP if set")
+	flag.StringVar(&cli.MetricsAddress, "metrics-address", "", "ip and port to serve agent self-metrics on /metrics; disabled if empty")
 	flag.StringVar(&configFile, "config", "", "Path to config file")
 	flag.StringVar(&configFile, "c", "", "Path to config file")
 	flag.Parse()
@@ -2,18 +2,23 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"github.com/Fuonder/metriccoll.git/internal/buildinfo"
 	"github.com/Fuonder/metriccoll.git/internal/certmanager"
 	"github.com/Fuonder/metriccoll.git/internal/logger"
 	memcollector "github.com/Fuonder/metriccoll.git/internal/metrics/MemoryCollector"
+	"github.com/Fuonder/metriccoll.git/internal/metrics/grpcsender"
+	"github.com/Fuonder/metriccoll.git/internal/selfmetrics"
 	agentcollection "github.com/Fuonder/metriccoll.git/internal/storage/agentCollection"
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
 var (
@@ -63,6 +68,26 @@ func run(CliOpt *CliOptions) error {
 		return err
 	}
 
+	if CliOpt.MetricsAddress != "" {
+		reg := selfmetrics.NewRegistry(memcollector.SelfMetricsNamespace)
+		service.SetSelfMetrics(reg)
+		srv := selfMetricsServer(CliOpt.MetricsAddress, reg)
+		g.Go(func() error {
+			logger.Log.Info("Serving agent self-metrics", zap.String("addr", srv.Addr))
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				cancel()
+				return fmt.Errorf("self-metrics server: %w", err)
+			}
+			return nil
+		})
+		g.Go(func() error {
+			<-ctx.Done()
+			shutdownCtx, stop := context.WithTimeout(context.Background(), selfMetricsShutdownTimeout)
+			defer stop()
+			return srv.Shutdown(shutdownCtx)
+		})
+	}
+
 	g.Go(func() error {
 		err := service.Collect(ctx, cancel)
 		close(jobsCh)
@@ -93,6 +118,16 @@ func run(CliOpt *CliOptions) error {
 	return nil
 }
 
+// selfMetricsShutdownTimeout — время на завершение запросов к локальному /metrics при остановке.
+const selfMetricsShutdownTimeout = 5 * time.Second
+
+// selfMetricsServer создает HTTP-сервер, отдающий показатели работы агента на /metrics.
+func selfMetricsServer(addr string, reg *selfmetrics.Registry) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", reg.Handler())
+	return &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: selfMetricsShutdownTimeout}
+}
+
 func prepareService(CliOpt *CliOptions, jobsCh chan []byte) (collector *memcollector.MemoryCollector, err error) {
 	mc, err := agentcollection.NewMetricsCollection()
 	if err != nil {
@@ -126,5 +161,48 @@ func prepareService(CliOpt *CliOptions, jobsCh chan []byte) (collector *memcolle
 		return nil, err
 	}
 
+	err = collector.SetAuthToken(CliOpt.AuthToken)
+	if err != nil {
+		logger.Log.Info("Can not set API token", zap.Error(err))
+		return nil, err
+	}
+
+	mtlsSettings := certmanager.MTLSSettings{
+		CertFile:     CliOpt.TLSCert,
+		KeyFile:      CliOpt.TLSKey,
+		ClientCAFile: CliOpt.TLSCA,
+	}
+	if err = mtlsSettings.Validate(); err != nil {
+		logger.Log.Info("Can not configure mTLS", zap.Error(err))
+		return nil, err
+	}
+	var tlsConfig *tls.Config
+	if mtlsSettings.Enabled() {
+		tlsConfig, err = certmanager.NewClientTLSConfig(mtlsSettings)
+		if err != nil {
+			logger.Log.Info("Can not configure mTLS", zap.Error(err))
+			return nil, err
+		}
+		err = collector.SetTLSConfig(tlsConfig)
+		if err != nil {
+			logger.Log.Info("Can not set TLS config", zap.Error(err))
+			return nil, err
+		}
+	}
+
+	if CliOpt.GRPCAddress != "" {
+		sender := grpcsender.NewSender(CliOpt.GRPCAddress, cipherManger)
+		_ = sender.SetHashKey(CliOpt.HashKey)
+		_ = sender.SetAuthToken(CliOpt.AuthToken)
+		_ = sender.SetTLSConfig(tlsConfig)
+		_ = sender.SetCompressionObserver(collector.ObserveCompression)
+		err = collector.SetSender(sender)
+		if err != nil {
+			logger.Log.Info("Can not set gRPC sender", zap.Error(err))
+			return nil, err
+		}
+		logger.Log.Info("Sending metrics over gRPC", zap.String("addr", CliOpt.GRPCAddress))
+	}
+
 	return collector, nil
 }
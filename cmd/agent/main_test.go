@@ -60,3 +60,13 @@ func TestMetrics_updateValues(t *testing.T) {
 		})
 	}
 }
+
+func TestCliOptionsStringRedactsSecrets(t *testing.T) {
+	o := CliOptions{HashKey: "hmac-secret", AuthToken: "bearer-secret"}
+	s := o.String()
+	assert.NotContains(t, s, "hmac-secret")
+	assert.NotContains(t, s, "bearer-secret")
+	assert.Contains(t, s, "AuthToken: ***")
+
+	assert.Contains(t, (&CliOptions{}).String(), "AuthToken: ,")
+}
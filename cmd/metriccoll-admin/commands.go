@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Fuonder/metriccoll.git/internal/models"
+)
+
+var (
+	ErrStoresDiffer      = errors.New("stores differ")
+	ErrTargetNotEmpty    = errors.New("target store already contains restored series")
+	ErrUnknownDumpFormat = errors.New("unknown dump format")
+)
+
+// Форматы файла выгрузки.
+const (
+	formatJSON  = "json"  // массив []models.Metrics, как в файловом хранилище сервера
+	formatJSONL = "jsonl" // по одной метрике в строке
+)
+
+// defaultTimeout — время выполнения команды по умолчанию.
+const defaultTimeout = 5 * time.Minute
+
+// newFlagSet создает набор флагов подкоманды name, выводящий справку в out.
+func newFlagSet(name string, out io.Writer) (*flag.FlagSet, *time.Duration) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.Usage = func() {
+		_, _ = fmt.Fprint(out, usage)
+		fs.PrintDefaults()
+	}
+	timeout := fs.Duration("timeout", defaultTimeout, "command timeout")
+	return fs, timeout
+}
+
+// runDump выполняет команду dump: выгружает все серии хранилища в файл.
+func runDump(args []string, out io.Writer) error {
+	fs, timeout := newFlagSet("dump", out)
+	from := fs.String("from", "", "source store")
+	output := fs.String("o", "-", "output file (- for stdout)")
+	format := fs.String("format", "", "dump format: json or jsonl (by default - by output file extension)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" {
+		return fmt.Errorf("source store is not set: use -from")
+	}
+	if *format == "" {
+		*format = formatJSON
+		if strings.HasSuffix(*output, jsonlSuffix) {
+			*format = formatJSONL
+		}
+	}
+	if *format != formatJSON && *format != formatJSONL {
+		return fmt.Errorf("%w: %q", ErrUnknownDumpFormat, *format)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	metrics, err := loadMetrics(ctx, *from)
+	if err != nil {
+		return err
+	}
+
+	if *output == "-" {
+		return writeDump(out, metrics, *format)
+	}
+	file, err := os.CreateTemp(filepath.Dir(*output), ".metriccoll-dump-*")
+	if err != nil {
+		return fmt.Errorf("can not create dump file: %w", err)
+	}
+	defer func() { _ = os.Remove(file.Name()) }()
+	if err := writeDump(file, metrics, *format); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("can not sync dump file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("can not close dump file: %w", err)
+	}
+	// Файл появляется под своим именем только целиком записанным.
+	if err := os.Rename(file.Name(), *output); err != nil {
+		return fmt.Errorf("can not write dump file: %w", err)
+	}
+	_, _ = fmt.Fprintf(out, "dumped %d series to %s\n", len(metrics), *output)
+	return nil
+}
+
+// writeDump записывает метрики в w в формате format.
+func writeDump(w io.Writer, metrics []models.Metrics, format string) error {
+	if metrics == nil {
+		metrics = []models.Metrics{}
+	}
+	if format == formatJSONL {
+		enc := json.NewEncoder(w)
+		for _, m := range metrics {
+			if err := enc.Encode(m); err != nil {
+				return fmt.Errorf("can not write dump: %w", err)
+			}
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(metrics, "", "    ")
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("can not write dump: %w", err)
+	}
+	return nil
+}
+
+// runRestore выполняет команду restore: загружает все серии источника в целевое хранилище.
+// Если цель уже содержит какие-либо из загружаемых серий, команда без -overwrite завершается
+// ошибкой: иначе значения counter сложились бы с прежними.
+func runRestore(args []string, out io.Writer) error {
+	fs, timeout := newFlagSet("restore", out)
+	from := fs.String("from", "", "source store or dump file")
+	to := fs.String("to", "", "target store")
+	overwrite := fs.Bool("overwrite", false, "replace series that already exist in the target store")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *to == "" {
+		return fmt.Errorf("source and target stores must be set: use -from and -to")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	metrics, err := loadMetrics(ctx, *from)
+	if err != nil {
+		return err
+	}
+	target, err := openTarget(ctx, *to)
+	if err != nil {
+		return err
+	}
+	if err := restore(ctx, target, metrics, *overwrite); err != nil {
+		_ = target.Close()
+		return err
+	}
+	if err := target.Close(); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(out, "restored %d series to %s\n", len(metrics), *to)
+	return nil
+}
+
+// restore записывает метрики в target одним пакетом. Время обновления серий назначает target.
+// С overwrite существующие серии заменяются тем же пакетом (одной транзакцией в БД),
+// поэтому при ошибке target не остается с удаленными, но не записанными сериями.
+func restore(ctx context.Context, target targetStore, metrics []models.Metrics, overwrite bool) error {
+	existing, err := target.ExportMetrics(ctx)
+	if err != nil {
+		return err
+	}
+	present := make(map[models.MetricKey]struct{}, len(existing))
+	for _, m := range existing {
+		present[m.Key()] = struct{}{}
+	}
+	var overlap []models.MetricKey
+	for _, m := range metrics {
+		if _, ok := present[m.Key()]; ok {
+			overlap = append(overlap, m.Key())
+		}
+	}
+	if len(overlap) > 0 && !overwrite {
+		return fmt.Errorf("%w: %d series (e.g. %s %s), use -overwrite to replace them",
+			ErrTargetNotEmpty, len(overlap), overlap[0].MType, overlap[0].ID)
+	}
+	if len(metrics) == 0 {
+		return nil
+	}
+	if len(overlap) > 0 {
+		return target.ReplaceMetrics(ctx, metrics)
+	}
+	return target.AppendMetrics(metrics)
+}
+
+// runVerify выполняет команду verify: сравнивает значения всех серий двух хранилищ.
+func runVerify(args []string, out io.Writer) error {
+	fs, timeout := newFlagSet("verify", out)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("verify expects two stores, got %d", fs.NArg())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	a, err := loadMetrics(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	b, err := loadMetrics(ctx, fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	diffs := compareMetrics(a, b)
+	for _, d := range diffs {
+		_, _ = fmt.Fprintln(out, d)
+	}
+	if len(diffs) > 0 {
+		return fmt.Errorf("%w: %d difference(s)", ErrStoresDiffer, len(diffs))
+	}
+	_, _ = fmt.Fprintf(out, "stores match: %d series\n", len(a))
+	return nil
+}
+
+// compareMetrics возвращает описания расхождений между наборами a и b, упорядоченные по ключу.
+// Сравниваются только значения: время обновления у разных хранилищ различается.
+func compareMetrics(a []models.Metrics, b []models.Metrics) []string {
+	index := func(metrics []models.Metrics) map[models.MetricKey]string {
+		result := make(map[models.MetricKey]string, len(metrics))
+		for _, m := range metrics {
+			result[m.Key()] = formatValue(m)
+		}
+		return result
+	}
+	left, right := index(a), index(b)
+	keys := make([]models.MetricKey, 0, len(left)+len(right))
+	for key := range left {
+		keys = append(keys, key)
+	}
+	for key := range right {
+		if _, ok := left[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].MType != keys[j].MType {
+			return keys[i].MType < keys[j].MType
+		}
+		return keys[i].ID < keys[j].ID
+	})
+
+	var diffs []string
+	for _, key := range keys {
+		l, inLeft := left[key]
+		r, inRight := right[key]
+		switch {
+		case !inRight:
+			diffs = append(diffs, fmt.Sprintf("- %s %s = %s (missing in second store)", key.MType, key.ID, l))
+		case !inLeft:
+			diffs = append(diffs, fmt.Sprintf("+ %s %s = %s (missing in first store)", key.MType, key.ID, r))
+		case l != r:
+			diffs = append(diffs, fmt.Sprintf("~ %s %s: %s != %s", key.MType, key.ID, l, r))
+		}
+	}
+	return diffs
+}
+
+// formatValue возвращает значение метрики в виде строки без потери точности.
+func formatValue(m models.Metrics) string {
+	switch {
+	case m.Value != nil:
+		return strconv.FormatFloat(*m.Value, 'g', -1, 64)
+	case m.Delta != nil:
+		return strconv.FormatInt(*m.Delta, 10)
+	}
+	return "<nil>"
+}
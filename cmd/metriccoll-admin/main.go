@@ -0,0 +1,65 @@
+// Команда metriccoll-admin обслуживает хранилища метрик: выгружает все серии в переносимый
+// файл, загружает их в другое хранилище и сравнивает содержимое двух хранилищ. Поддерживаются
+// PostgreSQL, SQLite и файловое хранилище сервера, поэтому команда подходит и для резервного
+// копирования, и для переноса данных между хранилищами.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/Fuonder/metriccoll.git/internal/logger"
+)
+
+var ErrUnknownCommand = errors.New("unknown command")
+
+// usage — справка команды.
+const usage = `usage: metriccoll-admin <command> [flags]
+
+commands:
+  dump     -from STORE [-o FILE] [-format json|jsonl]   export all series to FILE (stdout by default)
+  restore  -from STORE -to STORE [-overwrite]           copy all series from one store into another
+  verify   STORE STORE                                  compare two stores, exit status 1 if they differ
+
+STORE is one of:
+  postgres://...            PostgreSQL database
+  sqlite:///path/to/file.db SQLite database
+  path/to/file.jsonl        dump in JSON lines format (read only)
+  path/to/file              JSON file storage of the server or a dump in JSON format
+
+A JSON file storage must not be used as a restore target while the server is running on it.
+`
+
+func main() {
+	if err := logger.Initialize("warn"); err != nil {
+		log.Fatalf("metriccoll-admin: %v", err)
+	}
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		log.Fatalf("metriccoll-admin: %v", err)
+	}
+}
+
+// run выполняет команду args[0] с аргументами args[1:] и выводит результат в out.
+func run(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		_, _ = fmt.Fprint(out, usage)
+		return fmt.Errorf("%w: command is not set", ErrUnknownCommand)
+	}
+	switch args[0] {
+	case "dump":
+		return runDump(args[1:], out)
+	case "restore":
+		return runRestore(args[1:], out)
+	case "verify":
+		return runVerify(args[1:], out)
+	case "help", "-h", "-help", "--help":
+		_, _ = fmt.Fprint(out, usage)
+		return nil
+	default:
+		_, _ = fmt.Fprint(out, usage)
+		return fmt.Errorf("%w: %s", ErrUnknownCommand, args[0])
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Fuonder/metriccoll.git/internal/models"
+	"github.com/Fuonder/metriccoll.git/internal/storage"
+	"github.com/Fuonder/metriccoll.git/internal/storage/sqlite"
+	"github.com/stretchr/testify/require"
+)
+
+// newFileStore создает файловое хранилище сервера с метриками metrics.
+func newFileStore(t *testing.T, metrics []models.Metrics) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "metrics.dump")
+	st, err := storage.NewJSONStorage(storage.NewFileStoreInfo(path, 0, false))
+	require.NoError(t, err)
+	require.NoError(t, st.AppendMetrics(metrics))
+	require.NoError(t, st.Close())
+	return path
+}
+
+var testMetrics = []models.Metrics{
+	{ID: "PollCount", MType: "counter", Delta: models.Int64Ptr(42)},
+	{ID: "Alloc", MType: "gauge", Value: models.Float64Ptr(0.3)},
+	{ID: "FreeMemory", MType: "gauge", Value: models.Float64Ptr(1e-300)},
+}
+
+func TestDumpRestoreVerify(t *testing.T) {
+	source := newFileStore(t, testMetrics)
+	dir := t.TempDir()
+	dump := filepath.Join(dir, "backup.jsonl")
+	target := sqlite.DSNPrefix + filepath.Join(dir, "metrics.db")
+
+	var out bytes.Buffer
+	require.NoError(t, run([]string{"dump", "-from", source, "-o", dump}, &out))
+	require.Contains(t, out.String(), "dumped 3 series")
+	data, err := os.ReadFile(dump)
+	require.NoError(t, err)
+	require.Len(t, strings.Split(strings.TrimSpace(string(data)), "\n"), 3, "jsonl dump has one series per line")
+
+	out.Reset()
+	require.NoError(t, run([]string{"restore", "-from", dump, "-to", target}, &out))
+	require.Contains(t, out.String(), "restored 3 series")
+
+	out.Reset()
+	require.NoError(t, run([]string{"verify", source, target}, &out))
+	require.Contains(t, out.String(), "stores match: 3 series")
+
+	// Повторная загрузка сложила бы значения counter.
+	err = run([]string{"restore", "-from", dump, "-to", target}, &out)
+	require.ErrorIs(t, err, ErrTargetNotEmpty)
+	require.NoError(t, run([]string{"restore", "-from", source, "-to", target, "-overwrite"}, &out))
+	require.NoError(t, run([]string{"verify", dump, target}, &out))
+
+	// Расхождения выводятся, а команда завершается ошибкой.
+	st, err := sqlite.NewStorage(context.Background(), target)
+	require.NoError(t, err)
+	require.NoError(t, st.AppendMetrics([]models.Metrics{
+		{ID: "PollCount", MType: "counter", Delta: models.Int64Ptr(1)},
+		{ID: "Extra", MType: "gauge", Value: models.Float64Ptr(1)},
+	}))
+	require.NoError(t, st.DeleteMetric("Alloc", "gauge"))
+	require.NoError(t, st.Close())
+
+	out.Reset()
+	err = run([]string{"verify", source, target}, &out)
+	require.ErrorIs(t, err, ErrStoresDiffer)
+	require.Equal(t, "~ counter PollCount: 42 != 43\n"+
+		"- gauge Alloc = 0.3 (missing in second store)\n"+
+		"+ gauge Extra = 1 (missing in first store)\n",
+		out.String())
+}
+
+func TestDumpJSONIsFileStorage(t *testing.T) {
+	source := newFileStore(t, testMetrics)
+	dump := filepath.Join(t.TempDir(), "backup.json")
+	var out bytes.Buffer
+	require.NoError(t, run([]string{"dump", "-from", source, "-o", dump}, &out))
+
+	// Выгрузка в формате json совпадает с форматом файлового хранилища сервера.
+	st, err := storage.NewJSONStorage(storage.NewFileStoreInfo(dump, time.Hour, true))
+	require.NoError(t, err)
+	require.Len(t, st.GetAllMetrics(), 3)
+
+	out.Reset()
+	require.NoError(t, run([]string{"dump", "-from", source, "-format", "jsonl"}, &out))
+	require.Equal(t, 3, strings.Count(out.String(), "\n"))
+
+	require.ErrorIs(t, run([]string{"dump", "-from", source, "-format", "xml"}, &out), ErrUnknownDumpFormat)
+	require.ErrorIs(t, run([]string{"restore", "-from", source, "-to", filepath.Join(t.TempDir(), "x.jsonl")}, &out), ErrReadOnlyStore)
+	require.Error(t, run([]string{"dump", "-from", filepath.Join(t.TempDir(), "missing.json")}, &out))
+	require.ErrorIs(t, run([]string{"compact"}, &out), ErrUnknownCommand)
+}
+
+func TestRestoreIntoFileStorage(t *testing.T) {
+	source := newFileStore(t, testMetrics)
+	target := filepath.Join(t.TempDir(), "restored.dump")
+	var out bytes.Buffer
+	require.NoError(t, run([]string{"restore", "-from", source, "-to", target}, &out))
+
+	// После загрузки журнал свернут в снимок.
+	info, err := os.Stat(target + ".wal")
+	require.NoError(t, err)
+	require.Zero(t, info.Size())
+	metrics, err := storage.ReadJSONFile(target)
+	require.NoError(t, err)
+	require.Empty(t, compareMetrics(testMetrics, metrics))
+}
+
+// failingTarget — цель загрузки, замена серий в которой завершается ошибкой.
+type failingTarget struct {
+	*storage.JSONStorage
+}
+
+var errReplaceFailed = errors.New("replace failed")
+
+func (failingTarget) ReplaceMetrics(context.Context, []models.Metrics) error {
+	return errReplaceFailed
+}
+
+func TestRestoreOverwriteIsAtomic(t *testing.T) {
+	st, err := storage.NewJSONStorage(storage.NewFileStoreInfo(filepath.Join(t.TempDir(), "target.json"), time.Hour, false))
+	require.NoError(t, err)
+	require.NoError(t, st.AppendMetrics([]models.Metrics{
+		{ID: "PollCount", MType: "counter", Delta: models.Int64Ptr(7)},
+		{ID: "Alloc", MType: "gauge", Value: models.Float64Ptr(5)},
+	}))
+	ctx := context.Background()
+
+	// Ошибка замены не оставляет цель без существующих серий.
+	err = restore(ctx, failingTarget{st}, testMetrics, true)
+	require.ErrorIs(t, err, errReplaceFailed)
+	require.Len(t, st.GetAllMetrics(), 2)
+
+	require.NoError(t, restore(ctx, &jsonTarget{JSONStorage: st}, testMetrics, true))
+	m, err := st.GetMetricByName("PollCount", "counter")
+	require.NoError(t, err)
+	require.Equal(t, int64(42), *m.Delta)
+	require.Empty(t, compareMetrics(testMetrics, st.GetAllMetrics()))
+}
+
+func TestReadJSONLinesRejectsDuplicateSeries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte(
+		`{"id":"PollCount","type":"counter","delta":1}`+"\n"+
+			`{"id":"PollCount","type":"gauge","value":1}`+"\n\n"+
+			`{"id":"PollCount","type":"counter","delta":2}`+"\n"), 0o600))
+
+	_, err := readJSONLines(path)
+	require.ErrorIs(t, err, errDuplicateSeries)
+	require.Contains(t, err.Error(), path+":4:")
+	require.Contains(t, err.Error(), "first seen on line 1")
+}
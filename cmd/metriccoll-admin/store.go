@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Fuonder/metriccoll.git/internal/models"
+	"github.com/Fuonder/metriccoll.git/internal/storage"
+	"github.com/Fuonder/metriccoll.git/internal/storage/database"
+	"github.com/Fuonder/metriccoll.git/internal/storage/sqlite"
+)
+
+// ErrReadOnlyStore возвращается при попытке записи в файл выгрузки формата JSON lines.
+var ErrReadOnlyStore = errors.New("store is read only")
+
+// jsonlSuffix — расширение файла выгрузки в формате JSON lines.
+const jsonlSuffix = ".jsonl"
+
+// targetStore — хранилище, в которое загружаются метрики.
+type targetStore interface {
+	storage.MetricExporter
+	storage.MetricWriter
+	storage.MetricReplacer
+	Close() error
+}
+
+// isPostgresDSN сообщает, указывает ли spec на базу PostgreSQL.
+func isPostgresDSN(spec string) bool {
+	return strings.HasPrefix(spec, "postgres://") || strings.HasPrefix(spec, "postgresql://")
+}
+
+// loadMetrics читает все серии хранилища или файла выгрузки spec.
+func loadMetrics(ctx context.Context, spec string) ([]models.Metrics, error) {
+	switch {
+	case isPostgresDSN(spec):
+		conn, err := database.NewPSQLConnection(ctx, spec, database.PoolSettings{MaxConns: 1})
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = conn.Close() }()
+		return conn.GetAllMetrics(ctx)
+	case sqlite.IsDSN(spec):
+		path, err := sqlite.PathFromDSN(spec)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("can not open sqlite database: %w", err)
+		}
+		st, err := sqlite.NewStorage(ctx, spec)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = st.Close() }()
+		return st.ExportMetrics(ctx)
+	case strings.HasSuffix(spec, jsonlSuffix):
+		return readJSONLines(spec)
+	default:
+		return storage.ReadJSONFile(spec)
+	}
+}
+
+// openTarget открывает хранилище spec для записи, создавая его схему при необходимости.
+func openTarget(ctx context.Context, spec string) (targetStore, error) {
+	switch {
+	case isPostgresDSN(spec):
+		conn, err := database.NewPSQLConnection(ctx, spec, database.PoolSettings{MaxConns: 1})
+		if err != nil {
+			return nil, err
+		}
+		if err := conn.CreateTablesContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		st, err := database.NewDBStorage(ctx, conn)
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		return st, nil
+	case sqlite.IsDSN(spec):
+		return sqlite.NewStorage(ctx, spec)
+	case strings.HasSuffix(spec, jsonlSuffix):
+		return nil, fmt.Errorf("%w: %s, use dump to write JSON lines", ErrReadOnlyStore, spec)
+	default:
+		// Синхронный режим: каждая запись сразу попадает в журнал рядом с файлом.
+		st, err := storage.NewJSONStorage(storage.NewFileStoreInfo(spec, 0, true))
+		if err != nil {
+			return nil, err
+		}
+		return &jsonTarget{JSONStorage: st}, nil
+	}
+}
+
+// jsonTarget — файловое хранилище как цель загрузки: при закрытии журнал сворачивается в снимок.
+type jsonTarget struct {
+	*storage.JSONStorage
+}
+
+func (t *jsonTarget) Close() error {
+	if err := t.DumpMetrics(); err != nil {
+		_ = t.JSONStorage.Close()
+		return err
+	}
+	return t.JSONStorage.Close()
+}
+
+// errDuplicateSeries возвращается, если серия встречается в выгрузке повторно.
+var errDuplicateSeries = errors.New("duplicate metric series")
+
+// readJSONLines читает выгрузку в формате JSON lines: по одной метрике в строке.
+// Каждая серия должна встречаться один раз: при восстановлении с -overwrite пакет
+// передается в ReplaceMetrics, которая не допускает повторов.
+func readJSONLines(path string) ([]models.Metrics, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("can not open dump file: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	var metrics []models.Metrics
+	seen := make(map[models.MetricKey]int)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var m models.Metrics
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if err := m.Validate(); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if first, ok := seen[m.Key()]; ok {
+			return nil, fmt.Errorf("%s:%d: %w %s/%s, first seen on line %d", path, line, errDuplicateSeries, m.MType, m.ID, first)
+		}
+		seen[m.Key()] = line
+		metrics = append(metrics, m)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("can not read dump file: %w", err)
+	}
+	return metrics, nil
+}
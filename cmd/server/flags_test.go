@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromRawReplayWindow(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "Seconds", value: "300s", want: 300 * time.Second},
+		{name: "Minutes", value: "2m", want: 2 * time.Minute},
+		{name: "Milliseconds", value: "500ms", want: 500 * time.Millisecond},
+		{name: "Zero", value: "0s", wantErr: true},
+		{name: "Negative", value: "-5s", wantErr: true},
+		{name: "Empty", value: "", wantErr: true},
+		{name: "Malformed", value: "five", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var f Flags
+			raw := rawFlags{StoreInterval: "300s", ReplayWindow: tt.value, MaxBodySize: 1, DBMaxConns: 1, DBMaxConnIdle: "1800s", HistorySize: 1}
+			err := f.FromRaw(&raw)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, f.ReplayWindow)
+		})
+	}
+}
+
+func TestReadArgvReplayWindow(t *testing.T) {
+	f := Flags{ReplayWindow: 300 * time.Second}
+	require.NoError(t, f.ReadArgv(Flags{}, 0, 0, 0))
+	assert.Equal(t, 300*time.Second, f.ReplayWindow, "0 keeps the configured window")
+
+	require.NoError(t, f.ReadArgv(Flags{}, 0, 60, 0))
+	assert.Equal(t, time.Minute, f.ReplayWindow)
+
+	assert.Error(t, f.ReadArgv(Flags{}, 0, -1, 0))
+}
+
+func TestLoadENVReplayWindow(t *testing.T) {
+	f := Flags{ReplayWindow: 300 * time.Second}
+	t.Setenv("REPLAY_WINDOW", "0")
+	assert.Error(t, f.LoadENV())
+
+	t.Setenv("REPLAY_WINDOW", "60")
+	require.NoError(t, f.LoadENV())
+	assert.Equal(t, time.Minute, f.ReplayWindow)
+}
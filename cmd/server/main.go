@@ -4,9 +4,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/Fuonder/metriccoll.git/internal/auth"
 	"github.com/Fuonder/metriccoll.git/internal/buildinfo"
 	"github.com/Fuonder/metriccoll.git/internal/certmanager"
+	"github.com/Fuonder/metriccoll.git/internal/grpcserver"
+	"github.com/Fuonder/metriccoll.git/internal/idempotency"
+	pb "github.com/Fuonder/metriccoll.git/internal/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"log"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
@@ -15,9 +22,13 @@ import (
 	"time"
 
 	"github.com/Fuonder/metriccoll.git/internal/logger"
+	"github.com/Fuonder/metriccoll.git/internal/selfmetrics"
 	"github.com/Fuonder/metriccoll.git/internal/server"
 	"github.com/Fuonder/metriccoll.git/internal/storage"
 	"github.com/Fuonder/metriccoll.git/internal/storage/database"
+	"github.com/Fuonder/metriccoll.git/internal/storage/sqlite"
+	"github.com/Fuonder/metriccoll.git/internal/stream"
+	"github.com/Fuonder/metriccoll.git/internal/subnet"
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 )
@@ -25,10 +36,23 @@ import (
 //go:generate go run ../generator/buildinfo/genBuildInfo.go
 //go:generate go run ../generator/certificates/genCertificates.go
 
+// historyInterval — период опроса хранилища для истории значений на дашборде.
+const historyInterval = 10 * time.Second
+
 func main() {
 	bInfo := buildinfo.NewBuildInfo(buildVersion, buildCommit, buildDate, GeneratedBuildInfo)
 	fmt.Println(bInfo.String())
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := logger.Initialize("info"); err != nil {
+			panic(fmt.Errorf("method run: %v", err))
+		}
+		if err := runMigrate(os.Args[2:], os.Stdout); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		return
+	}
+
 	err := parseFlags()
 	if err != nil {
 		log.Fatalf("error while parsing flags: %v", err)
@@ -40,7 +64,7 @@ func main() {
 		zap.String("flags", FlagsOptions.String()))
 
 	logger.Log.Info("Starting metric MemoryCollector")
-	if err = run(); err != nil {
+	if err = run(bInfo); err != nil {
 		logger.Log.Fatal("", zap.Error(err))
 	}
 }
@@ -63,8 +87,56 @@ func createJSONStorage() (*storage.JSONStorage, error) {
 	return ms, nil
 }
 
-func run() error {
-	var handler *server.Handler
+// createCompositeStorage создает хранилище с основной БД primary (nil, если БД недоступна)
+// и JSON-снимком в FileStoragePath.
+func createCompositeStorage(primary storage.PrimaryStorage) (*storage.CompositeStorage, error) {
+	settings := storage.NewFileStoreInfo(FlagsOptions.FileStoragePath, FlagsOptions.StoreInterval, FlagsOptions.Restore)
+	cs, err := storage.NewCompositeStorage(primary, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	if !cs.IsSyncFileMode() {
+		go func() {
+			for {
+				time.Sleep(FlagsOptions.StoreInterval)
+				if err := cs.DumpMetrics(); err != nil {
+					logger.Log.Warn("Failed to dump metrics snapshot", zap.Error(err))
+				}
+			}
+		}()
+	}
+	return cs, nil
+}
+
+// connectDatabase подключается к БД, создает таблицы и возвращает хранилище поверх соединения.
+func connectDatabase(ctx context.Context, dsn string) (*database.DBStorage, *database.PSQLConnection, error) {
+	dbConnection, err := database.NewPSQLConnection(ctx, dsn, database.PoolSettings{
+		MaxConns:        FlagsOptions.DBMaxConns,
+		MaxConnIdleTime: FlagsOptions.DBMaxConnIdle,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := dbConnection.CreateTablesContext(ctx); err != nil {
+		_ = dbConnection.Close()
+		return nil, nil, err
+	}
+	dbStorage, err := database.NewDBStorage(ctx, dbConnection)
+	if err != nil {
+		_ = dbConnection.Close()
+		return nil, nil, err
+	}
+	return dbStorage, dbConnection, nil
+}
+
+func run(bInfo *buildinfo.BuildInfo) error {
+	var (
+		handler   *server.Handler
+		mReader   storage.MetricReader
+		mWriter   storage.MetricWriter
+		idemStore idempotency.Store = idempotency.NewMemoryStore(idempotency.DefaultTTL)
+	)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -80,42 +152,205 @@ func run() error {
 		return err
 	}
 
-	dbConnection, err := database.NewPSQLConnection(ctx, dbSettings)
-	if err != nil {
-		logger.Log.Warn("Cannot connect to db")
-		logger.Log.Info("Switching to file(json) storage")
+	shutdownCtx, shutdownStop := context.WithCancel(context.Background())
+	defer shutdownStop()
+
+	if dbSettings == "" {
+		logger.Log.Info("No database configured, using file(json) storage")
 		jsonStorage, err := createJSONStorage()
 		if err != nil {
 			return err
 		}
 		handler = server.NewHandler(jsonStorage, jsonStorage, jsonStorage, nil, cipherManager, FlagsOptions.HashKey)
-	} else {
-		logger.Log.Info("Connected to db")
-		err := dbConnection.CreateTablesContext(ctx)
+		handler.SetStorageFile(FlagsOptions.FileStoragePath)
+		mReader, mWriter = jsonStorage, jsonStorage
+		defer func(jsonStorage *storage.JSONStorage) {
+			err := jsonStorage.Close()
+			if err != nil {
+				logger.Log.Warn("Cannot close metrics wal", zap.Error(err))
+			}
+		}(jsonStorage)
+	} else if sqlite.IsDSN(dbSettings) {
+		sqliteStorage, err := sqlite.NewStorage(ctx, dbSettings)
 		if err != nil {
 			return err
 		}
-
-		dbStorage, err := database.NewDBStorage(ctx, dbConnection)
+		logger.Log.Info("Using embedded sqlite storage")
+		handler = server.NewHandler(sqliteStorage, sqliteStorage, nil, sqliteStorage, cipherManager, FlagsOptions.HashKey)
+		mReader, mWriter = sqliteStorage, sqliteStorage
+		defer func(sqliteStorage *sqlite.Storage) {
+			err := sqliteStorage.Close()
+			if err != nil {
+				logger.Log.Warn("Cannot close sqlite db", zap.Error(err))
+			}
+		}(sqliteStorage)
+	} else {
+		var primary storage.PrimaryStorage
+		dbStorage, dbConnection, err := connectDatabase(ctx, dbSettings)
+		if err != nil {
+			logger.Log.Warn("Cannot connect to db, restoring metrics from file snapshot", zap.Error(err))
+		} else {
+			logger.Log.Info("Connected to db")
+			primary = dbStorage
+		}
+		compositeStorage, err := createCompositeStorage(primary)
 		if err != nil {
 			return err
 		}
-		handler = server.NewHandler(dbStorage, dbStorage, nil, dbStorage, cipherManager, FlagsOptions.HashKey)
-		defer func(dbStorage *database.DBStorage) {
-			err := dbStorage.Close()
+		// Пока БД недоступна, ключи идемпотентности хранятся в памяти, иначе пакеты агентов
+		// с Idempotency-Key отклонялись бы вместо записи в журнал хранилища.
+		fallbackIdem := idempotency.NewFallbackStore(idempotency.NewMemoryStore(idempotency.DefaultTTL), compositeStorage.IsPrimaryActive)
+		if dbConnection != nil {
+			fallbackIdem.SetPrimary(database.NewIdempotencyStore(dbConnection, idempotency.DefaultTTL))
+		}
+		idemStore = fallbackIdem
+		handler = server.NewHandler(compositeStorage, compositeStorage, compositeStorage, compositeStorage, cipherManager, FlagsOptions.HashKey)
+		handler.SetStorageFile(FlagsOptions.FileStoragePath)
+		mReader, mWriter = compositeStorage, compositeStorage
+		defer func(compositeStorage *storage.CompositeStorage) {
+			err := compositeStorage.Close()
 			if err != nil {
 				logger.Log.Warn("Cannot close db", zap.Error(err))
 			}
-		}(dbStorage)
+		}(compositeStorage)
+		monitor := storage.NewHealthMonitor(compositeStorage, func(ctx context.Context) (storage.PrimaryStorage, error) {
+			connCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+			defer cancel()
+			dbStorage, dbConnection, err := connectDatabase(connCtx, dbSettings)
+			if err != nil {
+				return nil, err
+			}
+			fallbackIdem.SetPrimary(database.NewIdempotencyStore(dbConnection, idempotency.DefaultTTL))
+			return dbStorage, nil
+		})
+		go monitor.Run(shutdownCtx)
+	}
+
+	handler.SetBuildInfo(bInfo)
+	handler.SetReplayWindow(FlagsOptions.ReplayWindow)
+	handler.SetHashKeys(FlagsOptions.Keyring())
+	handler.SetStrictHMAC(FlagsOptions.HashStrict)
+	handler.SetMaxBodySize(FlagsOptions.MaxBodySize)
+	handler.SetIdempotencyStore(idemStore)
+	if FlagsOptions.HashStrict && len(FlagsOptions.Keyring()) == 0 {
+		logger.Log.Warn("Strict HMAC mode is enabled but no hash key is set, signatures are not checked")
+	}
+
+	stalePolicy, err := storage.ParseStalePolicy(FlagsOptions.StaleTTL, storage.StaleAction(FlagsOptions.StaleAction))
+	if err != nil {
+		return err
+	}
+	if stalePolicy != nil {
+		handler.SetStalePolicy(stalePolicy)
+		mReader = storage.NewStaleReader(mReader, stalePolicy)
+		logger.Log.Info("Stale series detection enabled",
+			zap.String("ttl", FlagsOptions.StaleTTL),
+			zap.String("action", string(stalePolicy.Action())))
+		if stalePolicy.Action() == storage.StaleRemove {
+			remover, ok := mWriter.(storage.MetricRemover)
+			if !ok {
+				return fmt.Errorf("metric storage does not support removing stale series")
+			}
+			go storage.NewStaleSweeper(mReader, remover, stalePolicy).Run(shutdownCtx)
+		}
+	}
+
+	if FlagsOptions.HistorySize > 0 {
+		history := storage.NewHistory(FlagsOptions.HistorySize)
+		handler.SetHistory(history)
+		go history.Run(shutdownCtx, mReader, historyInterval)
+		logger.Log.Info("Metric history enabled",
+			zap.Int("points", FlagsOptions.HistorySize),
+			zap.Duration("interval", historyInterval))
+	}
+
+	selfMetrics := selfmetrics.NewRegistry(server.SelfMetricsNamespace)
+	handler.SetSelfMetrics(selfMetrics)
+	if observable, ok := mWriter.(storage.OpObservable); ok {
+		observable.SetOpObserver(storage.InstrumentOps(selfMetrics))
+	}
+
+	broker := stream.NewBroker(stream.DefaultBufferSize)
+	handler.SetBroker(broker)
+
+	trusted, err := subnet.ParseTrusted(FlagsOptions.TrustedSubnet)
+	if err != nil {
+		return err
+	}
+	handler.SetTrustedSubnets(trusted)
+	proxies, err := subnet.ParseTrusted(FlagsOptions.TrustedProxies)
+	if err != nil {
+		return err
+	}
+	handler.SetTrustedProxies(proxies)
+	if trusted.Enabled() {
+		logger.Log.Info("Trusted subnet filtering enabled",
+			zap.String("subnets", trusted.String()),
+			zap.String("proxies", proxies.String()))
+	}
+
+	var authorizer *auth.Authorizer
+	if FlagsOptions.AuthConfig != "" {
+		authorizer, err = auth.LoadAuthorizer(FlagsOptions.AuthConfig)
+		if err != nil {
+			return err
+		}
+		handler.SetAuthorizer(authorizer)
+		logger.Log.Info("API token authorization enabled")
 	}
 
+	router := metricRouter(handler)
+	router.Get("/metrics", logger.HanlderWithLogger(selfMetrics.Handler()))
 	srv := &http.Server{
 		Addr:    FlagsOptions.NetAddress.String(),
-		Handler: metricRouter(handler),
+		Handler: router,
 	}
+	// Подписчики /stream отключаются в начале остановки, иначе Shutdown ждал бы их до таймаута.
+	srv.RegisterOnShutdown(broker.Close)
 
-	shutdownCtx, shutdownStop := context.WithCancel(context.Background())
-	defer shutdownStop()
+	mtlsSettings := FlagsOptions.MTLSSettings()
+	if mtlsSettings.Enabled() {
+		srv.TLSConfig, err = certmanager.NewServerTLSConfig(mtlsSettings)
+		if err != nil {
+			return fmt.Errorf("can not configure mTLS: %w", err)
+		}
+		logger.Log.Info("mTLS agent authentication enabled")
+	}
+
+	var grpcSrv *grpc.Server
+	if FlagsOptions.GRPCAddress != "" {
+		interceptors := grpcserver.NewInterceptors(grpcserver.Settings{
+			HashKeys:     FlagsOptions.Keyring(),
+			StrictHMAC:   FlagsOptions.HashStrict,
+			ReplayWindow: FlagsOptions.ReplayWindow,
+			Decipher:     cipherManager,
+			Authorizer:   authorizer,
+			Trusted:      trusted,
+			Proxies:      proxies,
+			Idempotency:  idemStore,
+			MaxBodySize:  FlagsOptions.MaxBodySize,
+		})
+		opts := interceptors.ServerOptions()
+		if FlagsOptions.MaxBodySize > 0 {
+			opts = append(opts, grpc.MaxRecvMsgSize(int(FlagsOptions.MaxBodySize)))
+		}
+		if srv.TLSConfig != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(srv.TLSConfig)))
+		}
+		grpcSrv = grpc.NewServer(opts...)
+		pb.RegisterMetricsServer(grpcSrv, grpcserver.NewMetricsServer(mReader, handler))
+
+		listener, err := net.Listen("tcp", FlagsOptions.GRPCAddress)
+		if err != nil {
+			return fmt.Errorf("gRPC server listen: %w", err)
+		}
+		go func() {
+			logger.Log.Info("Starting gRPC server", zap.String("addr", FlagsOptions.GRPCAddress))
+			if err := grpcSrv.Serve(listener); err != nil {
+				logger.Log.Error("gRPC server Serve failed", zap.Error(err))
+			}
+		}()
+	}
 
 	go func() {
 		sigCh := make(chan os.Signal, 1)
@@ -130,6 +365,9 @@ func run() error {
 		if err := srv.Shutdown(ctxTimeout); err != nil {
 			logger.Log.Error("HTTP server Shutdown failed", zap.Error(err))
 		}
+		if grpcSrv != nil {
+			grpcSrv.GracefulStop()
+		}
 
 		// Сохраняем данные, если нужно
 		if handler != nil && handler.HasFileHandler() {
@@ -143,7 +381,12 @@ func run() error {
 	}()
 
 	logger.Log.Info("Starting HTTP server", zap.String("addr", srv.Addr))
-	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+	if srv.TLSConfig != nil {
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		return fmt.Errorf("HTTP server ListenAndServe: %w", err)
 	}
 
@@ -156,8 +399,12 @@ func metricRouter(h *server.Handler) chi.Router {
 	logger.Log.Debug("Entering router")
 	router := chi.NewRouter()
 
+	router.Use(h.SelfMetricsMiddleware)
 	router.Use(h.CheckMethod)
+	router.Use(h.BodyLimitMiddleware)
 	router.Use(h.CheckContentType)
+	router.Use(h.AgentIdentityMiddleware)
+	router.Use(h.TokenAuthMiddleware)
 	router.Use(h.HashMiddleware)
 	router.Use(h.DecryptionMiddleware)
 
@@ -166,13 +413,30 @@ func metricRouter(h *server.Handler) chi.Router {
 	}))
 
 	router.Get("/", logger.HanlderWithLogger(h.WithHashing(server.GzipMiddleware(h.RootHandler))))
+	router.Get("/static/*", logger.HanlderWithLogger(server.StaticHandler().ServeHTTP))
+	router.Route("/metric/{mType}/{mName}", func(router chi.Router) {
+		router.Use(h.CheckMetricType)
+		router.Use(h.CheckMetricName)
+		router.Get("/", logger.HanlderWithLogger(h.WithHashing(server.GzipMiddleware(h.MetricPageHandler))))
+	})
+	router.Route("/api/v1/metrics", func(router chi.Router) {
+		router.Get("/", logger.HanlderWithLogger(h.WithHashing(server.GzipMiddleware(h.ListMetricsHandler))))
+	})
+	// Поток событий не сжимается и не подписывается: обе обертки буферизуют ответ.
+	router.Get("/stream", logger.HanlderWithLogger(h.StreamHandler))
+	// Пробы оркестратора не сжимаются и не подписываются.
+	router.Get("/healthz", h.HealthzHandler)
+	router.Get("/readyz", logger.HanlderWithLogger(h.ReadyzHandler))
+	router.Get("/version", h.VersionHandler)
 	router.Route("/ping", func(router chi.Router) {
 		router.Get("/", logger.HanlderWithLogger(h.WithHashing(server.GzipMiddleware(h.DBPingHandler))))
 	})
 	router.Route("/updates", func(router chi.Router) {
-		router.Post("/", logger.HanlderWithLogger(h.WithHashing(server.GzipMiddleware(h.MultipleUpdateHandler))))
+		router.Use(h.TrustedSubnetMiddleware)
+		router.Post("/", logger.HanlderWithLogger(h.WithHashing(server.GzipMiddleware(h.WithIdempotency(h.MultipleUpdateHandler)))))
 	})
 	router.Route("/update", func(router chi.Router) {
+		router.Use(h.TrustedSubnetMiddleware)
 		router.Post("/", logger.HanlderWithLogger(h.WithHashing(server.GzipMiddleware(h.JSONUpdateHandler))))
 		router.Route("/{mType}", func(router chi.Router) {
 			router.Use(h.CheckMetricType)
@@ -192,13 +456,22 @@ func metricRouter(h *server.Handler) chi.Router {
 	router.Route("/value", func(router chi.Router) {
 		router.Post("/", logger.HanlderWithLogger(h.WithHashing(server.GzipMiddleware(h.JSONGetHandler))))
 		// router.Post("/", -> JSON VALUE GET HANDLER)
+		router.With(h.TrustedSubnetMiddleware).Delete("/", logger.HanlderWithLogger(h.WithHashing(server.GzipMiddleware(h.BulkDeleteHandler))))
 		router.Route("/{mType}", func(router chi.Router) {
 			router.Use(h.CheckMetricType)
 			router.Route("/{mName}", func(router chi.Router) {
 				router.Use(h.CheckMetricName)
 				router.Get("/", logger.HanlderWithLogger(h.WithHashing(server.GzipMiddleware(h.ValueHandler))))
+				router.With(h.TrustedSubnetMiddleware).Delete("/", logger.HanlderWithLogger(h.WithHashing(server.GzipMiddleware(h.DeleteHandler))))
 			})
 		})
 	})
+	router.Route("/reset", func(router chi.Router) {
+		router.Use(h.TrustedSubnetMiddleware)
+		router.Route("/counter/{mName}", func(router chi.Router) {
+			router.Use(h.CheckMetricName)
+			router.Post("/", logger.HanlderWithLogger(h.WithHashing(server.GzipMiddleware(h.ResetHandler))))
+		})
+	})
 	return router
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"github.com/Fuonder/metriccoll.git/internal/certmanager"
 	"io"
@@ -126,6 +127,7 @@ func TestMetricRouter(t *testing.T) {
 		contentType string
 		want        int
 		wantResp    string
+		contains    []string
 	}{
 		{
 			name:        "PositiveGetValue",
@@ -141,7 +143,55 @@ func TestMetricRouter(t *testing.T) {
 			method:      http.MethodGet,
 			contentType: "text/plain",
 			want:        http.StatusOK,
-			wantResp:    "gMetric 1.01, cMetric 2",
+			contains:    []string{`<a href="/metric/gauge/gMetric">gMetric</a>`, `data-sort="1.01">1.01</td>`, `<a href="/metric/counter/cMetric">cMetric</a>`},
+		},
+		{
+			name:        "PositiveMetricPage",
+			url:         "/metric/counter/cMetric",
+			method:      http.MethodGet,
+			contentType: "text/plain",
+			want:        http.StatusOK,
+			contains:    []string{"<h1>cMetric <small>counter</small>", `<dd class="num">2</dd>`},
+		},
+		{
+			name:        "NegativeMetricPage",
+			url:         "/metric/gauge/negative",
+			method:      http.MethodGet,
+			contentType: "text/plain",
+			want:        http.StatusNotFound,
+			wantResp:    "metric not found\n",
+		},
+		{
+			name:        "PositiveStaticAsset",
+			url:         "/static/dashboard.js",
+			method:      http.MethodGet,
+			contentType: "text/plain",
+			want:        http.StatusOK,
+			contains:    []string{"sortTable"},
+		},
+		{
+			name:        "LivenessProbe",
+			url:         "/healthz",
+			method:      http.MethodGet,
+			contentType: "text/plain",
+			want:        http.StatusOK,
+			wantResp:    "{\"status\":\"ok\"}\n",
+		},
+		{
+			name:        "ReadinessProbe",
+			url:         "/readyz",
+			method:      http.MethodGet,
+			contentType: "text/plain",
+			want:        http.StatusOK,
+			wantResp:    "{\"status\":\"ready\"}\n",
+		},
+		{
+			name:        "VersionWithoutBuildInfo",
+			url:         "/version",
+			method:      http.MethodGet,
+			contentType: "text/plain",
+			want:        http.StatusNotImplemented,
+			contains:    []string{"build info is not available"},
 		},
 		{name: "NegativeValue",
 			url:         "/value/gauge/negative",
@@ -192,7 +242,12 @@ func TestMetricRouter(t *testing.T) {
 			//}(resp.Body)
 			defer resp.Body.Close()
 			require.Equal(t, test.want, resp.StatusCode)
-			require.Equal(t, test.wantResp, body)
+			if len(test.contains) == 0 {
+				require.Equal(t, test.wantResp, body)
+			}
+			for _, want := range test.contains {
+				require.Contains(t, body, want)
+			}
 		})
 	}
 }
@@ -325,8 +380,21 @@ func TestJSONHandling(t *testing.T) {
 			defer resp.Body.Close()
 			require.Equal(t, test.want.statusCode, resp.StatusCode)
 			if !test.want.err {
-				require.JSONEq(t, test.want.wantResp, stringResp)
+				require.JSONEq(t, test.want.wantResp, withoutUpdatedAt(t, stringResp))
 			}
 		})
 	}
 }
+
+// withoutUpdatedAt проверяет, что в ответе указано время обновления метрики, и убирает его
+// из ответа, чтобы сравнивать только значения.
+func withoutUpdatedAt(t *testing.T, resp string) string {
+	t.Helper()
+	var m map[string]any
+	require.NoError(t, json.Unmarshal([]byte(resp), &m))
+	require.Contains(t, m, "updated_at")
+	delete(m, "updated_at")
+	data, err := json.Marshal(m)
+	require.NoError(t, err)
+	return string(data)
+}
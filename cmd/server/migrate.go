@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Fuonder/metriccoll.git/internal/logger"
+	"github.com/Fuonder/metriccoll.git/internal/storage/database"
+	"github.com/Fuonder/metriccoll.git/internal/storage/sqlite"
+	"go.uber.org/zap"
+)
+
+var ErrUnknownMigrateCommand = errors.New("unknown migrate command")
+
+// migrateUsage — справка подкоманды migrate.
+const migrateUsage = `usage: server migrate [-d DSN] [up | down [N] | status]
+
+  up        apply all pending migrations (default)
+  down [N]  revert N last applied migrations (default 1)
+  status    list migrations and their state
+`
+
+// runMigrate выполняет подкоманду migrate. DSN берется из флага -d или переменной DATABASE_DSN.
+func runMigrate(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.Usage = func() { _, _ = fmt.Fprint(out, migrateUsage) }
+	dsn := fs.String("d", os.Getenv("DATABASE_DSN"), "Database DSN")
+	timeout := fs.Duration("timeout", time.Minute, "migration timeout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dsn == "" {
+		return fmt.Errorf("database DSN is not set: use -d or DATABASE_DSN")
+	}
+	if sqlite.IsDSN(*dsn) {
+		return fmt.Errorf("migrations apply to PostgreSQL only, sqlite storage creates its schema on startup")
+	}
+
+	command := "up"
+	if fs.NArg() > 0 {
+		command = fs.Arg(0)
+	}
+	steps := 1
+	if command == "down" && fs.NArg() > 1 {
+		n, err := strconv.Atoi(fs.Arg(1))
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid number of steps: %q", fs.Arg(1))
+		}
+		steps = n
+	}
+	if command != "up" && command != "down" && command != "status" {
+		fs.Usage()
+		return fmt.Errorf("%w: %s", ErrUnknownMigrateCommand, command)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	conn, err := database.NewPSQLConnection(ctx, *dsn, database.PoolSettings{MaxConns: 1})
+	if err != nil {
+		return err
+	}
+	defer func(conn *database.PSQLConnection) {
+		err := conn.Close()
+		if err != nil {
+			logger.Log.Warn("Cannot close db", zap.Error(err))
+		}
+	}(conn)
+	migrator, err := conn.Migrator()
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "up":
+		n, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(out, "applied %d migration(s)\n", n)
+	case "down":
+		n, err := migrator.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(out, "reverted %d migration(s)\n", n)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, st := range statuses {
+			applied := "pending"
+			if st.AppliedAt != nil {
+				applied = st.AppliedAt.Format(time.RFC3339)
+			}
+			_, _ = fmt.Fprintf(out, "%04d %-40s %s\n", st.Version, st.Name, applied)
+		}
+	}
+	return nil
+}
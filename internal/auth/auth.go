@@ -0,0 +1,196 @@
+// Package auth реализует авторизацию агентов и клиентов сервера по API-токенам.
+// Токены описываются в конфигурационном файле вместе с набором прав (scopes):
+//
+//   - "read" — чтение метрик (/value/, /);
+//   - "write:<prefix>" — запись метрик, имя которых начинается с <prefix> ("write:" — любых);
+//   - "admin" — полный доступ, включая удаление метрик, сброс счетчиков, отладочные и административные endpoint'ы.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	ErrNoToken      = errors.New("no auth token")
+	ErrUnknownToken = errors.New("unknown auth token")
+	ErrForbidden    = errors.New("token scope does not allow this operation")
+	ErrInvalidScope = errors.New("invalid token scope")
+)
+
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeAdmin = "admin"
+)
+
+// TokenConfig описывает один токен в конфигурационном файле.
+type TokenConfig struct {
+	ID     string   `json:"id"`     // Идентификатор токена, используется в логах.
+	Token  string   `json:"token"`  // Секретное значение, передаваемое в заголовке Authorization.
+	Scopes []string `json:"scopes"` // Список прав токена.
+}
+
+// Config описывает содержимое конфигурационного файла с токенами.
+type Config struct {
+	// RequireRead включает обязательную проверку права "read" для чтения метрик.
+	// Если выключено, чтение доступно без токена.
+	RequireRead bool          `json:"require_read"`
+	Tokens      []TokenConfig `json:"tokens"`
+}
+
+// Principal описывает права предъявленного токена.
+type Principal struct {
+	ID            string
+	read          bool
+	admin         bool
+	writePrefixes []string
+}
+
+// CanRead сообщает, разрешено ли чтение метрик. Право на запись не дает права на чтение:
+// токен агента с "write:<prefix>" не должен открывать доступ к метрикам других источников.
+func (p *Principal) CanRead() bool {
+	return p.read || p.admin
+}
+
+// CanWrite сообщает, разрешена ли запись метрики с именем name.
+func (p *Principal) CanWrite(name string) bool {
+	if p.admin {
+		return true
+	}
+	for _, prefix := range p.writePrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanWriteAny сообщает, есть ли у токена хоть какое-то право на запись.
+func (p *Principal) CanWriteAny() bool {
+	return p.admin || len(p.writePrefixes) > 0
+}
+
+// IsAdmin сообщает, есть ли у токена административные права.
+func (p *Principal) IsAdmin() bool {
+	return p.admin
+}
+
+// Authorizer хранит загруженные токены и проверяет предъявленные значения.
+type Authorizer struct {
+	requireRead bool
+	tokens      map[string]*Principal // ключ — SHA-256 от значения токена
+}
+
+func tokenDigest(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newPrincipal(tc TokenConfig) (*Principal, error) {
+	p := &Principal{ID: tc.ID}
+	for _, scope := range tc.Scopes {
+		switch {
+		case scope == ScopeRead:
+			p.read = true
+		case scope == ScopeAdmin:
+			p.admin = true
+		case scope == ScopeWrite:
+			p.writePrefixes = append(p.writePrefixes, "")
+		case strings.HasPrefix(scope, ScopeWrite+":"):
+			p.writePrefixes = append(p.writePrefixes, strings.TrimPrefix(scope, ScopeWrite+":"))
+		default:
+			return nil, fmt.Errorf("%w: %q for token %q", ErrInvalidScope, scope, tc.ID)
+		}
+	}
+	return p, nil
+}
+
+// NewAuthorizer создает Authorizer по конфигурации.
+func NewAuthorizer(cfg Config) (*Authorizer, error) {
+	a := &Authorizer{requireRead: cfg.RequireRead, tokens: make(map[string]*Principal, len(cfg.Tokens))}
+	for _, tc := range cfg.Tokens {
+		if tc.ID == "" || tc.Token == "" {
+			return nil, fmt.Errorf("token id and value must not be empty")
+		}
+		p, err := newPrincipal(tc)
+		if err != nil {
+			return nil, err
+		}
+		digest := tokenDigest(tc.Token)
+		if _, exists := a.tokens[digest]; exists {
+			return nil, fmt.Errorf("duplicate token value for id %q", tc.ID)
+		}
+		a.tokens[digest] = p
+	}
+	return a, nil
+}
+
+// LoadAuthorizer читает конфигурацию токенов из JSON-файла.
+func LoadAuthorizer(path string) (*Authorizer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can not read auth config: %w", err)
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("can not parse auth config: %w", err)
+	}
+	return NewAuthorizer(cfg)
+}
+
+// RequireRead сообщает, требуется ли токен для чтения метрик.
+func (a *Authorizer) RequireRead() bool {
+	return a.requireRead
+}
+
+// Authenticate возвращает права токена или ошибку, если токен пуст или неизвестен.
+func (a *Authorizer) Authenticate(token string) (*Principal, error) {
+	if token == "" {
+		return nil, ErrNoToken
+	}
+	p, ok := a.tokens[tokenDigest(token)]
+	if !ok {
+		return nil, ErrUnknownToken
+	}
+	return p, nil
+}
+
+// principalKey — ключ контекста, под которым хранятся права токена запроса.
+type principalKey struct{}
+
+// NewContext возвращает копию контекста с правами токена p.
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext возвращает права токена, сохраненные в контексте через NewContext.
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
+
+// Authorize проверяет, что токен p имеет право записи каждой из метрик names.
+func (p *Principal) Authorize(names ...string) error {
+	for _, name := range names {
+		if !p.CanWrite(name) {
+			return fmt.Errorf("%w: metric %q", ErrForbidden, name)
+		}
+	}
+	return nil
+}
+
+// TokenFromHeader извлекает токен из значения заголовка Authorization вида "Bearer <token>".
+func TokenFromHeader(header string) string {
+	const prefix = "Bearer "
+	if len(header) > len(prefix) && strings.EqualFold(header[:len(prefix)], prefix) {
+		return strings.TrimSpace(header[len(prefix):])
+	}
+	return ""
+}
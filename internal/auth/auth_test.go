@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthorizer(t *testing.T) {
+	a, err := NewAuthorizer(Config{
+		RequireRead: true,
+		Tokens: []TokenConfig{
+			{ID: "agent-cpu", Token: "t-cpu", Scopes: []string{"write:CPU"}},
+			{ID: "dashboard", Token: "t-read", Scopes: []string{"read"}},
+			{ID: "ops", Token: "t-admin", Scopes: []string{"admin"}},
+		},
+	})
+	require.NoError(t, err)
+	require.True(t, a.RequireRead())
+
+	_, err = a.Authenticate("")
+	require.ErrorIs(t, err, ErrNoToken)
+	_, err = a.Authenticate("t-unknown")
+	require.ErrorIs(t, err, ErrUnknownToken)
+
+	cpu, err := a.Authenticate("t-cpu")
+	require.NoError(t, err)
+	require.Equal(t, "agent-cpu", cpu.ID)
+	require.True(t, cpu.CanWrite("CPUutilization1"))
+	require.False(t, cpu.CanWrite("FreeMemory"))
+	require.False(t, cpu.CanRead(), "write scope must not grant read")
+	require.False(t, cpu.IsAdmin())
+
+	reader, err := a.Authenticate("t-read")
+	require.NoError(t, err)
+	require.True(t, reader.CanRead())
+	require.False(t, reader.CanWriteAny())
+
+	admin, err := a.Authenticate("t-admin")
+	require.NoError(t, err)
+	require.True(t, admin.CanWrite("anything"))
+	require.True(t, admin.IsAdmin())
+}
+
+func TestNewAuthorizerErrors(t *testing.T) {
+	_, err := NewAuthorizer(Config{Tokens: []TokenConfig{{ID: "x", Token: "t", Scopes: []string{"delete"}}}})
+	require.ErrorIs(t, err, ErrInvalidScope)
+
+	_, err = NewAuthorizer(Config{Tokens: []TokenConfig{
+		{ID: "a", Token: "same", Scopes: []string{"read"}},
+		{ID: "b", Token: "same", Scopes: []string{"read"}},
+	}})
+	require.Error(t, err)
+}
+
+func TestLoadAuthorizer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"tokens":[{"id":"w","token":"secret","scopes":["write:"]}]}`), 0600))
+	a, err := LoadAuthorizer(path)
+	require.NoError(t, err)
+	p, err := a.Authenticate("secret")
+	require.NoError(t, err)
+	require.True(t, p.CanWrite("Any"))
+}
+
+func TestTokenFromHeader(t *testing.T) {
+	require.Equal(t, "abc", TokenFromHeader("Bearer abc"))
+	require.Equal(t, "abc", TokenFromHeader("bearer abc"))
+	require.Equal(t, "", TokenFromHeader("Basic abc"))
+	require.Equal(t, "", TokenFromHeader(""))
+}
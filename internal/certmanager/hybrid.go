@@ -0,0 +1,70 @@
+package certmanager
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// sessionKeySize — размер сеансового ключа AES-256.
+const sessionKeySize = 32
+
+var ErrInvalidSessionKey = errors.New("invalid session key")
+
+// SealHybrid шифрует данные произвольного размера: plaintext шифруется AES-256-GCM
+// на случайном сеансовом ключе, а сам ключ — методом Cipher (открытым ключом RSA),
+// которому по силам только один блок. Если сертификат шифрования не загружен,
+// возвращаются пустые значения без ошибки, и данные передаются в открытом виде.
+func SealHybrid(c TLSCipher, plaintext []byte) (encryptedKey, nonce, ciphertext []byte, err error) {
+	key := make([]byte, sessionKeySize)
+	if _, err = rand.Read(key); err != nil {
+		return nil, nil, nil, fmt.Errorf("can not generate session key: %w", err)
+	}
+	encryptedKey, err = c.Cipher(key)
+	if err != nil || len(encryptedKey) == 0 {
+		return nil, nil, nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	nonce = make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, nil, nil, fmt.Errorf("can not generate nonce: %w", err)
+	}
+	return encryptedKey, nonce, aead.Seal(nil, nonce, plaintext, nil), nil
+}
+
+// OpenHybrid расшифровывает данные, зашифрованные SealHybrid.
+func OpenHybrid(d TLSDecipher, encryptedKey, nonce, ciphertext []byte) ([]byte, error) {
+	key, err := d.Decrypt(encryptedKey)
+	if err != nil {
+		return nil, err
+	}
+	// Decrypt при ошибке RSA возвращает исходный блок, поэтому длину ключа проверяем отдельно.
+	if len(key) != sessionKeySize {
+		return nil, ErrInvalidSessionKey
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce size %d", len(nonce))
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("can not decrypt payload: %w", err)
+	}
+	return plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSessionKey, err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,41 @@
+package certmanager
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHybridRoundTrip(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	m := &CertManager{cert: &key.PublicKey, key: key}
+
+	// Пакет намного больше одного блока RSA-2048.
+	plaintext := bytes.Repeat([]byte("metric"), 64<<10)
+	encryptedKey, nonce, ciphertext, err := SealHybrid(m, plaintext)
+	require.NoError(t, err)
+	require.NotEmpty(t, encryptedKey)
+
+	got, err := OpenHybrid(m, encryptedKey, nonce, ciphertext)
+	require.NoError(t, err)
+	require.Equal(t, plaintext, got)
+
+	ciphertext[0] ^= 0xff
+	_, err = OpenHybrid(m, encryptedKey, nonce, ciphertext)
+	require.Error(t, err, "tampered payload")
+
+	_, err = OpenHybrid(m, []byte("not a wrapped key"), nonce, ciphertext)
+	require.ErrorIs(t, err, ErrInvalidSessionKey)
+}
+
+func TestSealHybridWithoutCertificate(t *testing.T) {
+	encryptedKey, nonce, ciphertext, err := SealHybrid(&CertManager{}, []byte("metric"))
+	require.NoError(t, err)
+	require.Empty(t, encryptedKey)
+	require.Empty(t, nonce)
+	require.Empty(t, ciphertext)
+}
@@ -0,0 +1,252 @@
+package certmanager
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Fuonder/metriccoll.git/internal/logger"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrNoClientCertificate = errors.New("no client certificate presented")
+	ErrCertificateRevoked  = errors.New("client certificate is revoked")
+	ErrNoIdentity          = errors.New("client certificate has no CN or SAN")
+	ErrIncompleteMTLS      = errors.New("mtls: certificate, key and CA must be set together")
+	ErrCRLSignature        = errors.New("crl is not signed by the client CA")
+)
+
+// MTLSSettings описывает набор файлов, необходимых для взаимной TLS-аутентификации.
+type MTLSSettings struct {
+	CertFile     string // сертификат сервера (или агента) в формате PEM
+	KeyFile      string // закрытый ключ к CertFile в формате PEM
+	ClientCAFile string // CA, которым подписаны сертификаты второй стороны
+	CRLFile      string // список отозванных сертификатов (PEM или DER), необязателен
+}
+
+// Enabled сообщает, заданы ли все файлы, необходимые для работы в режиме mTLS.
+func (s MTLSSettings) Enabled() bool {
+	return s.CertFile != "" && s.KeyFile != "" && s.ClientCAFile != ""
+}
+
+// Validate проверяет, что настройки либо пусты, либо полны. Частично заданный набор
+// (например, только сертификат) — ошибка конфигурации: иначе сервер молча запустился
+// бы без TLS.
+func (s MTLSSettings) Validate() error {
+	if s.Enabled() || s == (MTLSSettings{}) {
+		return nil
+	}
+	return ErrIncompleteMTLS
+}
+
+// revocationList хранит серийные номера отозванных сертификатов из локального CRL-файла
+// и перечитывает файл, если он изменился с момента последней загрузки.
+type revocationList struct {
+	path    string
+	issuers []*x509.Certificate // CA, подписью одного из которых должен быть заверен CRL
+	modTime time.Time
+	serials map[string]struct{}
+	mu      sync.RWMutex
+}
+
+func newRevocationList(path string, issuers []*x509.Certificate) (*revocationList, error) {
+	rl := &revocationList{path: path, issuers: issuers, serials: make(map[string]struct{})}
+	if err := rl.reload(); err != nil {
+		return nil, err
+	}
+	return rl, nil
+}
+
+func (rl *revocationList) reload() error {
+	info, err := os.Stat(rl.path)
+	if err != nil {
+		return fmt.Errorf("can not stat crl file %q: %w", rl.path, err)
+	}
+
+	rl.mu.RLock()
+	unchanged := info.ModTime().Equal(rl.modTime)
+	rl.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	data, err := os.ReadFile(rl.path)
+	if err != nil {
+		return fmt.Errorf("can not read crl file %q: %w", rl.path, err)
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	crl, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return fmt.Errorf("can not parse crl file %q: %w", rl.path, err)
+	}
+	if !rl.signedByIssuer(crl) {
+		return fmt.Errorf("%w: %q", ErrCRLSignature, rl.path)
+	}
+
+	serials := make(map[string]struct{}, len(crl.RevokedCertificateEntries))
+	for _, entry := range crl.RevokedCertificateEntries {
+		serials[entry.SerialNumber.String()] = struct{}{}
+	}
+
+	rl.mu.Lock()
+	rl.serials = serials
+	rl.modTime = info.ModTime()
+	rl.mu.Unlock()
+	logger.Log.Info("Loaded CRL", zap.String("file", rl.path), zap.Int("revoked", len(serials)))
+	return nil
+}
+
+func (rl *revocationList) signedByIssuer(crl *x509.RevocationList) bool {
+	for _, issuer := range rl.issuers {
+		if crl.CheckSignatureFrom(issuer) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (rl *revocationList) isRevoked(serial *big.Int) bool {
+	if err := rl.reload(); err != nil {
+		logger.Log.Warn("can not reload crl, using previous version", zap.Error(err))
+	}
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+	_, ok := rl.serials[serial.String()]
+	return ok
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	certs, err := loadCACertificates(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	for _, cert := range certs {
+		pool.AddCert(cert)
+	}
+	return pool, nil
+}
+
+// loadCACertificates читает все сертификаты из PEM-файла CA.
+func loadCACertificates(caFile string) ([]*x509.Certificate, error) {
+	caPEM, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("can not read CA file %q: %w", caFile, err)
+	}
+	var certs []*x509.Certificate
+	for block, rest := pem.Decode(caPEM); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("can not parse CA file %q: %w", caFile, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found in CA file %q", caFile)
+	}
+	return certs, nil
+}
+
+// NewServerTLSConfig создает конфигурацию TLS для сервера, требующую от агентов
+// клиентский сертификат, подписанный settings.ClientCAFile. Если задан settings.CRLFile,
+// сертификаты с отозванными серийными номерами отклоняются при рукопожатии. CRL
+// принимается, только если он подписан одним из сертификатов settings.ClientCAFile.
+func NewServerTLSConfig(settings MTLSSettings) (*tls.Config, error) {
+	if !settings.Enabled() {
+		return nil, ErrIncompleteMTLS
+	}
+	cert, err := tls.LoadX509KeyPair(settings.CertFile, settings.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("can not load server key pair: %w", err)
+	}
+	caCerts, err := loadCACertificates(settings.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	for _, caCert := range caCerts {
+		pool.AddCert(caCert)
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if settings.CRLFile != "" {
+		crl, err := newRevocationList(settings.CRLFile, caCerts)
+		if err != nil {
+			return nil, err
+		}
+		cfg.VerifyPeerCertificate = func(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+			for _, chain := range verifiedChains {
+				if len(chain) == 0 {
+					continue
+				}
+				if crl.isRevoked(chain[0].SerialNumber) {
+					logger.Log.Info("rejected revoked client certificate",
+						zap.String("serial", chain[0].SerialNumber.String()),
+						zap.String("subject", chain[0].Subject.String()))
+					return ErrCertificateRevoked
+				}
+			}
+			return nil
+		}
+	}
+	return cfg, nil
+}
+
+// NewClientTLSConfig создает конфигурацию TLS для агента: клиентский сертификат
+// предъявляется серверу, а сертификат сервера проверяется по settings.ClientCAFile.
+func NewClientTLSConfig(settings MTLSSettings) (*tls.Config, error) {
+	if !settings.Enabled() {
+		return nil, ErrIncompleteMTLS
+	}
+	cert, err := tls.LoadX509KeyPair(settings.CertFile, settings.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("can not load client key pair: %w", err)
+	}
+	pool, err := loadCertPool(settings.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// AgentIdentity возвращает идентификатор агента из клиентского сертификата:
+// Common Name, а если он пуст — первое DNS-, URI- или email-имя из SAN.
+func AgentIdentity(state *tls.ConnectionState) (string, error) {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return "", ErrNoClientCertificate
+	}
+	cert := state.PeerCertificates[0]
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName, nil
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0], nil
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String(), nil
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0], nil
+	}
+	return "", ErrNoIdentity
+}
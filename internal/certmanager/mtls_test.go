@@ -0,0 +1,176 @@
+package certmanager
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testPKI struct {
+	caCert *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	dir    string
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	p := &testPKI{caCert: cert, caKey: key, dir: t.TempDir()}
+	p.writePEM(t, "ca.crt", "CERTIFICATE", der)
+	return p
+}
+
+func (p *testPKI) writePEM(t *testing.T, name string, blockType string, der []byte) string {
+	path := filepath.Join(p.dir, name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600))
+	return path
+}
+
+func (p *testPKI) issue(t *testing.T, name string, serial int64, tmpl *x509.Certificate) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl.SerialNumber = big.NewInt(serial)
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, p.caCert, &key.PublicKey, p.caKey)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return p.writePEM(t, name+".crt", "CERTIFICATE", der), p.writePEM(t, name+".key", "EC PRIVATE KEY", keyDER)
+}
+
+func (p *testPKI) revoke(t *testing.T, serials ...int64) string {
+	var entries []x509.RevocationListEntry
+	for _, s := range serials {
+		entries = append(entries, x509.RevocationListEntry{SerialNumber: big.NewInt(s), RevocationTime: time.Now()})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(1),
+		ThisUpdate:                time.Now().Add(-time.Minute),
+		NextUpdate:                time.Now().Add(time.Hour),
+		RevokedCertificateEntries: entries,
+	}, p.caCert, p.caKey)
+	require.NoError(t, err)
+	return p.writePEM(t, "ca.crl", "X509 CRL", der)
+}
+
+func TestNewServerTLSConfigRejectsRevoked(t *testing.T) {
+	pki := newTestPKI(t)
+	serverCert, serverKey := pki.issue(t, "server", 10, &x509.Certificate{Subject: pkix.Name{CommonName: "server"}})
+	goodCert, _ := pki.issue(t, "good", 11, &x509.Certificate{Subject: pkix.Name{CommonName: "agent-good"}})
+	badCert, _ := pki.issue(t, "bad", 12, &x509.Certificate{Subject: pkix.Name{CommonName: "agent-bad"}})
+	crl := pki.revoke(t, 12)
+
+	cfg, err := NewServerTLSConfig(MTLSSettings{
+		CertFile:     serverCert,
+		KeyFile:      serverKey,
+		ClientCAFile: filepath.Join(pki.dir, "ca.crt"),
+		CRLFile:      crl,
+	})
+	require.NoError(t, err)
+	require.Equal(t, tls.RequireAndVerifyClientCert, cfg.ClientAuth)
+
+	load := func(path string) *x509.Certificate {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		block, _ := pem.Decode(data)
+		cert, err := x509.ParseCertificate(block.Bytes)
+		require.NoError(t, err)
+		return cert
+	}
+
+	require.NoError(t, cfg.VerifyPeerCertificate(nil, [][]*x509.Certificate{{load(goodCert), pki.caCert}}))
+	require.ErrorIs(t, cfg.VerifyPeerCertificate(nil, [][]*x509.Certificate{{load(badCert), pki.caCert}}), ErrCertificateRevoked)
+}
+
+func TestAgentIdentity(t *testing.T) {
+	agentURI, _ := url.Parse("spiffe://metriccoll/agent-3")
+	tests := []struct {
+		name    string
+		cert    *x509.Certificate
+		want    string
+		wantErr error
+	}{
+		{"CommonName", &x509.Certificate{Subject: pkix.Name{CommonName: "agent-1"}, DNSNames: []string{"ignored"}}, "agent-1", nil},
+		{"DNSName", &x509.Certificate{DNSNames: []string{"agent-2.local"}}, "agent-2.local", nil},
+		{"URI", &x509.Certificate{URIs: []*url.URL{agentURI}}, "spiffe://metriccoll/agent-3", nil},
+		{"Empty", &x509.Certificate{}, "", ErrNoIdentity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := AgentIdentity(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{tt.cert}})
+			require.ErrorIs(t, err, tt.wantErr)
+			require.Equal(t, tt.want, id)
+		})
+	}
+
+	_, err := AgentIdentity(&tls.ConnectionState{})
+	require.ErrorIs(t, err, ErrNoClientCertificate)
+}
+
+func TestMTLSSettingsValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings MTLSSettings
+		wantErr  bool
+	}{
+		{name: "Empty", settings: MTLSSettings{}},
+		{name: "Full", settings: MTLSSettings{CertFile: "c", KeyFile: "k", ClientCAFile: "ca"}},
+		{name: "FullWithCRL", settings: MTLSSettings{CertFile: "c", KeyFile: "k", ClientCAFile: "ca", CRLFile: "crl"}},
+		{name: "OnlyCert", settings: MTLSSettings{CertFile: "c"}, wantErr: true},
+		{name: "NoCA", settings: MTLSSettings{CertFile: "c", KeyFile: "k"}, wantErr: true},
+		{name: "OnlyCRL", settings: MTLSSettings{CRLFile: "crl"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.settings.Validate()
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrIncompleteMTLS)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestNewServerTLSConfigRejectsForeignCRL(t *testing.T) {
+	pki := newTestPKI(t)
+	serverCert, serverKey := pki.issue(t, "server", 10, &x509.Certificate{Subject: pkix.Name{CommonName: "server"}})
+	foreign := newTestPKI(t)
+	crl := foreign.revoke(t, 11)
+
+	_, err := NewServerTLSConfig(MTLSSettings{
+		CertFile:     serverCert,
+		KeyFile:      serverKey,
+		ClientCAFile: filepath.Join(pki.dir, "ca.crt"),
+		CRLFile:      crl,
+	})
+	require.ErrorIs(t, err, ErrCRLSignature)
+}
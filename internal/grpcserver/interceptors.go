@@ -0,0 +1,394 @@
+package grpcserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Fuonder/metriccoll.git/internal/auth"
+	"github.com/Fuonder/metriccoll.git/internal/certmanager"
+	"github.com/Fuonder/metriccoll.git/internal/hmacsign"
+	"github.com/Fuonder/metriccoll.git/internal/idempotency"
+	"github.com/Fuonder/metriccoll.git/internal/logger"
+	pb "github.com/Fuonder/metriccoll.git/internal/proto"
+	"github.com/Fuonder/metriccoll.git/internal/subnet"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Ключи метаданных gRPC, соответствующие HTTP-заголовкам подписи и авторизации.
+var (
+	MetadataSignature = strings.ToLower(hmacsign.HeaderSignature)
+	MetadataTimestamp = strings.ToLower(hmacsign.HeaderTimestamp)
+	MetadataNonce     = strings.ToLower(hmacsign.HeaderNonce)
+)
+
+const metadataAuthorization = "authorization"
+
+// MetadataIdempotencyKey — ключ метаданных с ключом идемпотентности пакета (аналог заголовка Idempotency-Key).
+var MetadataIdempotencyKey = strings.ToLower(idempotency.HeaderKey)
+
+// MetadataRealIP — ключ метаданных с исходящим IP-адресом агента (аналог заголовка X-Real-IP).
+var MetadataRealIP = strings.ToLower(subnet.HeaderRealIP)
+
+// mutatingMethods — методы, изменяющие данные. Для них действуют права на запись
+// и строгий режим проверки подписи.
+var mutatingMethods = map[string]struct{}{
+	pb.Metrics_UpdateMetrics_FullMethodName: {},
+}
+
+// publicMethods — методы, доступные без токена, как HTTP endpoint /ping.
+var publicMethods = map[string]struct{}{
+	pb.Metrics_Ping_FullMethodName: {},
+}
+
+// Settings описывает параметры проверок, выполняемых перехватчиками.
+// Они совпадают с параметрами цепочки HTTP middleware сервера.
+type Settings struct {
+	HashKeys     []string                // Действующие ключи HMAC; первый используется для подписи ответов.
+	StrictHMAC   bool                    // Отклонять неподписанные изменяющие вызовы.
+	ReplayWindow time.Duration           // Допустимое отклонение метки времени подписанного вызова.
+	Decipher     certmanager.TLSDecipher // Расшифровка UpdateMetricsRequest.encrypted_metrics.
+	Authorizer   *auth.Authorizer        // Проверка API-токенов; nil — авторизация выключена.
+	Trusted      subnet.Trusted          // Доверенные подсети агентов; пусто — фильтрация выключена.
+	Proxies      subnet.Trusted          // Прокси, которым доверяются метаданные x-real-ip.
+	Idempotency  idempotency.Store       // Ключи идемпотентности; nil — метаданные idempotency-key не учитываются.
+	MaxBodySize  int64                   // Предельный размер распакованного пакета в байтах; 0 — без ограничения.
+}
+
+// Interceptors реализует проверки HTTP middleware (подпись, расшифровка, токены) для gRPC.
+type Interceptors struct {
+	settings Settings
+	nonces   *hmacsign.NonceCache
+}
+
+// NewInterceptors создает набор перехватчиков с заданными параметрами.
+func NewInterceptors(settings Settings) *Interceptors {
+	if settings.ReplayWindow <= 0 {
+		settings.ReplayWindow = hmacsign.DefaultReplayWindow
+	}
+	return &Interceptors{settings: settings, nonces: hmacsign.NewNonceCache(settings.ReplayWindow)}
+}
+
+// ServerOptions возвращает опции gRPC-сервера с цепочкой перехватчиков в том же порядке,
+// что и HTTP middleware: логирование, доверенные подсети, токены, подпись, расшифровка,
+// идемпотентность. Пакеты потока StreamMetrics проходят ту же цепочку (см. StreamInterceptor).
+func (i *Interceptors) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(i.unaryInterceptors()...),
+		grpc.ChainStreamInterceptor(i.StreamInterceptor),
+	}
+}
+
+func (i *Interceptors) unaryInterceptors() []grpc.UnaryServerInterceptor {
+	return []grpc.UnaryServerInterceptor{
+		i.LoggingInterceptor,
+		i.TrustedSubnetInterceptor,
+		i.AuthInterceptor,
+		i.HashInterceptor,
+		i.DecryptionInterceptor,
+		i.IdempotencyInterceptor,
+	}
+}
+
+// chainUnary объединяет перехватчики в один; первый из них вызывается первым,
+// как в grpc.ChainUnaryInterceptor.
+func chainUnary(interceptors []grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		next := handler
+		for k := len(interceptors) - 1; k >= 0; k-- {
+			interceptor, h := interceptors[k], next
+			next = func(ctx context.Context, req any) (any, error) {
+				return interceptor(ctx, req, info, h)
+			}
+		}
+		return next(ctx, req)
+	}
+}
+
+// batchInterceptorKey — ключ контекста потока, под которым хранится цепочка перехватчиков пакетов.
+type batchInterceptorKey struct{}
+
+// batchInterceptor возвращает цепочку перехватчиков, которую StreamInterceptor передал
+// в контекст потока, или пустой перехватчик, если сервер создан без перехватчиков.
+func batchInterceptor(ctx context.Context) grpc.UnaryServerInterceptor {
+	if interceptor, ok := ctx.Value(batchInterceptorKey{}).(grpc.UnaryServerInterceptor); ok {
+		return interceptor
+	}
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		return handler(ctx, req)
+	}
+}
+
+// contextStream подменяет контекст grpc.ServerStream.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// StreamInterceptor передает обработчику потока цепочку унарных перехватчиков:
+// MetricsServer.StreamMetrics пропускает через нее каждый пакет, поэтому пакеты потока
+// проверяются так же, как отдельные вызовы UpdateMetrics.
+func (i *Interceptors) StreamInterceptor(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx := context.WithValue(ss.Context(), batchInterceptorKey{}, chainUnary(i.unaryInterceptors()))
+	return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+}
+
+func metadataValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// LoggingInterceptor логирует вызовы, их длительность и код ответа.
+func (i *Interceptors) LoggingInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	fields := []zap.Field{zap.String("method", info.FullMethod)}
+	if p, ok := peer.FromContext(ctx); ok {
+		fields = append(fields, zap.String("peer", p.Addr.String()))
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			if id, err := certmanager.AgentIdentity(&tlsInfo.State); err == nil {
+				fields = append(fields, zap.String("agent", id))
+			}
+		}
+	}
+	logger.Log.Info("Got gRPC call", fields...)
+	resp, err := handler(ctx, req)
+	logger.Log.Info("gRPC call finished",
+		zap.String("method", info.FullMethod),
+		zap.String("code", status.Code(err).String()),
+		zap.Duration("Time spent", time.Since(start)))
+	return resp, err
+}
+
+// TrustedSubnetInterceptor отклоняет изменяющие вызовы агентов, IP-адрес которых не входит
+// в доверенные подсети. Как и в HTTP, адрес из метаданных x-real-ip принимается сам по себе
+// только от доверенного прокси, иначе проверяется и адрес соединения (см. subnet.Trusted.Allow).
+func (i *Interceptors) TrustedSubnetInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if _, mutating := mutatingMethods[info.FullMethod]; !mutating || !i.settings.Trusted.Enabled() {
+		return handler(ctx, req)
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	var remote string
+	if p, ok := peer.FromContext(ctx); ok {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			remote = host
+		}
+	}
+	ip, ok, err := i.settings.Trusted.Allow(remote, metadataValue(md, MetadataRealIP), i.settings.Proxies)
+	if err != nil || !ok {
+		logger.Log.Info("grpc call from untrusted ip rejected",
+			zap.String("ip", ip),
+			zap.String("method", info.FullMethod),
+			zap.Error(err))
+		return nil, status.Error(codes.PermissionDenied, "forbidden")
+	}
+	return handler(ctx, req)
+}
+
+// AuthInterceptor проверяет API-токен из метаданных authorization и его права на вызов метода.
+// Права на запись конкретных метрик проверяются в MetricsServer.
+func (i *Interceptors) AuthInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	a := i.settings.Authorizer
+	if _, public := publicMethods[info.FullMethod]; a == nil || public {
+		return handler(ctx, req)
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	token := auth.TokenFromHeader(metadataValue(md, metadataAuthorization))
+	_, mutating := mutatingMethods[info.FullMethod]
+	if token == "" && !mutating && !a.RequireRead() {
+		return handler(ctx, req)
+	}
+
+	principal, err := a.Authenticate(token)
+	if err != nil {
+		logger.Log.Warn("grpc call rejected by token auth", zap.String("method", info.FullMethod), zap.Error(err))
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if (mutating && !principal.CanWriteAny()) || (!mutating && !principal.CanRead() && a.RequireRead()) {
+		logger.Log.Warn("grpc call rejected by token auth",
+			zap.String("token_id", principal.ID),
+			zap.String("method", info.FullMethod),
+			zap.Error(auth.ErrForbidden))
+		return nil, status.Error(codes.PermissionDenied, auth.ErrForbidden.Error())
+	}
+	return handler(auth.NewContext(ctx, principal), req)
+}
+
+// HashInterceptor проверяет подпись вызова по схеме hmacsign: в качестве пути используется
+// полное имя метода, в качестве тела — детерминированно сериализованный запрос.
+// Ответ подписывается основным ключом в заголовке HashSHA256.
+func (i *Interceptors) HashInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	keys := i.settings.HashKeys
+	if len(keys) == 0 {
+		return handler(ctx, req)
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	signature := metadataValue(md, MetadataSignature)
+	if signature == "" {
+		if _, mutating := mutatingMethods[info.FullMethod]; mutating && i.settings.StrictHMAC {
+			logger.Log.Info("Validation", zap.String("HMAC", "No HMAC in call found, rejecting in strict mode"))
+			return nil, status.Error(codes.Unauthenticated, "request signature is required")
+		}
+		return handler(ctx, req)
+	}
+
+	timestamp := metadataValue(md, MetadataTimestamp)
+	nonce := metadataValue(md, MetadataNonce)
+	if timestamp == "" || nonce == "" {
+		return nil, status.Error(codes.InvalidArgument, hmacsign.ErrMissingReplayHeaders.Error())
+	}
+	now := time.Now()
+	if err := hmacsign.CheckTimestamp(timestamp, now, i.settings.ReplayWindow); err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return nil, status.Error(codes.Internal, "unsupported request type")
+	}
+	body, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !hmacsign.VerifyRequest(keys, signature, "POST", info.FullMethod, timestamp, nonce, body) {
+		return nil, status.Error(codes.InvalidArgument, "mismatched hash")
+	}
+	if err := i.nonces.Use(nonce, now); err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	logger.Log.Info("Validation", zap.String("HMAC", "CORRECT"))
+
+	resp, err := handler(ctx, req)
+	if err != nil {
+		return resp, err
+	}
+	if respMsg, ok := resp.(proto.Message); ok {
+		respBody, err := proto.MarshalOptions{Deterministic: true}.Marshal(respMsg)
+		if err == nil {
+			_ = grpc.SetHeader(ctx, metadata.Pairs(MetadataSignature, hmacsign.SignBody(keys[0], respBody)))
+		}
+	}
+	return resp, nil
+}
+
+// DecryptionInterceptor расшифровывает и распаковывает поле encrypted_metrics запроса UpdateMetrics.
+// Пакет зашифрован сеансовым ключом AES (certmanager.SealHybrid), поэтому его размер
+// не ограничен одним блоком RSA.
+func (i *Interceptors) DecryptionInterceptor(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	r, ok := req.(*pb.UpdateMetricsRequest)
+	if !ok || len(r.GetEncryptedMetrics()) == 0 {
+		return handler(ctx, req)
+	}
+	if i.settings.Decipher == nil {
+		return nil, status.Error(codes.FailedPrecondition, "decryption is not configured")
+	}
+	plaintext, err := certmanager.OpenHybrid(i.settings.Decipher, r.GetEncryptedKey(), r.GetNonce(), r.GetEncryptedMetrics())
+	if err != nil {
+		logger.Log.Info("can not decrypt metrics", zap.Error(err))
+		return nil, status.Error(codes.InvalidArgument, "failed to decrypt metrics")
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(plaintext))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "failed to decompress metrics")
+	}
+	defer func() {
+		if err := zr.Close(); err != nil {
+			logger.Log.Debug("can not close reader", zap.Error(err))
+		}
+	}()
+	// Как и BodyLimitMiddleware, ограничиваем распакованный размер: небольшой сжатый
+	// пакет может развернуться в сколь угодно большой.
+	var body io.Reader = zr
+	if i.settings.MaxBodySize > 0 {
+		body = io.LimitReader(zr, i.settings.MaxBodySize+1)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "failed to decompress metrics")
+	}
+	if i.settings.MaxBodySize > 0 && int64(len(data)) > i.settings.MaxBodySize {
+		logger.Log.Info("decompressed metrics exceed body limit", zap.Int64("limit", i.settings.MaxBodySize))
+		return nil, status.Error(codes.ResourceExhausted, "decompressed metrics are too large")
+	}
+	var batch pb.MetricBatch
+	if err := proto.Unmarshal(data, &batch); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "failed to decode metrics")
+	}
+	decrypted := &pb.UpdateMetricsRequest{Metrics: batch.GetMetrics()}
+	return handler(ctx, decrypted)
+}
+
+// IdempotencyInterceptor применяет изменяющий вызов с метаданными idempotency-key не более одного раза:
+// повтор с тем же ключом получает сохраненный ответ первого успешного вызова, а пока первый
+// вызов выполняется — codes.Aborted. Семантика совпадает с Handler.WithIdempotency.
+func (i *Interceptors) IdempotencyInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if _, mutating := mutatingMethods[info.FullMethod]; !mutating || i.settings.Idempotency == nil {
+		return handler(ctx, req)
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	key := metadataValue(md, MetadataIdempotencyKey)
+	if key == "" {
+		return handler(ctx, req)
+	}
+	if err := idempotency.ValidateKey(key); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if principal, ok := auth.FromContext(ctx); ok {
+		key = idempotency.ScopedKey(principal.ID, key)
+	}
+	key = info.FullMethod + ":" + key
+
+	stored, err := i.settings.Idempotency.Begin(ctx, key)
+	switch {
+	case errors.Is(err, idempotency.ErrInProgress):
+		return nil, status.Error(codes.Aborted, err.Error())
+	case err != nil:
+		logger.Log.Warn("can not reserve idempotency key", zap.Error(err))
+		return nil, status.Error(codes.Internal, "can not check idempotency key")
+	case stored != nil:
+		logger.Log.Info("replaying idempotent grpc response", zap.String("key", key))
+		resp := &pb.UpdateMetricsResponse{}
+		if err := proto.Unmarshal(stored.Body, resp); err != nil {
+			return nil, status.Error(codes.Internal, "can not decode stored response")
+		}
+		return resp, nil
+	}
+
+	resp, err := handler(ctx, req)
+	storeCtx := context.WithoutCancel(ctx)
+	if err != nil {
+		err2 := i.settings.Idempotency.Release(storeCtx, key)
+		if err2 != nil {
+			logger.Log.Warn("can not release idempotency key", zap.String("key", key), zap.Error(err2))
+		}
+		return resp, err
+	}
+	msg, ok := resp.(proto.Message)
+	if !ok {
+		return resp, nil
+	}
+	body, mErr := proto.Marshal(msg)
+	if mErr == nil {
+		mErr = i.settings.Idempotency.Complete(storeCtx, key, idempotency.Response{Body: body})
+	}
+	if mErr != nil {
+		logger.Log.Warn("can not store idempotency key", zap.String("key", key), zap.Error(mErr))
+	}
+	return resp, nil
+}
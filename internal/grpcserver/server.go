@@ -0,0 +1,155 @@
+// Package grpcserver реализует gRPC-API сервера метрик поверх того же хранилища
+// и той же обработки пакетов, что используют HTTP-обработчики.
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/Fuonder/metriccoll.git/internal/auth"
+	"github.com/Fuonder/metriccoll.git/internal/logger"
+	"github.com/Fuonder/metriccoll.git/internal/models"
+	pb "github.com/Fuonder/metriccoll.git/internal/proto"
+	"github.com/Fuonder/metriccoll.git/internal/storage"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// BatchApplier записывает пакет метрик так же, как HTTP endpoint POST /updates/:
+// с проверкой элементов, публикацией обновлений и учетом в метриках сервера
+// (см. server.Handler.ApplyBatch). Ошибка недопустимого элемента оборачивает models.ErrInvalidMetric.
+type BatchApplier interface {
+	ApplyBatch(metrics []models.Metrics) ([]models.Metrics, error)
+}
+
+// MetricsServer реализует сервис pb.MetricsServer.
+type MetricsServer struct {
+	pb.UnimplementedMetricsServer
+	mReader storage.MetricReader
+	batches BatchApplier
+}
+
+// NewMetricsServer создает MetricsServer, читающий метрики из mReader и записывающий пакеты через batches.
+func NewMetricsServer(mReader storage.MetricReader, batches BatchApplier) *MetricsServer {
+	return &MetricsServer{mReader: mReader, batches: batches}
+}
+
+// authorizeWrite проверяет право токена из контекста на запись метрик (если авторизация включена).
+func authorizeWrite(ctx context.Context, metrics []models.Metrics) error {
+	principal, ok := auth.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	names := make([]string, 0, len(metrics))
+	for _, m := range metrics {
+		names = append(names, m.ID)
+	}
+	if err := principal.Authorize(names...); err != nil {
+		logger.Log.Warn("grpc call rejected by token auth", zap.String("token_id", principal.ID), zap.Error(err))
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return nil
+}
+
+// UpdateMetrics сохраняет пакет метрик и возвращает их актуальные значения.
+// Аналог HTTP endpoint'а POST /updates/.
+func (s *MetricsServer) UpdateMetrics(ctx context.Context, req *pb.UpdateMetricsRequest) (*pb.UpdateMetricsResponse, error) {
+	if s.batches == nil {
+		return nil, status.Error(codes.Internal, "storage not initialized")
+	}
+	metrics := pb.ToModels(req.GetMetrics())
+	if err := authorizeWrite(ctx, metrics); err != nil {
+		return nil, err
+	}
+	updated, err := s.batches.ApplyBatch(metrics)
+	switch {
+	case errors.Is(err, models.ErrInvalidMetric):
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &pb.UpdateMetricsResponse{Metrics: pb.FromModels(updated)}, nil
+}
+
+// StreamMetrics принимает поток пакетов метрик и отвечает на каждый так же, как UpdateMetrics.
+// Каждый пакет проходит цепочку перехватчиков как отдельный вызов UpdateMetrics, при этом
+// подпись, метка времени, nonce и ключ идемпотентности берутся из сообщения, а не из
+// метаданных потока. Ошибка обработки пакета завершает поток с ее кодом.
+func (s *MetricsServer) StreamMetrics(stream pb.Metrics_StreamMetricsServer) error {
+	ctx := stream.Context()
+	intercept := batchInterceptor(ctx)
+	md, _ := metadata.FromIncomingContext(ctx)
+	info := &grpc.UnaryServerInfo{Server: s, FullMethod: pb.Metrics_UpdateMetrics_FullMethodName}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return s.UpdateMetrics(ctx, req.(*pb.UpdateMetricsRequest))
+	}
+	for {
+		msg, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if msg.GetBatch() == nil {
+			return status.Error(codes.InvalidArgument, "empty batch")
+		}
+		resp, err := intercept(metadata.NewIncomingContext(ctx, batchMetadata(md, msg)), msg.GetBatch(), info, handler)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(resp.(*pb.UpdateMetricsResponse)); err != nil {
+			return err
+		}
+	}
+}
+
+// batchMetadata возвращает метаданные потока md, в которых ключи подписи и идемпотентности
+// заменены значениями из сообщения msg.
+func batchMetadata(md metadata.MD, msg *pb.StreamMetricsRequest) metadata.MD {
+	md = md.Copy()
+	for key, value := range map[string]string{
+		MetadataSignature:      msg.GetSignature(),
+		MetadataTimestamp:      msg.GetTimestamp(),
+		MetadataNonce:          msg.GetNonce(),
+		MetadataIdempotencyKey: msg.GetIdempotencyKey(),
+	} {
+		if value == "" {
+			md.Delete(key)
+			continue
+		}
+		md.Set(key, value)
+	}
+	return md
+}
+
+// GetMetric возвращает метрику по имени и типу. Аналог HTTP endpoint'а POST /value/.
+func (s *MetricsServer) GetMetric(_ context.Context, req *pb.GetMetricRequest) (*pb.Metric, error) {
+	if s.mReader == nil {
+		return nil, status.Error(codes.Internal, "storage not initialized")
+	}
+	m, err := s.mReader.GetMetricByName(req.GetId(), req.GetType())
+	if err != nil {
+		logger.Log.Info("metric not found", zap.Error(err))
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return pb.FromModel(m), nil
+}
+
+// ListMetrics возвращает все метрики. Аналог HTTP endpoint'а GET /.
+func (s *MetricsServer) ListMetrics(_ context.Context, _ *pb.ListMetricsRequest) (*pb.ListMetricsResponse, error) {
+	if s.mReader == nil {
+		return nil, status.Error(codes.Internal, "storage not initialized")
+	}
+	return &pb.ListMetricsResponse{Metrics: pb.FromModels(s.mReader.GetAllMetrics())}, nil
+}
+
+// Ping отвечает на проверку доступности сервера. Аналог HTTP endpoint'а GET /ping,
+// но без обращения к хранилищу.
+func (s *MetricsServer) Ping(_ context.Context, _ *pb.PingRequest) (*pb.PingResponse, error) {
+	return &pb.PingResponse{}, nil
+}
@@ -0,0 +1,420 @@
+package grpcserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Fuonder/metriccoll.git/internal/auth"
+	"github.com/Fuonder/metriccoll.git/internal/certmanager"
+	"github.com/Fuonder/metriccoll.git/internal/hmacsign"
+	"github.com/Fuonder/metriccoll.git/internal/idempotency"
+	pb "github.com/Fuonder/metriccoll.git/internal/proto"
+	"github.com/Fuonder/metriccoll.git/internal/selfmetrics"
+	"github.com/Fuonder/metriccoll.git/internal/server"
+	"github.com/Fuonder/metriccoll.git/internal/storage"
+	"github.com/Fuonder/metriccoll.git/internal/stream"
+	"github.com/Fuonder/metriccoll.git/internal/subnet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+func startTestServer(t *testing.T, settings Settings) pb.MetricsClient {
+	st, err := storage.NewJSONStorage(storage.NewFileStoreInfo(filepath.Join(t.TempDir(), "metrics.json"), time.Hour, false))
+	require.NoError(t, err)
+	return serveHandler(t, settings, st, server.NewHandler(st, st, nil, nil, nil, ""))
+}
+
+func serveHandler(t *testing.T, settings Settings, st storage.MetricReader, h *server.Handler) pb.MetricsClient {
+	listener := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(NewInterceptors(settings).ServerOptions()...)
+	pb.RegisterMetricsServer(srv, NewMetricsServer(st, h))
+	go func() { _ = srv.Serve(listener) }()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return pb.NewMetricsClient(conn)
+}
+
+func signedContext(t *testing.T, key string, nonce string, req proto.Message) context.Context {
+	body, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	require.NoError(t, err)
+	ts := hmacsign.Timestamp(time.Now())
+	return metadata.AppendToOutgoingContext(context.Background(),
+		MetadataTimestamp, ts,
+		MetadataNonce, nonce,
+		MetadataSignature, hmacsign.SignRequest(key, "POST", pb.Metrics_UpdateMetrics_FullMethodName, ts, nonce, body))
+}
+
+func updateRequest() *pb.UpdateMetricsRequest {
+	delta := int64(5)
+	value := 1.5
+	return &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+		{Id: "PollCount", Type: "counter", Delta: &delta},
+		{Id: "Alloc", Type: "gauge", Value: &value},
+	}}
+}
+
+func TestMetricsServer(t *testing.T) {
+	client := startTestServer(t, Settings{})
+	ctx := context.Background()
+
+	resp, err := client.UpdateMetrics(ctx, updateRequest())
+	require.NoError(t, err)
+	assert.Len(t, resp.GetMetrics(), 2)
+
+	_, err = client.UpdateMetrics(ctx, updateRequest())
+	require.NoError(t, err)
+
+	m, err := client.GetMetric(ctx, &pb.GetMetricRequest{Id: "PollCount", Type: "counter"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), m.GetDelta())
+
+	_, err = client.GetMetric(ctx, &pb.GetMetricRequest{Id: "Unknown", Type: "gauge"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	list, err := client.ListMetrics(ctx, &pb.ListMetricsRequest{})
+	require.NoError(t, err)
+	assert.Len(t, list.GetMetrics(), 2)
+}
+
+func TestUpdateMetricsSharesHTTPBatchPath(t *testing.T) {
+	st, err := storage.NewJSONStorage(storage.NewFileStoreInfo(filepath.Join(t.TempDir(), "metrics.json"), time.Hour, false))
+	require.NoError(t, err)
+	h := server.NewHandler(st, st, nil, nil, nil, "")
+	broker := stream.NewBroker(0)
+	h.SetBroker(broker)
+	reg := selfmetrics.NewRegistry(server.SelfMetricsNamespace)
+	h.SetSelfMetrics(reg)
+	client := serveHandler(t, Settings{}, st, h)
+
+	sub, err := broker.Subscribe("*", "")
+	require.NoError(t, err)
+	t.Cleanup(func() { broker.Unsubscribe(sub) })
+
+	_, err = client.UpdateMetrics(context.Background(), updateRequest())
+	require.NoError(t, err)
+	for _, want := range []string{"PollCount", "Alloc"} {
+		select {
+		case ev := <-sub.Events():
+			assert.Equal(t, want, ev.Metric.ID)
+		case <-time.After(time.Second):
+			t.Fatalf("update of %s is not published", want)
+		}
+	}
+	var text bytes.Buffer
+	require.NoError(t, reg.WriteText(&text))
+	assert.Contains(t, text.String(), `batch_size_count{mode="default"} 1`)
+
+	// Gauge без значения отклоняется проверкой пакета, а не ошибкой хранилища.
+	_, err = client.UpdateMetrics(context.Background(), &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+		{Id: "Alloc", Type: "gauge"},
+	}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Len(t, st.GetAllMetrics(), 2)
+}
+
+func TestHashInterceptor(t *testing.T) {
+	const key = "secret"
+	client := startTestServer(t, Settings{HashKeys: []string{key}, StrictHMAC: true})
+
+	req := updateRequest()
+	var header metadata.MD
+	_, err := client.UpdateMetrics(signedContext(t, key, "nonce-1", req), req, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.NotEmpty(t, header.Get(MetadataSignature))
+
+	_, err = client.UpdateMetrics(signedContext(t, key, "nonce-1", req), req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "replayed nonce")
+
+	_, err = client.UpdateMetrics(signedContext(t, "wrong", "nonce-2", req), req)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "wrong key")
+
+	_, err = client.UpdateMetrics(context.Background(), req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "unsigned call in strict mode")
+
+	_, err = client.ListMetrics(context.Background(), &pb.ListMetricsRequest{})
+	assert.NoError(t, err, "reads are not signed")
+}
+
+func TestAuthInterceptor(t *testing.T) {
+	authorizer, err := auth.NewAuthorizer(auth.Config{Tokens: []auth.TokenConfig{
+		{ID: "reader", Token: "r-token", Scopes: []string{"read"}},
+		{ID: "cpu-writer", Token: "w-token", Scopes: []string{"write:Poll"}},
+	}})
+	require.NoError(t, err)
+	client := startTestServer(t, Settings{Authorizer: authorizer})
+
+	withToken := func(token string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+	}
+
+	_, err = client.UpdateMetrics(context.Background(), updateRequest())
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "no token")
+
+	_, err = client.UpdateMetrics(withToken("r-token"), updateRequest())
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "read-only token")
+
+	_, err = client.UpdateMetrics(withToken("w-token"), updateRequest())
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "metric outside of prefix")
+
+	delta := int64(1)
+	_, err = client.UpdateMetrics(withToken("w-token"), &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+		{Id: "PollCount", Type: "counter", Delta: &delta},
+	}})
+	assert.NoError(t, err)
+
+	_, err = client.ListMetrics(context.Background(), &pb.ListMetricsRequest{})
+	assert.NoError(t, err, "anonymous reads are allowed")
+	_, err = client.ListMetrics(withToken("w-token"), &pb.ListMetricsRequest{})
+	assert.NoError(t, err, "open reads are allowed with a write token")
+
+	strict, err := auth.NewAuthorizer(auth.Config{RequireRead: true, Tokens: []auth.TokenConfig{
+		{ID: "cpu-writer", Token: "w-token", Scopes: []string{"write:Poll"}},
+	}})
+	require.NoError(t, err)
+	client = startTestServer(t, Settings{Authorizer: strict})
+	_, err = client.ListMetrics(withToken("w-token"), &pb.ListMetricsRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "write scope does not grant read")
+}
+
+func TestTrustedSubnetInterceptor(t *testing.T) {
+	trusted, err := subnet.ParseTrusted("192.168.1.0/24")
+	require.NoError(t, err)
+	proxies, err := subnet.ParseTrusted("10.0.0.5/32")
+	require.NoError(t, err)
+	client := startTestServer(t, Settings{Trusted: trusted, Proxies: proxies})
+
+	withIP := func(ip string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), MetadataRealIP, ip)
+	}
+
+	_, err = client.UpdateMetrics(withIP("192.168.1.20"), updateRequest())
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "bufconn peer is not a trusted proxy")
+
+	_, err = client.UpdateMetrics(context.Background(), updateRequest())
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "bufconn peer address is not an ip")
+
+	_, err = client.ListMetrics(withIP("10.0.0.1"), &pb.ListMetricsRequest{})
+	assert.NoError(t, err, "reads are not filtered")
+
+	interceptors := NewInterceptors(Settings{Trusted: trusted, Proxies: proxies})
+	info := &grpc.UnaryServerInfo{FullMethod: pb.Metrics_UpdateMetrics_FullMethodName}
+	handler := func(context.Context, any) (any, error) { return nil, nil }
+
+	tests := []struct {
+		name   string
+		remote string
+		realIP string
+		want   codes.Code
+	}{
+		{"TrustedPeer", "192.168.1.20", "", codes.OK},
+		{"UntrustedPeer", "10.0.0.1", "", codes.PermissionDenied},
+		{"HeaderFromUntrustedPeer", "10.0.0.1", "192.168.1.20", codes.PermissionDenied},
+		{"UntrustedHeaderFromTrustedPeer", "192.168.1.20", "10.0.0.1", codes.PermissionDenied},
+		{"HeaderFromProxy", "10.0.0.5", "192.168.1.20", codes.OK},
+		{"UntrustedHeaderFromProxy", "10.0.0.5", "10.0.0.1", codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := peer.NewContext(context.Background(), &peer.Peer{
+				Addr: &net.TCPAddr{IP: net.ParseIP(tt.remote), Port: 1234},
+			})
+			if tt.realIP != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(MetadataRealIP, tt.realIP))
+			}
+			_, err := interceptors.TrustedSubnetInterceptor(ctx, updateRequest(), info, handler)
+			assert.Equal(t, tt.want, status.Code(err))
+		})
+	}
+}
+
+func TestIdempotencyInterceptor(t *testing.T) {
+	client := startTestServer(t, Settings{Idempotency: idempotency.NewMemoryStore(time.Minute)})
+	ctx := metadata.AppendToOutgoingContext(context.Background(), MetadataIdempotencyKey, "batch-1")
+
+	first, err := client.UpdateMetrics(ctx, updateRequest())
+	require.NoError(t, err)
+	retry, err := client.UpdateMetrics(ctx, updateRequest())
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(first, retry))
+
+	m, err := client.GetMetric(context.Background(), &pb.GetMetricRequest{Id: "PollCount", Type: "counter"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), m.GetDelta(), "retried batch must not be applied twice")
+
+	badCtx := metadata.AppendToOutgoingContext(context.Background(), MetadataIdempotencyKey, "bad key")
+	_, err = client.UpdateMetrics(badCtx, updateRequest())
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func signedBatch(key string, nonce string, idempotencyKey string, batch *pb.UpdateMetricsRequest) (*pb.StreamMetricsRequest, error) {
+	body, err := proto.MarshalOptions{Deterministic: true}.Marshal(batch)
+	if err != nil {
+		return nil, err
+	}
+	ts := hmacsign.Timestamp(time.Now())
+	return &pb.StreamMetricsRequest{
+		Batch:          batch,
+		IdempotencyKey: idempotencyKey,
+		Timestamp:      ts,
+		Nonce:          nonce,
+		Signature:      hmacsign.SignRequest(key, "POST", pb.Metrics_UpdateMetrics_FullMethodName, ts, nonce, body),
+	}, nil
+}
+
+func TestStreamMetrics(t *testing.T) {
+	const key = "secret"
+	client := startTestServer(t, Settings{
+		HashKeys:    []string{key},
+		StrictHMAC:  true,
+		Idempotency: idempotency.NewMemoryStore(time.Minute),
+	})
+
+	stream, err := client.StreamMetrics(context.Background())
+	require.NoError(t, err)
+	for i, idempotencyKey := range []string{"batch-1", "batch-2", "batch-2"} {
+		req, err := signedBatch(key, fmt.Sprintf("nonce-%d", i), idempotencyKey, updateRequest())
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(req))
+		resp, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Len(t, resp.GetMetrics(), 2)
+	}
+	require.NoError(t, stream.CloseSend())
+	_, err = stream.Recv()
+	assert.ErrorIs(t, err, io.EOF)
+
+	m, err := client.GetMetric(context.Background(), &pb.GetMetricRequest{Id: "PollCount", Type: "counter"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), m.GetDelta(), "batch retried in the stream must not be applied twice")
+
+	stream, err = client.StreamMetrics(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.StreamMetricsRequest{Batch: updateRequest()}))
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "unsigned batch in strict mode")
+
+	stream, err = client.StreamMetrics(context.Background())
+	require.NoError(t, err)
+	req, err := signedBatch(key, "nonce-0", "", updateRequest())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(req))
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "replayed nonce")
+}
+
+func TestStreamMetricsAuth(t *testing.T) {
+	authorizer, err := auth.NewAuthorizer(auth.Config{Tokens: []auth.TokenConfig{
+		{ID: "cpu-writer", Token: "w-token", Scopes: []string{"write:Poll"}},
+	}})
+	require.NoError(t, err)
+	client := startTestServer(t, Settings{Authorizer: authorizer})
+
+	stream, err := client.StreamMetrics(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.StreamMetricsRequest{Batch: updateRequest()}))
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "no token")
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer w-token")
+	stream, err = client.StreamMetrics(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.StreamMetricsRequest{Batch: updateRequest()}))
+	_, err = stream.Recv()
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "metric outside of prefix")
+}
+
+func TestDecryptionInterceptorLargeBatch(t *testing.T) {
+	decipher, err := certmanager.NewCertManager()
+	require.NoError(t, err)
+	require.NoError(t, decipher.LoadPrivateKey("../../certs/server.key"))
+	cipher, err := certmanager.NewCertManager()
+	require.NoError(t, err)
+	require.NoError(t, cipher.LoadCertificate("../../certs/server.crt"))
+	client := startTestServer(t, Settings{Decipher: decipher})
+
+	// Пакет заведомо больше одного блока RSA.
+	batch := &pb.MetricBatch{}
+	for i := 0; i < 1000; i++ {
+		value := float64(i)
+		batch.Metrics = append(batch.Metrics, &pb.Metric{Id: fmt.Sprintf("Gauge%d", i), Type: "gauge", Value: &value})
+	}
+	data, err := proto.Marshal(batch)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err = zw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	key, nonce, ciphertext, err := certmanager.SealHybrid(cipher, buf.Bytes())
+	require.NoError(t, err)
+	resp, err := client.UpdateMetrics(context.Background(), &pb.UpdateMetricsRequest{
+		EncryptedMetrics: ciphertext, EncryptedKey: key, Nonce: nonce,
+	})
+	require.NoError(t, err)
+	assert.Len(t, resp.GetMetrics(), 1000)
+
+	_, err = client.UpdateMetrics(context.Background(), &pb.UpdateMetricsRequest{EncryptedMetrics: ciphertext})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "payload without session key")
+}
+
+func TestDecryptionInterceptorBodyLimit(t *testing.T) {
+	decipher, err := certmanager.NewCertManager()
+	require.NoError(t, err)
+	require.NoError(t, decipher.LoadPrivateKey("../../certs/server.key"))
+	cipher, err := certmanager.NewCertManager()
+	require.NoError(t, err)
+	require.NoError(t, cipher.LoadCertificate("../../certs/server.crt"))
+	client := startTestServer(t, Settings{Decipher: decipher, MaxBodySize: 1 << 20})
+
+	// Несколько килобайт сжатых данных разворачиваются в 8 МБ.
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err = zw.Write(make([]byte, 8<<20))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	require.Less(t, buf.Len(), 64<<10)
+
+	key, nonce, ciphertext, err := certmanager.SealHybrid(cipher, buf.Bytes())
+	require.NoError(t, err)
+	_, err = client.UpdateMetrics(context.Background(), &pb.UpdateMetricsRequest{
+		EncryptedMetrics: ciphertext, EncryptedKey: key, Nonce: nonce,
+	})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestPingIsPublic(t *testing.T) {
+	authorizer, err := auth.NewAuthorizer(auth.Config{RequireRead: true, Tokens: []auth.TokenConfig{
+		{ID: "cpu-writer", Token: "w-token", Scopes: []string{"write:Poll"}},
+	}})
+	require.NoError(t, err)
+	client := startTestServer(t, Settings{Authorizer: authorizer})
+
+	_, err = client.Ping(context.Background(), &pb.PingRequest{})
+	assert.NoError(t, err)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer w-token")
+	_, err = client.Ping(ctx, &pb.PingRequest{})
+	assert.NoError(t, err, "write-only token can check the connection")
+}
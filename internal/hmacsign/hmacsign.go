@@ -0,0 +1,129 @@
+// Package hmacsign описывает схему HMAC-SHA256-подписи запросов агента к серверу.
+//
+// Подписывается не только тело запроса, но и его метод, путь, метка времени и одноразовое значение (nonce):
+//
+//	METHOD "\n" REQUEST-URI "\n" TIMESTAMP "\n" NONCE "\n" BODY
+//
+// Благодаря этому перехваченный запрос нельзя повторно отправить на сервер:
+// сервер отклоняет устаревшие метки времени и повторно использованные nonce.
+package hmacsign
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"strconv"
+	"time"
+)
+
+const (
+	// HeaderSignature — заголовок с подписью запроса или ответа.
+	HeaderSignature = "HashSHA256"
+	// HeaderTimestamp — заголовок с меткой времени запроса (Unix-время в секундах).
+	HeaderTimestamp = "X-Timestamp"
+	// HeaderNonce — заголовок с одноразовым значением запроса.
+	HeaderNonce = "X-Nonce"
+)
+
+var (
+	ErrMissingReplayHeaders = errors.New("missing timestamp or nonce header")
+	ErrInvalidTimestamp     = errors.New("invalid timestamp")
+	ErrStaleTimestamp       = errors.New("timestamp is outside of allowed window")
+)
+
+// NewNonce возвращает случайное одноразовое значение для подписи запроса.
+func NewNonce() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Timestamp возвращает метку времени t в формате заголовка X-Timestamp.
+func Timestamp(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+// newRequestHash возвращает HMAC ключом key, в который уже записаны заголовочные поля запроса.
+// Тело запроса дописывается в него отдельно.
+func newRequestHash(key string, method string, requestURI string, timestamp string, nonce string) hash.Hash {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write([]byte(method + "\n" + requestURI + "\n" + timestamp + "\n" + nonce + "\n"))
+	return h
+}
+
+// SignRequest вычисляет подпись запроса ключом key.
+func SignRequest(key string, method string, requestURI string, timestamp string, nonce string, body []byte) string {
+	h := newRequestHash(key, method, requestURI, timestamp, nonce)
+	h.Write(body)
+	return base64.URLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// RequestVerifier проверяет подпись запроса по мере чтения тела, не накапливая его в памяти.
+// Тело передается через Write (например, с помощью io.TeeReader), после чего вызывается Verify.
+type RequestVerifier struct {
+	hashes []hash.Hash
+}
+
+// NewRequestVerifier создает RequestVerifier для набора ключей keys.
+func NewRequestVerifier(keys []string, method string, requestURI string, timestamp string, nonce string) *RequestVerifier {
+	v := &RequestVerifier{hashes: make([]hash.Hash, 0, len(keys))}
+	for _, key := range keys {
+		v.hashes = append(v.hashes, newRequestHash(key, method, requestURI, timestamp, nonce))
+	}
+	return v
+}
+
+// Write добавляет очередную часть тела запроса.
+func (v *RequestVerifier) Write(p []byte) (int, error) {
+	for _, h := range v.hashes {
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+// Verify проверяет, что signature совпадает с подписью запроса любым из ключей.
+// Подписи сравниваются за постоянное время, и проверяются все ключи набора.
+func (v *RequestVerifier) Verify(signature string) bool {
+	matched := false
+	for _, h := range v.hashes {
+		if hmac.Equal([]byte(signature), []byte(base64.URLEncoding.EncodeToString(h.Sum(nil)))) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// VerifyRequest проверяет, что signature совпадает с подписью запроса любым из ключей keys.
+// Подписи сравниваются за постоянное время, и проверяются все ключи набора.
+func VerifyRequest(keys []string, signature string, method string, requestURI string, timestamp string, nonce string, body []byte) bool {
+	v := NewRequestVerifier(keys, method, requestURI, timestamp, nonce)
+	_, _ = v.Write(body)
+	return v.Verify(signature)
+}
+
+// SignBody вычисляет подпись тела ответа ключом key.
+func SignBody(key string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write(body)
+	return base64.URLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// CheckTimestamp проверяет, что метка времени timestamp отличается от now не больше чем на window.
+func CheckTimestamp(timestamp string, now time.Time, window time.Duration) error {
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidTimestamp
+	}
+	diff := now.Sub(time.Unix(sec, 0))
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff > window {
+		return ErrStaleTimestamp
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package hmacsign
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultReplayWindow — допустимое расхождение метки времени запроса с часами сервера.
+const DefaultReplayWindow = 5 * time.Minute
+
+var ErrReplayedNonce = errors.New("nonce has already been used")
+
+// NonceCache запоминает nonce подписанных запросов на время окна window.
+// Записи старше окна удаляются при очередной проверке, но не чаще одного раза за окно.
+type NonceCache struct {
+	window    time.Duration
+	seen      map[string]time.Time
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+// NewNonceCache создает кэш nonce с заданным окном времени.
+func NewNonceCache(window time.Duration) *NonceCache {
+	return &NonceCache{window: window, seen: make(map[string]time.Time)}
+}
+
+// Use отмечает nonce как использованный. Возвращает ErrReplayedNonce,
+// если nonce уже встречался в пределах окна.
+func (c *NonceCache) Use(nonce string, now time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.lastSweep) > c.window {
+		for n, seenAt := range c.seen {
+			if now.Sub(seenAt) > 2*c.window {
+				delete(c.seen, n)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	if seenAt, ok := c.seen[nonce]; ok && now.Sub(seenAt) <= 2*c.window {
+		return ErrReplayedNonce
+	}
+	c.seen[nonce] = now
+	return nil
+}
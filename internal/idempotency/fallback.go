@@ -0,0 +1,100 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/Fuonder/metriccoll.git/internal/logger"
+	"go.uber.org/zap"
+)
+
+// FallbackStore хранит ключи в основном хранилище (например, в таблице БД), а пока оно
+// недоступно — в памяти процесса, чтобы пакеты агентов принимались и в режиме деградации.
+// Ответы, сохраненные в памяти, возвращаются повторам и после восстановления основного
+// хранилища, пока не истечет их время жизни.
+type FallbackStore struct {
+	memory    *MemoryStore
+	available func() bool // Сообщает, можно ли обращаться к основному хранилищу.
+
+	mu      sync.Mutex
+	primary Store
+	owners  map[string]Store // Хранилище, в котором зарезервирован ключ выполняющегося запроса.
+}
+
+// NewFallbackStore создает хранилище, использующее memory, пока основное хранилище
+// не задано через SetPrimary или available возвращает false.
+func NewFallbackStore(memory *MemoryStore, available func() bool) *FallbackStore {
+	return &FallbackStore{memory: memory, available: available, owners: make(map[string]Store)}
+}
+
+// SetPrimary задает основное хранилище ключей, например после переподключения к БД.
+func (s *FallbackStore) SetPrimary(primary Store) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.primary = primary
+}
+
+// activePrimary возвращает основное хранилище, если оно задано и доступно.
+func (s *FallbackStore) activePrimary() Store {
+	s.mu.Lock()
+	primary := s.primary
+	s.mu.Unlock()
+	if primary == nil || (s.available != nil && !s.available()) {
+		return nil
+	}
+	return primary
+}
+
+// reserve запоминает, в каком хранилище зарезервирован ключ.
+func (s *FallbackStore) reserve(key string, store Store) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.owners[key] = store
+}
+
+// owner возвращает и забывает хранилище, в котором зарезервирован ключ.
+func (s *FallbackStore) owner(key string) Store {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	store, ok := s.owners[key]
+	if !ok {
+		return s.memory
+	}
+	delete(s.owners, key)
+	return store
+}
+
+// Begin резервирует ключ, см. Store. Если основное хранилище вернуло ошибку, ключ
+// резервируется в памяти: запись пакета важнее, чем общий для экземпляров учет ключей.
+func (s *FallbackStore) Begin(ctx context.Context, key string) (*Response, error) {
+	if resp := s.memory.completed(key); resp != nil {
+		return resp, nil
+	}
+	if primary := s.activePrimary(); primary != nil {
+		resp, err := primary.Begin(ctx, key)
+		if err == nil || errors.Is(err, ErrInProgress) {
+			if resp == nil && err == nil {
+				s.reserve(key, primary)
+			}
+			return resp, err
+		}
+		logger.Log.Warn("idempotency store is unavailable, keeping key in memory",
+			zap.String("key", key), zap.Error(err))
+	}
+	resp, err := s.memory.Begin(ctx, key)
+	if resp == nil && err == nil {
+		s.reserve(key, s.memory)
+	}
+	return resp, err
+}
+
+// Complete сохраняет ответ в том хранилище, где был зарезервирован ключ, см. Store.
+func (s *FallbackStore) Complete(ctx context.Context, key string, resp Response) error {
+	return s.owner(key).Complete(ctx, key, resp)
+}
+
+// Release снимает резерв в том хранилище, где был зарезервирован ключ, см. Store.
+func (s *FallbackStore) Release(ctx context.Context, key string) error {
+	return s.owner(key).Release(ctx, key)
+}
@@ -0,0 +1,158 @@
+// Package idempotency реализует повторяемую запись пакетов метрик по ключу идемпотентности.
+//
+// Агент передает один и тот же ключ во всех повторах отправки пакета. Сервер резервирует
+// ключ перед записью и сохраняет ответ после нее, поэтому повтор получает исходный ответ,
+// а дельты счетчиков не применяются второй раз.
+package idempotency
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// HeaderKey — заголовок HTTP-запроса с ключом идемпотентности.
+const HeaderKey = "Idempotency-Key"
+
+// HeaderReplayed — заголовок ответа, которым помечается ответ, сохраненный при первом запросе.
+const HeaderReplayed = "Idempotent-Replayed"
+
+// MaxKeyLength — максимальная длина ключа идемпотентности.
+const MaxKeyLength = 128
+
+// DefaultTTL — время, в течение которого сервер помнит примененные ключи.
+const DefaultTTL = 10 * time.Minute
+
+var (
+	ErrInvalidKey = errors.New("invalid idempotency key")
+	ErrInProgress = errors.New("request with this idempotency key is in progress")
+)
+
+// Response — сохраненный результат запроса.
+type Response struct {
+	Status      int    // Код ответа (HTTP-статус или 0 для gRPC).
+	ContentType string // Тип содержимого ответа.
+	Body        []byte // Тело ответа.
+}
+
+// Store хранит ключи идемпотентности и результаты запросов.
+type Store interface {
+	// Begin резервирует ключ. Если запрос с этим ключом уже завершен, возвращает его ответ;
+	// если он еще выполняется — ErrInProgress. Для нового ключа возвращает (nil, nil).
+	Begin(ctx context.Context, key string) (*Response, error)
+	// Complete сохраняет ответ для зарезервированного ключа.
+	Complete(ctx context.Context, key string, resp Response) error
+	// Release снимает резерв с ключа, если запрос не был применен.
+	Release(ctx context.Context, key string) error
+}
+
+// NewKey возвращает случайный ключ идемпотентности.
+func NewKey() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ValidateKey проверяет, что ключ непустой, не длиннее MaxKeyLength
+// и состоит из печатных ASCII-символов.
+func ValidateKey(key string) error {
+	if key == "" || len(key) > MaxKeyLength {
+		return ErrInvalidKey
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x21 || key[i] > 0x7e {
+			return ErrInvalidKey
+		}
+	}
+	return nil
+}
+
+// ScopedKey возвращает ключ хранилища для ключа key, предъявленного владельцем owner
+// (например, идентификатором API-токена), чтобы разные агенты не видели ответы друг друга.
+func ScopedKey(owner, key string) string {
+	if owner == "" {
+		return key
+	}
+	return owner + ":" + key
+}
+
+// memoryEntry — запись MemoryStore.
+type memoryEntry struct {
+	created time.Time
+	resp    *Response // nil, пока запрос выполняется.
+}
+
+// MemoryStore хранит ключи идемпотентности в памяти процесса.
+// Записи старше ttl удаляются при очередном обращении, но не чаще одного раза за ttl.
+type MemoryStore struct {
+	ttl       time.Duration
+	entries   map[string]memoryEntry
+	lastSweep time.Time
+	now       func() time.Time
+	mu        sync.Mutex
+}
+
+// NewMemoryStore создает хранилище ключей в памяти со временем жизни записи ttl.
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	return &MemoryStore{ttl: ttl, entries: make(map[string]memoryEntry), now: time.Now}
+}
+
+// Begin резервирует ключ, см. Store.
+func (s *MemoryStore) Begin(_ context.Context, key string) (*Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if now.Sub(s.lastSweep) > s.ttl {
+		for k, e := range s.entries {
+			if now.Sub(e.created) > s.ttl {
+				delete(s.entries, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	if e, ok := s.entries[key]; ok && now.Sub(e.created) <= s.ttl {
+		if e.resp == nil {
+			return nil, ErrInProgress
+		}
+		resp := *e.resp
+		return &resp, nil
+	}
+	s.entries[key] = memoryEntry{created: now}
+	return nil, nil
+}
+
+// completed возвращает сохраненный и еще не устаревший ответ для ключа или nil.
+func (s *MemoryStore) completed(key string) *Response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok || e.resp == nil || s.now().Sub(e.created) > s.ttl {
+		return nil
+	}
+	resp := *e.resp
+	return &resp
+}
+
+// Complete сохраняет ответ для ключа, см. Store.
+func (s *MemoryStore) Complete(_ context.Context, key string, resp Response) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp.Body = append([]byte(nil), resp.Body...)
+	s.entries[key] = memoryEntry{created: s.now(), resp: &resp}
+	return nil
+}
+
+// Release снимает резерв с ключа, см. Store.
+func (s *MemoryStore) Release(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[key]; ok && e.resp == nil {
+		delete(s.entries, key)
+	}
+	return nil
+}
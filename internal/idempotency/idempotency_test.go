@@ -0,0 +1,83 @@
+package idempotency
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	s := NewMemoryStore(time.Minute)
+	s.now = func() time.Time { return now }
+
+	resp, err := s.Begin(ctx, "k1")
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	_, err = s.Begin(ctx, "k1")
+	require.ErrorIs(t, err, ErrInProgress)
+
+	require.NoError(t, s.Complete(ctx, "k1", Response{Status: 200, Body: []byte("ok")}))
+	resp, err = s.Begin(ctx, "k1")
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.Status)
+	require.Equal(t, "ok", string(resp.Body))
+
+	_, err = s.Begin(ctx, "k2")
+	require.NoError(t, err)
+	require.NoError(t, s.Release(ctx, "k2"))
+	resp, err = s.Begin(ctx, "k2")
+	require.NoError(t, err)
+	require.Nil(t, resp, "released key must be reserved again")
+
+	now = now.Add(2 * time.Minute)
+	resp, err = s.Begin(ctx, "k1")
+	require.NoError(t, err)
+	require.Nil(t, resp, "expired key must be forgotten")
+}
+
+func TestValidateKey(t *testing.T) {
+	require.NoError(t, ValidateKey(NewKey()))
+	require.ErrorIs(t, ValidateKey(""), ErrInvalidKey)
+	require.ErrorIs(t, ValidateKey("with space"), ErrInvalidKey)
+	require.ErrorIs(t, ValidateKey(strings.Repeat("a", MaxKeyLength+1)), ErrInvalidKey)
+	require.Equal(t, "agent-1:k", ScopedKey("agent-1", "k"))
+	require.Equal(t, "k", ScopedKey("", "k"))
+}
+
+func TestFallbackStore(t *testing.T) {
+	ctx := context.Background()
+	primary := NewMemoryStore(time.Minute)
+	up := false
+	s := NewFallbackStore(NewMemoryStore(time.Minute), func() bool { return up })
+	s.SetPrimary(primary)
+
+	// Пока основное хранилище недоступно, ключ резервируется и сохраняется в памяти.
+	resp, err := s.Begin(ctx, "k1")
+	require.NoError(t, err)
+	require.Nil(t, resp)
+	up = true
+	require.NoError(t, s.Complete(ctx, "k1", Response{Status: 200, Body: []byte("ok")}))
+	_, err = primary.Begin(ctx, "k1")
+	require.NoError(t, err, "key reserved in memory must not reach the primary store")
+
+	// Ответ, сохраненный в памяти, возвращается и после восстановления.
+	resp, err = s.Begin(ctx, "k1")
+	require.NoError(t, err)
+	require.Equal(t, []byte("ok"), resp.Body)
+
+	resp, err = s.Begin(ctx, "k2")
+	require.NoError(t, err)
+	require.Nil(t, resp)
+	_, err = primary.Begin(ctx, "k2")
+	require.ErrorIs(t, err, ErrInProgress)
+	require.NoError(t, s.Release(ctx, "k2"))
+	resp, err = primary.Begin(ctx, "k2")
+	require.NoError(t, err)
+	require.Nil(t, resp)
+}
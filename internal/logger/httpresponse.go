@@ -41,6 +41,12 @@ func (r *LoggingResponseWriter) Write(b []byte) (int, error) {
 	return size, err
 }
 
+// Unwrap возвращает исходный ResponseWriter, чтобы http.ResponseController мог
+// отправлять данные клиенту сразу (Flush) и управлять таймаутами записи.
+func (r *LoggingResponseWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
 // WriteHeader записывает статус код в ResponseWriter и обновляет информацию
 // о статусе ответа и типе контента.
 func (r *LoggingResponseWriter) WriteHeader(statusCode int) {
@@ -2,21 +2,24 @@ package memcollector
 
 import (
 	"context"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/base64"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/Fuonder/metriccoll.git/internal/certmanager"
 	"net/http"
+	"net/url"
 	"sync"
 	"time"
 
+	"github.com/Fuonder/metriccoll.git/internal/hmacsign"
+	"github.com/Fuonder/metriccoll.git/internal/idempotency"
 	"github.com/Fuonder/metriccoll.git/internal/logger"
+	"github.com/Fuonder/metriccoll.git/internal/metrics"
 	"github.com/Fuonder/metriccoll.git/internal/metrics/middleware"
 	"github.com/Fuonder/metriccoll.git/internal/models"
 	"github.com/Fuonder/metriccoll.git/internal/storage"
+	"github.com/Fuonder/metriccoll.git/internal/subnet"
 	"github.com/go-resty/resty/v2"
 	"github.com/shirou/gopsutil/cpu"
 	"github.com/shirou/gopsutil/mem"
@@ -45,10 +48,14 @@ type MemoryCollector struct {
 	st            storage.Collection
 	remoteIP      string
 	hashKey       string
+	authToken     string
 	cipherManager certmanager.TLSCipher
+	tlsConfig     *tls.Config
+	sender        metrics.Sender
 	jobsCh        chan []byte
 	tData         TimeIntervals
 	wg            sync.WaitGroup
+	stats         agentStats
 }
 
 func NewMemoryCollector(stArg storage.Collection, tData *TimeIntervals, jobsCh chan []byte, cipherManager certmanager.TLSCipher) *MemoryCollector {
@@ -89,6 +96,32 @@ func (c *MemoryCollector) SetHashKey(key string) error {
 	return nil
 }
 
+// SetAuthToken задает API-токен, передаваемый серверу в заголовке Authorization.
+func (c *MemoryCollector) SetAuthToken(token string) error {
+	c.authToken = token
+	return nil
+}
+
+// SetTLSConfig включает отправку метрик по HTTPS с клиентским сертификатом (mTLS).
+func (c *MemoryCollector) SetTLSConfig(cfg *tls.Config) error {
+	c.tlsConfig = cfg
+	return nil
+}
+
+// SetSender задает альтернативный способ отправки метрик (например, через gRPC).
+// Если не задан, метрики отправляются по HTTP методом Post.
+func (c *MemoryCollector) SetSender(sender metrics.Sender) error {
+	c.sender = sender
+	return nil
+}
+
+func (c *MemoryCollector) baseURL() string {
+	if c.tlsConfig != nil {
+		return "https://" + c.remoteIP
+	}
+	return "http://" + c.remoteIP
+}
+
 func getMemoryInfo() ([]models.Metrics, error) {
 	v, err := mem.VirtualMemory()
 	if err != nil {
@@ -147,6 +180,7 @@ func (c *MemoryCollector) collectNewMetrics(ctx context.Context) ([]byte, error)
 			return nil, err
 		}
 		all := append(cpuMetrics, memMetrics...)
+		all = append(all, c.selfMetrics()...)
 		return json.Marshal(all)
 	}
 }
@@ -195,6 +229,7 @@ func (c *MemoryCollector) Collect(ctx context.Context, cancel context.CancelFunc
 				return fmt.Errorf("collect orig: %v", err)
 			}
 			c.jobsCh <- data
+			c.stats.produced.Add(1)
 		}
 	})
 
@@ -209,6 +244,7 @@ func (c *MemoryCollector) Collect(ctx context.Context, cancel context.CancelFunc
 				return fmt.Errorf("collect new: %v", err)
 			}
 			c.jobsCh <- data
+			c.stats.produced.Add(1)
 		}
 	})
 
@@ -244,15 +280,21 @@ func (c *MemoryCollector) WaitWorkers() {
 	c.wg.Wait()
 }
 
-func (c *MemoryCollector) Post(packetBody []byte, remoteURL string) error {
+// Post отправляет пакет метрик packetBody на сервер. Непустой idempotencyKey передается
+// в заголовке Idempotency-Key, чтобы повтор пакета после таймаута не применялся сервером дважды.
+func (c *MemoryCollector) Post(packetBody []byte, remoteURL string, idempotencyKey string) error {
 	if remoteURL == "" {
-		remoteURL = "http://" + c.remoteIP + "/updates/"
+		remoteURL = c.baseURL() + "/updates/"
 	}
 	client := resty.New()
+	if c.tlsConfig != nil {
+		client.SetTLSClientConfig(c.tlsConfig)
+	}
 	cBody, err := middleware.GzipCompress(packetBody)
 	if err != nil {
 		return fmt.Errorf("compress failed: %w", err)
 	}
+	c.ObserveCompression(len(packetBody), len(cBody))
 	cBody, err = c.cipherManager.Cipher(cBody)
 	if err != nil {
 		return fmt.Errorf("cipher failed: %w", err)
@@ -264,11 +306,30 @@ func (c *MemoryCollector) Post(packetBody []byte, remoteURL string) error {
 		SetHeader("Accept-Encoding", "gzip").
 		SetBody(cBody)
 
+	if c.authToken != "" {
+		req.SetAuthToken(c.authToken)
+	}
+	if idempotencyKey != "" {
+		req.SetHeader(idempotency.HeaderKey, idempotencyKey)
+	}
+
+	if ip, err := subnet.OutboundIP(c.remoteIP); err == nil {
+		req.SetHeader(subnet.HeaderRealIP, ip)
+	} else {
+		logger.Log.Warn("can not set X-Real-IP header", zap.Error(err))
+	}
+
 	if c.hashKey != "" {
-		h := hmac.New(sha256.New, []byte(c.hashKey))
-		h.Write(cBody)
-		hash := base64.URLEncoding.EncodeToString(h.Sum(nil))
-		req.SetHeader("HashSHA256", hash)
+		u, err := url.Parse(remoteURL)
+		if err != nil {
+			return fmt.Errorf("invalid remote url: %w", err)
+		}
+		timestamp := hmacsign.Timestamp(time.Now())
+		nonce := hmacsign.NewNonce()
+		req.SetHeader(hmacsign.HeaderTimestamp, timestamp).
+			SetHeader(hmacsign.HeaderNonce, nonce).
+			SetHeader(hmacsign.HeaderSignature,
+				hmacsign.SignRequest(c.hashKey, http.MethodPost, u.RequestURI(), timestamp, nonce, cBody))
 	}
 
 	resp, err := req.Post(remoteURL)
@@ -285,7 +346,10 @@ func (c *MemoryCollector) CheckConnection() error {
 	client := http.Client{
 		Timeout: 5 * time.Second,
 	}
-	resp, err := client.Get("http://" + c.remoteIP)
+	if c.tlsConfig != nil {
+		client.Transport = &http.Transport{TLSClientConfig: c.tlsConfig}
+	}
+	resp, err := client.Get(c.baseURL())
 	if err != nil {
 		return fmt.Errorf("failed to connect to server: %w", err)
 	}
@@ -297,9 +361,23 @@ func (c *MemoryCollector) CheckConnection() error {
 }
 
 func (c *MemoryCollector) worker(idx int, jobs <-chan []byte) error {
+	post := c.Post
+	if c.sender != nil {
+		post = c.sender.Post
+	}
 	for job := range jobs {
-		logger.Log.Info("processing job", zap.Int("worker", idx))
-		err := middleware.RetryableWorkerHTTPSend(c.Post, "", job, 3)
+		logger.Log.Debug("processing job", zap.Int("worker", idx))
+		// Ключ создается один раз на пакет, чтобы все повторы отправки сервер распознал как один запрос.
+		key := idempotency.NewKey()
+		attempts := 0
+		err := middleware.RetryableWorkerHTTPSend(func(data []byte, remoteURL string) error {
+			attempts++
+			start := time.Now()
+			err := post(data, remoteURL, key)
+			c.stats.observeSend(time.Since(start), err)
+			return err
+		}, "", job, 3)
+		c.stats.observeBatch(attempts, err)
 		if err != nil {
 			logger.Log.Debug("sending batch failed", zap.Error(err))
 			return fmt.Errorf("worker %d: %v", idx, err)
@@ -0,0 +1,135 @@
+package memcollector
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/Fuonder/metriccoll.git/internal/models"
+	"github.com/Fuonder/metriccoll.git/internal/selfmetrics"
+)
+
+// SelfMetricsNamespace — пространство имен метрик о работе агента на локальном /metrics.
+const SelfMetricsNamespace = "metriccoll_agent"
+
+// Имена метрик о работе агента, отправляемых на сервер вместе с метриками хоста.
+// Все они передаются как gauge: счетчики содержат итог с момента запуска агента,
+// а не приращение, поэтому потерянный пакет не искажает значения на сервере.
+const (
+	AgentBatchesProduced  = "AgentBatchesProduced"  // Пакетов поставлено в очередь отправки.
+	AgentBatchesSent      = "AgentBatchesSent"      // Пакетов отправлено успешно.
+	AgentBatchesRetried   = "AgentBatchesRetried"   // Повторных попыток отправки.
+	AgentBatchesFailed    = "AgentBatchesFailed"    // Пакетов, не отправленных после всех попыток.
+	AgentQueueDepth       = "AgentQueueDepth"       // Пакетов в очереди отправки.
+	AgentCompressionRatio = "AgentCompressionRatio" // Степень сжатия последнего пакета (исходный размер / сжатый).
+	AgentSendLatency      = "AgentSendLatency"      // Длительность последней успешной отправки, с.
+	AgentLastSuccessTime  = "AgentLastSuccessTime"  // Время последней успешной отправки, Unix-секунды.
+)
+
+// agentStats — показатели работы агента. Обновляются сборщиками и воркерами конкурентно.
+type agentStats struct {
+	produced    atomic.Uint64
+	sent        atomic.Uint64
+	retried     atomic.Uint64
+	failed      atomic.Uint64
+	lastRatio   atomic.Uint64 // math.Float64bits степени сжатия; 0 — сжатия еще не было.
+	lastLatency atomic.Int64  // Длительность последней успешной отправки, нс.
+	lastSuccess atomic.Int64  // Время последней успешной отправки, Unix-нс; 0 — отправок не было.
+
+	// Гистограммы локального /metrics; nil, если он не включен (см. SetSelfMetrics).
+	sendLatency *selfmetrics.Histogram
+	ratio       *selfmetrics.Histogram
+}
+
+// compressionRatioBuckets — границы корзин гистограммы степени сжатия.
+var compressionRatioBuckets = []float64{1, 1.5, 2, 3, 4, 6, 8, 12, 16, 32}
+
+// observeSend учитывает попытку отправки пакета длительностью d, завершившуюся ошибкой err.
+func (s *agentStats) observeSend(d time.Duration, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	} else {
+		s.lastLatency.Store(int64(d))
+		s.lastSuccess.Store(time.Now().UnixNano())
+	}
+	if s.sendLatency != nil {
+		s.sendLatency.Observe(d.Seconds(), result)
+	}
+}
+
+// observeBatch учитывает пакет, отправка которого заняла attempts попыток и завершилась ошибкой err.
+func (s *agentStats) observeBatch(attempts int, err error) {
+	if attempts > 1 {
+		s.retried.Add(uint64(attempts - 1))
+	}
+	if err != nil {
+		s.failed.Add(1)
+		return
+	}
+	s.sent.Add(1)
+}
+
+func (s *agentStats) compressionRatio() float64 {
+	return math.Float64frombits(s.lastRatio.Load())
+}
+
+// ObserveCompression учитывает сжатие пакета размером given байт до compressed байт.
+// Post вызывает его сам; отправщики с собственным сжатием получают его как
+// middleware.CompressionObserver (см. grpcsender.Sender.SetCompressionObserver).
+func (c *MemoryCollector) ObserveCompression(given int, compressed int) {
+	if compressed <= 0 {
+		return
+	}
+	ratio := float64(given) / float64(compressed)
+	c.stats.lastRatio.Store(math.Float64bits(ratio))
+	if c.stats.ratio != nil {
+		c.stats.ratio.Observe(ratio)
+	}
+}
+
+// SetSelfMetrics регистрирует показатели работы агента в reg для локального /metrics.
+// Вызывается до запуска воркеров.
+func (c *MemoryCollector) SetSelfMetrics(reg *selfmetrics.Registry) {
+	counter := func(v *atomic.Uint64) func() float64 {
+		return func() float64 { return float64(v.Load()) }
+	}
+	reg.NewCounterFunc("batches_produced_total", "Batches queued for sending.", counter(&c.stats.produced))
+	reg.NewCounterFunc("batches_sent_total", "Batches sent successfully.", counter(&c.stats.sent))
+	reg.NewCounterFunc("batches_retried_total", "Repeated attempts to send a batch.", counter(&c.stats.retried))
+	reg.NewCounterFunc("batches_failed_total", "Batches dropped after all send attempts failed.", counter(&c.stats.failed))
+	reg.NewGaugeFunc("queue_depth", "Batches waiting in the send queue.", func() float64 {
+		return float64(len(c.jobsCh))
+	})
+	reg.NewGaugeFunc("last_success_timestamp_seconds", "Time of the last successful send.", func() float64 {
+		return float64(c.stats.lastSuccess.Load()) / float64(time.Second)
+	})
+	c.stats.sendLatency = reg.NewHistogram("send_duration_seconds",
+		"Duration of batch send attempts.", selfmetrics.DefaultLatencyBuckets, "result")
+	c.stats.ratio = reg.NewHistogram("compression_ratio",
+		"Ratio of original to gzip-compressed batch size.", compressionRatioBuckets)
+}
+
+// selfMetrics возвращает показатели работы агента для отправки на сервер.
+// Степень сжатия, длительность и время отправки передаются после первой успешной отправки.
+func (c *MemoryCollector) selfMetrics() []models.Metrics {
+	gauge := func(id string, v float64) models.Metrics {
+		return models.Metrics{ID: id, MType: "gauge", Value: &v}
+	}
+	all := []models.Metrics{
+		gauge(AgentBatchesProduced, float64(c.stats.produced.Load())),
+		gauge(AgentBatchesSent, float64(c.stats.sent.Load())),
+		gauge(AgentBatchesRetried, float64(c.stats.retried.Load())),
+		gauge(AgentBatchesFailed, float64(c.stats.failed.Load())),
+		gauge(AgentQueueDepth, float64(len(c.jobsCh))),
+	}
+	if ratio := c.stats.compressionRatio(); ratio > 0 {
+		all = append(all, gauge(AgentCompressionRatio, ratio))
+	}
+	if last := c.stats.lastSuccess.Load(); last > 0 {
+		all = append(all,
+			gauge(AgentSendLatency, time.Duration(c.stats.lastLatency.Load()).Seconds()),
+			gauge(AgentLastSuccessTime, float64(last)/float64(time.Second)))
+	}
+	return all
+}
@@ -0,0 +1,80 @@
+package memcollector
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Fuonder/metriccoll.git/internal/logger"
+	"github.com/Fuonder/metriccoll.git/internal/selfmetrics"
+	"github.com/stretchr/testify/require"
+)
+
+// flakySender отклоняет первые failures попыток отправки.
+type flakySender struct {
+	failures int
+	calls    int
+}
+
+func (s *flakySender) SetHashKey(string) error { return nil }
+
+func (s *flakySender) Post([]byte, string, string) error {
+	s.calls++
+	if s.calls <= s.failures {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func (s *flakySender) CheckConnection() error { return nil }
+
+func selfMetricsByID(c *MemoryCollector) map[string]float64 {
+	values := make(map[string]float64)
+	for _, m := range c.selfMetrics() {
+		values[m.ID] = *m.Value
+	}
+	return values
+}
+
+func TestSelfMetrics(t *testing.T) {
+	require.NoError(t, logger.Initialize("Error"))
+	jobs := make(chan []byte, 2)
+	c := NewMemoryCollector(nil, NewTimeIntervals(0, 0), jobs, nil)
+	reg := selfmetrics.NewRegistry(SelfMetricsNamespace)
+	c.SetSelfMetrics(reg)
+
+	values := selfMetricsByID(c)
+	require.NotContains(t, values, AgentLastSuccessTime, "no successful send yet")
+	require.NotContains(t, values, AgentCompressionRatio)
+
+	jobs <- []byte(`[]`)
+	c.stats.produced.Add(1)
+	require.Equal(t, float64(1), selfMetricsByID(c)[AgentQueueDepth])
+
+	sender := &flakySender{failures: 1}
+	require.NoError(t, c.SetSender(sender))
+	close(jobs)
+	require.NoError(t, c.worker(0, jobs))
+	c.ObserveCompression(1000, 250)
+
+	values = selfMetricsByID(c)
+	require.Equal(t, float64(1), values[AgentBatchesProduced])
+	require.Equal(t, float64(1), values[AgentBatchesSent])
+	require.Equal(t, float64(1), values[AgentBatchesRetried])
+	require.Equal(t, float64(0), values[AgentBatchesFailed])
+	require.Equal(t, float64(0), values[AgentQueueDepth])
+	require.Equal(t, float64(4), values[AgentCompressionRatio])
+	require.Positive(t, values[AgentLastSuccessTime])
+	for _, m := range c.selfMetrics() {
+		require.Equal(t, "gauge", m.MType)
+	}
+
+	var sb strings.Builder
+	require.NoError(t, reg.WriteText(&sb))
+	text := sb.String()
+	require.Contains(t, text, "metriccoll_agent_batches_sent_total 1\n")
+	require.Contains(t, text, "metriccoll_agent_batches_retried_total 1\n")
+	require.Contains(t, text, `metriccoll_agent_send_duration_seconds_count{result="error"} 1`)
+	require.Contains(t, text, `metriccoll_agent_send_duration_seconds_count{result="ok"} 1`)
+	require.Contains(t, text, "metriccoll_agent_compression_ratio_count 1\n")
+}
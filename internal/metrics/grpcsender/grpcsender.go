@@ -0,0 +1,272 @@
+// Package grpcsender реализует отправку метрик агента на сервер через gRPC-API
+// вместо HTTP. Пакеты сжимаются и подписываются так же, как при отправке по HTTP,
+// а шифруются сеансовым ключом AES, зашифрованным открытым ключом сервера.
+package grpcsender
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/Fuonder/metriccoll.git/internal/certmanager"
+	"github.com/Fuonder/metriccoll.git/internal/grpcserver"
+	"github.com/Fuonder/metriccoll.git/internal/hmacsign"
+	"github.com/Fuonder/metriccoll.git/internal/logger"
+	"github.com/Fuonder/metriccoll.git/internal/metrics/middleware"
+	"github.com/Fuonder/metriccoll.git/internal/models"
+	pb "github.com/Fuonder/metriccoll.git/internal/proto"
+	"github.com/Fuonder/metriccoll.git/internal/subnet"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+var ErrCouldNotSendRequest = errors.New("could not send request")
+
+const requestTimeout = 10 * time.Second
+
+// Sender отправляет пакеты метрик потоком Metrics/StreamMetrics.
+// Соединение с сервером создается при первой отправке и переиспользуется, как и открытые
+// потоки: каждый поток в любой момент занят одним вызовом Post, а параллельные вызовы
+// получают разные потоки.
+type Sender struct {
+	address       string
+	hashKey       string
+	authToken     string
+	cipherManager certmanager.TLSCipher
+	tlsConfig     *tls.Config
+	observe       middleware.CompressionObserver
+
+	mu      sync.Mutex
+	conn    *grpc.ClientConn
+	streams []*batchStream // свободные потоки
+}
+
+// batchStream — открытый поток StreamMetrics.
+type batchStream struct {
+	stream pb.Metrics_StreamMetricsClient
+	cancel context.CancelFunc
+}
+
+// NewSender создает Sender для gRPC-сервера address.
+func NewSender(address string, cipherManager certmanager.TLSCipher) *Sender {
+	return &Sender{address: address, cipherManager: cipherManager}
+}
+
+func (s *Sender) SetHashKey(key string) error {
+	s.hashKey = key
+	return nil
+}
+
+// SetAuthToken задает API-токен, передаваемый серверу в метаданных authorization.
+func (s *Sender) SetAuthToken(token string) error {
+	s.authToken = token
+	return nil
+}
+
+// SetTLSConfig включает TLS с клиентским сертификатом (mTLS).
+func (s *Sender) SetTLSConfig(cfg *tls.Config) error {
+	s.tlsConfig = cfg
+	return nil
+}
+
+// SetCompressionObserver задает получателя размеров пакетов до и после сжатия.
+func (s *Sender) SetCompressionObserver(fn middleware.CompressionObserver) error {
+	s.observe = fn
+	return nil
+}
+
+func (s *Sender) client() (pb.MetricsClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		creds := insecure.NewCredentials()
+		if s.tlsConfig != nil {
+			creds = credentials.NewTLS(s.tlsConfig)
+		}
+		conn, err := grpc.NewClient(s.address, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return nil, err
+		}
+		s.conn = conn
+	}
+	return pb.NewMetricsClient(s.conn), nil
+}
+
+// acquireStream возвращает свободный поток или открывает новый. Признак reused
+// сообщает, что поток уже использовался и мог быть закрыт сервером.
+func (s *Sender) acquireStream() (bs *batchStream, reused bool, err error) {
+	s.mu.Lock()
+	if n := len(s.streams); n > 0 {
+		bs = s.streams[n-1]
+		s.streams = s.streams[:n-1]
+		s.mu.Unlock()
+		return bs, true, nil
+	}
+	s.mu.Unlock()
+
+	client, err := s.client()
+	if err != nil {
+		return nil, false, err
+	}
+	md := metadata.MD{}
+	if s.authToken != "" {
+		md.Set("authorization", "Bearer "+s.authToken)
+	}
+	if ip, err := subnet.OutboundIP(s.address); err == nil {
+		md.Set(grpcserver.MetadataRealIP, ip)
+	} else {
+		logger.Log.Warn("can not set x-real-ip metadata", zap.Error(err))
+	}
+	ctx, cancel := context.WithCancel(metadata.NewOutgoingContext(context.Background(), md))
+	stream, err := client.StreamMetrics(ctx)
+	if err != nil {
+		cancel()
+		return nil, false, err
+	}
+	return &batchStream{stream: stream, cancel: cancel}, false, nil
+}
+
+// releaseStream возвращает исправный поток в список свободных.
+func (s *Sender) releaseStream(bs *batchStream) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		// Sender закрыт, пока поток был занят.
+		bs.cancel()
+		return
+	}
+	s.streams = append(s.streams, bs)
+}
+
+// buildRequest формирует запрос из JSON-пакета метрик. Если шифрование настроено,
+// метрики передаются в поле encrypted_metrics в сжатом и зашифрованном виде.
+func (s *Sender) buildRequest(packetBody []byte) (*pb.UpdateMetricsRequest, error) {
+	var metrics []models.Metrics
+	if err := json.Unmarshal(packetBody, &metrics); err != nil {
+		return nil, fmt.Errorf("decode metrics: %w", err)
+	}
+	batch := &pb.MetricBatch{Metrics: pb.FromModels(metrics)}
+	data, err := proto.Marshal(batch)
+	if err != nil {
+		return nil, fmt.Errorf("encode metrics: %w", err)
+	}
+	cBody, err := middleware.GzipCompress(data)
+	if err != nil {
+		return nil, fmt.Errorf("compress failed: %w", err)
+	}
+	if s.observe != nil {
+		s.observe(len(data), len(cBody))
+	}
+	key, nonce, cBody, err := certmanager.SealHybrid(s.cipherManager, cBody)
+	if err != nil {
+		return nil, fmt.Errorf("cipher failed: %w", err)
+	}
+	if len(key) == 0 {
+		return &pb.UpdateMetricsRequest{Metrics: batch.GetMetrics()}, nil
+	}
+	return &pb.UpdateMetricsRequest{EncryptedMetrics: cBody, EncryptedKey: key, Nonce: nonce}, nil
+}
+
+// buildStreamRequest оборачивает пакет в сообщение потока и подписывает его так же,
+// как вызов UpdateMetrics.
+func (s *Sender) buildStreamRequest(batch *pb.UpdateMetricsRequest, idempotencyKey string) (*pb.StreamMetricsRequest, error) {
+	req := &pb.StreamMetricsRequest{Batch: batch, IdempotencyKey: idempotencyKey}
+	if s.hashKey != "" {
+		body, err := proto.MarshalOptions{Deterministic: true}.Marshal(batch)
+		if err != nil {
+			return nil, fmt.Errorf("encode request: %w", err)
+		}
+		req.Timestamp = hmacsign.Timestamp(time.Now())
+		req.Nonce = hmacsign.NewNonce()
+		req.Signature = hmacsign.SignRequest(s.hashKey, "POST", pb.Metrics_UpdateMetrics_FullMethodName, req.Timestamp, req.Nonce, body)
+	}
+	return req, nil
+}
+
+// exchange отправляет сообщение в поток и ждет ответа не дольше requestTimeout.
+func exchange(bs *batchStream, req *pb.StreamMetricsRequest) error {
+	timer := time.AfterFunc(requestTimeout, bs.cancel)
+	err := bs.stream.Send(req)
+	if err == nil || errors.Is(err, io.EOF) {
+		// При io.EOF поток уже завершен, и настоящую ошибку возвращает Recv.
+		_, err = bs.stream.Recv()
+	}
+	if !timer.Stop() && err == nil {
+		err = context.DeadlineExceeded
+	}
+	return err
+}
+
+// Post отправляет пакет метрик packetBody в формате JSON. Параметр remoteURL не используется
+// и оставлен для совместимости с metrics.Sender. Непустой idempotencyKey передается
+// вместе с пакетом, чтобы повтор пакета не применялся сервером дважды.
+func (s *Sender) Post(packetBody []byte, _ string, idempotencyKey string) error {
+	batch, err := s.buildRequest(packetBody)
+	if err != nil {
+		return err
+	}
+	req, err := s.buildStreamRequest(batch, idempotencyKey)
+	if err != nil {
+		return err
+	}
+
+	for {
+		bs, reused, err := s.acquireStream()
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrCouldNotSendRequest, err)
+		}
+		err = exchange(bs, req)
+		if err == nil {
+			s.releaseStream(bs)
+			return nil
+		}
+		bs.cancel()
+		// Соединение свободного потока могло быть разорвано, пока поток не использовался
+		// (например, при перезапуске сервера). Такой пакет повторяется в новом потоке
+		// с тем же ключом идемпотентности, как повторяет отправку агент.
+		if !reused || status.Code(err) != codes.Unavailable {
+			return fmt.Errorf("%w: %v", ErrCouldNotSendRequest, err)
+		}
+	}
+}
+
+// CheckConnection проверяет доступность сервера вызовом Ping.
+func (s *Sender) CheckConnection() error {
+	client, err := s.client()
+	if err != nil {
+		return fmt.Errorf("failed to connect to server: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := client.Ping(ctx, &pb.PingRequest{}); err != nil {
+		return fmt.Errorf("failed to connect to server: %w", err)
+	}
+	return nil
+}
+
+// Close закрывает свободные потоки и соединение с сервером.
+func (s *Sender) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, bs := range s.streams {
+		bs.cancel()
+	}
+	s.streams = nil
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
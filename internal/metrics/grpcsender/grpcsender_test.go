@@ -0,0 +1,130 @@
+package grpcsender
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Fuonder/metriccoll.git/internal/auth"
+	"github.com/Fuonder/metriccoll.git/internal/certmanager"
+	"github.com/Fuonder/metriccoll.git/internal/grpcserver"
+	pb "github.com/Fuonder/metriccoll.git/internal/proto"
+	"github.com/Fuonder/metriccoll.git/internal/server"
+	"github.com/Fuonder/metriccoll.git/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+func startServer(t *testing.T, settings grpcserver.Settings) (string, *storage.JSONStorage) {
+	st, err := storage.NewJSONStorage(storage.NewFileStoreInfo(filepath.Join(t.TempDir(), "metrics.json"), time.Hour, false))
+	require.NoError(t, err)
+	address, _ := serve(t, "127.0.0.1:0", settings, st)
+	return address, st
+}
+
+func serve(t *testing.T, address string, settings grpcserver.Settings, st *storage.JSONStorage) (string, *grpc.Server) {
+	listener, err := net.Listen("tcp", address)
+	require.NoError(t, err)
+	srv := grpc.NewServer(grpcserver.NewInterceptors(settings).ServerOptions()...)
+	pb.RegisterMetricsServer(srv, grpcserver.NewMetricsServer(st, server.NewHandler(st, st, nil, nil, nil, "")))
+	go func() { _ = srv.Serve(listener) }()
+	t.Cleanup(srv.Stop)
+	return listener.Addr().String(), srv
+}
+
+func TestSenderPostEncryptedSignedBatches(t *testing.T) {
+	decipher, err := certmanager.NewCertManager()
+	require.NoError(t, err)
+	require.NoError(t, decipher.LoadPrivateKey("../../../certs/server.key"))
+	address, st := startServer(t, grpcserver.Settings{
+		HashKeys:   []string{"secret"},
+		StrictHMAC: true,
+		Decipher:   decipher,
+	})
+
+	cipher, err := certmanager.NewCertManager()
+	require.NoError(t, err)
+	require.NoError(t, cipher.LoadCertificate("../../../certs/server.crt"))
+	sender := NewSender(address, cipher)
+	require.NoError(t, sender.SetHashKey("secret"))
+	t.Cleanup(func() { _ = sender.Close() })
+
+	// Пакет больше одного блока RSA и параллельные отправки через разные потоки.
+	var body []byte
+	body = append(body, '[')
+	for i := 0; i < 200; i++ {
+		body = append(body, fmt.Sprintf(`{"id":"Gauge%d","type":"gauge","value":1},`, i)...)
+	}
+	body = append(body, `{"id":"PollCount","type":"counter","delta":1}]`...)
+
+	const workers = 4
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*2)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 2; i++ {
+				errs <- sender.Post(body, "", "")
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	m, err := st.GetMetricByName("PollCount", "counter")
+	require.NoError(t, err)
+	assert.Equal(t, int64(workers*2), *m.Delta)
+	assert.Len(t, st.GetAllMetrics(), 201)
+}
+
+func TestSenderReusesStream(t *testing.T) {
+	st, err := storage.NewJSONStorage(storage.NewFileStoreInfo(filepath.Join(t.TempDir(), "metrics.json"), time.Hour, false))
+	require.NoError(t, err)
+	address, srv := serve(t, "127.0.0.1:0", grpcserver.Settings{}, st)
+	sender := NewSender(address, &certmanager.CertManager{})
+	t.Cleanup(func() { _ = sender.Close() })
+	var observed int
+	require.NoError(t, sender.SetCompressionObserver(func(given int, compressed int) {
+		assert.Positive(t, given)
+		assert.Positive(t, compressed)
+		observed++
+	}))
+
+	body := []byte(`[{"id":"PollCount","type":"counter","delta":1}]`)
+	require.NoError(t, sender.Post(body, "", "batch-1"))
+	require.NoError(t, sender.Post(body, "", "batch-2"))
+	require.Len(t, sender.streams, 1)
+
+	// После перезапуска сервера свободный поток закрыт, и пакет уходит в новом потоке.
+	srv.Stop()
+	serve(t, address, grpcserver.Settings{}, st)
+	require.NoError(t, sender.Post(body, "", "batch-3"))
+
+	m, err := st.GetMetricByName("PollCount", "counter")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), *m.Delta)
+	assert.Equal(t, 3, observed)
+}
+
+func TestSenderCheckConnectionWithWriteToken(t *testing.T) {
+	authorizer, err := auth.NewAuthorizer(auth.Config{RequireRead: true, Tokens: []auth.TokenConfig{
+		{ID: "agent", Token: "w-token", Scopes: []string{"write:"}},
+	}})
+	require.NoError(t, err)
+	address, _ := startServer(t, grpcserver.Settings{Authorizer: authorizer})
+
+	sender := NewSender(address, &certmanager.CertManager{})
+	require.NoError(t, sender.SetAuthToken("w-token"))
+	t.Cleanup(func() { _ = sender.Close() })
+
+	assert.NoError(t, sender.CheckConnection())
+	assert.NoError(t, sender.Post([]byte(`[{"id":"PollCount","type":"counter","delta":1}]`), "", ""))
+}
@@ -9,6 +9,9 @@ import (
 	"go.uber.org/zap"
 )
 
+// CompressionObserver получает размер данных до (given) и после (compressed) сжатия GzipCompress.
+type CompressionObserver func(given int, compressed int)
+
 func GzipCompress(data []byte) ([]byte, error) {
 	var buffer bytes.Buffer
 	writer, err := gzip.NewWriterLevel(&buffer, gzip.BestCompression)
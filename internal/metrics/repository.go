@@ -15,6 +15,8 @@ type Collector interface {
 }
 type Sender interface {
 	SetHashKey(key string) error
-	Post(packetBody []byte, remoteURL string) error
+	// Post отправляет пакет метрик. Все повторы одного пакета должны передавать
+	// один и тот же idempotencyKey; пустой ключ отключает защиту от повторного применения.
+	Post(packetBody []byte, remoteURL string, idempotencyKey string) error
 	CheckConnection() error
 }
@@ -5,8 +5,18 @@
 package models
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
+	"time"
+)
+
+var (
+	ErrEmptyMetricID         = errors.New("empty metric id")
+	ErrUnsupportedMetricType = errors.New("unsupported metric type")
+	ErrMissingMetricValue    = errors.New("missing metric value")
+	// ErrInvalidMetric оборачивает ошибку Validate, когда пакет отклонен из-за недопустимого элемента.
+	ErrInvalidMetric = errors.New("invalid metric")
 )
 
 // Int64Ptr — функция (макрос), которая принимает int64 и возвращает ссылку на него.
@@ -67,4 +77,40 @@ type Metrics struct {
 	Delta *int64 `json:"delta,omitempty"`
 	// Значение метрики типа Gauge
 	Value *float64 `json:"value,omitempty"`
+	// Время последнего обновления серии, заполняется хранилищем
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	// Признак устаревшей серии: значение не обновлялось дольше заданного TTL
+	Stale bool `json:"stale,omitempty"`
+}
+
+// MetricKey однозначно определяет метрику в хранилище: имя и тип.
+type MetricKey struct {
+	ID    string
+	MType string
+}
+
+// Key возвращает ключ метрики.
+func (m Metrics) Key() MetricKey {
+	return MetricKey{ID: m.ID, MType: m.MType}
+}
+
+// Validate проверяет, что метрика может быть сохранена: имя не пустое, тип поддерживается,
+// а значение задано в поле, соответствующем типу (Delta для counter, Value для gauge).
+func (m Metrics) Validate() error {
+	if m.ID == "" {
+		return ErrEmptyMetricID
+	}
+	switch m.MType {
+	case Gauge(0).Type():
+		if m.Value == nil {
+			return fmt.Errorf("%w: gauge requires \"value\"", ErrMissingMetricValue)
+		}
+	case Counter(0).Type():
+		if m.Delta == nil {
+			return fmt.Errorf("%w: counter requires \"delta\"", ErrMissingMetricValue)
+		}
+	default:
+		return fmt.Errorf("%w: %q", ErrUnsupportedMetricType, m.MType)
+	}
+	return nil
 }
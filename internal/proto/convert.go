@@ -0,0 +1,43 @@
+// Package pb содержит сгенерированный из proto/metrics.proto код gRPC-API сервера метрик
+// и функции преобразования между сообщениями protobuf и models.Metrics.
+package pb
+
+import "github.com/Fuonder/metriccoll.git/internal/models"
+
+// FromModel преобразует models.Metrics в сообщение Metric.
+func FromModel(m models.Metrics) *Metric {
+	return &Metric{
+		Id:    m.ID,
+		Type:  m.MType,
+		Delta: m.Delta,
+		Value: m.Value,
+	}
+}
+
+// ToModel преобразует сообщение Metric в models.Metrics.
+func ToModel(m *Metric) models.Metrics {
+	return models.Metrics{
+		ID:    m.GetId(),
+		MType: m.GetType(),
+		Delta: m.Delta,
+		Value: m.Value,
+	}
+}
+
+// FromModels преобразует список models.Metrics в список сообщений Metric.
+func FromModels(ms []models.Metrics) []*Metric {
+	res := make([]*Metric, 0, len(ms))
+	for _, m := range ms {
+		res = append(res, FromModel(m))
+	}
+	return res
+}
+
+// ToModels преобразует список сообщений Metric в список models.Metrics.
+func ToModels(ms []*Metric) []models.Metrics {
+	res := make([]models.Metrics, 0, len(ms))
+	for _, m := range ms {
+		res = append(res, ToModel(m))
+	}
+	return res
+}
@@ -0,0 +1,280 @@
+// Package selfmetrics реализует метрики о работе самих сервисов (запросы, задержки, ошибки).
+//
+// Метрики регистрируются в Registry с общим пространством имен и отдаются в текстовом
+// формате Prometheus (см. Registry.WriteText и Registry.Handler). Для каждой метрики
+// задается набор меток; значения меток передаются при каждом обновлении в том же порядке.
+package selfmetrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Kind — тип метрики.
+type Kind string
+
+const (
+	KindCounter   Kind = "counter"
+	KindGauge     Kind = "gauge"
+	KindHistogram Kind = "histogram"
+)
+
+// DefaultLatencyBuckets — границы корзин гистограмм длительности в секундах.
+var DefaultLatencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// DefaultSizeBuckets — границы корзин гистограмм размера пакетов (количество метрик).
+var DefaultSizeBuckets = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 5000, 10000}
+
+// series — значение метрики для одного набора значений меток.
+type series struct {
+	labels []string
+	value  float64  // Значение счетчика или gauge.
+	counts []uint64 // Количество наблюдений по корзинам гистограммы (не накопительное).
+	sum    float64
+	count  uint64
+}
+
+// metric — зарегистрированная метрика со всеми ее сериями.
+type metric struct {
+	name    string
+	help    string
+	kind    Kind
+	labels  []string
+	buckets []float64
+	fn      func() float64 // Источник значения метрики без меток (NewCounterFunc, NewGaugeFunc).
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// get возвращает серию для значений меток values, создавая ее при необходимости.
+// Вызывается под m.mu.
+func (m *metric) get(values []string) *series {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("selfmetrics: %s: got %d label values, want %d", m.name, len(values), len(m.labels)))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labels: append([]string(nil), values...)}
+		if m.kind == KindHistogram {
+			s.counts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+// Counter — монотонно растущий счетчик.
+type Counter struct{ m *metric }
+
+// Inc увеличивает счетчик серии с метками values на 1.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add увеличивает счетчик серии с метками values на v (v >= 0).
+func (c *Counter) Add(v float64, values ...string) {
+	if v < 0 {
+		return
+	}
+	c.m.mu.Lock()
+	c.m.get(values).value += v
+	c.m.mu.Unlock()
+}
+
+// Gauge — значение, которое может как расти, так и уменьшаться.
+type Gauge struct{ m *metric }
+
+// Set задает значение серии с метками values.
+func (g *Gauge) Set(v float64, values ...string) {
+	g.m.mu.Lock()
+	g.m.get(values).value = v
+	g.m.mu.Unlock()
+}
+
+// Add изменяет значение серии с метками values на v.
+func (g *Gauge) Add(v float64, values ...string) {
+	g.m.mu.Lock()
+	g.m.get(values).value += v
+	g.m.mu.Unlock()
+}
+
+// Histogram — распределение наблюдаемых значений по корзинам.
+type Histogram struct{ m *metric }
+
+// Observe добавляет наблюдение v в серию с метками values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.m.mu.Lock()
+	defer h.m.mu.Unlock()
+	s := h.m.get(values)
+	if i := sort.SearchFloat64s(h.m.buckets, v); i < len(s.counts) {
+		s.counts[i]++
+	}
+	s.sum += v
+	s.count++
+}
+
+// Registry — набор метрик с общим пространством имен.
+type Registry struct {
+	namespace string
+	mu        sync.RWMutex
+	metrics   map[string]*metric
+}
+
+// NewRegistry создает Registry; имена метрик получают префикс namespace_.
+func NewRegistry(namespace string) *Registry {
+	return &Registry{namespace: namespace, metrics: make(map[string]*metric)}
+}
+
+// register добавляет метрику. Повторная регистрация метрики с тем же именем
+// возвращает уже зарегистрированную метрику, если совпадают тип и метки.
+func (r *Registry) register(m *metric) *metric {
+	if r.namespace != "" {
+		m.name = r.namespace + "_" + m.name
+	}
+	m.series = make(map[string]*series)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.metrics[m.name]; ok {
+		if existing.kind != m.kind || strings.Join(existing.labels, ",") != strings.Join(m.labels, ",") {
+			panic(fmt.Sprintf("selfmetrics: %s is already registered with another type or labels", m.name))
+		}
+		return existing
+	}
+	r.metrics[m.name] = m
+	return m
+}
+
+// NewCounter регистрирует счетчик с метками labels.
+func (r *Registry) NewCounter(name string, help string, labels ...string) *Counter {
+	return &Counter{m: r.register(&metric{name: name, help: help, kind: KindCounter, labels: labels})}
+}
+
+// NewCounterFunc регистрирует счетчик без меток, значение которого вычисляется fn при каждом чтении.
+func (r *Registry) NewCounterFunc(name string, help string, fn func() float64) {
+	r.register(&metric{name: name, help: help, kind: KindCounter, fn: fn})
+}
+
+// NewGauge регистрирует gauge с метками labels.
+func (r *Registry) NewGauge(name string, help string, labels ...string) *Gauge {
+	return &Gauge{m: r.register(&metric{name: name, help: help, kind: KindGauge, labels: labels})}
+}
+
+// NewGaugeFunc регистрирует gauge без меток, значение которого вычисляется fn при каждом чтении.
+func (r *Registry) NewGaugeFunc(name string, help string, fn func() float64) {
+	r.register(&metric{name: name, help: help, kind: KindGauge, fn: fn})
+}
+
+// NewHistogram регистрирует гистограмму с границами корзин buckets (по возрастанию) и метками labels.
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Histogram{m: r.register(&metric{name: name, help: help, kind: KindHistogram, labels: labels, buckets: buckets})}
+}
+
+// sorted возвращает метрики, упорядоченные по имени.
+func (r *Registry) sorted() []*metric {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	metrics := make([]*metric, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		metrics = append(metrics, m)
+	}
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name < metrics[j].name })
+	return metrics
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels возвращает метки серии в виде {a="1",b="2"}; extra добавляется последней (le гистограмм).
+func formatLabels(names []string, values []string, extra string) string {
+	if len(names) == 0 && extra == "" {
+		return ""
+	}
+	parts := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		parts = append(parts, name+`="`+labelEscaper.Replace(values[i])+`"`)
+	}
+	if extra != "" {
+		parts = append(parts, extra)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// writeMetric записывает метрику m в текстовом формате Prometheus.
+// Ошибки записи в w накапливаются в bufio.Writer и возвращаются его Flush.
+func writeMetric(w *bufio.Writer, m *metric) {
+	printf := func(format string, args ...any) {
+		_, _ = fmt.Fprintf(w, format, args...)
+	}
+	printf("# HELP %s %s\n", m.name, strings.ReplaceAll(m.help, "\n", " "))
+	printf("# TYPE %s %s\n", m.name, m.kind)
+	if m.fn != nil {
+		printf("%s %s\n", m.name, formatFloat(m.fn()))
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := m.series[key]
+		if m.kind != KindHistogram {
+			printf("%s%s %s\n", m.name, formatLabels(m.labels, s.labels, ""), formatFloat(s.value))
+			continue
+		}
+		var cumulative uint64
+		for i, bound := range m.buckets {
+			cumulative += s.counts[i]
+			printf("%s_bucket%s %d\n", m.name,
+				formatLabels(m.labels, s.labels, `le="`+formatFloat(bound)+`"`), cumulative)
+		}
+		printf("%s_bucket%s %d\n", m.name, formatLabels(m.labels, s.labels, `le="+Inf"`), s.count)
+		printf("%s_sum%s %s\n", m.name, formatLabels(m.labels, s.labels, ""), formatFloat(s.sum))
+		printf("%s_count%s %d\n", m.name, formatLabels(m.labels, s.labels, ""), s.count)
+	}
+}
+
+// WriteText записывает все метрики в текстовом формате Prometheus.
+func (r *Registry) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, m := range r.sorted() {
+		writeMetric(bw, m)
+	}
+	return bw.Flush()
+}
+
+// ContentType — тип содержимого ответа Handler.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler возвращает HTTP-обработчик, отдающий метрики в текстовом формате Prometheus.
+func (r *Registry) Handler() http.HandlerFunc {
+	return func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("Content-Type", ContentType)
+		rw.WriteHeader(http.StatusOK)
+		_ = r.WriteText(rw)
+	}
+}
@@ -0,0 +1,80 @@
+package selfmetrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryWriteText(t *testing.T) {
+	reg := NewRegistry("test")
+	requests := reg.NewCounter("requests_total", "Requests.", "route", "status")
+	inFlight := reg.NewGauge("in_flight", "In-flight requests.")
+	reg.NewGaugeFunc("answer", "Constant.", func() float64 { return 42 })
+	reg.NewCounterFunc("ticks_total", "Ticks.", func() float64 { return 7 })
+	latency := reg.NewHistogram("latency_seconds", "Latency.", []float64{1, 0.1}, "route")
+
+	requests.Inc("/update/", "200")
+	requests.Add(2, "/update/", "200")
+	requests.Add(-1, "/update/", "200")
+	requests.Inc(`/a"b\c`, "400")
+	inFlight.Set(3)
+	inFlight.Add(-1)
+	latency.Observe(0.05, "/")
+	latency.Observe(0.1, "/")
+	latency.Observe(0.5, "/")
+	latency.Observe(3, "/")
+
+	var sb strings.Builder
+	require.NoError(t, reg.WriteText(&sb))
+	require.Equal(t, `# HELP test_answer Constant.
+# TYPE test_answer gauge
+test_answer 42
+# HELP test_in_flight In-flight requests.
+# TYPE test_in_flight gauge
+test_in_flight 2
+# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{route="/",le="0.1"} 2
+test_latency_seconds_bucket{route="/",le="1"} 3
+test_latency_seconds_bucket{route="/",le="+Inf"} 4
+test_latency_seconds_sum{route="/"} 3.65
+test_latency_seconds_count{route="/"} 4
+# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{route="/a\"b\\c",status="400"} 1
+test_requests_total{route="/update/",status="200"} 3
+# HELP test_ticks_total Ticks.
+# TYPE test_ticks_total counter
+test_ticks_total 7
+`, sb.String())
+}
+
+func TestRegistryRegister(t *testing.T) {
+	reg := NewRegistry("")
+	c1 := reg.NewCounter("hits", "Hits.", "route")
+	c2 := reg.NewCounter("hits", "Hits.", "route")
+	c1.Inc("/")
+	c2.Inc("/")
+
+	var sb strings.Builder
+	require.NoError(t, reg.WriteText(&sb))
+	require.Contains(t, sb.String(), "hits{route=\"/\"} 2\n", "re-registration returns the same metric")
+
+	require.Panics(t, func() { reg.NewGauge("hits", "Hits.", "route") })
+	require.Panics(t, func() { c1.Inc() }, "label values must match label names")
+}
+
+func TestRegistryHandler(t *testing.T) {
+	reg := NewRegistry("test")
+	reg.NewCounter("hits_total", "Hits.").Inc()
+
+	rr := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, ContentType, rr.Header().Get("Content-Type"))
+	require.Contains(t, rr.Body.String(), "test_hits_total 1\n")
+}
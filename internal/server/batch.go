@@ -0,0 +1,139 @@
+// Package server содержит обработку пакетного обновления метрик с результатом по каждому элементу.
+// batch.go реализует режимы atomic и partial endpoint'а /updates/.
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/Fuonder/metriccoll.git/internal/logger"
+	"github.com/Fuonder/metriccoll.git/internal/models"
+	"go.uber.org/zap"
+)
+
+// Режимы пакетного обновления, задаваемые параметром запроса mode.
+const (
+	// BatchModeAtomic — пакет применяется, только если все метрики прошли проверку.
+	BatchModeAtomic = "atomic"
+	// BatchModePartial — применяются метрики, прошедшие проверку, остальные отклоняются.
+	BatchModePartial = "partial"
+)
+
+// Статусы элемента пакета.
+const (
+	BatchItemAccepted = "accepted"
+	BatchItemRejected = "rejected"
+)
+
+// errBatchNotApplied — причина отказа для корректных метрик пакета, не примененного в режиме atomic.
+const errBatchNotApplied = "not applied: batch contains rejected metrics"
+
+// BatchItemResult описывает результат обработки одной метрики пакета.
+type BatchItemResult struct {
+	Index  int             `json:"index"`            // Позиция метрики в запросе.
+	ID     string          `json:"id"`               // Имя метрики.
+	MType  string          `json:"type"`             // Тип метрики.
+	Status string          `json:"status"`           // accepted или rejected.
+	Error  string          `json:"error,omitempty"`  // Причина отказа.
+	Metric *models.Metrics `json:"metric,omitempty"` // Актуальное значение принятой метрики.
+}
+
+// BatchResponse — ответ на пакетное обновление в режимах atomic и partial.
+type BatchResponse struct {
+	Mode     string            `json:"mode"`
+	Applied  bool              `json:"applied"`  // Были ли изменения записаны в хранилище.
+	Accepted int               `json:"accepted"` // Количество принятых метрик.
+	Rejected int               `json:"rejected"` // Количество отклоненных метрик.
+	Results  []BatchItemResult `json:"results"`
+}
+
+// writeBatchResponse отправляет BatchResponse с заданным кодом.
+func writeBatchResponse(rw http.ResponseWriter, status int, resp BatchResponse) {
+	body, err := json.Marshal(resp)
+	if err != nil {
+		logger.Log.Info("json marshal error", zap.Error(err))
+		http.Error(rw, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	rw.WriteHeader(status)
+	_, _ = rw.Write(body)
+}
+
+// updateBatchWithResults обрабатывает пакет в режиме mode (BatchModeAtomic или BatchModePartial).
+// Каждая метрика проверяется (формат и права токена) до записи, а в ответе возвращается
+// результат по каждому элементу. Принятые метрики записываются только после разбора всего
+// пакета одним вызовом AppendMetrics, который применяет их целиком или не применяет вовсе.
+// Ошибка разбора отклоняет пакет без записи.
+func (h *Handler) updateBatchWithResults(rw http.ResponseWriter, r *http.Request, mode string) {
+	resp := BatchResponse{Mode: mode, Results: make([]BatchItemResult, 0)}
+	var (
+		pending     []models.Metrics
+		keys        []models.MetricKey
+		acceptedIdx []int
+	)
+
+	err := decodeMetricsStream(json.NewDecoder(r.Body), func(mt models.Metrics) error {
+		item := BatchItemResult{Index: len(resp.Results), ID: mt.ID, MType: mt.MType, Status: BatchItemAccepted}
+		err := mt.Validate()
+		if err == nil {
+			err = h.authorizeWrite(r, mt.ID)
+		}
+		if err != nil {
+			item.Status = BatchItemRejected
+			item.Error = err.Error()
+			resp.Rejected++
+			resp.Results = append(resp.Results, item)
+			return nil
+		}
+		resp.Accepted++
+		resp.Results = append(resp.Results, item)
+		acceptedIdx = append(acceptedIdx, item.Index)
+		keys = append(keys, mt.Key())
+		pending = append(pending, mt)
+		return nil
+	})
+	if err != nil {
+		logger.Log.Debug("json decode error", zap.Error(err))
+		writeBodyError(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	h.selfMetrics.observeBatch(mode, len(resp.Results))
+
+	if mode == BatchModeAtomic && resp.Rejected > 0 {
+		for _, i := range acceptedIdx {
+			resp.Results[i].Status = BatchItemRejected
+			resp.Results[i].Error = errBatchNotApplied
+		}
+		resp.Rejected += resp.Accepted
+		resp.Accepted = 0
+		logger.Log.Info("atomic batch rejected", zap.Int("rejected", resp.Rejected))
+		writeBatchResponse(rw, http.StatusBadRequest, resp)
+		return
+	}
+
+	if len(pending) > 0 {
+		if err := h.mWriter.AppendMetrics(pending); err != nil {
+			logger.Log.Info("can not add metrics", zap.Error(err))
+			http.Error(rw, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+	}
+	resp.Applied = resp.Accepted > 0
+
+	if len(keys) > 0 {
+		updated, err := h.mReader.GetMetricsByKeys(keys)
+		if err != nil {
+			logger.Log.Info("can not get metrics by keys", zap.Error(err))
+			http.Error(rw, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		for i, idx := range acceptedIdx {
+			m := updated[i]
+			resp.Results[idx].Metric = &m
+		}
+		h.publish(updated...)
+	}
+	writeBatchResponse(rw, http.StatusOK, resp)
+}
@@ -0,0 +1,125 @@
+// Package server содержит middleware для ограничения размера тела запроса.
+// body.go также реализует буферизацию тела, которая при превышении порога переносит данные во временный файл.
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/Fuonder/metriccoll.git/internal/logger"
+	"go.uber.org/zap"
+)
+
+// DefaultMaxBodySize — ограничение размера тела запроса по умолчанию.
+const DefaultMaxBodySize int64 = 64 << 20
+
+// bodySpoolThreshold — размер тела, после которого оно сохраняется во временный файл, а не в памяти.
+const bodySpoolThreshold = 1 << 20
+
+// isBodyTooLarge сообщает, что чтение тела прервано из-за превышения допустимого размера.
+func isBodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+// writeBodyError отправляет ответ на ошибку чтения тела запроса.
+func writeBodyError(rw http.ResponseWriter, err error, status int) {
+	if isBodyTooLarge(err) {
+		http.Error(rw, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(rw, err.Error(), status)
+}
+
+// BodyLimitMiddleware ограничивает размер тела запроса значением, заданным SetMaxBodySize.
+// При превышении чтение тела завершается ошибкой, и запрос отклоняется с кодом 413.
+func (h *Handler) BodyLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if h.maxBodySize > 0 {
+			if r.ContentLength > h.maxBodySize {
+				logger.Log.Info("request body too large", zap.Int64("Content-Length", r.ContentLength))
+				http.Error(rw, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(rw, r.Body, h.maxBodySize)
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// spooledBody — тело запроса, сохраненное в памяти или во временном файле.
+type spooledBody struct {
+	buf  bytes.Buffer
+	file *os.File
+}
+
+// Write сохраняет данные в памяти, пока их размер не превысит bodySpoolThreshold,
+// после чего переносит накопленное во временный файл и дописывает в него.
+func (s *spooledBody) Write(p []byte) (int, error) {
+	if s.file == nil && s.buf.Len()+len(p) <= bodySpoolThreshold {
+		return s.buf.Write(p)
+	}
+	if s.file == nil {
+		f, err := os.CreateTemp("", "metriccoll-body-*")
+		if err != nil {
+			return 0, err
+		}
+		s.file = f
+		if _, err := s.buf.WriteTo(f); err != nil {
+			return 0, err
+		}
+	}
+	return s.file.Write(p)
+}
+
+// reader возвращает сохраненное тело для повторного чтения.
+// Временный файл удаляется при закрытии возвращенного io.ReadCloser.
+func (s *spooledBody) reader() (io.ReadCloser, error) {
+	if s.file == nil {
+		return io.NopCloser(&s.buf), nil
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		s.discard()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Read читает тело из временного файла.
+func (s *spooledBody) Read(p []byte) (int, error) {
+	return s.file.Read(p)
+}
+
+// Close закрывает и удаляет временный файл.
+func (s *spooledBody) Close() error {
+	s.discard()
+	return nil
+}
+
+func (s *spooledBody) discard() {
+	if s.file == nil {
+		return
+	}
+	name := s.file.Name()
+	if err := s.file.Close(); err != nil {
+		logger.Log.Debug("can not close spool file", zap.Error(err))
+	}
+	if err := os.Remove(name); err != nil {
+		logger.Log.Debug("can not remove spool file", zap.Error(err))
+	}
+	s.file = nil
+}
+
+// spoolBody читает body целиком, одновременно передавая данные в w,
+// и возвращает копию тела для повторного чтения. Большие тела сохраняются во временный файл.
+func spoolBody(body io.Reader, w io.Writer) (io.ReadCloser, error) {
+	s := &spooledBody{}
+	if _, err := io.Copy(io.MultiWriter(s, w), body); err != nil {
+		s.discard()
+		return nil, err
+	}
+	return s.reader()
+}
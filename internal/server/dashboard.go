@@ -0,0 +1,326 @@
+package server
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Fuonder/metriccoll.git/internal/logger"
+	"github.com/Fuonder/metriccoll.git/internal/models"
+	"github.com/Fuonder/metriccoll.git/internal/storage"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// DefaultDashboardRefresh — период автообновления страниц дашборда в секундах.
+const DefaultDashboardRefresh = 10
+
+// Размер SVG-графика истории на странице метрики.
+const (
+	sparklineWidth  = 600
+	sparklineHeight = 120
+)
+
+//go:embed web/templates/*.html web/static/*
+var webFiles embed.FS
+
+var dashboardFuncs = template.FuncMap{
+	"timestamp": func(t time.Time) string { return t.UTC().Format(time.RFC3339) },
+}
+
+// dashboardTemplates — шаблоны страниц дашборда; каждая страница собирается с общим layout.html.
+var dashboardTemplates = map[string]*template.Template{
+	"index":  parsePage("index.html"),
+	"metric": parsePage("metric.html"),
+}
+
+func parsePage(name string) *template.Template {
+	return template.Must(template.New(name).Funcs(dashboardFuncs).
+		ParseFS(webFiles, "web/templates/layout.html", "web/templates/"+name))
+}
+
+// StaticHandler отдает встроенные в бинарный файл статические файлы дашборда (CSS, JS)
+// по пути /static/<file>.
+func StaticHandler() http.Handler {
+	static, err := fs.Sub(webFiles, "web/static")
+	if err != nil {
+		panic(err)
+	}
+	files := http.StripPrefix("/static/", http.FileServer(http.FS(static)))
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Cache-Control", "public, max-age=3600")
+		files.ServeHTTP(rw, r)
+	})
+}
+
+// SetHistory подключает историю значений, по которой строятся графики на странице метрики.
+func (h *Handler) SetHistory(history *storage.History) {
+	h.history = history
+}
+
+// dashboardPage — общие данные страниц дашборда.
+type dashboardPage struct {
+	Title   string
+	Now     time.Time
+	Refresh int // Период автообновления в секундах; 0 — выключено.
+}
+
+// dashboardRow — строка таблицы метрик.
+type dashboardRow struct {
+	ID        string
+	Type      string
+	Group     string
+	Value     string
+	UpdatedAt *time.Time
+	Age       string
+	Stale     bool
+}
+
+// UpdatedSort возвращает ключ сортировки строки по времени обновления (миллисекунды Unix).
+func (r dashboardRow) UpdatedSort() int64 {
+	if r.UpdatedAt == nil {
+		return 0
+	}
+	return r.UpdatedAt.UnixMilli()
+}
+
+// dashboardType — таблица метрик одного типа.
+type dashboardType struct {
+	Type string
+	Rows []dashboardRow
+}
+
+type indexPage struct {
+	dashboardPage
+	Total int
+	Types []dashboardType
+}
+
+// sparkline — SVG-график истории значений серии.
+type sparkline struct {
+	Points   []storage.HistoryPoint
+	Polyline string // Координаты точек для <polyline points="...">.
+	Width    int
+	Height   int
+	Min      float64
+	Max      float64
+	From     time.Time
+}
+
+type metricPage struct {
+	dashboardPage
+	Metric         dashboardRow
+	Spark          *sparkline
+	HistoryEnabled bool
+}
+
+// metricGroup возвращает группу метрики по ее имени: часть имени до первого разделителя
+// ("_", ".", ":", "-", "/"), а для имен без разделителей — первое слово в camelCase
+// (HeapAlloc → Heap). Имя из одного слова само является группой.
+func metricGroup(name string) string {
+	if i := strings.IndexAny(name, "_.:-/"); i > 0 {
+		return name[:i]
+	}
+	runes := []rune(name)
+	for i := 1; i < len(runes); i++ {
+		if unicode.IsUpper(runes[i]) && unicode.IsLower(runes[i-1]) {
+			return string(runes[:i])
+		}
+	}
+	return name
+}
+
+// formatMetricValue возвращает значение метрики в том же виде, что и ValueHandler.
+func formatMetricValue(m models.Metrics) string {
+	switch {
+	case m.MType == "gauge" && m.Value != nil:
+		return strconv.FormatFloat(*m.Value, 'f', -1, 64)
+	case m.MType == "counter" && m.Delta != nil:
+		return strconv.FormatInt(*m.Delta, 10)
+	}
+	return ""
+}
+
+// formatAge округляет прошедшее время для отображения: "42s", "5m", "3h", "2d".
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(max(d, 0)/time.Second))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d/time.Minute))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(d/time.Hour))
+	}
+	return fmt.Sprintf("%dd", int(d/(24*time.Hour)))
+}
+
+func newDashboardRow(m models.Metrics, now time.Time) dashboardRow {
+	row := dashboardRow{
+		ID:        m.ID,
+		Type:      m.MType,
+		Group:     metricGroup(m.ID),
+		Value:     formatMetricValue(m),
+		UpdatedAt: m.UpdatedAt,
+		Stale:     m.Stale,
+	}
+	if m.UpdatedAt != nil {
+		row.Age = formatAge(now.Sub(*m.UpdatedAt))
+	}
+	return row
+}
+
+// newSparkline строит график по истории серии; для графика нужно не менее двух точек.
+// Ось X пропорциональна времени, ось Y масштабируется от минимального до максимального значения.
+func newSparkline(points []storage.HistoryPoint) *sparkline {
+	if len(points) < 2 {
+		return nil
+	}
+	s := &sparkline{
+		Points: points,
+		Width:  sparklineWidth,
+		Height: sparklineHeight,
+		Min:    math.Inf(1),
+		Max:    math.Inf(-1),
+		From:   points[0].At,
+	}
+	for _, p := range points {
+		s.Min = math.Min(s.Min, p.Value)
+		s.Max = math.Max(s.Max, p.Value)
+	}
+	const pad = 4.0
+	span := points[len(points)-1].At.Sub(s.From).Seconds()
+	coords := make([]string, 0, len(points))
+	for i, p := range points {
+		x := float64(i) / float64(len(points)-1)
+		if span > 0 {
+			x = p.At.Sub(s.From).Seconds() / span
+		}
+		y := 0.5
+		if s.Max > s.Min {
+			y = (p.Value - s.Min) / (s.Max - s.Min)
+		}
+		coords = append(coords, fmt.Sprintf("%.1f,%.1f",
+			pad+x*(sparklineWidth-2*pad),
+			sparklineHeight-pad-y*(sparklineHeight-2*pad)))
+	}
+	s.Polyline = strings.Join(coords, " ")
+	return s
+}
+
+// dashboardRefresh возвращает период автообновления из параметра запроса refresh
+// (в секундах, 0 — выключить); по умолчанию DefaultDashboardRefresh.
+func dashboardRefresh(r *http.Request) int {
+	if n, err := strconv.Atoi(r.URL.Query().Get("refresh")); err == nil && n >= 0 {
+		return n
+	}
+	return DefaultDashboardRefresh
+}
+
+// renderDashboard выполняет шаблон страницы и отправляет результат клиенту.
+// Страница собирается в буфер, чтобы ошибка шаблона не привела к обрезанному ответу.
+func renderDashboard(rw http.ResponseWriter, page string, data any) {
+	var buf strings.Builder
+	if err := dashboardTemplates[page].ExecuteTemplate(&buf, "layout", data); err != nil {
+		logger.Log.Info("can not render dashboard page", zap.String("page", page), zap.Error(err))
+		http.Error(rw, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write([]byte(buf.String()))
+}
+
+// RootHandler обрабатывает корневой GET-запрос и возвращает HTML-дашборд со всеми метриками.
+// Метрики сгруппированы в таблицы по типу и упорядочены по группе (см. metricGroup) и имени;
+// таблицы сортируются по клику на заголовок столбца. Устаревшие серии помечаются
+// (см. storage.StalePolicy). Страница обновляется каждые DefaultDashboardRefresh секунд;
+// параметр запроса refresh задает другой период в секундах (0 — без обновления).
+//
+// Возвращает:
+//
+//   - 200 OK: HTML-страница (text/html).
+//   - 500 Internal Server Error: внутренняя ошибка.
+func (h *Handler) RootHandler(rw http.ResponseWriter, r *http.Request) {
+	if h.mReader == nil {
+		http.Error(rw, ErrMetricReaderNotInitialized.Message, ErrMetricReaderNotInitialized.Code)
+		return
+	}
+
+	now := time.Now().UTC()
+	metrics := h.mReader.GetAllMetrics()
+	byType := make(map[string][]dashboardRow)
+	for _, m := range metrics {
+		if m.MType != "gauge" && m.MType != "counter" {
+			continue
+		}
+		byType[m.MType] = append(byType[m.MType], newDashboardRow(m, now))
+	}
+
+	page := indexPage{
+		dashboardPage: dashboardPage{Title: "Metrics", Now: now, Refresh: dashboardRefresh(r)},
+	}
+	for _, mType := range []string{"gauge", "counter"} {
+		rows := byType[mType]
+		if len(rows) == 0 {
+			continue
+		}
+		sort.Slice(rows, func(i, j int) bool {
+			if rows[i].Group != rows[j].Group {
+				return rows[i].Group < rows[j].Group
+			}
+			return rows[i].ID < rows[j].ID
+		})
+		page.Types = append(page.Types, dashboardType{Type: mType, Rows: rows})
+		page.Total += len(rows)
+	}
+	logger.Log.Debug("rendering dashboard", zap.Int("metrics", page.Total))
+	renderDashboard(rw, "index", page)
+}
+
+// MetricPageHandler возвращает HTML-страницу метрики: значение, время обновления
+// и график истории значений, если история включена (см. SetHistory).
+//
+// Параметры URL:
+//
+//   - mType: тип метрики (gauge | counter)
+//   - mName: имя метрики
+//
+// Возвращает:
+//
+//   - 200 OK: HTML-страница (text/html).
+//   - 404 Not Found: метрика не найдена.
+//   - 500 Internal Server Error: внутренняя ошибка.
+func (h *Handler) MetricPageHandler(rw http.ResponseWriter, r *http.Request) {
+	if h.mReader == nil {
+		http.Error(rw, ErrMetricReaderNotInitialized.Message, ErrMetricReaderNotInitialized.Code)
+		return
+	}
+	mType := chi.URLParam(r, "mType")
+	mName := chi.URLParam(r, "mName")
+	m, err := h.mReader.GetMetricByName(mName, mType)
+	if err != nil {
+		logger.Log.Debug("metric page: metric not found",
+			zap.String("type", mType), zap.String("name", mName), zap.Error(err))
+		http.Error(rw, "metric not found", http.StatusNotFound)
+		return
+	}
+
+	now := time.Now().UTC()
+	page := metricPage{
+		dashboardPage:  dashboardPage{Title: m.ID, Now: now, Refresh: dashboardRefresh(r)},
+		Metric:         newDashboardRow(m, now),
+		HistoryEnabled: h.history != nil,
+	}
+	if h.history != nil {
+		page.Spark = newSparkline(h.history.Points(m.Key()))
+	}
+	renderDashboard(rw, "metric", page)
+}
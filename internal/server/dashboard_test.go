@@ -0,0 +1,110 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Fuonder/metriccoll.git/internal/models"
+	"github.com/Fuonder/metriccoll.git/internal/storage"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricGroup(t *testing.T) {
+	for name, want := range map[string]string{
+		"cpu_1":           "cpu",
+		"http.requests":   "http",
+		"HeapAlloc":       "Heap",
+		"PollCount":       "Poll",
+		"GCSys":           "GCSys",
+		"Alloc":           "Alloc",
+		"CPUutilization1": "CPUutilization1",
+		"_private":        "_private",
+	} {
+		require.Equal(t, want, metricGroup(name), name)
+	}
+}
+
+func TestNewSparkline(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.Nil(t, newSparkline(nil))
+	require.Nil(t, newSparkline([]storage.HistoryPoint{{At: start, Value: 1}}))
+
+	s := newSparkline([]storage.HistoryPoint{
+		{At: start, Value: 1},
+		{At: start.Add(time.Second), Value: 3},
+		{At: start.Add(4 * time.Second), Value: 2},
+	})
+	require.NotNil(t, s)
+	require.Equal(t, 1.0, s.Min)
+	require.Equal(t, 3.0, s.Max)
+	require.Equal(t, "4.0,116.0 152.0,4.0 596.0,60.0", s.Polyline)
+
+	flat := newSparkline([]storage.HistoryPoint{{At: start, Value: 5}, {At: start, Value: 5}})
+	require.Equal(t, "4.0,60.0 596.0,60.0", flat.Polyline, "constant series is drawn in the middle")
+}
+
+func TestDashboardRefresh(t *testing.T) {
+	require.Equal(t, DefaultDashboardRefresh, dashboardRefresh(httptest.NewRequest(http.MethodGet, "/", nil)))
+	require.Equal(t, 0, dashboardRefresh(httptest.NewRequest(http.MethodGet, "/?refresh=0", nil)))
+	require.Equal(t, 30, dashboardRefresh(httptest.NewRequest(http.MethodGet, "/?refresh=30", nil)))
+	require.Equal(t, DefaultDashboardRefresh, dashboardRefresh(httptest.NewRequest(http.MethodGet, "/?refresh=-1", nil)))
+}
+
+func TestDashboardPages(t *testing.T) {
+	st, err := storage.NewJSONStorage(storage.NewFileStoreInfo(filepath.Join(t.TempDir(), "metrics.json"), time.Hour, false))
+	require.NoError(t, err)
+	require.NoError(t, st.AppendMetrics([]models.Metrics{
+		{ID: "HeapSys", MType: "gauge", Value: models.Float64Ptr(2)},
+		{ID: "HeapAlloc", MType: "gauge", Value: models.Float64Ptr(1.5)},
+		{ID: "<script>", MType: "gauge", Value: models.Float64Ptr(0)},
+		{ID: "PollCount", MType: "counter", Delta: models.Int64Ptr(7)},
+	}))
+	h := NewHandler(st, st, nil, nil, nil, "")
+	r := chi.NewRouter()
+	r.Get("/", h.RootHandler)
+	r.Get("/metric/{mType}/{mName}", h.MetricPageHandler)
+	r.Handle("/static/*", StaticHandler())
+	get := func(target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, nil))
+		return rr
+	}
+
+	rr := get("/?refresh=5")
+	require.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	require.Contains(t, body, `<meta http-equiv="refresh" content="5">`)
+	require.Contains(t, body, "<h1>Metrics <small>4 series</small></h1>")
+	require.NotContains(t, body, "<script>", "metric names must be escaped")
+	require.Contains(t, body, "&lt;script&gt;")
+	require.Less(t, strings.Index(body, ">HeapAlloc<"), strings.Index(body, ">HeapSys<"))
+	require.Less(t, strings.Index(body, "<h2>gauge"), strings.Index(body, "<h2>counter"))
+	require.NotContains(t, get("/?refresh=0").Body.String(), "http-equiv")
+
+	rr = get("/metric/gauge/HeapAlloc")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Contains(t, rr.Body.String(), "History is not available.")
+	require.Equal(t, http.StatusNotFound, get("/metric/gauge/missing").Code)
+
+	history := storage.NewHistory(10)
+	h.SetHistory(history)
+	require.Contains(t, get("/metric/gauge/HeapAlloc").Body.String(), "the series needs at least two updates")
+	start := time.Now().UTC()
+	for i, v := range []float64{1, 4, 2} {
+		at := start.Add(time.Duration(i) * time.Second)
+		history.Record([]models.Metrics{{ID: "HeapAlloc", MType: "gauge", Value: models.Float64Ptr(v), UpdatedAt: &at}}, start)
+	}
+	body = get("/metric/gauge/HeapAlloc").Body.String()
+	require.Contains(t, body, `<svg class="sparkline"`)
+	require.Contains(t, body, "min 1 · max 4")
+
+	rr = get("/static/dashboard.css")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Contains(t, rr.Header().Get("Content-Type"), "text/css")
+	require.Equal(t, http.StatusNotFound, get("/static/missing.js").Code)
+}
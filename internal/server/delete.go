@@ -0,0 +1,186 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/Fuonder/metriccoll.git/internal/logger"
+	"github.com/Fuonder/metriccoll.git/internal/models"
+	"github.com/Fuonder/metriccoll.git/internal/storage"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// ErrMetricRemoverNotSupported возвращается, если хранилище не поддерживает удаление метрик.
+var ErrMetricRemoverNotSupported = ErrorResponse{Code: http.StatusNotImplemented, Message: "metric storage does not support deletion"}
+
+// DeletedMetric описывает удаленную метрику в ответе BulkDeleteHandler.
+type DeletedMetric struct {
+	ID    string `json:"id"`
+	MType string `json:"type"`
+}
+
+// DeleteResponse — тело ответа BulkDeleteHandler.
+type DeleteResponse struct {
+	Deleted int             `json:"deleted"`
+	Metrics []DeletedMetric `json:"metrics"`
+}
+
+// remover возвращает хранилище с поддержкой удаления или отправляет ответ с ошибкой.
+func (h *Handler) remover(rw http.ResponseWriter) (storage.MetricRemover, bool) {
+	if h.mWriter == nil {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(ErrMetricWriterNotInitialized.Code)
+		_ = json.NewEncoder(rw).Encode(ErrMetricWriterNotInitialized)
+		return nil, false
+	}
+	remover, ok := h.mWriter.(storage.MetricRemover)
+	if !ok {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(ErrMetricRemoverNotSupported.Code)
+		_ = json.NewEncoder(rw).Encode(ErrMetricRemoverNotSupported)
+		return nil, false
+	}
+	return remover, true
+}
+
+// writeRemoveError отправляет ответ с кодом, соответствующим ошибке удаления.
+func writeRemoveError(rw http.ResponseWriter, err error) {
+	logger.Log.Info("can not remove metrics", zap.Error(err))
+	switch {
+	case errors.Is(err, storage.ErrMetricNotFound):
+		http.Error(rw, err.Error(), http.StatusNotFound)
+	case errors.Is(err, storage.ErrInvalidPattern):
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, storage.ErrPrimaryUnavailable):
+		http.Error(rw, err.Error(), http.StatusServiceUnavailable)
+	default:
+		http.Error(rw, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// DeleteHandler удаляет метрику, указанную в URL. Требует токен с правами "admin";
+// если авторизация не настроена, запрос отклоняется.
+//
+// Параметры URL:
+//
+//   - mType: тип метрики (gauge | counter)
+//   - mName: имя метрики
+//
+// Возвращает:
+//
+//   - 200 OK: метрика удалена.
+//   - 401 Unauthorized: токен не передан.
+//   - 403 Forbidden: токен без прав "admin" или авторизация не настроена.
+//   - 404 Not Found: метрика не найдена.
+//   - 501 Not Implemented: хранилище не поддерживает удаление.
+//   - 503 Service Unavailable: основное хранилище недоступно.
+//   - 500 Internal Server Error: внутренняя ошибка.
+func (h *Handler) DeleteHandler(rw http.ResponseWriter, r *http.Request) {
+	if err := h.authorizeAdmin(r); err != nil {
+		writeAuthError(rw, err)
+		return
+	}
+	remover, ok := h.remover(rw)
+	if !ok {
+		return
+	}
+	mType := chi.URLParam(r, "mType")
+	mName := chi.URLParam(r, "mName")
+	if err := remover.DeleteMetric(mName, mType); err != nil {
+		writeRemoveError(rw, err)
+		return
+	}
+	logger.Log.Info("metric deleted", zap.String("type", mType), zap.String("name", mName))
+	rw.WriteHeader(http.StatusOK)
+}
+
+// BulkDeleteHandler удаляет метрики, имя которых соответствует шаблону. Требует токен с правами "admin";
+// если авторизация не настроена, запрос отклоняется.
+//
+// Параметры запроса:
+//
+//   - pattern: шаблон имени в синтаксисе path.Match (например, "cpu_*"), обязателен;
+//     "*" удаляет все метрики выбранного типа.
+//   - type: тип метрики (gauge | counter), по умолчанию — любой.
+//
+// Формат ответа (application/json):
+//
+//	{"deleted": 2, "metrics": [{"id": "cpu_1", "type": "gauge"}, {"id": "cpu_2", "type": "gauge"}]}
+//
+// Возвращает:
+//
+//   - 200 OK: в теле — список удаленных метрик (возможно пустой).
+//   - 401 Unauthorized: токен не передан.
+//   - 403 Forbidden: токен без прав "admin" или авторизация не настроена.
+//   - 400 Bad Request: шаблон не задан или некорректен, неизвестный тип.
+//   - 501 Not Implemented: хранилище не поддерживает удаление.
+//   - 503 Service Unavailable: основное хранилище недоступно.
+//   - 500 Internal Server Error: внутренняя ошибка.
+func (h *Handler) BulkDeleteHandler(rw http.ResponseWriter, r *http.Request) {
+	if err := h.authorizeAdmin(r); err != nil {
+		writeAuthError(rw, err)
+		return
+	}
+	remover, ok := h.remover(rw)
+	if !ok {
+		return
+	}
+	pattern := r.URL.Query().Get("pattern")
+	mType := r.URL.Query().Get("type")
+	if err := storage.ValidatePattern(pattern, mType); err != nil {
+		logger.Log.Info("invalid delete pattern", zap.Error(err))
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	removed, err := remover.DeleteMetrics(pattern, mType)
+	if err != nil {
+		writeRemoveError(rw, err)
+		return
+	}
+	resp := DeleteResponse{Deleted: len(removed), Metrics: make([]DeletedMetric, 0, len(removed))}
+	for _, key := range removed {
+		resp.Metrics = append(resp.Metrics, DeletedMetric{ID: key.ID, MType: key.MType})
+	}
+	logger.Log.Info("metrics deleted", zap.String("pattern", pattern), zap.String("type", mType), zap.Int("count", len(removed)))
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(rw).Encode(resp)
+}
+
+// ResetHandler обнуляет counter, указанный в URL. Требует токен с правами "admin";
+// если авторизация не настроена, запрос отклоняется.
+//
+// Формат ответа (application/json):
+//
+//	{"id": "PollCount", "type": "counter", "delta": 0}
+//
+// Возвращает:
+//
+//   - 200 OK: счетчик обнулен.
+//   - 401 Unauthorized: токен не передан.
+//   - 403 Forbidden: токен без прав "admin" или авторизация не настроена.
+//   - 404 Not Found: счетчик не найден.
+//   - 501 Not Implemented: хранилище не поддерживает сброс.
+//   - 503 Service Unavailable: основное хранилище недоступно.
+//   - 500 Internal Server Error: внутренняя ошибка.
+func (h *Handler) ResetHandler(rw http.ResponseWriter, r *http.Request) {
+	if err := h.authorizeAdmin(r); err != nil {
+		writeAuthError(rw, err)
+		return
+	}
+	remover, ok := h.remover(rw)
+	if !ok {
+		return
+	}
+	mName := chi.URLParam(r, "mName")
+	if err := remover.ResetCounter(mName); err != nil {
+		writeRemoveError(rw, err)
+		return
+	}
+	logger.Log.Info("counter reset", zap.String("name", mName))
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(rw).Encode(models.Metrics{ID: mName, MType: "counter", Delta: models.Int64Ptr(0)})
+}
@@ -6,15 +6,21 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/Fuonder/metriccoll.git/internal/auth"
+	"github.com/Fuonder/metriccoll.git/internal/buildinfo"
 	"github.com/Fuonder/metriccoll.git/internal/certmanager"
+	"github.com/Fuonder/metriccoll.git/internal/hmacsign"
+	"github.com/Fuonder/metriccoll.git/internal/idempotency"
 	"io"
 	"net/http"
 	"strconv"
-	"strings"
+	"time"
 
 	"github.com/Fuonder/metriccoll.git/internal/logger"
 	"github.com/Fuonder/metriccoll.git/internal/models"
 	"github.com/Fuonder/metriccoll.git/internal/storage"
+	"github.com/Fuonder/metriccoll.git/internal/stream"
+	"github.com/Fuonder/metriccoll.git/internal/subnet"
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 )
@@ -34,16 +40,33 @@ var (
 	ErrInvalidMetricValue              = errors.New("invalid metric value")
 	ErrNoHashKey                       = errors.New("no hash key")
 	ErrMismatchedHash                  = errors.New("mismatched hash")
+	ErrMissingHash                     = errors.New("request signature is required")
+	ErrReadBody                        = errors.New("error reading request body")
+	ErrExpectedJSONArray               = errors.New("expected JSON array of metrics")
 )
 
 // Handler реализует обработчики HTTP-запросов для различных endpoint-ов сервиса метрик.
 type Handler struct {
-	mReader       storage.MetricReader          // Интерфейс для чтения метрик.
-	mWriter       storage.MetricWriter          // Интерфейс для записи метрик.
-	mFileHandler  storage.MetricFileHandler     // Интерфейс для работы с файлами.
-	mDBHandler    storage.MetricDatabaseHandler // Интерфейс для взаимодействия с БД.
-	cipherManager certmanager.TLSDecipher       // Интерфейс для дешифровки запрсов
-	hashKey       string                        // Ключ для проверки/генерации HMAC.
+	mReader        storage.MetricReader          // Интерфейс для чтения метрик.
+	mWriter        storage.MetricWriter          // Интерфейс для записи метрик.
+	mFileHandler   storage.MetricFileHandler     // Интерфейс для работы с файлами.
+	mDBHandler     storage.MetricDatabaseHandler // Интерфейс для взаимодействия с БД.
+	cipherManager  certmanager.TLSDecipher       // Интерфейс для дешифровки запрсов
+	hashKey        string                        // Основной ключ для проверки/генерации HMAC.
+	hashKeys       []string                      // Все действующие ключи для проверки HMAC (включая основной).
+	strictHMAC     bool                          // Отклонять изменяющие запросы без подписи.
+	authorizer     *auth.Authorizer              // Проверка API-токенов; nil — авторизация выключена.
+	replayWindow   time.Duration                 // Допустимое отклонение метки времени подписанного запроса.
+	nonces         *hmacsign.NonceCache          // Использованные nonce подписанных запросов.
+	trusted        subnet.Trusted                // Доверенные подсети агентов; пусто — фильтрация выключена.
+	trustedProxies subnet.Trusted                // Прокси, которым доверяется заголовок X-Real-IP.
+	maxBodySize    int64                         // Максимальный размер тела запроса в байтах; 0 — без ограничения.
+	idempotency    idempotency.Store             // Ключи идемпотентности пакетов; nil — заголовок Idempotency-Key не учитывается.
+	history        *storage.History              // История значений для графиков дашборда; nil — не ведется.
+	broker         *stream.Broker                // Рассылка принятых обновлений подписчикам /stream; nil — выключена.
+	selfMetrics    *serverMetrics                // Метрики о работе сервера; nil — не собираются.
+	buildInfo      *buildinfo.BuildInfo          // Информация о сборке для /version.
+	storageFile    string                        // Файл хранилища, проверяемый /readyz; пусто — не проверяется.
 }
 
 // NewHandler создает новый экземпляр Handler и инициализирует зависимости.
@@ -60,62 +83,78 @@ func NewHandler(mReader storage.MetricReader,
 		mDBHandler:    mDBHandler,
 		cipherManager: cipherManager,
 		hashKey:       hashKey,
+		hashKeys:      nil,
+		replayWindow:  hmacsign.DefaultReplayWindow,
+		nonces:        hmacsign.NewNonceCache(hmacsign.DefaultReplayWindow),
+		maxBodySize:   DefaultMaxBodySize,
+	}
+	if hashKey != "" {
+		h.hashKeys = []string{hashKey}
 	}
 	return &h
 }
 
-// RootHandler обрабатывает корневой GET-запрос и возвращает список всех метрик в формате text/html.
-//
-// Возвращает:
-//
-//   - 200 OK: в теле — список метрик в виде строки (например: "metric1 42.1, metric2 17")
-//   - 500 Internal Server Error: внутренняя ошибка
-func (h *Handler) RootHandler(rw http.ResponseWriter, r *http.Request) {
-
-	if h.mReader == nil {
-		// TODO: consider using html template for error page
-		rw.Header().Set("Content-Type", "text/html")
-		rw.WriteHeader(ErrMetricReaderNotInitialized.Code)
-		resp, _ := json.MarshalIndent(ErrMetricReaderNotInitialized, "", "    ")
-		_, err := rw.Write(resp)
-		if err != nil {
-			return
+// SetHashKeys задает набор действующих ключей HMAC. Запрос считается подписанным верно,
+// если подпись совпадает с подписью любым из ключей, что позволяет менять общий ключ
+// у агентов постепенно. Ответы сервера подписываются первым ключом набора.
+func (h *Handler) SetHashKeys(keys []string) {
+	h.hashKeys = nil
+	for _, key := range keys {
+		if key != "" {
+			h.hashKeys = append(h.hashKeys, key)
 		}
-		return
 	}
+	if len(h.hashKeys) > 0 {
+		h.hashKey = h.hashKeys[0]
+	} else {
+		h.hashKey = ""
+	}
+}
 
-	logger.Log.Debug("Entering root handler")
+// SetStrictHMAC включает строгий режим: если ключ HMAC задан, запросы,
+// изменяющие данные (все, кроме GET), без подписи отклоняются.
+func (h *Handler) SetStrictHMAC(strict bool) {
+	h.strictHMAC = strict
+}
 
-	var metricList []models.Metrics
-	logger.Log.Debug("creating metric list")
+// SetReplayWindow задает окно времени, в пределах которого принимаются подписанные запросы
+// и запоминаются их nonce. Нулевое или отрицательное окно заменяется hmacsign.DefaultReplayWindow.
+func (h *Handler) SetReplayWindow(window time.Duration) {
+	if window <= 0 {
+		window = hmacsign.DefaultReplayWindow
+	}
+	h.replayWindow = window
+	h.nonces = hmacsign.NewNonceCache(window)
+}
 
-	metricList = h.mReader.GetAllMetrics()
-	var stringMetricList []string
+// SetAuthorizer включает авторизацию запросов по API-токенам.
+func (h *Handler) SetAuthorizer(a *auth.Authorizer) {
+	h.authorizer = a
+}
 
-	for _, m := range metricList {
-		if m.MType == "gauge" {
-			stringMetricList = append(stringMetricList, fmt.Sprintf("%s %s",
-				m.ID,
-				strconv.FormatFloat(*m.Value, 'f', -1, 64)))
-		} else if m.MType == "counter" {
-			stringMetricList = append(stringMetricList, fmt.Sprintf("%s %s",
-				m.ID,
-				strconv.FormatInt(*m.Delta, 10)))
-		}
-	}
-	logger.Log.Debug("final metric list",
-		zap.String("metrics", strings.Join(stringMetricList, ", ")))
+// SetMaxBodySize задает максимальный размер тела запроса в байтах (0 — без ограничения).
+func (h *Handler) SetMaxBodySize(size int64) {
+	h.maxBodySize = size
+}
 
-	//if r.Header.Get("Accept-Encoding") == "gzip" {
-	//	rw.Header().Set("Content-Encoding", "gzip")
-	//}
-	out := strings.Join(stringMetricList, ", ")
-	rw.Header().Set("Content-Type", "text/html")
-	rw.WriteHeader(http.StatusOK)
-	_, err := rw.Write([]byte(out))
-	if err != nil {
+// SetTrustedSubnets задает доверенные подсети, из которых принимаются запросы на запись метрик.
+func (h *Handler) SetTrustedSubnets(trusted subnet.Trusted) {
+	h.trusted = trusted
+}
+
+// SetTrustedProxies задает подсети обратных прокси, которые подставляют адрес агента
+// в заголовок X-Real-IP. Запросу с этим заголовком от других адресов доверенная подсеть
+// проверяется и по адресу соединения.
+func (h *Handler) SetTrustedProxies(proxies subnet.Trusted) {
+	h.trustedProxies = proxies
+}
+
+// SetStalePolicy включает пометку устаревших серий при чтении метрик (см. storage.StaleReader).
+func (h *Handler) SetStalePolicy(policy *storage.StalePolicy) {
+	if policy == nil || h.mReader == nil {
 		return
 	}
+	h.mReader = storage.NewStaleReader(h.mReader, policy)
 }
 
 // ValueHandler возвращает значение метрики по имени и типу (gauge или counter), переданным в URL.
@@ -196,9 +235,15 @@ func (h *Handler) UpdateHandler(rw http.ResponseWriter, r *http.Request) {
 	mName := chi.URLParam(r, "mName")
 	mValue := chi.URLParam(r, "mValue")
 
+	if err := h.authorizeWrite(r, mName); err != nil {
+		writeAuthError(rw, err)
+		return
+	}
+
 	if mType == "gauge" {
 		value, _ := models.CheckTypeGauge(mValue)
-		err := h.mWriter.AppendMetric(models.Metrics{ID: mName, MType: "gauge", Value: (*float64)(&value)})
+		mt := models.Metrics{ID: mName, MType: "gauge", Value: (*float64)(&value)}
+		err := h.mWriter.AppendMetric(mt)
 		if err != nil {
 			logger.Log.Info("can not add metric", zap.Error(err))
 			if errors.Is(err, ErrInvalidMetricValue) {
@@ -208,11 +253,13 @@ func (h *Handler) UpdateHandler(rw http.ResponseWriter, r *http.Request) {
 			http.Error(rw, "Internal server error", http.StatusInternalServerError)
 			return
 		}
+		h.publishAccepted(mt)
 		rw.WriteHeader(http.StatusOK)
 	} else if mType == "counter" {
 		value, _ := models.CheckTypeCounter(mValue)
 
-		err := h.mWriter.AppendMetric(models.Metrics{ID: mName, MType: "counter", Delta: (*int64)(&value)})
+		mt := models.Metrics{ID: mName, MType: "counter", Delta: (*int64)(&value)}
+		err := h.mWriter.AppendMetric(mt)
 		if err != nil {
 			logger.Log.Info("can not add metric", zap.Error(err))
 			if errors.Is(err, ErrInvalidMetricValue) {
@@ -222,6 +269,7 @@ func (h *Handler) UpdateHandler(rw http.ResponseWriter, r *http.Request) {
 			http.Error(rw, "Internal server error", http.StatusInternalServerError)
 			return
 		}
+		h.publishAccepted(mt)
 		rw.WriteHeader(http.StatusOK)
 	} else {
 		logger.Log.Info("Invalid metric type, can not add metric")
@@ -295,6 +343,10 @@ func (h *Handler) JSONUpdateHandler(rw http.ResponseWriter, r *http.Request) {
 			logger.Log.Warn("can not close body", zap.Error(err))
 		}
 	}(r.Body)
+	if err := h.authorizeWrite(r, mt.ID); err != nil {
+		writeAuthError(rw, err)
+		return
+	}
 	err := h.mWriter.AppendMetric(mt)
 	if err != nil {
 		logger.Log.Debug("can not add metric", zap.Error(err))
@@ -319,6 +371,7 @@ func (h *Handler) JSONUpdateHandler(rw http.ResponseWriter, r *http.Request) {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
+	h.publish(mtRes)
 
 	resp, err := json.Marshal(mtRes)
 	if err != nil {
@@ -409,13 +462,19 @@ func (h *Handler) JSONGetHandler(rw http.ResponseWriter, r *http.Request) {
 
 // DBPingHandler проверяет доступность соединения с базой данных.
 //
-// Используется для health-check.
+// Используется для health-check. В теле ответа возвращается режим работы хранилища
+// в формате JSON (см. storage.Status), например:
+//
+//	{"mode": "degraded", "database": "down", "buffered": 12, "since": "...", "error": "..."}
+//
+// В режиме degraded сервер продолжает принимать метрики и накапливает их до восстановления БД.
 //
 // Возвращает:
 //
 //   - 200 OK: если соединение с базой установлено.
 //   - 500 Internal Server Error: если не удалось подключиться к БД или другая внутренняя ошибка.
 func (h *Handler) DBPingHandler(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
 	if h.mDBHandler == nil {
 		resp, _ := json.MarshalIndent(ErrMetricDBHandlerNotInitialized, "", "    ")
 		rw.WriteHeader(ErrMetricDBHandlerNotInitialized.Code)
@@ -426,14 +485,32 @@ func (h *Handler) DBPingHandler(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	code := http.StatusOK
 	err := h.mDBHandler.CheckConnection()
 	if err != nil {
 		logger.Log.Info("can not connect to database", zap.Error(err))
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		code = http.StatusInternalServerError
+	}
+
+	var status storage.Status
+	if reporter, ok := h.mDBHandler.(storage.StatusReporter); ok {
+		status = reporter.Status()
+	} else {
+		status = storage.Status{Mode: storage.ModeNormal, Database: storage.DatabaseUp}
+	}
+	if err != nil {
+		status.Database = storage.DatabaseDown
+		status.Error = err.Error()
+	}
+
+	resp, err := json.Marshal(status)
+	if err != nil {
+		logger.Log.Info("json marshal error", zap.Error(err))
+		http.Error(rw, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
 		return
 	}
-	rw.WriteHeader(http.StatusOK)
-	_, err = rw.Write([]byte(""))
+	rw.WriteHeader(code)
+	_, err = rw.Write(resp)
 	if err != nil {
 		return
 	}
@@ -457,10 +534,22 @@ func (h *Handler) DBPingHandler(rw http.ResponseWriter, r *http.Request) {
 //
 // ]
 //
+// Параметр mode=atomic|partial включает проверку всех метрик до записи и ответ
+// с результатом по каждому элементу (см. BatchResponse).
+//
+// Без параметра mode массив разбирается потоково, каждая метрика проверяется (формат и права токена),
+// и только после разбора всего пакета метрики записываются в хранилище одним вызовом AppendMetrics,
+// который применяет пакет целиком или не применяет вовсе. Поэтому ни ошибка в элементе, ни ошибка
+// записи не оставляют пакет примененным частично, и повтор с тем же Idempotency-Key безопасен.
+// Актуальные значения для ответа читаются из хранилища одним запросом.
+//
+// Повторы пакета с заголовком Idempotency-Key обрабатываются обёрткой WithIdempotency.
+//
 // Возвращает:
 //
 //   - 200 OK: при успешном обновлении всех метрик.
 //   - 400 Bad Request: при ошибках в формате запроса, значениях метрик или при ошибке чтения/записи.
+//   - 413 Request Entity Too Large: если тело запроса превышает допустимый размер.
 //   - 500 Internal Server Error: внутренняя ошибка.
 func (h *Handler) MultipleUpdateHandler(rw http.ResponseWriter, r *http.Request) {
 	rw.Header().Set("Content-Type", "application/json")
@@ -489,35 +578,56 @@ func (h *Handler) MultipleUpdateHandler(rw http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	var metrics []models.Metrics
+	if h.maxBodySize > 0 {
+		r.Body = http.MaxBytesReader(rw, r.Body, h.maxBodySize)
+	}
+
+	switch mode := r.URL.Query().Get("mode"); mode {
+	case "":
+	case BatchModeAtomic, BatchModePartial:
+		h.updateBatchWithResults(rw, r, mode)
+		return
+	default:
+		logger.Log.Info("unknown batch mode", zap.String("mode", mode))
+		http.Error(rw, `{"error": "unknown batch mode"}`, http.StatusBadRequest)
+		return
+	}
 
+	var (
+		metrics          []models.Metrics
+		authErr, itemErr error
+	)
 	logger.Log.Info("DECODING BATCH")
-
-	if err := json.NewDecoder(r.Body).Decode(&metrics); err != nil {
+	err := decodeMetricsStream(json.NewDecoder(r.Body), func(mt models.Metrics) error {
+		if itemErr = mt.Validate(); itemErr != nil {
+			return itemErr
+		}
+		if authErr = h.authorizeWrite(r, mt.ID); authErr != nil {
+			return authErr
+		}
+		metrics = append(metrics, mt)
+		return nil
+	})
+	switch {
+	case err == nil:
+	case authErr != nil:
+		writeAuthError(rw, authErr)
+		return
+	case itemErr != nil:
+		logger.Log.Info("invalid metric in batch", zap.Error(itemErr))
+		http.Error(rw, fmt.Sprintf(`{"error": "%s"}`, itemErr.Error()), http.StatusBadRequest)
+		return
+	default:
 		logger.Log.Debug("json decode error", zap.Error(err))
-		http.Error(rw, err.Error(), http.StatusBadRequest)
+		writeBodyError(rw, err, http.StatusBadRequest)
 		return
 	}
-	logger.Log.Info("APPENDING METRICS BATCH")
 
-	if err := h.mWriter.AppendMetrics(metrics); err != nil {
-		logger.Log.Info("can not add metrics", zap.Error(err))
+	updatedMetrics, err := h.ApplyBatch(metrics)
+	if err != nil {
 		http.Error(rw, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusBadRequest)
 		return
 	}
-
-	updatedMetrics := make([]models.Metrics, 0, len(metrics))
-
-	logger.Log.Info("FORMING RESP METRICS BATCH")
-	for _, mt := range metrics {
-		mtRes, err := h.mReader.GetMetricByName(mt.ID, mt.MType)
-		if err != nil {
-			logger.Log.Info("can not get metric by name", zap.Error(err))
-			http.Error(rw, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusBadRequest)
-			return
-		}
-		updatedMetrics = append(updatedMetrics, mtRes)
-	}
 	logger.Log.Info("MARSHALING FINAL METRICS BATCH")
 	resp, err := json.Marshal(updatedMetrics)
 	if err != nil {
@@ -529,6 +639,67 @@ func (h *Handler) MultipleUpdateHandler(rw http.ResponseWriter, r *http.Request)
 	_, _ = rw.Write(resp)
 }
 
+// ApplyBatch записывает пакет метрик, принятый любым транспортом (POST /updates/ или gRPC):
+// проверяет каждый элемент, записывает пакет, учитывает его в метриках сервера и публикует
+// значения после записи подписчикам /stream. Возвращает актуальные значения метрик пакета.
+// Если элемент не прошел проверку, ничего не записывается, а ошибка оборачивает
+// models.ErrInvalidMetric. Права токена проверяет транспорт до вызова.
+func (h *Handler) ApplyBatch(metrics []models.Metrics) ([]models.Metrics, error) {
+	if h.mWriter == nil || h.mReader == nil {
+		return nil, errors.New("storage not initialized")
+	}
+	keys := make([]models.MetricKey, 0, len(metrics))
+	for i, mt := range metrics {
+		if err := mt.Validate(); err != nil {
+			logger.Log.Info("invalid metric in batch", zap.Int("item", i), zap.Error(err))
+			return nil, fmt.Errorf("%w: item %d: %w", models.ErrInvalidMetric, i, err)
+		}
+		keys = append(keys, mt.Key())
+	}
+
+	if len(metrics) > 0 {
+		if err := h.mWriter.AppendMetrics(metrics); err != nil {
+			logger.Log.Info("can not add metrics", zap.Error(err))
+			return nil, err
+		}
+	}
+	h.selfMetrics.observeBatch("default", len(keys))
+
+	logger.Log.Info("FORMING RESP METRICS BATCH")
+	updatedMetrics, err := h.mReader.GetMetricsByKeys(keys)
+	if err != nil {
+		logger.Log.Info("can not get metrics by keys", zap.Error(err))
+		return nil, err
+	}
+	h.publish(updatedMetrics...)
+	return updatedMetrics, nil
+}
+
+// decodeMetricsStream потоково разбирает JSON-массив метрик и вызывает fn для каждого элемента.
+// Разбор прекращается при первой ошибке fn.
+func decodeMetricsStream(dec *json.Decoder, fn func(models.Metrics) error) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return ErrExpectedJSONArray
+	}
+	for dec.More() {
+		var mt models.Metrics
+		if err := dec.Decode(&mt); err != nil {
+			return err
+		}
+		if err := fn(mt); err != nil {
+			return err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	return nil
+}
+
 func (h *Handler) HasFileHandler() bool {
 	return h.mFileHandler != nil
 }
@@ -10,6 +10,7 @@ import (
 	"github.com/golang/mock/gomock"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 )
 
 func ExampleHandler_RootHandler() {
@@ -46,11 +47,13 @@ func ExampleHandler_RootHandler() {
 	r.ServeHTTP(rec, req)
 
 	fmt.Println(rec.Code)
-	fmt.Println(rec.Body.String())
+	fmt.Println(rec.Header().Get("Content-Type"))
+	fmt.Println(strings.Count(rec.Body.String(), `<tr class="metric`))
 
 	// Output:
 	// 200
-	// PollCount 11639078, BuckHashSys 3301, GCSys 2491368, HeapReleased 2400256, HeapSys 7847936, Sys 11754512, MCacheInuse 3600, PauseTotalNs 1417573, HeapAlloc 1515480, HeapObjects 1518, OtherSys 823059, StackInuse 524288, RandomValue 29.719607371392165, Alloc 1515480, Frees 2303, LastGC 1745266266262774500
+	// text/html; charset=utf-8
+	// 16
 }
 
 func ExampleHandler_DBPingHandler() {
@@ -75,6 +78,7 @@ func ExampleHandler_DBPingHandler() {
 
 	// Output:
 	// 200
+	// {"mode":"normal","database":"up","buffered":0}
 }
 
 func ExampleHandler_MultipleUpdateHandler() {
@@ -105,9 +109,11 @@ func ExampleHandler_MultipleUpdateHandler() {
 
 	mockWriter.EXPECT().AppendMetrics(metrics).Return(nil)
 
+	keys := make([]models.MetricKey, 0, len(metrics))
 	for _, mt := range metrics {
-		mockReader.EXPECT().GetMetricByName(mt.ID, mt.MType).Return(mt, nil)
+		keys = append(keys, mt.Key())
 	}
+	mockReader.EXPECT().GetMetricsByKeys(keys).Return(metrics, nil)
 
 	h := NewHandler(mockReader, mockWriter, nil, nil, nil, "")
 
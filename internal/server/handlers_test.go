@@ -0,0 +1,544 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Fuonder/metriccoll.git/internal/auth"
+	"github.com/Fuonder/metriccoll.git/internal/idempotency"
+	"github.com/Fuonder/metriccoll.git/internal/models"
+	"github.com/Fuonder/metriccoll.git/internal/storage"
+	mocks "github.com/Fuonder/metriccoll.git/internal/storage/mocks"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultipleUpdateHandlerLargeBatch(t *testing.T) {
+	st, err := storage.NewJSONStorage(storage.NewFileStoreInfo(filepath.Join(t.TempDir(), "metrics.json"), time.Hour, false))
+	require.NoError(t, err)
+	h := NewHandler(st, st, nil, nil, nil, "")
+
+	const n = 2500
+	metrics := make([]models.Metrics, 0, n+1)
+	for i := 0; i < n; i++ {
+		metrics = append(metrics, models.Metrics{ID: fmt.Sprintf("g%d", i), MType: "gauge", Value: models.Float64Ptr(float64(i))})
+	}
+	metrics = append(metrics, models.Metrics{ID: "g0", MType: "gauge", Value: models.Float64Ptr(-1)})
+	body, err := json.Marshal(metrics)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	h.MultipleUpdateHandler(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var resp []models.Metrics
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.Len(t, resp, n+1)
+	require.Equal(t, "g1", resp[1].ID)
+	require.Equal(t, -1.0, *resp[0].Value)
+	require.Len(t, st.GetAllMetrics(), n)
+}
+
+func TestMultipleUpdateHandlerSingleWrite(t *testing.T) {
+	const n = 2500
+	metrics := make([]models.Metrics, 0, n)
+	for i := 0; i < n; i++ {
+		metrics = append(metrics, models.Metrics{ID: fmt.Sprintf("c%d", i), MType: "counter", Delta: models.Int64Ptr(1)})
+	}
+	body, err := json.Marshal(metrics)
+	require.NoError(t, err)
+
+	for _, target := range []string{"/updates/", "/updates/?mode=partial"} {
+		t.Run(target, func(t *testing.T) {
+			// Весь пакет записывается одним вызовом: ошибка хранилища не оставляет
+			// его примененным частично, и повтор с тем же ключом безопасен.
+			ctrl := gomock.NewController(t)
+			mockWriter := mocks.NewMockMetricWriter(ctrl)
+			mockWriter.EXPECT().AppendMetrics(gomock.Len(n)).Return(errors.New("connection reset"))
+			h := NewHandler(mocks.NewMockMetricReader(ctrl), mockWriter, nil, nil, nil, "")
+
+			req := httptest.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+			h.MultipleUpdateHandler(rr, req)
+			require.NotEqual(t, http.StatusOK, rr.Code)
+		})
+	}
+}
+
+func TestMultipleUpdateHandlerNoPartialWrite(t *testing.T) {
+	authorizer, err := auth.NewAuthorizer(auth.Config{
+		Tokens: []auth.TokenConfig{{ID: "cpu-writer", Token: "w", Scopes: []string{"write:CPU"}}},
+	})
+	require.NoError(t, err)
+	principal, err := authorizer.Authenticate("w")
+	require.NoError(t, err)
+
+	// Крупный пакет допустимых метрик, затем недопустимый элемент.
+	items := make([]string, 0, 1500)
+	for i := 0; i < 1500; i++ {
+		items = append(items, fmt.Sprintf(`{"id":"CPU%d","type":"counter","delta":1}`, i))
+	}
+	valid := "[" + strings.Join(items, ",")
+
+	tests := []struct {
+		name         string
+		target       string
+		body         string
+		expectedCode int
+	}{
+		{"Malformed", "/updates/", valid + `,{"id":"CPUx","type":"counter","delta":"x"}]`, http.StatusBadRequest},
+		{"Invalid", "/updates/", valid + `,{"id":"CPUx","type":"counter"}]`, http.StatusBadRequest},
+		{"Forbidden", "/updates/", valid + `,{"id":"RAM","type":"counter","delta":1}]`, http.StatusForbidden},
+		{"PartialMalformed", "/updates/?mode=partial", valid + `,{"id":"CPUx","type":"counter","delta":"x"}]`, http.StatusBadRequest},
+		{"PartialTruncated", "/updates/?mode=partial", valid, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Ни одна запись не ожидается: mock завершит тест при вызове AppendMetrics.
+			ctrl := gomock.NewController(t)
+			h := NewHandler(mocks.NewMockMetricReader(ctrl), mocks.NewMockMetricWriter(ctrl), nil, nil, nil, "")
+			h.SetAuthorizer(authorizer)
+
+			req := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req = req.WithContext(auth.NewContext(req.Context(), principal))
+			rr := httptest.NewRecorder()
+			h.MultipleUpdateHandler(rr, req)
+			require.Equal(t, tt.expectedCode, rr.Code)
+		})
+	}
+}
+
+func TestMultipleUpdateHandlerBadBody(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockWriter := mocks.NewMockMetricWriter(ctrl)
+	mockReader := mocks.NewMockMetricReader(ctrl)
+	h := NewHandler(mockReader, mockWriter, nil, nil, nil, "")
+	h.SetMaxBodySize(64)
+
+	tests := []struct {
+		name         string
+		body         string
+		expectedCode int
+	}{
+		{"NotArray", `{"id":"a","type":"gauge","value":1}`, http.StatusBadRequest},
+		{"Truncated", `[{"id":"a","type":"gauge","value":1}`, http.StatusBadRequest},
+		{"TooLarge", "[" + strings.Repeat(" ", 100) + "]", http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+			h.MultipleUpdateHandler(rr, req)
+			require.Equal(t, tt.expectedCode, rr.Code)
+		})
+	}
+}
+
+func TestMultipleUpdateHandlerBatchModes(t *testing.T) {
+	body := `[
+		{"id":"c","type":"counter","delta":3},
+		{"id":"g","type":"gauge"},
+		{"id":"x","type":"histogram","value":1},
+		{"id":"g2","type":"gauge","value":2.5}
+	]`
+	newHandler := func(t *testing.T) (*Handler, *storage.JSONStorage) {
+		st, err := storage.NewJSONStorage(storage.NewFileStoreInfo(filepath.Join(t.TempDir(), "metrics.json"), time.Hour, false))
+		require.NoError(t, err)
+		return NewHandler(st, st, nil, nil, nil, ""), st
+	}
+	post := func(h *Handler, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		h.MultipleUpdateHandler(rr, req)
+		return rr
+	}
+
+	t.Run("AtomicRejected", func(t *testing.T) {
+		h, st := newHandler(t)
+		rr := post(h, "/updates/?mode=atomic", body)
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+
+		var resp BatchResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		require.False(t, resp.Applied)
+		require.Equal(t, 0, resp.Accepted)
+		require.Equal(t, 4, resp.Rejected)
+		require.Len(t, resp.Results, 4)
+		require.Equal(t, errBatchNotApplied, resp.Results[0].Error)
+		require.Contains(t, resp.Results[1].Error, models.ErrMissingMetricValue.Error())
+		require.Contains(t, resp.Results[2].Error, models.ErrUnsupportedMetricType.Error())
+		require.Empty(t, st.GetAllMetrics())
+	})
+
+	t.Run("AtomicApplied", func(t *testing.T) {
+		h, st := newHandler(t)
+		rr := post(h, "/updates/?mode=atomic", `[{"id":"c","type":"counter","delta":3},{"id":"c","type":"counter","delta":4}]`)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var resp BatchResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		require.True(t, resp.Applied)
+		require.Equal(t, 2, resp.Accepted)
+		require.Equal(t, int64(7), *resp.Results[1].Metric.Delta)
+		require.Len(t, st.GetAllMetrics(), 1)
+	})
+
+	t.Run("Partial", func(t *testing.T) {
+		h, st := newHandler(t)
+		rr := post(h, "/updates/?mode=partial", body)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var resp BatchResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		require.True(t, resp.Applied)
+		require.Equal(t, 2, resp.Accepted)
+		require.Equal(t, 2, resp.Rejected)
+		require.Equal(t, BatchItemAccepted, resp.Results[0].Status)
+		require.Equal(t, int64(3), *resp.Results[0].Metric.Delta)
+		require.Equal(t, BatchItemRejected, resp.Results[1].Status)
+		require.Equal(t, 1, resp.Results[1].Index)
+		require.Nil(t, resp.Results[1].Metric)
+		require.Equal(t, 2.5, *resp.Results[3].Metric.Value)
+		require.Len(t, st.GetAllMetrics(), 2)
+	})
+
+	t.Run("UnknownMode", func(t *testing.T) {
+		h, st := newHandler(t)
+		rr := post(h, "/updates/?mode=best-effort", body)
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Empty(t, st.GetAllMetrics())
+	})
+}
+
+func TestWithIdempotency(t *testing.T) {
+	st, err := storage.NewJSONStorage(storage.NewFileStoreInfo(filepath.Join(t.TempDir(), "metrics.json"), time.Hour, false))
+	require.NoError(t, err)
+	h := NewHandler(st, st, nil, nil, nil, "")
+	h.SetIdempotencyStore(idempotency.NewMemoryStore(time.Minute))
+	handler := h.WithIdempotency(h.MultipleUpdateHandler)
+
+	post := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set(idempotency.HeaderKey, key)
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+	const body = `[{"id":"c","type":"counter","delta":5}]`
+
+	first := post("batch-1", body)
+	require.Equal(t, http.StatusOK, first.Code)
+	require.Empty(t, first.Header().Get(idempotency.HeaderReplayed))
+
+	retry := post("batch-1", body)
+	require.Equal(t, http.StatusOK, retry.Code)
+	require.Equal(t, "true", retry.Header().Get(idempotency.HeaderReplayed))
+	require.JSONEq(t, first.Body.String(), retry.Body.String())
+
+	m, err := st.GetMetricByName("c", "counter")
+	require.NoError(t, err)
+	require.Equal(t, int64(5), *m.Delta, "retried batch must not be applied twice")
+
+	require.Equal(t, http.StatusOK, post("batch-2", body).Code)
+	require.Equal(t, http.StatusOK, post("", body).Code)
+	m, err = st.GetMetricByName("c", "counter")
+	require.NoError(t, err)
+	require.Equal(t, int64(15), *m.Delta)
+
+	require.Equal(t, http.StatusBadRequest, post("batch-3", `[{"id":"c","type":"counter"}]`).Code)
+	require.Equal(t, http.StatusOK, post("batch-3", body).Code, "failed request must release its key")
+
+	require.Equal(t, http.StatusBadRequest, post("bad key", body).Code)
+}
+
+// unavailableIdempotencyStore имитирует таблицу ключей в недоступной БД.
+type unavailableIdempotencyStore struct{}
+
+func (unavailableIdempotencyStore) Begin(context.Context, string) (*idempotency.Response, error) {
+	return nil, errors.New("connection refused")
+}
+
+func (unavailableIdempotencyStore) Complete(context.Context, string, idempotency.Response) error {
+	return errors.New("connection refused")
+}
+
+func (unavailableIdempotencyStore) Release(context.Context, string) error {
+	return errors.New("connection refused")
+}
+
+func TestWithIdempotencyDegradedStorage(t *testing.T) {
+	cs, err := storage.NewCompositeStorage(nil, storage.NewFileStoreInfo(filepath.Join(t.TempDir(), "snapshot.json"), time.Hour, false))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = cs.Close() })
+	h := NewHandler(cs, cs, cs, cs, nil, "")
+
+	for name, available := range map[string]func() bool{
+		"PrimaryDown":        cs.IsPrimaryActive,
+		"DownNotYetDetected": func() bool { return true },
+	} {
+		t.Run(name, func(t *testing.T) {
+			store := idempotency.NewFallbackStore(idempotency.NewMemoryStore(time.Minute), available)
+			store.SetPrimary(unavailableIdempotencyStore{})
+			h.SetIdempotencyStore(store)
+			handler := h.WithIdempotency(h.MultipleUpdateHandler)
+
+			post := func() *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(`[{"id":"c`+name+`","type":"counter","delta":5}]`))
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set(idempotency.HeaderKey, "batch-"+name)
+				rr := httptest.NewRecorder()
+				handler(rr, req)
+				return rr
+			}
+			buffered := cs.PendingCount()
+
+			require.Equal(t, http.StatusOK, post().Code)
+			require.Equal(t, buffered+1, cs.PendingCount(), "batch must be buffered while the database is down")
+
+			retry := post()
+			require.Equal(t, http.StatusOK, retry.Code)
+			require.Equal(t, "true", retry.Header().Get(idempotency.HeaderReplayed))
+			m, err := cs.GetMetricByName("c"+name, "counter")
+			require.NoError(t, err)
+			require.Equal(t, int64(5), *m.Delta)
+		})
+	}
+}
+
+func TestMultipleUpdateHandlerConcurrentLoad(t *testing.T) {
+	st, err := storage.NewJSONStorage(storage.NewFileStoreInfo(filepath.Join(t.TempDir(), "metrics.json"), time.Hour, false))
+	require.NoError(t, err)
+	h := NewHandler(st, st, nil, nil, nil, "")
+
+	const (
+		clients  = 8
+		requests = 10
+		series   = 200
+	)
+	metrics := make([]models.Metrics, 0, 2*series)
+	for i := 0; i < series; i++ {
+		metrics = append(metrics,
+			models.Metrics{ID: fmt.Sprintf("c%d", i), MType: "counter", Delta: models.Int64Ptr(1)},
+			models.Metrics{ID: fmt.Sprintf("g%d", i), MType: "gauge", Value: models.Float64Ptr(float64(i))},
+		)
+	}
+	body, err := json.Marshal(metrics)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for c := 0; c < clients; c++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for r := 0; r < requests; r++ {
+				req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
+				req.Header.Set("Content-Type", "application/json")
+				rr := httptest.NewRecorder()
+				h.MultipleUpdateHandler(rr, req)
+				if !assert.Equal(t, http.StatusOK, rr.Code) {
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for r := 0; r < requests; r++ {
+				for _, m := range st.GetAllMetrics() {
+					if m.MType == "counter" && !assert.Positive(t, *m.Delta) {
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	all := st.GetAllMetrics()
+	require.Len(t, all, 2*series)
+	for _, m := range all {
+		if m.MType == "counter" {
+			require.Equal(t, int64(clients*requests), *m.Delta, m.ID)
+		}
+	}
+}
+
+func TestDeleteAndResetHandlers(t *testing.T) {
+	st, err := storage.NewJSONStorage(storage.NewFileStoreInfo(filepath.Join(t.TempDir(), "metrics.json"), time.Hour, false))
+	require.NoError(t, err)
+	require.NoError(t, st.AppendMetrics([]models.Metrics{
+		{ID: "cpu_1", MType: "gauge", Value: models.Float64Ptr(1)},
+		{ID: "cpu_2", MType: "gauge", Value: models.Float64Ptr(2)},
+		{ID: "cpu_3", MType: "counter", Delta: models.Int64Ptr(3)},
+		{ID: "PollCount", MType: "counter", Delta: models.Int64Ptr(10)},
+		{ID: "Alloc", MType: "gauge", Value: models.Float64Ptr(5)},
+	}))
+	authorizer, err := auth.NewAuthorizer(auth.Config{
+		Tokens: []auth.TokenConfig{
+			{ID: "writer", Token: "w", Scopes: []string{"write"}},
+			{ID: "ops", Token: "a", Scopes: []string{"admin"}},
+		},
+	})
+	require.NoError(t, err)
+	h := NewHandler(st, st, nil, nil, nil, "")
+
+	newRouter := func(h *Handler) chi.Router {
+		r := chi.NewRouter()
+		r.Use(h.TokenAuthMiddleware)
+		r.Delete("/value/", h.BulkDeleteHandler)
+		r.Delete("/value/{mType}/{mName}", h.DeleteHandler)
+		r.Post("/reset/counter/{mName}", h.ResetHandler)
+		return r
+	}
+	serve := func(r chi.Router, method, target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Без настроенной авторизации удаление и сброс запрещены.
+	noAuth := newRouter(h)
+	require.Equal(t, http.StatusForbidden, serve(noAuth, http.MethodDelete, "/value/gauge/Alloc", "").Code)
+	require.Equal(t, http.StatusForbidden, serve(noAuth, http.MethodDelete, "/value/?pattern=*", "").Code)
+	require.Equal(t, http.StatusForbidden, serve(noAuth, http.MethodPost, "/reset/counter/PollCount", "").Code)
+	require.Len(t, st.GetAllMetrics(), 5)
+
+	h.SetAuthorizer(authorizer)
+	r := newRouter(h)
+	require.Equal(t, http.StatusUnauthorized, serve(r, http.MethodDelete, "/value/gauge/Alloc", "").Code)
+	require.Equal(t, http.StatusForbidden, serve(r, http.MethodDelete, "/value/gauge/Alloc", "w").Code)
+	require.Len(t, st.GetAllMetrics(), 5)
+	do := func(method, target string) *httptest.ResponseRecorder {
+		return serve(r, method, target, "a")
+	}
+
+	require.Equal(t, http.StatusOK, do(http.MethodDelete, "/value/gauge/Alloc").Code)
+	require.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/value/gauge/Alloc").Code)
+	_, err = st.GetMetricByName("Alloc", "gauge")
+	require.Error(t, err)
+
+	require.Equal(t, http.StatusBadRequest, do(http.MethodDelete, "/value/").Code, "pattern is required")
+	require.Equal(t, http.StatusBadRequest, do(http.MethodDelete, "/value/?pattern=cpu_[").Code)
+	rr := do(http.MethodDelete, "/value/?pattern=cpu_*&type=gauge")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var resp DeleteResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.Equal(t, 2, resp.Deleted)
+	require.ElementsMatch(t, []DeletedMetric{{ID: "cpu_1", MType: "gauge"}, {ID: "cpu_2", MType: "gauge"}}, resp.Metrics)
+	_, err = st.GetMetricByName("cpu_3", "counter")
+	require.NoError(t, err, "other types must be kept")
+
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/reset/counter/PollCount").Code)
+	require.Equal(t, http.StatusNotFound, do(http.MethodPost, "/reset/counter/missing").Code)
+	m, err := st.GetMetricByName("PollCount", "counter")
+	require.NoError(t, err)
+	require.Equal(t, int64(0), *m.Delta)
+
+	ctrl := gomock.NewController(t)
+	noRemover := NewHandler(mocks.NewMockMetricReader(ctrl), mocks.NewMockMetricWriter(ctrl), nil, nil, nil, "")
+	noRemover.SetAuthorizer(authorizer)
+	require.Equal(t, http.StatusNotImplemented, serve(newRouter(noRemover), http.MethodDelete, "/value/?pattern=*", "a").Code)
+}
+
+func TestRootHandlerMarksStaleSeries(t *testing.T) {
+	st, err := storage.NewJSONStorage(storage.NewFileStoreInfo(filepath.Join(t.TempDir(), "metrics.json"), time.Hour, false))
+	require.NoError(t, err)
+	require.NoError(t, st.AppendMetrics([]models.Metrics{
+		{ID: "FreeMemory", MType: "gauge", Value: models.Float64Ptr(1)},
+		{ID: "PollCount", MType: "counter", Delta: models.Int64Ptr(2)},
+	}))
+	policy, err := storage.ParseStalePolicy("gauge=1ns", storage.StaleMark)
+	require.NoError(t, err)
+	h := NewHandler(st, st, nil, nil, nil, "")
+	h.SetStalePolicy(policy)
+	time.Sleep(time.Millisecond)
+
+	rr := httptest.NewRecorder()
+	h.RootHandler(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Contains(t, rr.Body.String(), `<tr class="metric stale">
+<td>Free</td>
+<td><a href="/metric/gauge/FreeMemory">FreeMemory</a> <span class="badge">stale</span></td>`)
+	require.Contains(t, rr.Body.String(), `<tr class="metric">
+<td>Poll</td>`)
+}
+
+func TestListMetricsHandler(t *testing.T) {
+	st, err := storage.NewJSONStorage(storage.NewFileStoreInfo(filepath.Join(t.TempDir(), "metrics.json"), time.Hour, false))
+	require.NoError(t, err)
+	require.NoError(t, st.AppendMetrics([]models.Metrics{
+		{ID: "cpu_1", MType: "gauge", Value: models.Float64Ptr(1)},
+		{ID: "cpu_2", MType: "gauge", Value: models.Float64Ptr(2)},
+		{ID: "cpu_3", MType: "counter", Delta: models.Int64Ptr(3)},
+		{ID: "Alloc", MType: "gauge", Value: models.Float64Ptr(5)},
+	}))
+	h := NewHandler(st, st, nil, nil, nil, "")
+	list := func(target string) (int, ListResponse) {
+		rr := httptest.NewRecorder()
+		h.ListMetricsHandler(rr, httptest.NewRequest(http.MethodGet, target, nil))
+		var resp ListResponse
+		if rr.Code == http.StatusOK {
+			require.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		}
+		return rr.Code, resp
+	}
+
+	code, resp := list("/api/v1/metrics?prefix=cpu_&sort=-name&limit=2")
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, resp.Metrics, 2)
+	require.Equal(t, "cpu_3", resp.Metrics[0].ID)
+	require.Equal(t, "cpu_2", resp.Metrics[1].ID)
+	require.NotEmpty(t, resp.NextCursor)
+
+	code, next := list("/api/v1/metrics?prefix=cpu_&sort=-name&limit=2&cursor=" + resp.NextCursor)
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, next.Metrics, 1)
+	require.Equal(t, "cpu_1", next.Metrics[0].ID)
+	require.Empty(t, next.NextCursor)
+
+	code, resp = list("/api/v1/metrics?type=counter&regex=^none$")
+	require.Equal(t, http.StatusOK, code)
+	require.NotNil(t, resp.Metrics)
+	require.Empty(t, resp.Metrics)
+
+	for _, target := range []string{
+		"/api/v1/metrics?type=histogram",
+		"/api/v1/metrics?regex=cpu_(",
+		"/api/v1/metrics?limit=0",
+		"/api/v1/metrics?limit=abc",
+		"/api/v1/metrics?sort=value",
+		"/api/v1/metrics?cursor=%21%21",
+		"/api/v1/metrics?sort=name&cursor=" + resp.NextCursor + "x",
+	} {
+		code, _ := list(target)
+		require.Equal(t, http.StatusBadRequest, code, target)
+	}
+	_, first := list("/api/v1/metrics?limit=1")
+	code, _ = list("/api/v1/metrics?sort=type&cursor=" + first.NextCursor)
+	require.Equal(t, http.StatusBadRequest, code, "cursor is bound to the sort order")
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Fuonder/metriccoll.git/internal/buildinfo"
+	"github.com/Fuonder/metriccoll.git/internal/logger"
+	"github.com/Fuonder/metriccoll.git/internal/storage"
+	"github.com/Fuonder/metriccoll.git/internal/validation/filevalidation"
+	"go.uber.org/zap"
+)
+
+// ErrBuildInfoNotSet возвращается /version, если информация о сборке не задана (см. SetBuildInfo).
+var ErrBuildInfoNotSet = ErrorResponse{Code: http.StatusNotImplemented, Message: "build info is not available"}
+
+// Состояния проверок /healthz и /readyz.
+const (
+	HealthOK       = "ok"                 // Проверка пройдена.
+	HealthReady    = "ready"              // Сервер готов принимать запись.
+	HealthNotReady = "not_ready"          // Сервер не может принимать запись.
+	HealthDegraded = storage.ModeDegraded // БД недоступна, записи накапливаются в файле снимка.
+)
+
+// Имена проверок готовности в ответе /readyz.
+const (
+	ReadyCheckDatabase = "database" // Подключение к БД (MetricDatabaseHandler.CheckConnection).
+	ReadyCheckFile     = "file"     // Доступность файла хранилища на запись.
+)
+
+// HealthResponse — ответ /healthz и /readyz.
+type HealthResponse struct {
+	Status string            `json:"status"`           // HealthOK, HealthReady или HealthNotReady.
+	Checks map[string]string `json:"checks,omitempty"` // Результаты проверок: HealthOK, HealthDegraded или текст ошибки.
+}
+
+// SetBuildInfo задает информацию о сборке, которую отдает /version.
+func (h *Handler) SetBuildInfo(info *buildinfo.BuildInfo) {
+	h.buildInfo = info
+}
+
+// SetStorageFile задает файл хранилища (JSON или снимок), доступность каталога которого
+// на запись проверяет /readyz. Пустой путь отключает проверку.
+func (h *Handler) SetStorageFile(path string) {
+	h.storageFile = path
+}
+
+func writeHealth(rw http.ResponseWriter, code int, resp any) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(code)
+	if err := json.NewEncoder(rw).Encode(resp); err != nil {
+		logger.Log.Info("can not write health response", zap.Error(err))
+	}
+}
+
+// HealthzHandler сообщает, что процесс сервера запущен и обрабатывает запросы (liveness).
+// Состояние хранилища не проверяется, чтобы недоступность БД не приводила к перезапуску сервера.
+//
+// Возвращает:
+//
+//   - 200 OK: {"status": "ok"}.
+func (h *Handler) HealthzHandler(rw http.ResponseWriter, r *http.Request) {
+	writeHealth(rw, http.StatusOK, HealthResponse{Status: HealthOK})
+}
+
+// ReadyzHandler сообщает, может ли сервер принимать запись метрик (readiness).
+//
+// Проверяются подключение к БД (если хранилище ее использует) и возможность записать
+// файл хранилища (см. SetStorageFile): файл пишется через временный файл и переименование,
+// поэтому проверяется каталог, а сам файл не создается. Если хранилище с БД работает в режиме
+// degraded (см. storage.CompositeStorage), записи накапливаются в файле снимка, поэтому
+// недоступность БД не делает сервер неготовым, пока файл доступен на запись; в ответе
+// проверка database получает значение "degraded". Пример ответа:
+//
+//	{"status": "not_ready", "checks": {"database": "connection refused", "file": "ok"}}
+//
+// Возвращает:
+//
+//   - 200 OK: сервер готов принимать запись.
+//   - 503 Service Unavailable: хранилище недоступно для записи.
+func (h *Handler) ReadyzHandler(rw http.ResponseWriter, r *http.Request) {
+	resp := HealthResponse{Status: HealthReady, Checks: make(map[string]string)}
+	ready := true
+
+	fileOK := true
+	if h.storageFile != "" {
+		resp.Checks[ReadyCheckFile] = HealthOK
+		if err := filevalidation.CheckDirWritable(h.storageFile); err != nil {
+			resp.Checks[ReadyCheckFile] = err.Error()
+			fileOK, ready = false, false
+		}
+	}
+
+	if h.mDBHandler != nil {
+		resp.Checks[ReadyCheckDatabase] = HealthOK
+		if err := h.mDBHandler.CheckConnection(); err != nil {
+			resp.Checks[ReadyCheckDatabase] = err.Error()
+			reporter, ok := h.mDBHandler.(storage.StatusReporter)
+			if ok && reporter.Status().Mode == storage.ModeDegraded && h.storageFile != "" && fileOK {
+				resp.Checks[ReadyCheckDatabase] = HealthDegraded
+			} else {
+				ready = false
+			}
+		}
+	}
+
+	code := http.StatusOK
+	if !ready {
+		resp.Status = HealthNotReady
+		code = http.StatusServiceUnavailable
+		logger.Log.Info("server is not ready", zap.Any("checks", resp.Checks))
+	}
+	writeHealth(rw, code, resp)
+}
+
+// VersionHandler возвращает информацию о сборке сервера в формате JSON (см. buildinfo.BuildInfo):
+//
+//	{"version": "v1.2.0", "commit_id": "a076dec", "time": "2026-10-19T04:30:00Z", "compiler": "go1.23.4"}
+//
+// Возвращает:
+//
+//   - 200 OK: информация о сборке.
+//   - 501 Not Implemented: информация о сборке не задана.
+func (h *Handler) VersionHandler(rw http.ResponseWriter, r *http.Request) {
+	if h.buildInfo == nil {
+		writeHealth(rw, ErrBuildInfoNotSet.Code, ErrBuildInfoNotSet)
+		return
+	}
+	writeHealth(rw, http.StatusOK, h.buildInfo)
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Fuonder/metriccoll.git/internal/buildinfo"
+	"github.com/Fuonder/metriccoll.git/internal/storage"
+	mocks "github.com/Fuonder/metriccoll.git/internal/storage/mocks"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthzHandler(t *testing.T) {
+	h := NewHandler(nil, nil, nil, nil, nil, "")
+	rr := httptest.NewRecorder()
+	h.HealthzHandler(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.JSONEq(t, `{"status":"ok"}`, rr.Body.String())
+}
+
+func TestReadyzHandler(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	dir := t.TempDir()
+	writable := filepath.Join(dir, "metrics.json")
+	missingDir := filepath.Join(dir, "missing", "metrics.json")
+
+	dbUp := mocks.NewMockMetricDatabaseHandler(ctrl)
+	dbUp.EXPECT().CheckConnection().Return(nil).AnyTimes()
+	dbDown := mocks.NewMockMetricDatabaseHandler(ctrl)
+	dbDown.EXPECT().CheckConnection().Return(errors.New("connection refused")).AnyTimes()
+
+	degraded, err := storage.NewCompositeStorage(nil, storage.NewFileStoreInfo(filepath.Join(dir, "snapshot.json"), time.Hour, false))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		db           storage.MetricDatabaseHandler
+		file         string
+		expectedCode int
+		expected     HealthResponse
+	}{
+		{"FileWritable", nil, writable, http.StatusOK,
+			HealthResponse{Status: HealthReady, Checks: map[string]string{ReadyCheckFile: HealthOK}}},
+		{"DatabaseUp", dbUp, "", http.StatusOK,
+			HealthResponse{Status: HealthReady, Checks: map[string]string{ReadyCheckDatabase: HealthOK}}},
+		{"DatabaseDown", dbDown, "", http.StatusServiceUnavailable,
+			HealthResponse{Status: HealthNotReady, Checks: map[string]string{ReadyCheckDatabase: "connection refused"}}},
+		{"DegradedWithSnapshot", degraded, writable, http.StatusOK,
+			HealthResponse{Status: HealthReady, Checks: map[string]string{ReadyCheckDatabase: HealthDegraded, ReadyCheckFile: HealthOK}}},
+		{"DegradedWithoutSnapshot", degraded, "", http.StatusServiceUnavailable,
+			HealthResponse{Status: HealthNotReady, Checks: map[string]string{ReadyCheckDatabase: storage.ErrPrimaryUnavailable.Error()}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandler(nil, nil, nil, tt.db, nil, "")
+			h.SetStorageFile(tt.file)
+			rr := httptest.NewRecorder()
+			h.ReadyzHandler(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			require.Equal(t, tt.expectedCode, rr.Code)
+			require.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+			var resp HealthResponse
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+			require.Equal(t, tt.expected, resp)
+		})
+	}
+
+	t.Run("FileNotWritable", func(t *testing.T) {
+		h := NewHandler(nil, nil, nil, nil, nil, "")
+		h.SetStorageFile(missingDir)
+		rr := httptest.NewRecorder()
+		h.ReadyzHandler(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		require.Equal(t, http.StatusServiceUnavailable, rr.Code)
+
+		var resp HealthResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		require.Equal(t, HealthNotReady, resp.Status)
+		require.Contains(t, resp.Checks[ReadyCheckFile], "can not create file")
+	})
+
+	t.Run("FileNotCreated", func(t *testing.T) {
+		h := NewHandler(nil, nil, nil, nil, nil, "")
+		h.SetStorageFile(writable)
+		rr := httptest.NewRecorder()
+		h.ReadyzHandler(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.NoFileExists(t, writable, "readiness probe must not create the store file")
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		for _, e := range entries {
+			require.NotContains(t, e.Name(), ".tmp-", "probe file must be removed")
+		}
+	})
+}
+
+func TestVersionHandler(t *testing.T) {
+	h := NewHandler(nil, nil, nil, nil, nil, "")
+	rr := httptest.NewRecorder()
+	h.VersionHandler(rr, httptest.NewRequest(http.MethodGet, "/version", nil))
+	require.Equal(t, http.StatusNotImplemented, rr.Code)
+
+	h.SetBuildInfo(&buildinfo.BuildInfo{
+		BuildVersion: "v1.2.0",
+		BuildCommit:  "a076dec",
+		BuildDate:    time.Date(2026, 10, 19, 4, 30, 0, 0, time.UTC),
+		Compiler:     "go1.23.4",
+	})
+	rr = httptest.NewRecorder()
+	h.VersionHandler(rr, httptest.NewRequest(http.MethodGet, "/version", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.JSONEq(t, `{"version":"v1.2.0","commit_id":"a076dec","time":"2026-10-19T04:30:00Z","compiler":"go1.23.4"}`, rr.Body.String())
+}
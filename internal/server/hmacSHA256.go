@@ -3,11 +3,9 @@
 package server
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/base64"
 	"net/http"
 
+	"github.com/Fuonder/metriccoll.git/internal/hmacsign"
 	"github.com/Fuonder/metriccoll.git/internal/logger"
 )
 
@@ -52,21 +50,15 @@ func (hw *hashWriter) WriteHeader(statusCode int) {
 // calculateHMAC вычисляет HMAC-SHA256 для заданного тела сообщения и ключа.
 // Возвращает HMAC в виде строки, закодированной в base64.
 func calculateHMAC(body []byte, key string) (string, error) {
-	hm := hmac.New(sha256.New, []byte(key))
-	hm.Write(body)
-	s := hm.Sum(nil)
-	return base64.URLEncoding.EncodeToString(s), nil
+	return hmacsign.SignBody(key, body), nil
 }
 
-// validateHMAC проверяет, совпадает ли HMAC-подпись из запроса с вычисленным значением.
-// Возвращает ErrMismatchedHash в случае несовпадения.
-func validateHMAC(packetHash string, body []byte, key string) error {
-	calculatedHash, err := calculateHMAC(body, key)
-	if err != nil {
-		return err
-	}
-	if packetHash != calculatedHash {
-		return ErrMismatchedHash
-	}
-	return nil
+// newRequestVerifier создает hmacsign.RequestVerifier для заголовков запроса r.
+// Тело запроса передается в него по мере чтения.
+func newRequestVerifier(r *http.Request, keys []string) *hmacsign.RequestVerifier {
+	return hmacsign.NewRequestVerifier(keys,
+		r.Method,
+		r.URL.RequestURI(),
+		r.Header.Get(hmacsign.HeaderTimestamp),
+		r.Header.Get(hmacsign.HeaderNonce))
 }
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/Fuonder/metriccoll.git/internal/auth"
+	"github.com/Fuonder/metriccoll.git/internal/idempotency"
+	"github.com/Fuonder/metriccoll.git/internal/logger"
+	"go.uber.org/zap"
+)
+
+// recordingWriter передает ответ дальше и одновременно запоминает его статус и тело.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+// WriteHeader запоминает и отправляет HTTP-статус.
+func (w *recordingWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write запоминает и отправляет часть тела ответа.
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(p)
+	return w.ResponseWriter.Write(p)
+}
+
+// SetIdempotencyStore включает обработку заголовка Idempotency-Key хранилищем store.
+func (h *Handler) SetIdempotencyStore(store idempotency.Store) {
+	h.idempotency = store
+}
+
+// WithIdempotency оборачивает обработчик записи так, чтобы запрос с заголовком Idempotency-Key
+// применялся не более одного раза. Ключ резервируется до вызова next; успешный (2xx) ответ
+// сохраняется и возвращается повторным запросам с тем же ключом с заголовком Idempotent-Replayed,
+// а при неуспешном ответе резерв снимается, и повтор будет выполнен заново.
+// Пока первый запрос выполняется, повтор получает 409 Conflict.
+// Ключи разных API-токенов не пересекаются.
+func (h *Handler) WithIdempotency(next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotency.HeaderKey)
+		if h.idempotency == nil || key == "" {
+			next(rw, r)
+			return
+		}
+		if err := idempotency.ValidateKey(key); err != nil {
+			logger.Log.Info("invalid idempotency key", zap.Error(err))
+			http.Error(rw, `{"error": "invalid idempotency key"}`, http.StatusBadRequest)
+			return
+		}
+		if principal, ok := auth.FromContext(r.Context()); ok {
+			key = idempotency.ScopedKey(principal.ID, key)
+		}
+
+		stored, err := h.idempotency.Begin(r.Context(), key)
+		switch {
+		case errors.Is(err, idempotency.ErrInProgress):
+			logger.Log.Info("idempotent request is in progress", zap.String("key", key))
+			rw.Header().Set("Retry-After", "1")
+			http.Error(rw, `{"error": "request with this idempotency key is in progress"}`, http.StatusConflict)
+			return
+		case err != nil:
+			logger.Log.Warn("can not reserve idempotency key", zap.Error(err))
+			http.Error(rw, `{"error": "can not check idempotency key"}`, http.StatusInternalServerError)
+			return
+		case stored != nil:
+			logger.Log.Info("replaying idempotent response", zap.String("key", key))
+			if stored.ContentType != "" {
+				rw.Header().Set("Content-Type", stored.ContentType)
+			}
+			rw.Header().Set(idempotency.HeaderReplayed, "true")
+			rw.WriteHeader(stored.Status)
+			_, _ = rw.Write(stored.Body)
+			return
+		}
+
+		rec := &recordingWriter{ResponseWriter: rw}
+		next(rec, r)
+
+		// Результат сохраняется, даже если клиент уже отключился: именно тогда он и повторит запрос.
+		ctx := context.WithoutCancel(r.Context())
+		if rec.status >= 200 && rec.status < 300 {
+			err = h.idempotency.Complete(ctx, key, idempotency.Response{
+				Status:      rec.status,
+				ContentType: rec.Header().Get("Content-Type"),
+				Body:        rec.body.Bytes(),
+			})
+		} else {
+			err = h.idempotency.Release(ctx, key)
+		}
+		if err != nil {
+			logger.Log.Warn("can not store idempotency key", zap.String("key", key), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Fuonder/metriccoll.git/internal/logger"
+	"github.com/Fuonder/metriccoll.git/internal/models"
+	"github.com/Fuonder/metriccoll.git/internal/storage"
+	"go.uber.org/zap"
+)
+
+// ErrInvalidCursor возвращается, если курсор страницы поврежден или выдан для другой сортировки.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ListResponse — тело ответа ListMetricsHandler.
+type ListResponse struct {
+	Metrics []models.Metrics `json:"metrics"`
+	// NextCursor передается в параметре cursor для получения следующей страницы;
+	// отсутствует на последней странице.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// listCursor — содержимое курсора страницы: последняя метрика страницы и сортировка,
+// для которой курсор выдан.
+type listCursor struct {
+	Sort  storage.MetricSort `json:"s"`
+	Desc  bool               `json:"d,omitempty"`
+	MType string             `json:"t"`
+	ID    string             `json:"i"`
+}
+
+func encodeCursor(q storage.MetricQuery, key models.MetricKey) string {
+	data, _ := json.Marshal(listCursor{Sort: q.Sort, Desc: q.Desc, MType: key.MType, ID: key.ID})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(q storage.MetricQuery, value string) (*models.MetricKey, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c listCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if c.Sort != q.Sort || c.Desc != q.Desc {
+		return nil, fmt.Errorf("%w: cursor was issued for another sort order", ErrInvalidCursor)
+	}
+	return &models.MetricKey{ID: c.ID, MType: c.MType}, nil
+}
+
+// parseListQuery разбирает параметры запроса ListMetricsHandler.
+func parseListQuery(r *http.Request) (storage.MetricQuery, error) {
+	params := r.URL.Query()
+	q := storage.MetricQuery{
+		MType:  params.Get("type"),
+		Prefix: params.Get("prefix"),
+		Regex:  params.Get("regex"),
+	}
+	if sortBy := params.Get("sort"); sortBy != "" {
+		q.Desc = strings.HasPrefix(sortBy, "-")
+		q.Sort = storage.MetricSort(strings.TrimPrefix(sortBy, "-"))
+	}
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return q, fmt.Errorf("%w: invalid limit %q", storage.ErrInvalidQuery, limit)
+		}
+		q.Limit = n
+	}
+	if err := q.Normalize(); err != nil {
+		return q, err
+	}
+	if cursor := params.Get("cursor"); cursor != "" {
+		after, err := decodeCursor(q, cursor)
+		if err != nil {
+			return q, err
+		}
+		q.After = after
+	}
+	return q, nil
+}
+
+// ListMetricsHandler возвращает список метрик с фильтрацией, сортировкой и постраничным чтением.
+// Фильтрация выполняется хранилищем (см. storage.MetricQuerier), например, в SQL для PostgreSQL.
+//
+// Параметры запроса:
+//
+//   - type: тип метрики (gauge | counter), по умолчанию — любой.
+//   - prefix: префикс имени.
+//   - regex: регулярное выражение для имени (синтаксис RE2).
+//   - sort: name (по умолчанию) или type; префикс "-" задает сортировку по убыванию.
+//   - limit: размер страницы, от 1 до storage.MaxQueryLimit (по умолчанию storage.DefaultQueryLimit).
+//   - cursor: значение next_cursor из ответа с предыдущей страницей.
+//
+// Формат ответа (application/json):
+//
+//	{"metrics": [{"id": "Alloc", "type": "gauge", "value": 1.5, "updated_at": "..."}], "next_cursor": "..."}
+//
+// Возвращает:
+//
+//   - 200 OK: страница метрик (возможно пустая).
+//   - 400 Bad Request: некорректные параметры или курсор.
+//   - 500 Internal Server Error: внутренняя ошибка.
+func (h *Handler) ListMetricsHandler(rw http.ResponseWriter, r *http.Request) {
+	if h.mReader == nil {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(ErrMetricReaderNotInitialized.Code)
+		_ = json.NewEncoder(rw).Encode(ErrMetricReaderNotInitialized)
+		return
+	}
+	q, err := parseListQuery(r)
+	if err != nil {
+		logger.Log.Info("invalid metric list query", zap.Error(err))
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, err := storage.QueryReader(r.Context(), h.mReader, q)
+	if err != nil {
+		logger.Log.Info("can not list metrics", zap.Error(err))
+		http.Error(rw, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := ListResponse{Metrics: page.Metrics}
+	if resp.Metrics == nil {
+		resp.Metrics = []models.Metrics{}
+	}
+	if page.Next != nil {
+		resp.NextCursor = encodeCursor(q, *page.Next)
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(rw).Encode(resp)
+}
@@ -2,13 +2,19 @@ package server
 
 import (
 	"bytes"
+	"errors"
+	"github.com/Fuonder/metriccoll.git/internal/auth"
+	"github.com/Fuonder/metriccoll.git/internal/hmacsign"
 	"github.com/Fuonder/metriccoll.git/internal/logger"
 	"github.com/Fuonder/metriccoll.git/internal/models"
+	"github.com/Fuonder/metriccoll.git/internal/subnet"
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 	"io"
+	"net"
 	"net/http"
 	"strings"
+	"time"
 )
 
 var validContentTypes = map[string]struct{}{
@@ -23,11 +29,11 @@ func isValidContentType(ct string) bool {
 	return ok || ct == ""
 }
 
-// CheckMethod проверяет, что метод запроса является GET или POST.
+// CheckMethod проверяет, что метод запроса является GET, POST или DELETE.
 func (h *Handler) CheckMethod(next http.Handler) http.Handler {
 	logger.Log.Debug("checking method")
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		if r.Method != http.MethodPost && r.Method != http.MethodGet && r.Method != http.MethodDelete {
 			logger.Log.Info("wrong method", zap.String("method", r.Method))
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
@@ -155,28 +161,63 @@ func GzipMiddleware(h http.HandlerFunc) http.HandlerFunc {
 }
 
 // HashMiddleware проверяет подпись HMAC (если задан ключ) и отклоняет запросы с некорректной подписью.
+// Подпись охватывает метод, путь, метку времени и nonce запроса (см. пакет hmacsign), поэтому
+// запросы с устаревшей меткой времени или повторно использованным nonce также отклоняются.
+// В строгом режиме (SetStrictHMAC) отклоняются и неподписанные запросы, кроме GET.
 func (h *Handler) HashMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		if h.hashKey == "" {
+		if len(h.hashKeys) == 0 {
 			next.ServeHTTP(rw, r)
 			return
 		}
 		logger.Log.Info("Validating HMAC")
-		if HMACPresent := r.Header.Get("HashSHA256"); HMACPresent != "" {
-			var bodyCopy bytes.Buffer
-			teeReader := io.TeeReader(r.Body, &bodyCopy)
-			body, err := io.ReadAll(teeReader)
-			if err != nil {
-				http.Error(rw, "Error reading request body", http.StatusInternalServerError)
+		if HMACPresent := r.Header.Get(hmacsign.HeaderSignature); HMACPresent != "" {
+			timestamp := r.Header.Get(hmacsign.HeaderTimestamp)
+			nonce := r.Header.Get(hmacsign.HeaderNonce)
+			if timestamp == "" || nonce == "" {
+				logger.Log.Info("Validation", zap.Error(hmacsign.ErrMissingReplayHeaders))
+				h.selfMetrics.hmacFailure(hmacFailureMissingHeaders)
+				http.Error(rw, hmacsign.ErrMissingReplayHeaders.Error(), http.StatusBadRequest)
+				return
+			}
+			now := time.Now()
+			if err := hmacsign.CheckTimestamp(timestamp, now, h.replayWindow); err != nil {
+				logger.Log.Info("Validation", zap.Error(err))
+				h.selfMetrics.hmacFailure(hmacFailureTimestamp)
+				http.Error(rw, err.Error(), http.StatusUnauthorized)
 				return
 			}
-			err = validateHMAC(HMACPresent, body, h.hashKey)
+
+			verifier := newRequestVerifier(r, h.hashKeys)
+			body, err := spoolBody(r.Body, verifier)
 			if err != nil {
+				logger.Log.Info("Error reading request body", zap.Error(err))
+				writeBodyError(rw, errors.Join(ErrReadBody, err), http.StatusInternalServerError)
+				return
+			}
+			defer func(body io.ReadCloser) {
+				if err := body.Close(); err != nil {
+					logger.Log.Debug("can not close body", zap.Error(err))
+				}
+			}(body)
+			if !verifier.Verify(r.Header.Get(hmacsign.HeaderSignature)) {
+				h.selfMetrics.hmacFailure(hmacFailureMismatch)
 				http.Error(rw, ErrMismatchedHash.Error(), http.StatusBadRequest)
 				return
 			}
+			if err := h.nonces.Use(nonce, now); err != nil {
+				logger.Log.Info("Validation", zap.String("nonce", nonce), zap.Error(err))
+				h.selfMetrics.hmacFailure(hmacFailureReplay)
+				http.Error(rw, err.Error(), http.StatusUnauthorized)
+				return
+			}
 			logger.Log.Info("Validation", zap.String("HMAC", "CORRECT"))
-			r.Body = io.NopCloser(&bodyCopy)
+			r.Body = body
+		} else if h.strictHMAC && r.Method != http.MethodGet {
+			logger.Log.Info("Validation", zap.String("HMAC", "No HMAC in request found, rejecting in strict mode"))
+			h.selfMetrics.hmacFailure(hmacFailureMissing)
+			http.Error(rw, ErrMissingHash.Error(), http.StatusUnauthorized)
+			return
 		} else {
 			logger.Log.Info("Validation", zap.String("HMAC", "No HMAC in request found, skipping validation"))
 		}
@@ -184,6 +225,158 @@ func (h *Handler) HashMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// remoteIP возвращает адрес, с которого установлено соединение.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// TrustedSubnetMiddleware отклоняет с кодом 403 запросы агентов, IP-адрес которых
+// не входит в доверенные подсети. Если подсети не заданы, запросы пропускаются без проверки.
+// Адрес из заголовка X-Real-IP принимается сам по себе только от доверенного прокси
+// (см. SetTrustedProxies), иначе проверяется и адрес соединения (см. subnet.Trusted.Allow).
+func (h *Handler) TrustedSubnetMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if !h.trusted.Enabled() {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		ip, ok, err := h.trusted.Allow(remoteIP(r), r.Header.Get(subnet.HeaderRealIP), h.trustedProxies)
+		if err != nil || !ok {
+			logger.Log.Info("request from untrusted ip rejected",
+				zap.String("ip", ip),
+				zap.String("path", r.URL.Path),
+				zap.Error(err))
+			http.Error(rw, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// routeScope определяет, какое право требуется для обращения к endpoint'у.
+// Пустая строка означает, что endpoint не требует авторизации.
+func routeScope(r *http.Request) string {
+	path := r.URL.Path
+	switch {
+	case r.Method == http.MethodDelete || strings.HasPrefix(path, "/reset"):
+		return auth.ScopeAdmin
+	case strings.HasPrefix(path, "/update"):
+		return auth.ScopeWrite
+	case path == "/" || strings.HasPrefix(path, "/value") || strings.HasPrefix(path, "/metric/") ||
+		strings.HasPrefix(path, "/api/v1/metrics") || path == "/stream" || path == "/metrics":
+		return auth.ScopeRead
+	case strings.HasPrefix(path, "/debug/"):
+		return auth.ScopeAdmin
+	}
+	return ""
+}
+
+// logRejected записывает в лог отклоненный по токену запрос.
+func logRejected(r *http.Request, tokenID string, err error) {
+	logger.Log.Warn("request rejected by token auth",
+		zap.String("token_id", tokenID),
+		zap.String("method", r.Method),
+		zap.String("path", r.URL.Path),
+		zap.Error(err))
+}
+
+// TokenAuthMiddleware проверяет API-токен из заголовка Authorization (если настроена авторизация)
+// и права токена на обращение к endpoint'у. Права на запись конкретных метрик проверяются
+// в обработчиках через authorizeWrite, так как имена метрик могут находиться в теле запроса.
+func (h *Handler) TokenAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if h.authorizer == nil {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		scope := routeScope(r)
+		token := auth.TokenFromHeader(r.Header.Get("Authorization"))
+		if token == "" && (scope == "" || (scope == auth.ScopeRead && !h.authorizer.RequireRead())) {
+			next.ServeHTTP(rw, r)
+			return
+		}
+
+		principal, err := h.authorizer.Authenticate(token)
+		if err != nil {
+			logRejected(r, "", err)
+			http.Error(rw, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		allowed := true
+		switch scope {
+		case auth.ScopeRead:
+			// Если чтение доступно без токена, токен без права "read" не должен его запрещать.
+			allowed = principal.CanRead() || !h.authorizer.RequireRead()
+		case auth.ScopeWrite:
+			allowed = principal.CanWriteAny()
+		case auth.ScopeAdmin:
+			allowed = principal.IsAdmin()
+		}
+		if !allowed {
+			logRejected(r, principal.ID, auth.ErrForbidden)
+			http.Error(rw, auth.ErrForbidden.Error(), http.StatusForbidden)
+			return
+		}
+		logger.Log.Debug("token auth - OK", zap.String("token_id", principal.ID))
+		next.ServeHTTP(rw, r.WithContext(auth.NewContext(r.Context(), principal)))
+	})
+}
+
+// authorizeWrite проверяет, что токен запроса имеет право записи каждой из метрик names.
+// Если авторизация не настроена, запись разрешена.
+func (h *Handler) authorizeWrite(r *http.Request, names ...string) error {
+	if h.authorizer == nil {
+		return nil
+	}
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		logRejected(r, "", auth.ErrNoToken)
+		return auth.ErrNoToken
+	}
+	if err := principal.Authorize(names...); err != nil {
+		logRejected(r, principal.ID, err)
+		return err
+	}
+	return nil
+}
+
+// ErrAdminAuthRequired возвращается удалением и сбросом метрик, если авторизация по токенам не настроена:
+// без токенов с правами "admin" эти операции были бы доступны любому клиенту.
+var ErrAdminAuthRequired = errors.New("token auth with admin scope is required for this operation")
+
+// authorizeAdmin проверяет, что запрос выполнен с токеном с правами "admin".
+// Если авторизация не настроена, операция запрещена.
+func (h *Handler) authorizeAdmin(r *http.Request) error {
+	if h.authorizer == nil {
+		logRejected(r, "", ErrAdminAuthRequired)
+		return ErrAdminAuthRequired
+	}
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		logRejected(r, "", auth.ErrNoToken)
+		return auth.ErrNoToken
+	}
+	if !principal.IsAdmin() {
+		logRejected(r, principal.ID, auth.ErrForbidden)
+		return auth.ErrForbidden
+	}
+	return nil
+}
+
+// writeAuthError отправляет ответ с кодом, соответствующим ошибке авторизации.
+func writeAuthError(rw http.ResponseWriter, err error) {
+	if errors.Is(err, auth.ErrNoToken) {
+		http.Error(rw, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	http.Error(rw, err.Error(), http.StatusForbidden)
+}
+
 // WithHashing добавляет подпись HMAC к ответу сервера, если задан ключ.
 func (h *Handler) WithHashing(next http.HandlerFunc) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
@@ -195,21 +388,41 @@ func (h *Handler) WithHashing(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// maxCipherBlockSize — максимальный размер шифротекста RSA (ключ 8192 бит),
+// который агент может передать одним блоком.
+const maxCipherBlockSize = 1024
+
+// DecryptionMiddleware расшифровывает тело запроса закрытым ключом сервера.
+// Зашифровано может быть только тело, помещающееся в один блок RSA, поэтому в память
+// читается не больше maxCipherBlockSize байт, а более длинные тела передаются дальше как есть.
 func (h *Handler) DecryptionMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		ciphertext, err := io.ReadAll(r.Body)
-		if err != nil {
-			http.Error(rw, "Failed to read body", http.StatusInternalServerError)
+		head := make([]byte, maxCipherBlockSize+1)
+		n, err := io.ReadFull(r.Body, head)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			writeBodyError(rw, err, http.StatusInternalServerError)
 			return
 		}
-		defer r.Body.Close()
-		if len(ciphertext) == 0 {
+		head = head[:n]
+		if n == 0 {
 			next.ServeHTTP(rw, r)
 			return
 		}
+		if n > maxCipherBlockSize {
+			// Тело длиннее одного блока RSA и не может быть зашифровано агентом:
+			// передаем его дальше без расшифровки и без чтения в память.
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(head), r.Body), r.Body}
+			next.ServeHTTP(rw, r)
+			return
+		}
+		defer r.Body.Close()
 
-		plaintext, err := h.cipherManager.Decrypt(ciphertext)
+		plaintext, err := h.cipherManager.Decrypt(head)
 		if err != nil {
+			h.selfMetrics.decryptFailure()
 			http.Error(rw, "Failed to decrypt body", http.StatusInternalServerError)
 			return
 		}
@@ -1,11 +1,17 @@
 package server
 
 import (
+	"bytes"
+	"github.com/Fuonder/metriccoll.git/internal/auth"
+	"github.com/Fuonder/metriccoll.git/internal/hmacsign"
+	"github.com/Fuonder/metriccoll.git/internal/subnet"
 	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/require"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestCheckMethod(t *testing.T) {
@@ -17,7 +23,7 @@ func TestCheckMethod(t *testing.T) {
 		{"ValidGET", http.MethodGet, http.StatusOK},
 		{"ValidPOST", http.MethodPost, http.StatusOK},
 		{"InvalidMethod", http.MethodPut, http.StatusMethodNotAllowed},
-		{"InvalidDELETE", http.MethodDelete, http.StatusMethodNotAllowed},
+		{"ValidDELETE", http.MethodDelete, http.StatusOK},
 	}
 
 	for _, tt := range tests {
@@ -26,6 +32,7 @@ func TestCheckMethod(t *testing.T) {
 			r.Use((&Handler{}).CheckMethod)
 			r.Get("/", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
 			r.Post("/", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+			r.Delete("/", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
 
 			req := httptest.NewRequest(tt.method, "/", nil)
 			rr := httptest.NewRecorder()
@@ -162,3 +169,321 @@ func TestCheckMetricValue(t *testing.T) {
 		})
 	}
 }
+
+func TestTokenAuthMiddleware(t *testing.T) {
+	authorizer, err := auth.NewAuthorizer(auth.Config{
+		RequireRead: true,
+		Tokens: []auth.TokenConfig{
+			{ID: "cpu-writer", Token: "w", Scopes: []string{"write:CPU"}},
+			{ID: "reader", Token: "r", Scopes: []string{"read"}},
+			{ID: "ops", Token: "a", Scopes: []string{"admin"}},
+		},
+	})
+	require.NoError(t, err)
+
+	h := NewHandler(nil, nil, nil, nil, nil, "")
+	h.SetAuthorizer(authorizer)
+
+	r := chi.NewRouter()
+	r.Use(h.TokenAuthMiddleware)
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	r.Get("/ping", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	r.Get("/value/{mType}/{mName}", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	r.Get("/readyz", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	r.Get("/api/v1/metrics", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	r.Get("/stream", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	r.Get("/metrics", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	r.Post("/update/{mType}/{mName}/{mValue}", func(w http.ResponseWriter, r *http.Request) {
+		if err := h.authorizeWrite(r, chi.URLParam(r, "mName")); err != nil {
+			writeAuthError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	r.Delete("/value/{mType}/{mName}", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	r.Post("/reset/counter/{mName}", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+
+	tests := []struct {
+		name         string
+		method       string
+		url          string
+		token        string
+		expectedCode int
+	}{
+		{"ReadNoToken", http.MethodGet, "/", "", http.StatusUnauthorized},
+		{"ReadWithReader", http.MethodGet, "/", "r", http.StatusOK},
+		{"ReadUnknownToken", http.MethodGet, "/", "x", http.StatusUnauthorized},
+		{"ReadWriterForbidden", http.MethodGet, "/", "w", http.StatusForbidden},
+		{"ValueWithReader", http.MethodGet, "/value/gauge/CPU0", "r", http.StatusOK},
+		{"ValueWriterForbidden", http.MethodGet, "/value/gauge/CPU0", "w", http.StatusForbidden},
+		{"ValueAdmin", http.MethodGet, "/value/gauge/CPU0", "a", http.StatusOK},
+		{"ListNoToken", http.MethodGet, "/api/v1/metrics", "", http.StatusUnauthorized},
+		{"ListWithReader", http.MethodGet, "/api/v1/metrics?type=gauge", "r", http.StatusOK},
+		{"StreamNoToken", http.MethodGet, "/stream", "", http.StatusUnauthorized},
+		{"StreamWithReader", http.MethodGet, "/stream?pattern=CPU*", "r", http.StatusOK},
+		{"SelfMetricsNoToken", http.MethodGet, "/metrics", "", http.StatusUnauthorized},
+		{"SelfMetricsWithReader", http.MethodGet, "/metrics", "r", http.StatusOK},
+		{"PingOpen", http.MethodGet, "/ping", "", http.StatusOK},
+		{"ReadinessProbeOpen", http.MethodGet, "/readyz", "", http.StatusOK},
+		{"WriteNoToken", http.MethodPost, "/update/gauge/CPU0/1", "", http.StatusUnauthorized},
+		{"WriteReaderForbidden", http.MethodPost, "/update/gauge/CPU0/1", "r", http.StatusForbidden},
+		{"WriteAllowedPrefix", http.MethodPost, "/update/gauge/CPU0/1", "w", http.StatusOK},
+		{"WriteOtherPrefix", http.MethodPost, "/update/gauge/FreeMemory/1", "w", http.StatusForbidden},
+		{"DeleteNoToken", http.MethodDelete, "/value/gauge/CPU0", "", http.StatusUnauthorized},
+		{"DeleteWriterForbidden", http.MethodDelete, "/value/gauge/CPU0", "w", http.StatusForbidden},
+		{"DeleteAdmin", http.MethodDelete, "/value/gauge/CPU0", "a", http.StatusOK},
+		{"ResetWriterForbidden", http.MethodPost, "/reset/counter/CPU0", "w", http.StatusForbidden},
+		{"ResetAdmin", http.MethodPost, "/reset/counter/CPU0", "a", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.url, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+			require.Equal(t, tt.expectedCode, rr.Code)
+		})
+	}
+}
+
+func TestTokenAuthMiddlewareOpenRead(t *testing.T) {
+	authorizer, err := auth.NewAuthorizer(auth.Config{
+		Tokens: []auth.TokenConfig{{ID: "cpu-writer", Token: "w", Scopes: []string{"write:CPU"}}},
+	})
+	require.NoError(t, err)
+	h := NewHandler(nil, nil, nil, nil, nil, "")
+	h.SetAuthorizer(authorizer)
+
+	r := chi.NewRouter()
+	r.Use(h.TokenAuthMiddleware)
+	r.Get("/value/{mType}/{mName}", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+
+	// Без require_read чтение открыто, и токен агента без права "read" его не запрещает.
+	for _, token := range []string{"", "w"} {
+		req := httptest.NewRequest(http.MethodGet, "/value/gauge/CPU0", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, token)
+	}
+}
+
+func TestHashMiddlewareReplayProtection(t *testing.T) {
+	const key = "secret"
+	h := NewHandler(nil, nil, nil, nil, nil, key)
+	h.SetReplayWindow(time.Minute)
+
+	r := chi.NewRouter()
+	r.Use(h.HashMiddleware)
+	r.Post("/updates/", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+
+	body := []byte(`[{"id":"c","type":"counter","delta":1}]`)
+	newRequest := func(method string, path string, ts time.Time, nonce string, signKey string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		timestamp := hmacsign.Timestamp(ts)
+		req.Header.Set(hmacsign.HeaderTimestamp, timestamp)
+		req.Header.Set(hmacsign.HeaderNonce, nonce)
+		req.Header.Set(hmacsign.HeaderSignature, hmacsign.SignRequest(signKey, method, "/updates/", timestamp, nonce, body))
+		return req
+	}
+
+	tests := []struct {
+		name         string
+		req          *http.Request
+		expectedCode int
+	}{
+		{"Valid", newRequest(http.MethodPost, "/updates/", time.Now(), "n1", key), http.StatusOK},
+		{"ReplayedNonce", newRequest(http.MethodPost, "/updates/", time.Now(), "n1", key), http.StatusUnauthorized},
+		{"StaleTimestamp", newRequest(http.MethodPost, "/updates/", time.Now().Add(-2*time.Minute), "n2", key), http.StatusUnauthorized},
+		{"WrongKey", newRequest(http.MethodPost, "/updates/", time.Now(), "n3", "other"), http.StatusBadRequest},
+		{"SignedForOtherMethod", newRequest(http.MethodGet, "/updates/", time.Now(), "n4", key), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, tt.req)
+			require.Equal(t, tt.expectedCode, rr.Code)
+		})
+	}
+
+	t.Run("MissingNonce", func(t *testing.T) {
+		req := newRequest(http.MethodPost, "/updates/", time.Now(), "n5", key)
+		req.Header.Del(hmacsign.HeaderNonce)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
+
+func TestHashMiddlewareKeyringAndStrictMode(t *testing.T) {
+	h := NewHandler(nil, nil, nil, nil, nil, "")
+	h.SetHashKeys([]string{"new-key", "old-key"})
+	h.SetStrictHMAC(true)
+	require.Equal(t, "new-key", h.hashKey)
+
+	r := chi.NewRouter()
+	r.Use(h.HashMiddleware)
+	r.Post("/updates/", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+
+	signed := func(key string, nonce string) *http.Request {
+		body := []byte(`[]`)
+		req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
+		timestamp := hmacsign.Timestamp(time.Now())
+		req.Header.Set(hmacsign.HeaderTimestamp, timestamp)
+		req.Header.Set(hmacsign.HeaderNonce, nonce)
+		req.Header.Set(hmacsign.HeaderSignature, hmacsign.SignRequest(key, http.MethodPost, "/updates/", timestamp, nonce, body))
+		return req
+	}
+
+	tests := []struct {
+		name         string
+		req          *http.Request
+		expectedCode int
+	}{
+		{"NewKey", signed("new-key", "k1"), http.StatusOK},
+		{"OldKey", signed("old-key", "k2"), http.StatusOK},
+		{"RetiredKey", signed("retired-key", "k3"), http.StatusBadRequest},
+		{"UnsignedPostRejected", httptest.NewRequest(http.MethodPost, "/updates/", nil), http.StatusUnauthorized},
+		{"UnsignedGetAllowed", httptest.NewRequest(http.MethodGet, "/", nil), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, tt.req)
+			require.Equal(t, tt.expectedCode, rr.Code)
+		})
+	}
+}
+
+func TestTrustedSubnetMiddleware(t *testing.T) {
+	trusted, err := subnet.ParseTrusted("192.168.1.0/24")
+	require.NoError(t, err)
+	proxies, err := subnet.ParseTrusted("10.0.0.5/32")
+	require.NoError(t, err)
+
+	newRouter := func(proxies subnet.Trusted) http.Handler {
+		h := NewHandler(nil, nil, nil, nil, nil, "")
+		h.SetTrustedSubnets(trusted)
+		h.SetTrustedProxies(proxies)
+
+		r := chi.NewRouter()
+		r.Route("/update", func(r chi.Router) {
+			r.Use(h.TrustedSubnetMiddleware)
+			r.Post("/", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+		})
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+		return r
+	}
+	direct := newRouter(nil)
+	behindProxy := newRouter(proxies)
+
+	tests := []struct {
+		name         string
+		router       http.Handler
+		method       string
+		url          string
+		realIP       string
+		remoteAddr   string
+		expectedCode int
+	}{
+		{"TrustedHeaderUntrustedRemote", direct, http.MethodPost, "/update/", "192.168.1.10", "10.0.0.1:1234", http.StatusForbidden},
+		{"TrustedHeaderTrustedRemote", direct, http.MethodPost, "/update/", "192.168.1.10", "192.168.1.20:1234", http.StatusOK},
+		{"UntrustedHeader", direct, http.MethodPost, "/update/", "10.0.0.1", "192.168.1.10:1234", http.StatusForbidden},
+		{"InvalidHeader", direct, http.MethodPost, "/update/", "localhost", "192.168.1.10:1234", http.StatusForbidden},
+		{"NoHeaderTrustedRemote", direct, http.MethodPost, "/update/", "", "192.168.1.10:1234", http.StatusOK},
+		{"NoHeaderUntrustedRemote", direct, http.MethodPost, "/update/", "", "10.0.0.1:1234", http.StatusForbidden},
+		{"TrustedHeaderFromProxy", behindProxy, http.MethodPost, "/update/", "192.168.1.10", "10.0.0.5:1234", http.StatusOK},
+		{"UntrustedHeaderFromProxy", behindProxy, http.MethodPost, "/update/", "10.0.0.1", "10.0.0.5:1234", http.StatusForbidden},
+		{"TrustedHeaderFromOtherHost", behindProxy, http.MethodPost, "/update/", "192.168.1.10", "10.0.0.1:1234", http.StatusForbidden},
+		{"NoHeaderFromProxy", behindProxy, http.MethodPost, "/update/", "", "10.0.0.5:1234", http.StatusForbidden},
+		{"ReadNotFiltered", direct, http.MethodGet, "/", "10.0.0.1", "10.0.0.1:1234", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.url, nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.realIP != "" {
+				req.Header.Set(subnet.HeaderRealIP, tt.realIP)
+			}
+			rr := httptest.NewRecorder()
+			tt.router.ServeHTTP(rr, req)
+			require.Equal(t, tt.expectedCode, rr.Code)
+		})
+	}
+}
+
+func TestHashMiddlewareLargeBody(t *testing.T) {
+	const key = "secret"
+	h := NewHandler(nil, nil, nil, nil, nil, key)
+
+	body := bytes.Repeat([]byte("x"), 3*bodySpoolThreshold)
+	var received []byte
+	r := chi.NewRouter()
+	r.Use(h.HashMiddleware)
+	r.Post("/updates/", func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	timestamp := hmacsign.Timestamp(time.Now())
+	req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
+	req.Header.Set(hmacsign.HeaderTimestamp, timestamp)
+	req.Header.Set(hmacsign.HeaderNonce, "large")
+	req.Header.Set(hmacsign.HeaderSignature, hmacsign.SignRequest(key, http.MethodPost, "/updates/", timestamp, "large", body))
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, body, received)
+}
+
+func TestBodyLimitMiddleware(t *testing.T) {
+	h := NewHandler(nil, nil, nil, nil, nil, "secret")
+	h.SetMaxBodySize(16)
+
+	r := chi.NewRouter()
+	r.Use(h.BodyLimitMiddleware)
+	r.Use(h.HashMiddleware)
+	r.Post("/updates/", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+
+	body := bytes.Repeat([]byte("x"), 32)
+	timestamp := hmacsign.Timestamp(time.Now())
+	req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
+	req.Header.Set(hmacsign.HeaderTimestamp, timestamp)
+	req.Header.Set(hmacsign.HeaderNonce, "n")
+	req.Header.Set(hmacsign.HeaderSignature, hmacsign.SignRequest("secret", http.MethodPost, "/updates/", timestamp, "n", body))
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
+	req.ContentLength = -1
+	req.Header.Set(hmacsign.HeaderTimestamp, timestamp)
+	req.Header.Set(hmacsign.HeaderNonce, "n2")
+	req.Header.Set(hmacsign.HeaderSignature, hmacsign.SignRequest("secret", http.MethodPost, "/updates/", timestamp, "n2", body))
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusRequestEntityTooLarge, rr.Code, "unknown length is limited while reading")
+}
+
+func TestDecryptionMiddlewarePassesLargeBody(t *testing.T) {
+	h := NewHandler(nil, nil, nil, nil, nil, "")
+
+	body := bytes.Repeat([]byte("y"), 4*maxCipherBlockSize)
+	var received []byte
+	r := chi.NewRouter()
+	r.Use(h.DecryptionMiddleware)
+	r.Post("/updates/", func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, body, received)
+}
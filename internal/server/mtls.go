@@ -0,0 +1,46 @@
+// Package server содержит middleware для идентификации агентов по клиентскому TLS-сертификату.
+// mtls.go извлекает идентификатор агента из сертификата и передает его дальше через контекст запроса.
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Fuonder/metriccoll.git/internal/certmanager"
+	"github.com/Fuonder/metriccoll.git/internal/logger"
+	"go.uber.org/zap"
+)
+
+// agentIdentityKey — ключ контекста, под которым хранится идентификатор агента.
+type agentIdentityKey struct{}
+
+// AgentIdentityFromContext возвращает идентификатор агента, установленный AgentIdentityMiddleware.
+func AgentIdentityFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(agentIdentityKey{}).(string)
+	return id, ok
+}
+
+// WithAgentIdentity возвращает копию контекста с заданным идентификатором агента.
+func WithAgentIdentity(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, agentIdentityKey{}, id)
+}
+
+// AgentIdentityMiddleware сопоставляет клиентский сертификат (CN или SAN) с идентификатором агента
+// и сохраняет его в контексте запроса. Соединения без TLS пропускаются без изменений,
+// а TLS-соединения без пригодного сертификата отклоняются с кодом 401.
+func (h *Handler) AgentIdentityMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		id, err := certmanager.AgentIdentity(r.TLS)
+		if err != nil {
+			logger.Log.Info("can not identify agent", zap.Error(err))
+			http.Error(rw, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		logger.Log.Debug("agent identified", zap.String("agent", id))
+		next.ServeHTTP(rw, r.WithContext(WithAgentIdentity(r.Context(), id)))
+	})
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"net/http"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/Fuonder/metriccoll.git/internal/selfmetrics"
+	"github.com/go-chi/chi/v5"
+)
+
+// SelfMetricsNamespace — пространство имен метрик о работе сервера.
+const SelfMetricsNamespace = "metriccoll_server"
+
+// Причины отклонения запроса при проверке HMAC (метка reason).
+const (
+	hmacFailureMissingHeaders = "missing_headers" // Нет метки времени или nonce.
+	hmacFailureTimestamp      = "timestamp"       // Метка времени вне окна.
+	hmacFailureMismatch       = "mismatch"        // Подпись не совпала.
+	hmacFailureReplay         = "replay"          // Nonce уже использован.
+	hmacFailureMissing        = "missing"         // Нет подписи в строгом режиме.
+)
+
+// serverMetrics — метрики о работе HTTP-сервера. Методы безопасно вызывать у nil.
+type serverMetrics struct {
+	requests        *selfmetrics.Counter
+	latency         *selfmetrics.Histogram
+	batchSize       *selfmetrics.Histogram
+	decryptFailures *selfmetrics.Counter
+	hmacFailures    *selfmetrics.Counter
+}
+
+func newServerMetrics(reg *selfmetrics.Registry) *serverMetrics {
+	reg.NewGaugeFunc("goroutines", "Number of goroutines.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+	return &serverMetrics{
+		requests: reg.NewCounter("http_requests_total",
+			"HTTP requests by method, route and status.", "method", "route", "status"),
+		latency: reg.NewHistogram("http_request_duration_seconds",
+			"HTTP request latency by method, route and status.", selfmetrics.DefaultLatencyBuckets, "method", "route", "status"),
+		batchSize: reg.NewHistogram("batch_size",
+			"Number of metrics in batch updates.", selfmetrics.DefaultSizeBuckets, "mode"),
+		decryptFailures: reg.NewCounter("decrypt_failures_total",
+			"Requests rejected because the body could not be decrypted."),
+		hmacFailures: reg.NewCounter("hmac_failures_total",
+			"Requests rejected by HMAC signature checks by reason.", "reason"),
+	}
+}
+
+func (m *serverMetrics) observeRequest(method string, route string, status int, d time.Duration) {
+	if m == nil {
+		return
+	}
+	code := strconv.Itoa(status)
+	m.requests.Inc(method, route, code)
+	m.latency.Observe(d.Seconds(), method, route, code)
+}
+
+func (m *serverMetrics) observeBatch(mode string, size int) {
+	if m == nil {
+		return
+	}
+	m.batchSize.Observe(float64(size), mode)
+}
+
+func (m *serverMetrics) decryptFailure() {
+	if m == nil {
+		return
+	}
+	m.decryptFailures.Inc()
+}
+
+func (m *serverMetrics) hmacFailure(reason string) {
+	if m == nil {
+		return
+	}
+	m.hmacFailures.Inc(reason)
+}
+
+// SetSelfMetrics включает сбор метрик о работе сервера в reg (см. SelfMetricsMiddleware).
+func (h *Handler) SetSelfMetrics(reg *selfmetrics.Registry) {
+	h.selfMetrics = newServerMetrics(reg)
+}
+
+// statusRecorder запоминает код ответа для метрик запросов.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap возвращает исходный ResponseWriter для http.ResponseController.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// SelfMetricsMiddleware считает запросы и их длительность по методу, маршруту и коду ответа.
+// Маршрут — шаблон chi (например, /update/{mType}/{mName}/{mValue}), поэтому имена метрик
+// не попадают в метки; запросы к несуществующим маршрутам учитываются как "unmatched".
+func (h *Handler) SelfMetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if h.selfMetrics == nil {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: rw}
+		next.ServeHTTP(rec, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		h.selfMetrics.observeRequest(r.Method, route, rec.status, time.Since(start))
+	})
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Fuonder/metriccoll.git/internal/hmacsign"
+	"github.com/Fuonder/metriccoll.git/internal/selfmetrics"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func selfMetricsText(t *testing.T, reg *selfmetrics.Registry) string {
+	t.Helper()
+	var sb strings.Builder
+	require.NoError(t, reg.WriteText(&sb))
+	return sb.String()
+}
+
+func TestSelfMetricsMiddleware(t *testing.T) {
+	h := NewHandler(nil, nil, nil, nil, nil, "")
+	reg := selfmetrics.NewRegistry(SelfMetricsNamespace)
+	h.SetSelfMetrics(reg)
+
+	r := chi.NewRouter()
+	r.Use(h.SelfMetricsMiddleware)
+	r.Get("/value/{mType}/{mName}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte("ok")) })
+
+	for _, target := range []string{"/value/gauge/Alloc", "/value/gauge/Sys", "/", "/nope"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	text := selfMetricsText(t, reg)
+	require.Contains(t, text, `metriccoll_server_http_requests_total{method="GET",route="/value/{mType}/{mName}",status="404"} 2`)
+	require.Contains(t, text, `metriccoll_server_http_requests_total{method="GET",route="/",status="200"} 1`)
+	require.Contains(t, text, `metriccoll_server_http_requests_total{method="GET",route="unmatched",status="404"} 1`)
+	require.Contains(t, text, `metriccoll_server_http_request_duration_seconds_count{method="GET",route="/",status="200"} 1`)
+	require.Contains(t, text, "metriccoll_server_goroutines ")
+	require.NotContains(t, text, "Alloc", "metric names must not become label values")
+}
+
+func TestSelfMetricsHMACFailures(t *testing.T) {
+	h := NewHandler(nil, nil, nil, nil, nil, "key")
+	h.SetStrictHMAC(true)
+	reg := selfmetrics.NewRegistry(SelfMetricsNamespace)
+	h.SetSelfMetrics(reg)
+
+	r := chi.NewRouter()
+	r.Use(h.HashMiddleware)
+	r.Post("/updates/", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+
+	signed := func(key string, timestamp string, nonce string) *http.Request {
+		body := []byte(`[]`)
+		req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
+		req.Header.Set(hmacsign.HeaderTimestamp, timestamp)
+		req.Header.Set(hmacsign.HeaderNonce, nonce)
+		req.Header.Set(hmacsign.HeaderSignature, hmacsign.SignRequest(key, http.MethodPost, "/updates/", timestamp, nonce, body))
+		return req
+	}
+	now := hmacsign.Timestamp(time.Now())
+	requests := []*http.Request{
+		signed("key", now, "n1"),
+		signed("key", now, "n1"),
+		signed("other", now, "n2"),
+		signed("key", hmacsign.Timestamp(time.Now().Add(-time.Hour)), "n3"),
+		httptest.NewRequest(http.MethodPost, "/updates/", nil),
+	}
+	for _, req := range requests {
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	text := selfMetricsText(t, reg)
+	for _, reason := range []string{hmacFailureReplay, hmacFailureMismatch, hmacFailureTimestamp, hmacFailureMissing} {
+		require.Contains(t, text, `metriccoll_server_hmac_failures_total{reason="`+reason+`"} 1`)
+	}
+}
+
+func TestSelfMetricsNilSafe(t *testing.T) {
+	var m *serverMetrics
+	require.NotPanics(t, func() {
+		m.observeRequest(http.MethodGet, "/", http.StatusOK, time.Millisecond)
+		m.observeBatch(BatchModeAtomic, 1)
+		m.decryptFailure()
+		m.hmacFailure(hmacFailureMismatch)
+	})
+}
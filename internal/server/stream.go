@@ -0,0 +1,180 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Fuonder/metriccoll.git/internal/logger"
+	"github.com/Fuonder/metriccoll.git/internal/models"
+	"github.com/Fuonder/metriccoll.git/internal/storage"
+	"github.com/Fuonder/metriccoll.git/internal/stream"
+	"go.uber.org/zap"
+)
+
+// ErrStreamNotEnabled возвращается, если рассылка обновлений не включена (см. SetBroker).
+var ErrStreamNotEnabled = ErrorResponse{Code: http.StatusNotImplemented, Message: "metric stream is not enabled"}
+
+const (
+	// streamHeartbeat — период комментариев-пингов, которые не дают прокси закрыть
+	// простаивающее соединение и позволяют обнаружить отключившегося клиента.
+	streamHeartbeat = 15 * time.Second
+	// streamWriteTimeout — время, за которое клиент должен принять очередное событие;
+	// клиент, не успевший его принять, отключается.
+	streamWriteTimeout = 10 * time.Second
+)
+
+// DroppedEvent — данные события dropped потока /stream.
+type DroppedEvent struct {
+	Dropped uint64 `json:"dropped"` // Количество пропущенных обновлений.
+}
+
+// SetBroker включает рассылку принятых обновлений подписчикам /stream.
+func (h *Handler) SetBroker(broker *stream.Broker) {
+	h.broker = broker
+}
+
+// publish отправляет подписчикам /stream обновленные метрики (значения после записи).
+func (h *Handler) publish(metrics ...models.Metrics) {
+	if h.broker != nil {
+		h.broker.Publish(metrics...)
+	}
+}
+
+// publishAccepted отправляет подписчикам /stream метрики, принятые без чтения результата.
+// Подписчик получает значение после записи (для счетчика — накопленное), поэтому метрики
+// перечитываются из хранилища; если перечитать не удалось, отправляются значения из запроса.
+func (h *Handler) publishAccepted(accepted ...models.Metrics) {
+	if h.broker == nil || !h.broker.HasSubscribers() {
+		return
+	}
+	if h.mReader != nil {
+		keys := make([]models.MetricKey, 0, len(accepted))
+		for _, m := range accepted {
+			keys = append(keys, m.Key())
+		}
+		if current, err := h.mReader.GetMetricsByKeys(keys); err == nil {
+			h.broker.Publish(current...)
+			return
+		}
+	}
+	h.broker.Publish(accepted...)
+}
+
+// streamWriter записывает события в формате text/event-stream.
+type streamWriter struct {
+	rw http.ResponseWriter
+	rc *http.ResponseController
+}
+
+// send записывает сообщение и сразу отправляет его клиенту. Если клиент не принимает
+// данные за streamWriteTimeout, запись завершается ошибкой.
+func (w streamWriter) send(format string, args ...any) error {
+	if err := w.rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	if _, err := fmt.Fprintf(w.rw, format, args...); err != nil {
+		return err
+	}
+	return w.rc.Flush()
+}
+
+func (w streamWriter) event(ev stream.Event) error {
+	if ev.Dropped > 0 {
+		data, _ := json.Marshal(DroppedEvent{Dropped: ev.Dropped})
+		if err := w.send("event: dropped\ndata: %s\n\n", data); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(ev.Metric)
+	if err != nil {
+		return err
+	}
+	return w.send("id: %d\nevent: metric\ndata: %s\n\n", ev.Seq, data)
+}
+
+// StreamHandler передает принятые сервером обновления метрик в формате Server-Sent Events.
+// Каждое обновление, принятое UpdateHandler, JSONUpdateHandler и MultipleUpdateHandler,
+// отправляется событием metric со значением метрики после записи.
+//
+// Параметры запроса:
+//
+//   - pattern: шаблон имени метрики (синтаксис path.Match, например "CPUutilization*"),
+//     по умолчанию — все метрики.
+//   - type: тип метрики (gauge | counter), по умолчанию — любой.
+//
+// Формат потока (text/event-stream):
+//
+//	id: 42
+//	event: metric
+//	data: {"id":"CPUutilization1","type":"gauge","value":12.5,"updated_at":"..."}
+//
+// Если клиент не успевает принимать события, часть обновлений для него пропускается,
+// а перед следующим событием отправляется событие dropped с их количеством:
+//
+//	event: dropped
+//	data: {"dropped":17}
+//
+// Клиент, не принимающий данные дольше streamWriteTimeout, отключается.
+//
+// Возвращает:
+//
+//   - 200 OK: поток событий до отключения клиента или остановки сервера.
+//   - 400 Bad Request: некорректный шаблон или тип метрики.
+//   - 501 Not Implemented: рассылка обновлений не включена.
+//   - 503 Service Unavailable: сервер останавливается.
+func (h *Handler) StreamHandler(rw http.ResponseWriter, r *http.Request) {
+	if h.broker == nil {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(ErrStreamNotEnabled.Code)
+		_ = json.NewEncoder(rw).Encode(ErrStreamNotEnabled)
+		return
+	}
+	pattern := r.URL.Query().Get("pattern")
+	mType := r.URL.Query().Get("type")
+	sub, err := h.broker.Subscribe(pattern, mType)
+	switch {
+	case errors.Is(err, storage.ErrInvalidPattern):
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(rw, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer h.broker.Unsubscribe(sub)
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("X-Accel-Buffering", "no")
+	rw.WriteHeader(http.StatusOK)
+	w := streamWriter{rw: rw, rc: http.NewResponseController(rw)}
+	if err := w.send(": subscribed\n\n"); err != nil {
+		logger.Log.Info("can not start metric stream", zap.Error(err))
+		return
+	}
+	logger.Log.Info("metric stream subscriber connected",
+		zap.String("pattern", pattern), zap.String("type", mType), zap.String("remote", r.RemoteAddr))
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			logger.Log.Info("metric stream subscriber disconnected", zap.String("remote", r.RemoteAddr))
+			return
+		case ev, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			err = w.event(ev)
+		case <-heartbeat.C:
+			err = w.send(": ping\n\n")
+		}
+		if err != nil {
+			logger.Log.Info("metric stream subscriber dropped", zap.String("remote", r.RemoteAddr), zap.Error(err))
+			return
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Fuonder/metriccoll.git/internal/models"
+	"github.com/Fuonder/metriccoll.git/internal/storage"
+	"github.com/Fuonder/metriccoll.git/internal/stream"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+)
+
+// readEvent читает из потока следующее событие (без комментариев).
+func readEvent(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	var lines []string
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && len(lines) > 0:
+			return strings.Join(lines, "\n")
+		case line == "" || strings.HasPrefix(line, ":"):
+		default:
+			lines = append(lines, line)
+		}
+	}
+}
+
+func TestStreamHandler(t *testing.T) {
+	st, err := storage.NewJSONStorage(storage.NewFileStoreInfo(filepath.Join(t.TempDir(), "metrics.json"), time.Hour, false))
+	require.NoError(t, err)
+	h := NewHandler(st, st, nil, nil, nil, "")
+
+	rr := httptest.NewRecorder()
+	h.StreamHandler(rr, httptest.NewRequest(http.MethodGet, "/stream", nil))
+	require.Equal(t, http.StatusNotImplemented, rr.Code)
+
+	broker := stream.NewBroker(stream.DefaultBufferSize)
+	h.SetBroker(broker)
+	r := chi.NewRouter()
+	r.Get("/stream", h.StreamHandler)
+	r.Post("/update/{mType}/{mName}/{mValue}", h.UpdateHandler)
+	r.Post("/update/", h.JSONUpdateHandler)
+	r.Post("/updates/", h.MultipleUpdateHandler)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/stream?pattern=cpu[")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = http.Get(ts.URL + "/stream?pattern=CPUutilization*")
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	events := bufio.NewReader(resp.Body)
+
+	post := func(target, body string) {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+target, strings.NewReader(body))
+		require.NoError(t, err)
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	post("/update/gauge/FreeMemory/1", "")
+	post("/update/gauge/CPUutilization1/12.5", "")
+	post("/update/", `{"id":"CPUutilization2","type":"counter","delta":2}`)
+	post("/updates/", `[{"id":"CPUutilization2","type":"counter","delta":3},{"id":"Alloc","type":"gauge","value":1}]`)
+
+	ev := readEvent(t, events)
+	require.True(t, strings.HasPrefix(ev, "id: "), ev)
+	require.Contains(t, ev, "event: metric\n")
+	require.Contains(t, ev, `data: {"id":"CPUutilization1","type":"gauge","value":12.5,`)
+	require.Contains(t, readEvent(t, events), `data: {"id":"CPUutilization2","type":"counter","delta":2,`)
+	require.Contains(t, readEvent(t, events), `data: {"id":"CPUutilization2","type":"counter","delta":5,`,
+		"subscribers receive the accumulated counter value")
+
+	require.NoError(t, resp.Body.Close())
+	require.Eventually(t, func() bool { return !broker.HasSubscribers() }, time.Second, 10*time.Millisecond,
+		"subscription is cancelled when the client disconnects")
+}
+
+func TestStreamWriterReportsDropped(t *testing.T) {
+	rr := httptest.NewRecorder()
+	w := streamWriter{rw: rr, rc: http.NewResponseController(rr)}
+	require.NoError(t, w.event(stream.Event{Seq: 7, Dropped: 3, Metric: models.Metrics{ID: "Alloc", MType: "gauge", Value: models.Float64Ptr(1)}}))
+	require.Equal(t, "event: dropped\ndata: {\"dropped\":3}\n\n"+
+		"id: 7\nevent: metric\ndata: {\"id\":\"Alloc\",\"type\":\"gauge\",\"value\":1}\n\n", rr.Body.String())
+}
@@ -0,0 +1,73 @@
+// Сортировка таблиц дашборда по клику на заголовок столбца.
+// Выбранная сортировка сохраняется в sessionStorage и переживает автообновление страницы.
+(function () {
+  "use strict";
+
+  var storageKey = "metriccoll.sort";
+
+  function loadState() {
+    try {
+      return JSON.parse(sessionStorage.getItem(storageKey)) || {};
+    } catch (e) {
+      return {};
+    }
+  }
+
+  function saveState(state) {
+    try {
+      sessionStorage.setItem(storageKey, JSON.stringify(state));
+    } catch (e) {
+      // sessionStorage недоступен: сортировка действует до перезагрузки.
+    }
+  }
+
+  function cellValue(row, index) {
+    var cell = row.cells[index];
+    var value = cell.getAttribute("data-sort");
+    return value !== null ? value : cell.textContent.trim();
+  }
+
+  function sortTable(table, index, desc) {
+    var header = table.tHead.rows[0].cells[index];
+    if (!header) {
+      return;
+    }
+    var numeric = header.hasAttribute("data-numeric");
+    var body = table.tBodies[0];
+    var rows = Array.prototype.slice.call(body.rows);
+    rows.sort(function (a, b) {
+      var x = cellValue(a, index);
+      var y = cellValue(b, index);
+      var c = numeric ? (parseFloat(x) || 0) - (parseFloat(y) || 0) : x.localeCompare(y);
+      return desc ? -c : c;
+    });
+    rows.forEach(function (row) {
+      body.appendChild(row);
+    });
+    Array.prototype.forEach.call(table.tHead.rows[0].cells, function (th, i) {
+      if (i === index) {
+        th.setAttribute("aria-sort", desc ? "descending" : "ascending");
+      } else {
+        th.removeAttribute("aria-sort");
+      }
+    });
+  }
+
+  var state = loadState();
+  document.querySelectorAll("table.sortable").forEach(function (table) {
+    var key = table.getAttribute("data-sort-key");
+    var saved = state[key];
+    if (saved) {
+      sortTable(table, saved.index, saved.desc);
+    }
+    Array.prototype.forEach.call(table.tHead.rows[0].cells, function (th, index) {
+      th.addEventListener("click", function () {
+        var current = state[key];
+        var desc = !!current && current.index === index && !current.desc;
+        state[key] = { index: index, desc: desc };
+        saveState(state);
+        sortTable(table, index, desc);
+      });
+    });
+  });
+})();
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/Fuonder/metriccoll.git/internal/models"
+)
+
+// CheckMetric проверяет метрику перед записью. Используется всеми хранилищами,
+// чтобы они одинаково отклоняли недопустимые метрики.
+func CheckMetric(metric models.Metrics) error {
+	switch metric.MType {
+	case "gauge":
+		if metric.Value == nil {
+			return ErrInvalidMetricValue
+		}
+	case "counter":
+		if metric.Delta == nil {
+			return ErrInvalidMetricValue
+		}
+	default:
+		return fmt.Errorf("metric type: %s is not supported", metric.MType)
+	}
+	return nil
+}
+
+// AggregateBatch проверяет метрики пакета и объединяет повторы одной серии так же,
+// как при последовательной записи: gauge принимает последнее значение, дельты counter суммируются.
+// Результат упорядочен по имени, чтобы параллельные пакеты блокировали строки в одном порядке.
+func AggregateBatch(metrics []models.Metrics) (gauges []models.Metrics, counters []models.Metrics, err error) {
+	gaugeIdx := make(map[string]int)
+	counterIdx := make(map[string]int)
+	for _, m := range metrics {
+		if err := CheckMetric(m); err != nil {
+			return nil, nil, err
+		}
+		switch m.MType {
+		case "gauge":
+			if i, ok := gaugeIdx[m.ID]; ok {
+				gauges[i].Value = m.Value
+				continue
+			}
+			gaugeIdx[m.ID] = len(gauges)
+			gauges = append(gauges, models.Metrics{ID: m.ID, MType: m.MType, Value: m.Value})
+		case "counter":
+			if i, ok := counterIdx[m.ID]; ok {
+				counters[i].Delta = models.Int64Ptr(*counters[i].Delta + *m.Delta)
+				continue
+			}
+			counterIdx[m.ID] = len(counters)
+			counters = append(counters, models.Metrics{ID: m.ID, MType: m.MType, Delta: models.Int64Ptr(*m.Delta)})
+		}
+	}
+	sort.Slice(gauges, func(i, j int) bool { return gauges[i].ID < gauges[j].ID })
+	sort.Slice(counters, func(i, j int) bool { return counters[i].ID < counters[j].ID })
+	return gauges, counters, nil
+}
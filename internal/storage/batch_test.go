@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"testing"
+
+	model "github.com/Fuonder/metriccoll.git/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregateBatch(t *testing.T) {
+	gauges, counters, err := AggregateBatch([]model.Metrics{
+		{ID: "b", MType: "counter", Delta: model.Int64Ptr(2)},
+		{ID: "z", MType: "gauge", Value: model.Float64Ptr(1)},
+		{ID: "a", MType: "counter", Delta: model.Int64Ptr(1)},
+		{ID: "b", MType: "counter", Delta: model.Int64Ptr(3)},
+		{ID: "z", MType: "gauge", Value: model.Float64Ptr(7)},
+		{ID: "b", MType: "gauge", Value: model.Float64Ptr(0.5)},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, gauges, 2)
+	require.Equal(t, "b", gauges[0].ID)
+	require.Equal(t, "z", gauges[1].ID)
+	require.Equal(t, 7.0, *gauges[1].Value, "last gauge value wins")
+
+	require.Len(t, counters, 2)
+	require.Equal(t, "a", counters[0].ID)
+	require.Equal(t, int64(5), *counters[1].Delta, "counter deltas are summed")
+
+	_, _, err = AggregateBatch([]model.Metrics{{ID: "g", MType: "gauge"}})
+	require.ErrorIs(t, err, ErrInvalidMetricValue)
+	_, _, err = AggregateBatch([]model.Metrics{{ID: "h", MType: "histogram"}})
+	require.Error(t, err)
+}
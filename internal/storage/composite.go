@@ -0,0 +1,544 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Fuonder/metriccoll.git/internal/logger"
+	"github.com/Fuonder/metriccoll.git/internal/models"
+	"go.uber.org/zap"
+)
+
+// ErrPrimaryUnavailable возвращается, если основное хранилище не подключено.
+var ErrPrimaryUnavailable = errors.New("primary storage is unavailable")
+
+// PrimaryStorage описывает основное хранилище составного хранилища (например, DBStorage).
+type PrimaryStorage interface {
+	MetricReader
+	MetricWriter
+	MetricRemover
+	MetricDatabaseHandler
+}
+
+// Режимы работы составного хранилища.
+const (
+	// ModeNormal — основное хранилище доступно, записи применяются к нему.
+	ModeNormal = "normal"
+	// ModeDegraded — основное хранилище недоступно, записи накапливаются в журнале.
+	ModeDegraded = "degraded"
+)
+
+// Состояния подключения к основному хранилищу.
+const (
+	DatabaseUp   = "up"
+	DatabaseDown = "down"
+)
+
+// Status описывает текущий режим работы хранилища.
+type Status struct {
+	Mode     string     `json:"mode"`            // ModeNormal или ModeDegraded.
+	Database string     `json:"database"`        // DatabaseUp или DatabaseDown.
+	Buffered int        `json:"buffered"`        // Количество серий, ожидающих передачи в основное хранилище.
+	Since    *time.Time `json:"since,omitempty"` // Время последней смены режима.
+	Error    string     `json:"error,omitempty"` // Последняя ошибка основного хранилища.
+}
+
+// StatusReporter сообщает текущий режим работы хранилища.
+type StatusReporter interface {
+	Status() Status
+}
+
+// pendingSuffix — суффикс файла с журналом записей, не переданных в основное хранилище.
+const pendingSuffix = ".pending"
+
+// CompositeStorage объединяет основное хранилище (БД) и JSON-снимок в файле FileStoragePath.
+//
+// Пока основное хранилище доступно, чтение выполняется из него, а каждая запись применяется
+// к нему и к копии в памяти, которая периодически сохраняется в файл; в синхронном режиме
+// каждая запись копии дописывается в ее журнал (см. JSONStorage). Запросы к основному
+// хранилищу выполняются без блокировки составного хранилища: она берется только для
+// изменения копии, журнала и режима работы. Если основное хранилище недоступно при запуске или перестает отвечать
+// во время работы, хранилище переходит в режим ModeDegraded: чтение выполняется из копии,
+// записи применяются к копии и накапливаются в журнале, а после подключения (SetPrimary)
+// журнал передается в основное хранилище. Переподключением управляет HealthMonitor.
+type CompositeStorage struct {
+	primary PrimaryStorage // nil, пока основное хранилище недоступно.
+	standby PrimaryStorage // Отключенное после сбоя основное хранилище для повторной проверки.
+	mirror  *JSONStorage   // Копия метрик, сохраняемая в снимок.
+	sync    bool           // Сохранять снимок после каждой записи.
+	fPath   string
+	since   time.Time // Время последней смены режима.
+	lastErr error     // Последняя ошибка основного хранилища.
+
+	// Журнал записей, накопленных без основного хранилища. Значения gauge заменяются,
+	// дельты counter суммируются, поэтому журнал не растет больше числа серий.
+	pending      map[models.MetricKey]models.Metrics
+	pendingOrder []models.MetricKey
+	// pendingWAL — файл журнала, открытый для дозаписи пакетов в синхронном режиме;
+	// nil, пока в него ничего не дописано после последнего сохранения.
+	pendingWAL *walLog
+
+	observer OpObserver // Получатель длительностей операций, передаваемый новым основным хранилищам.
+
+	mu sync.RWMutex
+}
+
+// NewCompositeStorage создает составное хранилище со снимком fileInfo.
+// Метрики и журнал всегда восстанавливаются из файлов снимка независимо от параметра
+// восстановления fileInfo: журнал содержит записи, принятые без БД до перезапуска.
+// Если primary задан, журнал передается в него (см. SetPrimary), после чего копия
+// заполняется из primary. Если передать журнал не удалось, хранилище запускается
+// в режиме ModeDegraded, а primary ожидает повторной проверки HealthMonitor.
+func NewCompositeStorage(primary PrimaryStorage, fileInfo *FileStoreInfo) (*CompositeStorage, error) {
+	mirrorInfo := *fileInfo
+	mirrorInfo.fLoadFromFile = false
+	cs := &CompositeStorage{
+		mirror:  &JSONStorage{index: newMetricIndex(), fileInfo: &mirrorInfo},
+		sync:    fileInfo.Sync,
+		fPath:   fileInfo.fPath,
+		pending: make(map[models.MetricKey]models.Metrics),
+		since:   time.Now(),
+		lastErr: ErrPrimaryUnavailable,
+	}
+	if err := cs.loadMetricsFromFile(); err != nil {
+		return nil, err
+	}
+	if cs.sync {
+		wal, err := openWAL(cs.mirror.walPath(), false)
+		if err != nil {
+			return nil, err
+		}
+		cs.mirror.wal = wal
+	}
+	if primary != nil {
+		if err := cs.SetPrimary(primary); err != nil {
+			logger.Log.Warn("Cannot attach primary storage, starting in degraded mode", zap.Error(err))
+		}
+	}
+	return cs, nil
+}
+
+// SetOpObserver задает получателя длительностей операций основного хранилища (в том числе
+// подключенного позже) и копии снимка. Копия сообщает только операции, выполняемые вместо
+// основного хранилища в режиме ModeDegraded, и сохранение снимка: ее обновление вслед
+// за основным хранилищем не учитывается, чтобы запись не считалась дважды.
+func (cs *CompositeStorage) SetOpObserver(fn OpObserver) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.observer = fn
+	cs.mirror.SetOpObserver(fn)
+	setOpObserver(cs.primary, fn)
+	setOpObserver(cs.standby, fn)
+}
+
+// IsSyncFileMode сообщает, сохраняется ли снимок после каждой записи.
+func (cs *CompositeStorage) IsSyncFileMode() bool {
+	return cs.sync
+}
+
+// IsPrimaryActive сообщает, подключено ли основное хранилище.
+func (cs *CompositeStorage) IsPrimaryActive() bool {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.primary != nil
+}
+
+// PendingCount возвращает количество серий в журнале, ожидающих передачи в основное хранилище.
+func (cs *CompositeStorage) PendingCount() int {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return len(cs.pendingOrder)
+}
+
+// Status возвращает текущий режим работы хранилища.
+func (cs *CompositeStorage) Status() Status {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	since := cs.since
+	st := Status{
+		Mode:     ModeNormal,
+		Database: DatabaseUp,
+		Buffered: len(cs.pendingOrder),
+		Since:    &since,
+	}
+	if cs.primary == nil {
+		st.Mode = ModeDegraded
+		st.Database = DatabaseDown
+	}
+	if cs.lastErr != nil {
+		st.Error = cs.lastErr.Error()
+	}
+	return st
+}
+
+// SetPrimary подключает основное хранилище: передает в него накопленный журнал,
+// после чего обновляет копию метрик его содержимым. Если передать журнал не удалось,
+// хранилище остается в режиме ModeDegraded.
+func (cs *CompositeStorage) SetPrimary(primary PrimaryStorage) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	setOpObserver(primary, cs.observer)
+	if len(cs.pendingOrder) > 0 {
+		logger.Log.Info("Replaying pending metrics to primary storage", zap.Int("count", len(cs.pendingOrder)))
+		if err := primary.AppendMetrics(cs.pendingMetrics()); err != nil {
+			cs.standby = primary
+			cs.lastErr = err
+			return fmt.Errorf("replay pending metrics: %w", err)
+		}
+		cs.pending = make(map[models.MetricKey]models.Metrics)
+		cs.pendingOrder = nil
+	}
+	cs.primary = primary
+	cs.standby = nil
+	cs.lastErr = nil
+	cs.since = time.Now()
+	if all := primary.GetAllMetrics(); all != nil {
+		cs.mirror.replaceMetrics(all)
+	}
+	return cs.dump()
+}
+
+// degrade отключает основное хранилище после ошибки err. Вызывается под cs.mu.
+func (cs *CompositeStorage) degrade(err error) {
+	if cs.primary == nil {
+		return
+	}
+	logger.Log.Warn("Primary storage is unavailable, switching to degraded mode", zap.Error(err))
+	cs.standby = cs.primary
+	cs.primary = nil
+	cs.lastErr = err
+	cs.since = time.Now()
+}
+
+// Degrade переводит хранилище в режим ModeDegraded после ошибки err.
+func (cs *CompositeStorage) Degrade(err error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.degrade(err)
+}
+
+// standbyPrimary возвращает отключенное после сбоя основное хранилище.
+func (cs *CompositeStorage) standbyPrimary() PrimaryStorage {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.standby
+}
+
+// pendingMetrics возвращает журнал в порядке первой записи. Вызывается под cs.mu.
+func (cs *CompositeStorage) pendingMetrics() []models.Metrics {
+	metrics := make([]models.Metrics, 0, len(cs.pendingOrder))
+	for _, key := range cs.pendingOrder {
+		metrics = append(metrics, cs.pending[key])
+	}
+	return metrics
+}
+
+// addPending добавляет запись в журнал. Вызывается под cs.mu после успешной проверки метрики.
+func (cs *CompositeStorage) addPending(metric models.Metrics) {
+	key := metric.Key()
+	existing, ok := cs.pending[key]
+	if !ok {
+		cs.pendingOrder = append(cs.pendingOrder, key)
+		existing = models.Metrics{ID: metric.ID, MType: metric.MType}
+	}
+	switch metric.MType {
+	case "gauge":
+		existing.Value = models.Float64Ptr(*metric.Value)
+	case "counter":
+		delta := *metric.Delta
+		if existing.Delta != nil {
+			delta += *existing.Delta
+		}
+		existing.Delta = models.Int64Ptr(delta)
+	}
+	cs.pending[key] = existing
+}
+
+// AppendMetric добавляет одну метрику.
+func (cs *CompositeStorage) AppendMetric(metric models.Metrics) error {
+	return cs.AppendMetrics([]models.Metrics{metric})
+}
+
+// AppendMetrics добавляет пакет метрик в основное хранилище и в копию снимка.
+// Без основного хранилища пакет применяется к копии и добавляется в журнал.
+// Если запись в основное хранилище не удалась из-за потери соединения, хранилище
+// переходит в режим ModeDegraded и пакет также попадает в журнал.
+func (cs *CompositeStorage) AppendMetrics(metrics []models.Metrics) error {
+	for _, metric := range metrics {
+		if err := CheckMetric(metric); err != nil {
+			return err
+		}
+	}
+	if len(metrics) == 0 {
+		return nil
+	}
+	for {
+		cs.mu.RLock()
+		primary := cs.primary
+		cs.mu.RUnlock()
+
+		var primaryErr error
+		if primary != nil {
+			if primaryErr = primary.AppendMetrics(metrics); primaryErr == nil {
+				cs.mu.RLock()
+				err := cs.mirror.appendMetrics(metrics)
+				cs.mu.RUnlock()
+				return err
+			}
+			if connErr := primary.CheckConnection(); connErr == nil {
+				return primaryErr
+			}
+		}
+
+		cs.mu.Lock()
+		if primary != nil && cs.primary == primary {
+			cs.degrade(primaryErr)
+		}
+		if cs.primary != nil {
+			// Пока пакет записывался, основное хранилище было подключено заново:
+			// пакет передается в него, а не в журнал.
+			cs.mu.Unlock()
+			continue
+		}
+		err := cs.appendPending(metrics)
+		cs.mu.Unlock()
+		return err
+	}
+}
+
+// appendPending применяет пакет к копии и добавляет его в журнал; в синхронном режиме
+// пакет сразу дописывается в файл журнала. Вызывается под cs.mu.Lock.
+func (cs *CompositeStorage) appendPending(metrics []models.Metrics) error {
+	if err := cs.mirror.AppendMetrics(metrics); err != nil {
+		return err
+	}
+	for _, metric := range metrics {
+		cs.addPending(metric)
+	}
+	if !cs.sync {
+		return nil
+	}
+	if cs.pendingWAL == nil {
+		wal, err := openWAL(cs.fPath+pendingSuffix, false)
+		if err != nil {
+			return err
+		}
+		cs.pendingWAL = wal
+	}
+	if err := cs.pendingWAL.append(metrics); err != nil {
+		return err
+	}
+	if cs.pendingWAL.entries >= walCompactThreshold {
+		return cs.dumpPending()
+	}
+	return nil
+}
+
+// activePrimary возвращает подключенное основное хранилище или ErrPrimaryUnavailable.
+func (cs *CompositeStorage) activePrimary() (PrimaryStorage, error) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	if cs.primary == nil {
+		return nil, ErrPrimaryUnavailable
+	}
+	return cs.primary, nil
+}
+
+// DeleteMetric удаляет метрику из основного хранилища и из копии снимка.
+// Без основного хранилища удаление невозможно, так как журнал не хранит удаления.
+func (cs *CompositeStorage) DeleteMetric(name string, mType string) error {
+	primary, err := cs.activePrimary()
+	if err != nil {
+		return err
+	}
+	if err := primary.DeleteMetric(name, mType); err != nil {
+		return err
+	}
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	_, err = cs.mirror.removeKeys([]models.MetricKey{{ID: name, MType: mType}})
+	return err
+}
+
+// DeleteMetrics удаляет метрики, подходящие под шаблон, из основного хранилища и из копии снимка.
+func (cs *CompositeStorage) DeleteMetrics(pattern string, mType string) ([]models.MetricKey, error) {
+	primary, err := cs.activePrimary()
+	if err != nil {
+		return nil, err
+	}
+	removed, err := primary.DeleteMetrics(pattern, mType)
+	if err != nil {
+		return nil, err
+	}
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	if _, err := cs.mirror.removeKeys(removed); err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// ResetCounter обнуляет counter в основном хранилище и в копии снимка.
+func (cs *CompositeStorage) ResetCounter(name string) error {
+	primary, err := cs.activePrimary()
+	if err != nil {
+		return err
+	}
+	if err := primary.ResetCounter(name); err != nil {
+		return err
+	}
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	if err := cs.mirror.resetCounter(name); err != nil && !errors.Is(err, ErrMetricNotFound) {
+		return err
+	}
+	return nil
+}
+
+// reader возвращает хранилище для чтения. Вызывается под cs.mu.
+func (cs *CompositeStorage) reader() MetricReader {
+	if cs.primary != nil {
+		return cs.primary
+	}
+	return cs.mirror
+}
+
+// GetAllMetrics возвращает все метрики.
+func (cs *CompositeStorage) GetAllMetrics() []models.Metrics {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.reader().GetAllMetrics()
+}
+
+// QueryMetrics выполняет запрос q к основному хранилищу или, в режиме ModeDegraded, к копии снимка.
+func (cs *CompositeStorage) QueryMetrics(ctx context.Context, q MetricQuery) (MetricPage, error) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return QueryReader(ctx, cs.reader(), q)
+}
+
+// GetMetricByName возвращает метрику по имени и типу.
+func (cs *CompositeStorage) GetMetricByName(name string, mType string) (models.Metrics, error) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.reader().GetMetricByName(name, mType)
+}
+
+// GetMetricsByKeys возвращает метрики с заданными ключами в том же порядке.
+func (cs *CompositeStorage) GetMetricsByKeys(keys []models.MetricKey) ([]models.Metrics, error) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.reader().GetMetricsByKeys(keys)
+}
+
+// CheckConnection проверяет доступность основного хранилища.
+// Проверка выполняется без блокировки хранилища, чтобы не задерживать запись.
+func (cs *CompositeStorage) CheckConnection() error {
+	cs.mu.RLock()
+	primary := cs.primary
+	cs.mu.RUnlock()
+	if primary == nil {
+		return ErrPrimaryUnavailable
+	}
+	return primary.CheckConnection()
+}
+
+// DumpMetrics сохраняет снимок метрик и журнал в файлы.
+func (cs *CompositeStorage) DumpMetrics() error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.dump()
+}
+
+// dump сохраняет снимок и журнал. Вызывается под cs.mu.Lock.
+func (cs *CompositeStorage) dump() error {
+	if err := cs.mirror.DumpMetrics(); err != nil {
+		return err
+	}
+	return cs.dumpPending()
+}
+
+// dumpPending заменяет файл журнала одной записью с накопленным журналом
+// (или удаляет его, если журнал пуст). Вызывается под cs.mu.Lock.
+func (cs *CompositeStorage) dumpPending() error {
+	if cs.pendingWAL != nil {
+		err := cs.pendingWAL.close()
+		cs.pendingWAL = nil
+		if err != nil {
+			return err
+		}
+	}
+	pendingPath := cs.fPath + pendingSuffix
+	if len(cs.pendingOrder) == 0 {
+		if err := os.Remove(pendingPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(cs.pendingMetrics())
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(pendingPath, append(data, '\n'), OsAllRw)
+}
+
+// loadMetricsFromFile восстанавливает копию метрик и журнал из файлов снимка.
+// Файл журнала содержит записи в формате журнала упреждающей записи (по пакету в строке);
+// файл прежних версий с одним массивом JSON также принимается.
+func (cs *CompositeStorage) loadMetricsFromFile() error {
+	if err := cs.mirror.loadMetricsFromFile(); err != nil {
+		return err
+	}
+	pendingPath := cs.fPath + pendingSuffix
+	data, err := os.ReadFile(pendingPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("can not open pending metrics file: %w", err)
+	}
+	var items []models.Metrics
+	if err := json.Unmarshal(data, &items); err == nil {
+		for _, item := range items {
+			if err := CheckMetric(item); err != nil {
+				return fmt.Errorf("invalid pending metric %q: %w", item.ID, err)
+			}
+			cs.addPending(item)
+		}
+	} else if _, err := replayWAL(pendingPath, func(items []models.Metrics) error {
+		for _, item := range items {
+			cs.addPending(item)
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("can not read pending metrics file: %w", err)
+	}
+	logger.Log.Info("Restored pending metrics", zap.Int("count", len(cs.pendingOrder)))
+	return nil
+}
+
+// Close закрывает основное хранилище (в том числе отключенное после сбоя),
+// если оно поддерживает закрытие, и файлы журналов.
+func (cs *CompositeStorage) Close() error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	var errs []error
+	if cs.pendingWAL != nil {
+		errs = append(errs, cs.pendingWAL.close())
+		cs.pendingWAL = nil
+	}
+	errs = append(errs, cs.mirror.Close())
+	primary := cs.primary
+	if primary == nil {
+		primary = cs.standby
+	}
+	if closer, ok := primary.(io.Closer); ok {
+		errs = append(errs, closer.Close())
+	}
+	return errors.Join(errs...)
+}
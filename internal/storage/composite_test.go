@@ -0,0 +1,323 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	model "github.com/Fuonder/metriccoll.git/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePrimary — основное хранилище для тестов поверх JSONStorage.
+type fakePrimary struct {
+	*JSONStorage
+}
+
+func (fakePrimary) CheckConnection() error { return nil }
+
+func TestCompositeStorageReplaysPending(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[{"id":"c","type":"counter","delta":10}]`), OsAllRw))
+	info := NewFileStoreInfo(path, 0, false)
+
+	cs, err := NewCompositeStorage(nil, info)
+	require.NoError(t, err)
+	require.False(t, cs.IsPrimaryActive())
+	require.ErrorIs(t, cs.CheckConnection(), ErrPrimaryUnavailable)
+
+	require.NoError(t, cs.AppendMetrics([]model.Metrics{
+		{ID: "c", MType: "counter", Delta: model.Int64Ptr(5)},
+		{ID: "g", MType: "gauge", Value: model.Float64Ptr(1)},
+		{ID: "c", MType: "counter", Delta: model.Int64Ptr(2)},
+	}))
+	c, err := cs.GetMetricByName("c", "counter")
+	require.NoError(t, err)
+	require.Equal(t, int64(17), *c.Delta)
+	require.Equal(t, 2, cs.PendingCount())
+	require.FileExists(t, path+pendingSuffix)
+
+	// После перезапуска без БД состояние и журнал восстанавливаются из снимка.
+	cs, err = NewCompositeStorage(nil, info)
+	require.NoError(t, err)
+	require.Equal(t, 2, cs.PendingCount())
+	c, err = cs.GetMetricByName("c", "counter")
+	require.NoError(t, err)
+	require.Equal(t, int64(17), *c.Delta)
+
+	db, err := NewJSONStorage(NewFileStoreInfo(filepath.Join(t.TempDir(), "db.json"), time.Hour, false))
+	require.NoError(t, err)
+	require.NoError(t, db.AppendMetric(model.Metrics{ID: "c", MType: "counter", Delta: model.Int64Ptr(100)}))
+
+	require.NoError(t, cs.SetPrimary(fakePrimary{db}))
+	require.True(t, cs.IsPrimaryActive())
+	require.Equal(t, 0, cs.PendingCount())
+	require.NoFileExists(t, path+pendingSuffix)
+
+	c, err = db.GetMetricByName("c", "counter")
+	require.NoError(t, err)
+	require.Equal(t, int64(107), *c.Delta, "only deltas accumulated without primary are replayed")
+	g, err := cs.GetMetricByName("g", "gauge")
+	require.NoError(t, err)
+	require.Equal(t, 1.0, *g.Value)
+
+	require.NoError(t, cs.AppendMetric(model.Metrics{ID: "c", MType: "counter", Delta: model.Int64Ptr(1)}))
+	c, err = db.GetMetricByName("c", "counter")
+	require.NoError(t, err)
+	require.Equal(t, int64(108), *c.Delta)
+
+	restored, err := NewJSONStorage(NewFileStoreInfo(path, time.Hour, true))
+	require.NoError(t, err)
+	c, err = restored.GetMetricByName("c", "counter")
+	require.NoError(t, err)
+	require.Equal(t, int64(108), *c.Delta, "snapshot must follow primary")
+}
+
+func TestCompositeStorageRestartReplaysPending(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	info := NewFileStoreInfo(path, 0, false)
+
+	cs, err := NewCompositeStorage(nil, info)
+	require.NoError(t, err)
+	require.NoError(t, cs.AppendMetrics([]model.Metrics{
+		{ID: "c", MType: "counter", Delta: model.Int64Ptr(5)},
+		{ID: "g", MType: "gauge", Value: model.Float64Ptr(2)},
+	}))
+	require.FileExists(t, path+pendingSuffix)
+
+	db, err := NewJSONStorage(NewFileStoreInfo(filepath.Join(t.TempDir(), "db.json"), time.Hour, false))
+	require.NoError(t, err)
+	require.NoError(t, db.AppendMetric(model.Metrics{ID: "c", MType: "counter", Delta: model.Int64Ptr(100)}))
+	primary := &flakyPrimary{JSONStorage: db}
+
+	// БД недоступна при перезапуске: журнал сохраняется до следующей попытки.
+	primary.down.Store(true)
+	cs, err = NewCompositeStorage(primary, info)
+	require.NoError(t, err)
+	require.False(t, cs.IsPrimaryActive())
+	require.Equal(t, 2, cs.PendingCount())
+	require.FileExists(t, path+pendingSuffix)
+	require.Equal(t, PrimaryStorage(primary), cs.standbyPrimary())
+
+	// Перезапуск с доступной БД передает журнал до начала работы и только затем удаляет его.
+	primary.down.Store(false)
+	cs, err = NewCompositeStorage(primary, info)
+	require.NoError(t, err)
+	require.True(t, cs.IsPrimaryActive())
+	require.Equal(t, 0, cs.PendingCount())
+	require.NoFileExists(t, path+pendingSuffix)
+
+	c, err := db.GetMetricByName("c", "counter")
+	require.NoError(t, err)
+	require.Equal(t, int64(105), *c.Delta)
+	g, err := cs.GetMetricByName("g", "gauge")
+	require.NoError(t, err)
+	require.Equal(t, 2.0, *g.Value)
+
+	require.NoError(t, cs.DumpMetrics())
+	cs, err = NewCompositeStorage(primary, info)
+	require.NoError(t, err)
+	c, err = db.GetMetricByName("c", "counter")
+	require.NoError(t, err)
+	require.Equal(t, int64(105), *c.Delta, "replayed journal must not be applied twice")
+}
+
+func TestCompositeStorageSyncModeUsesJournals(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	info := NewFileStoreInfo(path, 0, false)
+	db, err := NewJSONStorage(NewFileStoreInfo(filepath.Join(t.TempDir(), "db.json"), time.Hour, false))
+	require.NoError(t, err)
+	primary := &flakyPrimary{JSONStorage: db}
+
+	cs, err := NewCompositeStorage(primary, info)
+	require.NoError(t, err)
+	snapshot, err := os.Stat(path)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, cs.AppendMetric(model.Metrics{ID: "c", MType: "counter", Delta: model.Int64Ptr(1)}))
+	}
+	after, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, snapshot.ModTime(), after.ModTime(), "writes must go to the wal, not rewrite the snapshot")
+	restored, err := ReadJSONFile(path)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), *restored[0].Delta)
+
+	// Без БД пакеты дописываются в журнал, а не переписывают его целиком.
+	primary.down.Store(true)
+	require.NoError(t, cs.AppendMetric(model.Metrics{ID: "c", MType: "counter", Delta: model.Int64Ptr(2)}))
+	require.NoError(t, cs.AppendMetric(model.Metrics{ID: "c", MType: "counter", Delta: model.Int64Ptr(5)}))
+	data, err := os.ReadFile(path + pendingSuffix)
+	require.NoError(t, err)
+	require.Equal(t, 2, bytes.Count(data, []byte("\n")))
+	require.NoError(t, cs.Close())
+
+	cs, err = NewCompositeStorage(nil, info)
+	require.NoError(t, err)
+	require.Equal(t, 1, cs.PendingCount())
+	c, err := cs.GetMetricByName("c", "counter")
+	require.NoError(t, err)
+	require.Equal(t, int64(10), *c.Delta)
+
+	primary.down.Store(false)
+	require.NoError(t, cs.SetPrimary(primary))
+	c, err = db.GetMetricByName("c", "counter")
+	require.NoError(t, err)
+	require.Equal(t, int64(10), *c.Delta)
+	require.NoFileExists(t, path+pendingSuffix)
+}
+
+func TestCompositeStorageLegacyPendingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	legacy := "[\n    {\n        \"id\": \"c\",\n        \"type\": \"counter\",\n        \"delta\": 4\n    }\n]"
+	require.NoError(t, os.WriteFile(path+pendingSuffix, []byte(legacy), OsAllRw))
+	cs, err := NewCompositeStorage(nil, NewFileStoreInfo(path, time.Hour, false))
+	require.NoError(t, err)
+	require.Equal(t, 1, cs.PendingCount())
+}
+
+// slowPrimary — основное хранилище, запись в которое ждет сигнала release.
+type slowPrimary struct {
+	fakePrimary
+	started chan struct{}
+	release chan struct{}
+}
+
+func (p *slowPrimary) AppendMetrics(metrics []model.Metrics) error {
+	close(p.started)
+	<-p.release
+	return p.JSONStorage.AppendMetrics(metrics)
+}
+
+func TestCompositeStorageWritesPrimaryWithoutLock(t *testing.T) {
+	db, err := NewJSONStorage(NewFileStoreInfo(filepath.Join(t.TempDir(), "db.json"), time.Hour, false))
+	require.NoError(t, err)
+	primary := &slowPrimary{fakePrimary: fakePrimary{db}, started: make(chan struct{}), release: make(chan struct{})}
+	cs, err := NewCompositeStorage(primary, NewFileStoreInfo(filepath.Join(t.TempDir(), "metrics.json"), 0, false))
+	require.NoError(t, err)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cs.AppendMetric(model.Metrics{ID: "g", MType: "gauge", Value: model.Float64Ptr(1)})
+	}()
+	<-primary.started
+
+	// Пока БД обрабатывает запись, состояние хранилища доступно.
+	status := make(chan Status, 1)
+	go func() { status <- cs.Status() }()
+	select {
+	case st := <-status:
+		require.Equal(t, ModeNormal, st.Mode)
+	case <-time.After(time.Second):
+		t.Fatal("storage is locked while primary write is in progress")
+	}
+
+	close(primary.release)
+	require.NoError(t, <-done)
+	g, err := cs.mirror.GetMetricByName("g", "gauge")
+	require.NoError(t, err)
+	require.Equal(t, 1.0, *g.Value)
+}
+
+func TestCompositeStorageRejectsInvalidBatch(t *testing.T) {
+	cs, err := NewCompositeStorage(nil, NewFileStoreInfo(filepath.Join(t.TempDir(), "metrics.json"), time.Hour, false))
+	require.NoError(t, err)
+	err = cs.AppendMetrics([]model.Metrics{
+		{ID: "c", MType: "counter", Delta: model.Int64Ptr(1)},
+		{ID: "g", MType: "gauge"},
+	})
+	require.ErrorIs(t, err, ErrInvalidMetricValue)
+	require.Empty(t, cs.GetAllMetrics())
+	require.Equal(t, 0, cs.PendingCount())
+}
+
+// flakyPrimary — основное хранилище, соединение с которым можно «разорвать».
+type flakyPrimary struct {
+	*JSONStorage
+	down atomic.Bool
+}
+
+var errConnectionLost = errors.New("connection lost")
+
+func (p *flakyPrimary) CheckConnection() error {
+	if p.down.Load() {
+		return errConnectionLost
+	}
+	return nil
+}
+
+func (p *flakyPrimary) AppendMetrics(metrics []model.Metrics) error {
+	if p.down.Load() {
+		return errConnectionLost
+	}
+	return p.JSONStorage.AppendMetrics(metrics)
+}
+
+func TestHealthMonitorDegradedMode(t *testing.T) {
+	db, err := NewJSONStorage(NewFileStoreInfo(filepath.Join(t.TempDir(), "db.json"), time.Hour, false))
+	require.NoError(t, err)
+	primary := &flakyPrimary{JSONStorage: db}
+	cs, err := NewCompositeStorage(primary, NewFileStoreInfo(filepath.Join(t.TempDir(), "metrics.json"), time.Hour, false))
+	require.NoError(t, err)
+	require.Equal(t, ModeNormal, cs.Status().Mode)
+
+	// Запись при потере соединения буферизуется, а не отклоняется.
+	primary.down.Store(true)
+	require.NoError(t, cs.AppendMetric(model.Metrics{ID: "c", MType: "counter", Delta: model.Int64Ptr(3)}))
+	st := cs.Status()
+	require.Equal(t, ModeDegraded, st.Mode)
+	require.Equal(t, DatabaseDown, st.Database)
+	require.Equal(t, 1, st.Buffered)
+	require.Equal(t, errConnectionLost.Error(), st.Error)
+	c, err := cs.GetMetricByName("c", "counter")
+	require.NoError(t, err)
+	require.Equal(t, int64(3), *c.Delta)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := NewHealthMonitor(cs, nil)
+	m.interval, m.minBackoff, m.maxBackoff = 5*time.Millisecond, time.Millisecond, 10*time.Millisecond
+	go m.Run(ctx)
+
+	primary.down.Store(false)
+	require.Eventually(t, cs.IsPrimaryActive, time.Second, 5*time.Millisecond)
+	require.Equal(t, 0, cs.Status().Buffered)
+	c, err = db.GetMetricByName("c", "counter")
+	require.NoError(t, err)
+	require.Equal(t, int64(3), *c.Delta)
+
+	// Монитор сам обнаруживает потерю соединения.
+	primary.down.Store(true)
+	require.Eventually(t, func() bool { return !cs.IsPrimaryActive() }, time.Second, 5*time.Millisecond)
+}
+
+func TestHealthMonitorConnect(t *testing.T) {
+	cs, err := NewCompositeStorage(nil, NewFileStoreInfo(filepath.Join(t.TempDir(), "metrics.json"), time.Hour, false))
+	require.NoError(t, err)
+	require.NoError(t, cs.AppendMetric(model.Metrics{ID: "g", MType: "gauge", Value: model.Float64Ptr(2)}))
+
+	db, err := NewJSONStorage(NewFileStoreInfo(filepath.Join(t.TempDir(), "db.json"), time.Hour, false))
+	require.NoError(t, err)
+	var attempts atomic.Int32
+	m := NewHealthMonitor(cs, func(context.Context) (PrimaryStorage, error) {
+		if attempts.Add(1) < 3 {
+			return nil, errConnectionLost
+		}
+		return fakePrimary{db}, nil
+	})
+	m.interval, m.minBackoff, m.maxBackoff = 5*time.Millisecond, time.Millisecond, 4*time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx)
+	require.Eventually(t, cs.IsPrimaryActive, time.Second, 5*time.Millisecond)
+	require.GreaterOrEqual(t, attempts.Load(), int32(3))
+	g, err := db.GetMetricByName("g", "gauge")
+	require.NoError(t, err)
+	require.Equal(t, 2.0, *g.Value)
+}
@@ -5,13 +5,17 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"regexp"
 	"strings"
 	"time"
 
 	"github.com/Fuonder/metriccoll.git/internal/logger"
 	"github.com/Fuonder/metriccoll.git/internal/models"
 	"github.com/Fuonder/metriccoll.git/internal/storage"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
 	"go.uber.org/zap"
 )
 
@@ -27,22 +31,46 @@ func isConnectionError(err error) bool {
 	return false
 }
 
+// PoolSettings описывает параметры пула соединений с БД. Нулевые значения оставляют настройки pgx по умолчанию.
+type PoolSettings struct {
+	MaxConns        int32         // Максимальное количество соединений в пуле.
+	MaxConnIdleTime time.Duration // Время простоя, после которого соединение закрывается.
+}
+
+// PSQLConnection — соединение с PostgreSQL поверх пула pgxpool. Простые запросы выполняются
+// через database/sql (stdlib.OpenDBFromPool), пакетная запись — через нативный интерфейс pgx.
 type PSQLConnection struct {
-	db *sql.DB
+	pool *pgxpool.Pool
+	db   *sql.DB
 }
 
-func NewPSQLConnection(ctx context.Context, settings string) (*PSQLConnection, error) {
-	var err error
+func NewPSQLConnection(ctx context.Context, settings string, poolSettings PoolSettings) (*PSQLConnection, error) {
 	c := &PSQLConnection{}
 
 	logger.Log.Info("Connecting to database")
-	c.db, err = sql.Open("pgx", settings)
+	cfg, err := pgxpool.ParseConfig(settings)
+	if err != nil {
+		return &PSQLConnection{}, fmt.Errorf("can not connect with database: %v", err)
+	}
+	if poolSettings.MaxConns > 0 {
+		cfg.MaxConns = poolSettings.MaxConns
+	}
+	if poolSettings.MaxConnIdleTime > 0 {
+		cfg.MaxConnIdleTime = poolSettings.MaxConnIdleTime
+	}
+	c.pool, err = pgxpool.NewWithConfig(ctx, cfg)
 	if err != nil {
 		return &PSQLConnection{}, fmt.Errorf("can not connect with database: %v", err)
 	}
-	logger.Log.Info("Database initial connection successful")
+	c.db = stdlib.OpenDBFromPool(c.pool)
+	logger.Log.Info("Database initial connection successful",
+		zap.Int32("max-conns", cfg.MaxConns),
+		zap.Duration("max-conn-idle-time", cfg.MaxConnIdleTime))
 	err = c.TryConnectContext(ctx)
 	if err != nil {
+		if closeErr := c.Close(); closeErr != nil {
+			logger.Log.Debug("can not close database", zap.Error(closeErr))
+		}
 		return &PSQLConnection{}, fmt.Errorf("access to database: %v", err)
 	}
 	return c, nil
@@ -75,35 +103,20 @@ func (c *PSQLConnection) TryConnectContext(ctx context.Context) error {
 	return fmt.Errorf("can not access database: %v", err)
 }
 
+// CreateTablesContext приводит схему БД к последней версии, применяя встроенные миграции
+// (см. Migrator). Ошибка миграции возвращается вызывающему коду.
 func (c *PSQLConnection) CreateTablesContext(ctx context.Context) error {
-	logger.Log.Info("Creating tables in database")
-	query := `
-			CREATE TABLE IF NOT EXISTS gauge_metrics (
-			id TEXT PRIMARY KEY,
-			type TEXT NOT NULL,
-			value DOUBLE PRECISION);
-		`
-
-	_, err := c.db.ExecContext(ctx, query)
+	logger.Log.Info("Migrating database schema")
+	migrator, err := c.Migrator()
 	if err != nil {
-		logger.Log.Fatal("Failed to create gauge table", zap.Error(err))
-		return err
+		return fmt.Errorf("load migrations: %w", err)
 	}
-
-	query = `
-			CREATE TABLE IF NOT EXISTS counter_metrics (
-			id TEXT PRIMARY KEY,
-			type TEXT NOT NULL,
-			delta BIGINT);
-			`
-	_, err = c.db.ExecContext(ctx, query)
+	applied, err := migrator.Up(ctx)
 	if err != nil {
-		logger.Log.Fatal("Failed to create counter table", zap.Error(err))
-		return err
+		return fmt.Errorf("migrate database: %w", err)
 	}
-	logger.Log.Info("Tables created successfully")
+	logger.Log.Info("Database schema is up to date", zap.Int("applied", applied))
 	return nil
-
 }
 
 func (c *PSQLConnection) GetGaugeMetric(ctx context.Context, name string) (models.Metrics, error) {
@@ -112,8 +125,8 @@ func (c *PSQLConnection) GetGaugeMetric(ctx context.Context, name string) (model
 		metric models.Metrics
 	)
 
-	query = `SELECT id, type, value from gauge_metrics WHERE id = $1`
-	err := c.db.QueryRowContext(ctx, query, name).Scan(&metric.ID, &metric.MType, &metric.Value)
+	query = `SELECT id, type, value, updated_at from gauge_metrics WHERE id = $1`
+	err := c.db.QueryRowContext(ctx, query, name).Scan(&metric.ID, &metric.MType, &metric.Value, &metric.UpdatedAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return models.Metrics{}, fmt.Errorf("metric not found for id '%s' and type 'Gauge'", name)
@@ -130,8 +143,8 @@ func (c *PSQLConnection) GetCounterMetric(ctx context.Context, name string) (mod
 		metric models.Metrics
 	)
 
-	query = `SELECT id, type, delta from counter_metrics WHERE id = $1`
-	err := c.db.QueryRowContext(ctx, query, name).Scan(&metric.ID, &metric.MType, &metric.Delta)
+	query = `SELECT id, type, delta, updated_at from counter_metrics WHERE id = $1`
+	err := c.db.QueryRowContext(ctx, query, name).Scan(&metric.ID, &metric.MType, &metric.Delta, &metric.UpdatedAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return models.Metrics{}, fmt.Errorf("metric not found for id '%s' and type 'Counter'", name)
@@ -157,7 +170,7 @@ func (c *PSQLConnection) AppendGaugeMetric(ctx context.Context, metric models.Me
 			INSERT INTO gauge_metrics (id, type, value)
 			VALUES ($1, 'gauge', $2)
 			ON CONFLICT (id)
-			DO UPDATE SET value = EXCLUDED.value;
+			DO UPDATE SET value = EXCLUDED.value, updated_at = now();
 		`
 	_, err = tx.ExecContext(ctx, query, metric.ID, metric.Value)
 	if err != nil {
@@ -182,7 +195,7 @@ func (c *PSQLConnection) AppendCounterMetric(ctx context.Context, metric models.
 			INSERT INTO counter_metrics (id, type, delta)
 			VALUES ($1, 'counter', $2)
 			ON CONFLICT (id)
-			DO UPDATE SET delta = counter_metrics.delta + EXCLUDED.delta;
+			DO UPDATE SET delta = counter_metrics.delta + EXCLUDED.delta, updated_at = now();
 		`
 	_, err = tx.ExecContext(ctx, query, metric.ID, metric.Delta)
 	if err != nil {
@@ -191,12 +204,25 @@ func (c *PSQLConnection) AppendCounterMetric(ctx context.Context, metric models.
 	return tx.Commit()
 }
 
+// GetAllMetrics читает обе таблицы в одной транзакции REPEATABLE READ только для чтения,
+// поэтому результат — согласованный снимок, даже если параллельно идет запись.
 func (c *PSQLConnection) GetAllMetrics(ctx context.Context) ([]models.Metrics, error) {
 	var metrics []models.Metrics
-	queryCounter := `SELECT id, type, delta FROM counter_metrics`
-	queryGauge := `SELECT id, type, value FROM gauge_metrics`
+	queryCounter := `SELECT id, type, delta, updated_at FROM counter_metrics`
+	queryGauge := `SELECT id, type, value, updated_at FROM gauge_metrics`
+
+	tx, err := c.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return []models.Metrics{}, fmt.Errorf("can not begin transaction: %w", err)
+	}
+	defer func(tx *sql.Tx) {
+		err := tx.Rollback()
+		if err != nil && !errors.Is(err, sql.ErrTxDone) {
+			logger.Log.Info("can not rollback transaction", zap.Error(err))
+		}
+	}(tx)
 
-	rowsCounter, err := c.db.QueryContext(ctx, queryCounter)
+	rowsCounter, err := tx.QueryContext(ctx, queryCounter)
 	if err != nil {
 		return []models.Metrics{}, fmt.Errorf("can not query counter metrics: %w", err)
 	}
@@ -208,7 +234,7 @@ func (c *PSQLConnection) GetAllMetrics(ctx context.Context) ([]models.Metrics, e
 	}(rowsCounter)
 	for rowsCounter.Next() {
 		var m models.Metrics
-		if err := rowsCounter.Scan(&m.ID, &m.MType, &m.Delta); err != nil {
+		if err := rowsCounter.Scan(&m.ID, &m.MType, &m.Delta, &m.UpdatedAt); err != nil {
 			return []models.Metrics{}, fmt.Errorf("can not scan counter metrics: %w", err)
 		}
 		metrics = append(metrics, m)
@@ -217,7 +243,7 @@ func (c *PSQLConnection) GetAllMetrics(ctx context.Context) ([]models.Metrics, e
 		return []models.Metrics{}, fmt.Errorf("counter metrics has errors: %w", err)
 	}
 
-	rowsGauge, err := c.db.QueryContext(ctx, queryGauge)
+	rowsGauge, err := tx.QueryContext(ctx, queryGauge)
 	if err != nil {
 		return []models.Metrics{}, fmt.Errorf("can not query gauge metrics: %w", err)
 	}
@@ -232,7 +258,7 @@ func (c *PSQLConnection) GetAllMetrics(ctx context.Context) ([]models.Metrics, e
 
 	for rowsGauge.Next() {
 		var m models.Metrics
-		if err := rowsGauge.Scan(&m.ID, &m.MType, &m.Value); err != nil {
+		if err := rowsGauge.Scan(&m.ID, &m.MType, &m.Value, &m.UpdatedAt); err != nil {
 			return []models.Metrics{}, fmt.Errorf("can not scan gauge metrics: %w", err)
 		}
 		metrics = append(metrics, m)
@@ -243,79 +269,352 @@ func (c *PSQLConnection) GetAllMetrics(ctx context.Context) ([]models.Metrics, e
 	return metrics, nil
 }
 
-func (c *PSQLConnection) AppendBatch(ctx context.Context, metrics []models.Metrics) error {
-	tx, err := c.db.BeginTx(ctx, nil)
-	if err != nil {
-		return err
+// GetMetricsByNames получает метрики с заданными именами двумя запросами (по одному на таблицу).
+func (c *PSQLConnection) GetMetricsByNames(ctx context.Context, gaugeNames []string, counterNames []string) ([]models.Metrics, error) {
+	metrics := make([]models.Metrics, 0, len(gaugeNames)+len(counterNames))
+	queries := []struct {
+		query string
+		names []string
+		gauge bool
+	}{
+		{`SELECT id, type, value, updated_at FROM gauge_metrics WHERE id = ANY($1)`, gaugeNames, true},
+		{`SELECT id, type, delta, updated_at FROM counter_metrics WHERE id = ANY($1)`, counterNames, false},
 	}
-	defer func(tx *sql.Tx) {
-		err := tx.Rollback()
+	for _, q := range queries {
+		if len(q.names) == 0 {
+			continue
+		}
+		rows, err := c.db.QueryContext(ctx, q.query, q.names)
 		if err != nil {
-			logger.Log.Info("can not rollback transaction", zap.Error(err))
+			return nil, fmt.Errorf("can not query metrics: %w", err)
 		}
-	}(tx)
+		for rows.Next() {
+			var m models.Metrics
+			if q.gauge {
+				err = rows.Scan(&m.ID, &m.MType, &m.Value, &m.UpdatedAt)
+			} else {
+				err = rows.Scan(&m.ID, &m.MType, &m.Delta, &m.UpdatedAt)
+			}
+			if err != nil {
+				_ = rows.Close()
+				return nil, fmt.Errorf("can not scan metrics: %w", err)
+			}
+			metrics = append(metrics, m)
+		}
+		err = rows.Err()
+		if closeErr := rows.Close(); closeErr != nil {
+			logger.Log.Info("Rows can not be closed", zap.Error(closeErr))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("metrics has errors: %w", err)
+		}
+	}
+	return metrics, nil
+}
 
-	stmtGauge, err := tx.PrepareContext(ctx,
-		`
-			INSERT INTO gauge_metrics (id, type, value)
-			VALUES ($1, 'gauge', $2)
-			ON CONFLICT (id)
-			DO UPDATE SET value = EXCLUDED.value;
-		`)
+// likeEscaper экранирует спецсимволы шаблона LIKE (экранирующий символ по умолчанию — обратная косая черта).
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// buildMetricQuery строит SQL-запрос для MetricQuery: объединяет таблицы выбранных типов,
+// фильтрует по префиксу (LIKE, использует индекс при подходящей сортировке) и курсору
+// (сравнение строк), упорядочивает и ограничивает результат q.Limit+1 строками, чтобы
+// определить наличие следующей страницы. Регулярное выражение в запрос не передается:
+// оператор ~ PostgreSQL понимает другой синтаксис, чем RE2, и не ограничен по времени
+// выполнения, поэтому выражение применяется к строкам результата в QueryMetrics, а запрос
+// с выражением не ограничивается по числу строк.
+func buildMetricQuery(q storage.MetricQuery) (string, []any) {
+	var sources []string
+	if q.MType == "" || q.MType == "gauge" {
+		sources = append(sources, `SELECT id, type, value, NULL::BIGINT AS delta, updated_at FROM gauge_metrics`)
+	}
+	if q.MType == "" || q.MType == "counter" {
+		sources = append(sources, `SELECT id, type, NULL::DOUBLE PRECISION AS value, delta, updated_at FROM counter_metrics`)
+	}
+
+	var (
+		conds []string
+		args  []any
+	)
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if q.Prefix != "" {
+		conds = append(conds, "id LIKE "+arg(likeEscaper.Replace(q.Prefix)+"%"))
+	}
+	// Побайтовое сравнение (COLLATE "C") совпадает с порядком остальных хранилищ
+	// и не зависит от локали БД.
+	first, second := `id COLLATE "C"`, `type COLLATE "C"`
+	if q.Sort == storage.SortByType {
+		first, second = second, first
+	}
+	order, cmp := "ASC", ">"
+	if q.Desc {
+		order, cmp = "DESC", "<"
+	}
+	if q.After != nil {
+		a1, a2 := q.SortKey(*q.After)
+		conds = append(conds, fmt.Sprintf("(%s, %s) %s (%s, %s)", first, second, cmp, arg(a1), arg(a2)))
+	}
+
+	query := `SELECT id, type, value, delta, updated_at FROM (` + strings.Join(sources, ` UNION ALL `) + `) AS m`
+	if len(conds) > 0 {
+		query += ` WHERE ` + strings.Join(conds, ` AND `)
+	}
+	query += fmt.Sprintf(` ORDER BY %s %s, %s %s`, first, order, second, order)
+	if q.Regex == "" {
+		query += ` LIMIT ` + arg(q.Limit+1)
+	}
+	return query, args
+}
+
+// QueryMetrics выполняет MetricQuery в БД (см. buildMetricQuery). Регулярное выражение
+// (синтаксис RE2, как в остальных хранилищах) проверяется по мере чтения упорядоченных строк;
+// чтение прекращается, как только отобрано q.Limit+1 метрик.
+func (c *PSQLConnection) QueryMetrics(ctx context.Context, q storage.MetricQuery) ([]models.Metrics, error) {
+	var re *regexp.Regexp
+	if q.Regex != "" {
+		var err error
+		if re, err = regexp.Compile(q.Regex); err != nil {
+			return nil, fmt.Errorf("%w: regex: %v", storage.ErrInvalidQuery, err)
+		}
+	}
+	query, args := buildMetricQuery(q)
+	rows, err := c.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("can not query metrics: %w", err)
 	}
-	defer func(stmtGauge *sql.Stmt) {
-		err := stmtGauge.Close()
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
 		if err != nil {
-			logger.Log.Info("Can not close gauge metric", zap.Error(err))
+			logger.Log.Info("Rows can not be closed", zap.Error(err))
 		}
-	}(stmtGauge)
-	stmtCounter, err := tx.PrepareContext(ctx,
+	}(rows)
+
+	metrics := make([]models.Metrics, 0, q.Limit+1)
+	for len(metrics) <= q.Limit && rows.Next() {
+		var m models.Metrics
+		if err := rows.Scan(&m.ID, &m.MType, &m.Value, &m.Delta, &m.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("can not scan metrics: %w", err)
+		}
+		if re != nil && !re.MatchString(m.ID) {
+			continue
+		}
+		metrics = append(metrics, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("metrics has errors: %w", err)
+	}
+	return metrics, nil
+}
+
+// copyThreshold — размер пакета (после объединения повторов), начиная с которого
+// метрики загружаются через COPY во временную таблицу, а не через pgx.Batch.
+const copyThreshold = 500
+
+const (
+	upsertGaugeQuery = `
+			INSERT INTO gauge_metrics (id, type, value)
+			VALUES ($1, 'gauge', $2)
+			ON CONFLICT (id)
+			DO UPDATE SET value = EXCLUDED.value, updated_at = now();
 		`
+	upsertCounterQuery = `
 			INSERT INTO counter_metrics (id, type, delta)
 			VALUES ($1, 'counter', $2)
 			ON CONFLICT (id)
-			DO UPDATE SET delta = counter_metrics.delta + EXCLUDED.delta;
-		`)
+			DO UPDATE SET delta = counter_metrics.delta + EXCLUDED.delta, updated_at = now();
+		`
+	replaceCounterQuery = `
+			INSERT INTO counter_metrics (id, type, delta)
+			VALUES ($1, 'counter', $2)
+			ON CONFLICT (id)
+			DO UPDATE SET delta = EXCLUDED.delta, updated_at = now();
+		`
+)
+
+// AppendBatch записывает пакет метрик в одной транзакции. Небольшие пакеты отправляются
+// одним pgx.Batch (один сетевой обмен на весь пакет), крупные загружаются через COPY
+// во временные таблицы и переносятся в основные одним upsert на тип.
+func (c *PSQLConnection) AppendBatch(ctx context.Context, metrics []models.Metrics) error {
+	return c.writeBatch(ctx, metrics, false)
+}
+
+// ReplaceBatch записывает пакет метрик в одной транзакции так же, как AppendBatch,
+// но значение counter заменяется суммой дельт пакета, а не увеличивается на нее.
+func (c *PSQLConnection) ReplaceBatch(ctx context.Context, metrics []models.Metrics) error {
+	return c.writeBatch(ctx, metrics, true)
+}
+
+// writeBatch записывает пакет метрик в одной транзакции; replace задает замену значений counter.
+func (c *PSQLConnection) writeBatch(ctx context.Context, metrics []models.Metrics, replace bool) error {
+	gauges, counters, err := storage.AggregateBatch(metrics)
+	if err != nil {
+		return err
+	}
+	if len(gauges)+len(counters) == 0 {
+		return nil
+	}
+
+	tx, err := c.pool.Begin(ctx)
 	if err != nil {
 		return err
 	}
-	defer func(stmtCounter *sql.Stmt) {
-		err := stmtCounter.Close()
+	defer func(tx pgx.Tx) {
+		err := tx.Rollback(context.WithoutCancel(ctx))
+		if err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			logger.Log.Info("can not rollback transaction", zap.Error(err))
+		}
+	}(tx)
+
+	if len(gauges)+len(counters) < copyThreshold {
+		err = appendWithBatch(ctx, tx, gauges, counters, replace)
+	} else {
+		err = appendWithCopy(ctx, tx, gauges, counters, replace)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// appendWithBatch отправляет upsert каждой серии одним pgx.Batch.
+func appendWithBatch(ctx context.Context, tx pgx.Tx, gauges []models.Metrics, counters []models.Metrics, replace bool) error {
+	counterQuery := upsertCounterQuery
+	if replace {
+		counterQuery = replaceCounterQuery
+	}
+	batch := &pgx.Batch{}
+	for _, m := range gauges {
+		batch.Queue(upsertGaugeQuery, m.ID, *m.Value)
+	}
+	for _, m := range counters {
+		batch.Queue(counterQuery, m.ID, *m.Delta)
+	}
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return fmt.Errorf("can not execute batch: %w", err)
+	}
+	return nil
+}
+
+// appendWithCopy загружает серии через COPY во временные таблицы и переносит их
+// в основные таблицы одним upsert на тип.
+func appendWithCopy(ctx context.Context, tx pgx.Tx, gauges []models.Metrics, counters []models.Metrics, replace bool) error {
+	counterUpdate := "counter_metrics.delta + EXCLUDED.delta"
+	if replace {
+		counterUpdate = "EXCLUDED.delta"
+	}
+	steps := []struct {
+		table   string
+		column  string
+		columns string
+		merge   string
+		rows    [][]any
+	}{
+		{
+			table:   "tmp_gauge_metrics",
+			column:  "value",
+			columns: "id TEXT NOT NULL, value DOUBLE PRECISION NOT NULL",
+			merge: `
+				INSERT INTO gauge_metrics (id, type, value)
+				SELECT id, 'gauge', value FROM tmp_gauge_metrics
+				ON CONFLICT (id)
+				DO UPDATE SET value = EXCLUDED.value, updated_at = now();
+			`,
+		},
+		{
+			table:   "tmp_counter_metrics",
+			column:  "delta",
+			columns: "id TEXT NOT NULL, delta BIGINT NOT NULL",
+			merge: fmt.Sprintf(`
+				INSERT INTO counter_metrics (id, type, delta)
+				SELECT id, 'counter', delta FROM tmp_counter_metrics
+				ON CONFLICT (id)
+				DO UPDATE SET delta = %s, updated_at = now();
+			`, counterUpdate),
+		},
+	}
+	for _, m := range gauges {
+		steps[0].rows = append(steps[0].rows, []any{m.ID, *m.Value})
+	}
+	for _, m := range counters {
+		steps[1].rows = append(steps[1].rows, []any{m.ID, *m.Delta})
+	}
+
+	for _, step := range steps {
+		if len(step.rows) == 0 {
+			continue
+		}
+		_, err := tx.Exec(ctx, fmt.Sprintf(`CREATE TEMP TABLE %s (%s) ON COMMIT DROP`, step.table, step.columns))
 		if err != nil {
-			logger.Log.Info("can not close counter metric", zap.Error(err))
+			return fmt.Errorf("can not create temp table: %w", err)
+		}
+		_, err = tx.CopyFrom(ctx, pgx.Identifier{step.table}, []string{"id", step.column}, pgx.CopyFromRows(step.rows))
+		if err != nil {
+			return fmt.Errorf("can not copy metrics: %w", err)
+		}
+		if _, err = tx.Exec(ctx, step.merge); err != nil {
+			return fmt.Errorf("can not merge metrics: %w", err)
 		}
-	}(stmtCounter)
+	}
+	return nil
+}
 
-	for _, m := range metrics {
-		if m.MType == "gauge" {
-			if m.Value == nil {
-				return storage.ErrInvalidMetricValue
-			}
-			_, err = stmtGauge.ExecContext(ctx,
-				m.ID,
-				m.Value)
-			if err != nil {
-				return err
-			}
-		} else if m.MType == "counter" {
-			if m.Delta == nil {
-				return storage.ErrInvalidMetricValue
-			}
-			_, err = stmtCounter.ExecContext(ctx,
-				m.ID,
-				m.Delta)
-			if err != nil {
-				return err
-			}
-		} else {
-			return fmt.Errorf("metric type: %s is not supported", m.MType)
+// DeleteMetricsByNames удаляет метрики с заданными именами в одной транзакции.
+func (c *PSQLConnection) DeleteMetricsByNames(ctx context.Context, gaugeNames []string, counterNames []string) ([]models.MetricKey, error) {
+	tx, err := c.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func(tx pgx.Tx) {
+		err := tx.Rollback(context.WithoutCancel(ctx))
+		if err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			logger.Log.Info("can not rollback transaction", zap.Error(err))
+		}
+	}(tx)
+
+	removed := make([]models.MetricKey, 0, len(gaugeNames)+len(counterNames))
+	for _, q := range []struct {
+		query string
+		names []string
+		mType string
+	}{
+		{`DELETE FROM gauge_metrics WHERE id = ANY($1) RETURNING id`, gaugeNames, "gauge"},
+		{`DELETE FROM counter_metrics WHERE id = ANY($1) RETURNING id`, counterNames, "counter"},
+	} {
+		if len(q.names) == 0 {
+			continue
+		}
+		rows, err := tx.Query(ctx, q.query, q.names)
+		if err != nil {
+			return nil, fmt.Errorf("can not delete metrics: %w", err)
+		}
+		ids, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		if err != nil {
+			return nil, fmt.Errorf("can not delete metrics: %w", err)
+		}
+		for _, id := range ids {
+			removed = append(removed, models.MetricKey{ID: id, MType: q.mType})
 		}
 	}
-	return tx.Commit()
+	return removed, tx.Commit(ctx)
+}
+
+// ResetCounterMetric обнуляет метрику типа Counter.
+func (c *PSQLConnection) ResetCounterMetric(ctx context.Context, name string) (bool, error) {
+	res, err := c.db.ExecContext(ctx, `UPDATE counter_metrics SET delta = 0, updated_at = now() WHERE id = $1`, name)
+	if err != nil {
+		return false, fmt.Errorf("can not reset counter metric: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("can not reset counter metric: %w", err)
+	}
+	return n > 0, nil
 }
 
 func (c *PSQLConnection) Close() error {
-	return c.db.Close()
+	err := c.db.Close()
+	c.pool.Close()
+	return err
 }
@@ -0,0 +1,35 @@
+package database
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Fuonder/metriccoll.git/internal/models"
+	"github.com/Fuonder/metriccoll.git/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildMetricQuery(t *testing.T) {
+	query, args := buildMetricQuery(storage.MetricQuery{Sort: storage.SortByName, Limit: 10})
+	require.Equal(t, `SELECT id, type, value, delta, updated_at FROM (`+
+		`SELECT id, type, value, NULL::BIGINT AS delta, updated_at FROM gauge_metrics UNION ALL `+
+		`SELECT id, type, NULL::DOUBLE PRECISION AS value, delta, updated_at FROM counter_metrics) AS m`+
+		` ORDER BY id COLLATE "C" ASC, type COLLATE "C" ASC LIMIT $1`, query)
+	require.Equal(t, []any{11}, args)
+
+	query, args = buildMetricQuery(storage.MetricQuery{
+		MType:  "counter",
+		Prefix: "cpu_%",
+		Regex:  "^cpu",
+		Sort:   storage.SortByType,
+		Desc:   true,
+		Limit:  5,
+		After:  &models.MetricKey{ID: "cpu_%9", MType: "counter"},
+	})
+	require.NotContains(t, query, "gauge_metrics")
+	require.Contains(t, query, `WHERE id LIKE $1 AND (type COLLATE "C", id COLLATE "C") < ($2, $3)`)
+	require.NotContains(t, query, "~", "regex is applied in Go, not by PostgreSQL")
+	require.True(t, strings.HasSuffix(query, `ORDER BY type COLLATE "C" DESC, id COLLATE "C" DESC`),
+		"query with regex must not be limited in SQL")
+	require.Equal(t, []any{`cpu\_\%%`, "counter", "cpu_%9"}, args)
+}
@@ -31,6 +31,12 @@ type DBStorage struct {
 	//fileStoreInfo DBFileStoreInfo // fileStoreInfo TODO: if it will be necessary to load/save files
 	//fileMu        sync.RWMutex // fileMu TODO: consider necessity
 	rwMutex sync.RWMutex
+	ops     storage.OpRecorder
+}
+
+// SetOpObserver задает получателя длительностей операций хранилища (nil — выключить).
+func (db *DBStorage) SetOpObserver(fn storage.OpObserver) {
+	db.ops.Set(fn)
 }
 
 func NewDBStorage(ctx context.Context, conn storage.DBConnection) (*DBStorage, error) {
@@ -64,11 +70,9 @@ func (db *DBStorage) Close() error {
 	return nil
 }
 
-func (db *DBStorage) GetMetricByName(name string, mType string) (models.Metrics, error) {
-	var (
-		metric models.Metrics
-		err    error
-	)
+func (db *DBStorage) GetMetricByName(name string, mType string) (_ models.Metrics, err error) {
+	defer db.ops.Observe(storage.BackendPostgres, storage.OpGet, time.Now(), &err)
+	var metric models.Metrics
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -91,7 +95,52 @@ func (db *DBStorage) GetMetricByName(name string, mType string) (models.Metrics,
 	return metric, nil
 }
 
-func (db *DBStorage) AppendMetric(metric models.Metrics) error {
+// GetMetricsByKeys получает метрики с заданными ключами одним запросом на каждый тип.
+func (db *DBStorage) GetMetricsByKeys(keys []models.MetricKey) (_ []models.Metrics, err error) {
+	defer db.ops.Observe(storage.BackendPostgres, storage.OpGet, time.Now(), &err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if db.connection == nil {
+		return nil, fmt.Errorf("no active connection with db")
+	}
+	var gaugeNames, counterNames []string
+	seen := make(map[models.MetricKey]struct{}, len(keys))
+	for _, key := range keys {
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		switch key.MType {
+		case "gauge":
+			gaugeNames = append(gaugeNames, key.ID)
+		case "counter":
+			counterNames = append(counterNames, key.ID)
+		default:
+			return nil, fmt.Errorf("metric type: %s is not supported", key.MType)
+		}
+	}
+	found, err := db.connection.GetMetricsByNames(ctx, gaugeNames, counterNames)
+	if err != nil {
+		return nil, fmt.Errorf("GetMetricsByKeys: %v", err)
+	}
+	byKey := make(map[models.MetricKey]models.Metrics, len(found))
+	for _, m := range found {
+		byKey[m.Key()] = m
+	}
+	result := make([]models.Metrics, 0, len(keys))
+	for _, key := range keys {
+		m, ok := byKey[key]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", storage.ErrMetricNotFound, key.ID)
+		}
+		result = append(result, m)
+	}
+	return result, nil
+}
+
+func (db *DBStorage) AppendMetric(metric models.Metrics) (err error) {
+	defer db.ops.Observe(storage.BackendPostgres, storage.OpAppend, time.Now(), &err)
 	db.rwMutex.Lock()
 	defer db.rwMutex.Unlock()
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -125,6 +174,8 @@ func (db *DBStorage) AppendMetric(metric models.Metrics) error {
 
 // GetAllMetrics TODO: error handling
 func (db *DBStorage) GetAllMetrics() []models.Metrics {
+	var err error
+	defer db.ops.Observe(storage.BackendPostgres, storage.OpGetAll, time.Now(), &err)
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	if db.connection == nil {
@@ -138,7 +189,29 @@ func (db *DBStorage) GetAllMetrics() []models.Metrics {
 	return metrics
 }
 
-func (db *DBStorage) AppendMetrics(metrics []models.Metrics) error {
+// QueryMetrics выполняет запрос q средствами БД.
+func (db *DBStorage) QueryMetrics(ctx context.Context, q storage.MetricQuery) (_ storage.MetricPage, err error) {
+	defer db.ops.Observe(storage.BackendPostgres, storage.OpQuery, time.Now(), &err)
+	if db.connection == nil {
+		return storage.MetricPage{}, fmt.Errorf("no active connection with db")
+	}
+	metrics, err := db.connection.QueryMetrics(ctx, q)
+	if err != nil {
+		return storage.MetricPage{}, err
+	}
+	return q.Page(metrics), nil
+}
+
+// ExportMetrics возвращает согласованный снимок всех метрик БД.
+func (db *DBStorage) ExportMetrics(ctx context.Context) ([]models.Metrics, error) {
+	if db.connection == nil {
+		return nil, fmt.Errorf("no active connection with db")
+	}
+	return db.connection.GetAllMetrics(ctx)
+}
+
+func (db *DBStorage) AppendMetrics(metrics []models.Metrics) (err error) {
+	defer db.ops.Observe(storage.BackendPostgres, storage.OpAppend, time.Now(), &err)
 	db.rwMutex.Lock()
 	defer db.rwMutex.Unlock()
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -150,3 +223,104 @@ func (db *DBStorage) AppendMetrics(metrics []models.Metrics) error {
 
 	return db.connection.AppendBatch(ctx, metrics)
 }
+
+// ReplaceMetrics записывает пакет метрик в одной транзакции, заменяя значения существующих серий.
+func (db *DBStorage) ReplaceMetrics(ctx context.Context, metrics []models.Metrics) (err error) {
+	defer db.ops.Observe(storage.BackendPostgres, storage.OpAppend, time.Now(), &err)
+	db.rwMutex.Lock()
+	defer db.rwMutex.Unlock()
+	if db.connection == nil {
+		return fmt.Errorf("no active connection with db")
+	}
+	return db.connection.ReplaceBatch(ctx, metrics)
+}
+
+// DeleteMetric удаляет метрику по имени и типу.
+func (db *DBStorage) DeleteMetric(name string, mType string) (err error) {
+	defer db.ops.Observe(storage.BackendPostgres, storage.OpDelete, time.Now(), &err)
+	var gaugeNames, counterNames []string
+	switch mType {
+	case "gauge":
+		gaugeNames = []string{name}
+	case "counter":
+		counterNames = []string{name}
+	default:
+		return fmt.Errorf("metric type: %s is not supported", mType)
+	}
+	removed, err := db.deleteByNames(gaugeNames, counterNames)
+	if err != nil {
+		return err
+	}
+	if len(removed) == 0 {
+		return fmt.Errorf("%w: %s", storage.ErrMetricNotFound, name)
+	}
+	return nil
+}
+
+// DeleteMetrics удаляет метрики, подходящие под шаблон имени pattern и тип mType.
+// Шаблон применяется к списку серий на стороне сервера, а удаление выполняется по точным именам.
+func (db *DBStorage) DeleteMetrics(pattern string, mType string) (_ []models.MetricKey, err error) {
+	defer db.ops.Observe(storage.BackendPostgres, storage.OpDelete, time.Now(), &err)
+	if err := storage.ValidatePattern(pattern, mType); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if db.connection == nil {
+		return nil, fmt.Errorf("no active connection with db")
+	}
+	all, err := db.connection.GetAllMetrics(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("DeleteMetrics: %v", err)
+	}
+	var gaugeNames, counterNames []string
+	for _, m := range all {
+		if !storage.MatchKey(m.Key(), pattern, mType) {
+			continue
+		}
+		if m.MType == "gauge" {
+			gaugeNames = append(gaugeNames, m.ID)
+		} else {
+			counterNames = append(counterNames, m.ID)
+		}
+	}
+	if len(gaugeNames)+len(counterNames) == 0 {
+		return nil, nil
+	}
+	return db.deleteByNames(gaugeNames, counterNames)
+}
+
+func (db *DBStorage) deleteByNames(gaugeNames []string, counterNames []string) ([]models.MetricKey, error) {
+	db.rwMutex.Lock()
+	defer db.rwMutex.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if db.connection == nil {
+		return nil, fmt.Errorf("no active connection with db")
+	}
+	removed, err := db.connection.DeleteMetricsByNames(ctx, gaugeNames, counterNames)
+	if err != nil {
+		return nil, fmt.Errorf("DeleteMetrics: %v", err)
+	}
+	return removed, nil
+}
+
+// ResetCounter обнуляет метрику типа Counter.
+func (db *DBStorage) ResetCounter(name string) (err error) {
+	defer db.ops.Observe(storage.BackendPostgres, storage.OpReset, time.Now(), &err)
+	db.rwMutex.Lock()
+	defer db.rwMutex.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if db.connection == nil {
+		return fmt.Errorf("no active connection with db")
+	}
+	found, err := db.connection.ResetCounterMetric(ctx, name)
+	if err != nil {
+		return fmt.Errorf("ResetCounter: %v", err)
+	}
+	if !found {
+		return fmt.Errorf("%w: %s", storage.ErrMetricNotFound, name)
+	}
+	return nil
+}
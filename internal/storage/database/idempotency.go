@@ -0,0 +1,100 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Fuonder/metriccoll.git/internal/idempotency"
+	"github.com/Fuonder/metriccoll.git/internal/logger"
+	"go.uber.org/zap"
+)
+
+// IdempotencyStore хранит ключи идемпотентности в таблице idempotency_keys,
+// чтобы повторы пакетов распознавались всеми экземплярами сервера и после перезапуска.
+type IdempotencyStore struct {
+	conn      *PSQLConnection
+	ttl       time.Duration
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+// NewIdempotencyStore создает хранилище ключей идемпотентности поверх соединения conn.
+// Записи старше ttl считаются забытыми.
+func NewIdempotencyStore(conn *PSQLConnection, ttl time.Duration) *IdempotencyStore {
+	return &IdempotencyStore{conn: conn, ttl: ttl}
+}
+
+// sweep удаляет устаревшие ключи не чаще одного раза за ttl.
+func (s *IdempotencyStore) sweep(ctx context.Context) {
+	s.mu.Lock()
+	if time.Since(s.lastSweep) <= s.ttl {
+		s.mu.Unlock()
+		return
+	}
+	s.lastSweep = time.Now()
+	s.mu.Unlock()
+
+	_, err := s.conn.db.ExecContext(ctx,
+		`DELETE FROM idempotency_keys WHERE created_at < now() - $1 * interval '1 second'`, s.ttl.Seconds())
+	if err != nil {
+		logger.Log.Info("can not delete expired idempotency keys", zap.Error(err))
+	}
+}
+
+// Begin резервирует ключ, см. idempotency.Store.
+func (s *IdempotencyStore) Begin(ctx context.Context, key string) (*idempotency.Response, error) {
+	s.sweep(ctx)
+
+	_, err := s.conn.db.ExecContext(ctx,
+		`DELETE FROM idempotency_keys WHERE key = $1 AND created_at < now() - $2 * interval '1 second'`,
+		key, s.ttl.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("can not expire idempotency key: %w", err)
+	}
+	res, err := s.conn.db.ExecContext(ctx,
+		`INSERT INTO idempotency_keys (key) VALUES ($1) ON CONFLICT (key) DO NOTHING`, key)
+	if err != nil {
+		return nil, fmt.Errorf("can not reserve idempotency key: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 1 {
+		return nil, nil
+	}
+
+	var (
+		completed bool
+		resp      idempotency.Response
+	)
+	err = s.conn.db.QueryRowContext(ctx,
+		`SELECT completed, status, content_type, body FROM idempotency_keys WHERE key = $1`, key).
+		Scan(&completed, &resp.Status, &resp.ContentType, &resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("can not read idempotency key: %w", err)
+	}
+	if !completed {
+		return nil, idempotency.ErrInProgress
+	}
+	return &resp, nil
+}
+
+// Complete сохраняет ответ для ключа, см. idempotency.Store.
+func (s *IdempotencyStore) Complete(ctx context.Context, key string, resp idempotency.Response) error {
+	_, err := s.conn.db.ExecContext(ctx,
+		`UPDATE idempotency_keys SET completed = TRUE, status = $2, content_type = $3, body = $4 WHERE key = $1`,
+		key, resp.Status, resp.ContentType, resp.Body)
+	if err != nil {
+		return fmt.Errorf("can not save idempotent response: %w", err)
+	}
+	return nil
+}
+
+// Release снимает резерв с ключа, см. idempotency.Store.
+func (s *IdempotencyStore) Release(ctx context.Context, key string) error {
+	_, err := s.conn.db.ExecContext(ctx,
+		`DELETE FROM idempotency_keys WHERE key = $1 AND NOT completed`, key)
+	if err != nil {
+		return fmt.Errorf("can not release idempotency key: %w", err)
+	}
+	return nil
+}
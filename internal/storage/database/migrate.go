@@ -0,0 +1,292 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Fuonder/metriccoll.git/internal/logger"
+	"go.uber.org/zap"
+)
+
+//go:embed migrations/*.sql
+var embeddedMigrations embed.FS
+
+// migrationLockID — ключ pg_advisory_lock, которым сервера исключают одновременное выполнение миграций.
+const migrationLockID int64 = 7_230_158_431_001
+
+var (
+	ErrInvalidMigration = errors.New("invalid migration")
+	ErrUnknownMigration = errors.New("applied migration is not known to this server")
+)
+
+// migrationName — формат имени файла миграции: <версия>_<название>.<up|down>.sql.
+var migrationName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration описывает одну версию схемы БД.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string // SQL применения миграции.
+	Down    string // SQL отката миграции.
+}
+
+// MigrationStatus описывает состояние миграции в БД.
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	AppliedAt *time.Time // nil, если миграция не применена.
+}
+
+// LoadMigrations читает миграции из корня fsys и возвращает их в порядке версий.
+// Для каждой версии должны быть заданы и up-, и down-файлы.
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("read migrations: %w", err)
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		match := migrationName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("%w: unexpected file name %q", ErrInvalidMigration, entry.Name())
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("%w: bad version in %q", ErrInvalidMigration, entry.Name())
+		}
+		data, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("read migration %q: %w", entry.Name(), err)
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("%w: version %d has names %q and %q", ErrInvalidMigration, version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("%w: version %d must have both up and down files", ErrInvalidMigration, m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrator применяет и откатывает миграции схемы, записывая примененные версии в таблицу
+// schema_migrations. На время работы берется pg_advisory_lock, поэтому несколько серверов,
+// запущенных одновременно, выполняют миграции по очереди.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// NewMigrator создает Migrator для соединения db и набора migrations, упорядоченного по версиям.
+func NewMigrator(db *sql.DB, migrations []Migration) *Migrator {
+	return &Migrator{db: db, migrations: migrations}
+}
+
+// Migrator возвращает Migrator со встроенными в сервер миграциями.
+func (c *PSQLConnection) Migrator() (*Migrator, error) {
+	sub, err := fs.Sub(embeddedMigrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := LoadMigrations(sub)
+	if err != nil {
+		return nil, err
+	}
+	return NewMigrator(c.db, migrations), nil
+}
+
+// withLock выполняет fn на отдельном соединении под pg_advisory_lock,
+// предварительно создав таблицу schema_migrations.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("can not get db connection: %w", err)
+	}
+	defer func(conn *sql.Conn) {
+		err := conn.Close()
+		if err != nil {
+			logger.Log.Info("can not close db connection", zap.Error(err))
+		}
+	}(conn)
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return fmt.Errorf("can not acquire migration lock: %w", err)
+	}
+	defer func() {
+		_, err := conn.ExecContext(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock($1)`, migrationLockID)
+		if err != nil {
+			logger.Log.Warn("can not release migration lock", zap.Error(err))
+		}
+	}()
+
+	_, err = conn.ExecContext(ctx, `
+			CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now());
+		`)
+	if err != nil {
+		return fmt.Errorf("can not create schema_migrations table: %w", err)
+	}
+	return fn(conn)
+}
+
+// applied возвращает время применения миграций по версиям.
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("can not read schema_migrations: %w", err)
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			logger.Log.Info("Rows can not be closed", zap.Error(err))
+		}
+	}(rows)
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var (
+			version   int64
+			appliedAt time.Time
+		)
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("can not scan schema_migrations: %w", err)
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// run выполняет SQL миграции и изменение schema_migrations в одной транзакции.
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, query string, record string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Up применяет все еще не примененные миграции и возвращает их количество.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	count := 0
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := m.checkKnown(applied); err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			logger.Log.Info("Applying migration", zap.Int64("version", mig.Version), zap.String("name", mig.Name))
+			err := m.run(ctx, conn, mig.Up,
+				`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, mig.Version, mig.Name)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", mig.Version, mig.Name, err)
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Down откатывает steps последних примененных миграций и возвращает их количество.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	count := 0
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := m.checkKnown(applied); err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && count < steps; i-- {
+			mig := m.migrations[i]
+			if _, ok := applied[mig.Version]; !ok {
+				continue
+			}
+			logger.Log.Info("Reverting migration", zap.Int64("version", mig.Version), zap.String("name", mig.Name))
+			err := m.run(ctx, conn, mig.Down,
+				`DELETE FROM schema_migrations WHERE version = $1`, mig.Version)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", mig.Version, mig.Name, err)
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Status возвращает состояние всех известных миграций.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var result []MigrationStatus
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			st := MigrationStatus{Version: mig.Version, Name: mig.Name}
+			if at, ok := applied[mig.Version]; ok {
+				st.AppliedAt = &at
+			}
+			result = append(result, st)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// checkKnown проверяет, что в БД нет миграций новее известных серверу:
+// старая версия сервера не должна работать со схемой, которую она не понимает.
+func (m *Migrator) checkKnown(applied map[int64]time.Time) error {
+	known := make(map[int64]struct{}, len(m.migrations))
+	for _, mig := range m.migrations {
+		known[mig.Version] = struct{}{}
+	}
+	for version := range applied {
+		if _, ok := known[version]; !ok {
+			return fmt.Errorf("%w: version %d", ErrUnknownMigration, version)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package database
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_add_index.up.sql":      {Data: []byte("CREATE INDEX i ON t (v);")},
+		"0002_add_index.down.sql":    {Data: []byte("DROP INDEX i;")},
+		"0001_create_t.up.sql":       {Data: []byte("CREATE TABLE t (v INT);")},
+		"0001_create_t.down.sql":     {Data: []byte("DROP TABLE t;")},
+		"0010_later_change.up.sql":   {Data: []byte("SELECT 1;")},
+		"0010_later_change.down.sql": {Data: []byte("SELECT 1;")},
+	}
+	migrations, err := LoadMigrations(fsys)
+	require.NoError(t, err)
+	require.Len(t, migrations, 3)
+	require.Equal(t, int64(1), migrations[0].Version)
+	require.Equal(t, "create_t", migrations[0].Name)
+	require.Equal(t, "DROP TABLE t;", migrations[0].Down)
+	require.Equal(t, int64(10), migrations[2].Version)
+
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+	}{
+		{"MissingDown", fstest.MapFS{"0001_a.up.sql": {Data: []byte("SELECT 1;")}}},
+		{"BadName", fstest.MapFS{"create.sql": {Data: []byte("SELECT 1;")}}},
+		{"NameMismatch", fstest.MapFS{
+			"0001_a.up.sql":   {Data: []byte("SELECT 1;")},
+			"0001_b.down.sql": {Data: []byte("SELECT 1;")},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadMigrations(tt.fsys)
+			require.ErrorIs(t, err, ErrInvalidMigration)
+		})
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	sub, err := fs.Sub(embeddedMigrations, "migrations")
+	require.NoError(t, err)
+	migrations, err := LoadMigrations(sub)
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+	for i, m := range migrations {
+		require.Equal(t, int64(i+1), m.Version, "migration versions must be sequential")
+	}
+}
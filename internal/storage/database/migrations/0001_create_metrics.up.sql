@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS gauge_metrics (
+    id TEXT PRIMARY KEY,
+    type TEXT NOT NULL,
+    value DOUBLE PRECISION
+);
+
+CREATE TABLE IF NOT EXISTS counter_metrics (
+    id TEXT PRIMARY KEY,
+    type TEXT NOT NULL,
+    delta BIGINT
+);
@@ -0,0 +1,2 @@
+ALTER TABLE counter_metrics DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE gauge_metrics DROP COLUMN IF EXISTS updated_at;
@@ -5,3 +5,6 @@ import "errors"
 var ErrFieldNotFound = errors.New("field not found")
 var ErrMetricNotFound = errors.New("metric with such key is not found")
 var ErrInvalidMetricValue = errors.New("invalid metric value")
+var ErrInvalidPattern = errors.New("invalid metric name pattern")
+var ErrInvalidStalePolicy = errors.New("invalid stale policy")
+var ErrInvalidQuery = errors.New("invalid metric query")
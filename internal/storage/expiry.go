@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Fuonder/metriccoll.git/internal/logger"
+	"github.com/Fuonder/metriccoll.git/internal/models"
+	"go.uber.org/zap"
+)
+
+// StaleAction — действие с сериями, которые не обновлялись дольше TTL.
+type StaleAction string
+
+const (
+	// StaleMark помечает устаревшие серии признаком Metrics.Stale при чтении.
+	StaleMark StaleAction = "mark"
+	// StaleRemove дополнительно удаляет устаревшие серии из хранилища (см. StaleSweeper).
+	StaleRemove StaleAction = "remove"
+)
+
+// Ограничения периода проверки StaleSweeper.
+const (
+	minSweepInterval = time.Second
+	maxSweepInterval = time.Minute
+)
+
+// staleRule — TTL для серий типа mType ("*" — любой тип), имя которых начинается с prefix.
+type staleRule struct {
+	mType  string
+	prefix string
+	ttl    time.Duration
+}
+
+// StalePolicy задает, через какое время без обновлений серия считается устаревшей.
+type StalePolicy struct {
+	rules  []staleRule
+	action StaleAction
+}
+
+// ParseStalePolicy разбирает список правил, разделенных запятыми, в формате
+// <тип>[:<префикс имени>]=<TTL>, например "gauge=10m,gauge:FreeMemory=2m,*:host1_=1h".
+// Тип — gauge, counter или "*" (любой). TTL записывается в формате time.ParseDuration;
+// нулевой TTL отключает устаревание для подходящих серий. Для серии применяется правило
+// с самым длинным подходящим префиксом, а при равных префиксах — правило для конкретного типа.
+// Пустой spec означает, что серии не устаревают: возвращается nil.
+func ParseStalePolicy(spec string, action StaleAction) (*StalePolicy, error) {
+	switch action {
+	case "", StaleMark:
+		action = StaleMark
+	case StaleRemove:
+	default:
+		return nil, fmt.Errorf("%w: unknown action %q (expected %q or %q)", ErrInvalidStalePolicy, action, StaleMark, StaleRemove)
+	}
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+
+	policy := &StalePolicy{action: action}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		selector, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: rule %q must be <type>[:<prefix>]=<ttl>", ErrInvalidStalePolicy, item)
+		}
+		mType, prefix, _ := strings.Cut(strings.TrimSpace(selector), ":")
+		if mType != "*" && mType != "gauge" && mType != "counter" {
+			return nil, fmt.Errorf("%w: rule %q: unknown metric type %q", ErrInvalidStalePolicy, item, mType)
+		}
+		ttl, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || ttl < 0 {
+			return nil, fmt.Errorf("%w: rule %q: invalid ttl %q", ErrInvalidStalePolicy, item, value)
+		}
+		policy.rules = append(policy.rules, staleRule{mType: mType, prefix: prefix, ttl: ttl})
+	}
+	if len(policy.rules) == 0 {
+		return nil, nil
+	}
+	return policy, nil
+}
+
+// Action возвращает действие с устаревшими сериями.
+func (p *StalePolicy) Action() StaleAction {
+	return p.action
+}
+
+// TTL возвращает время жизни серии без обновлений. Ноль означает, что серия не устаревает.
+func (p *StalePolicy) TTL(key models.MetricKey) time.Duration {
+	var best *staleRule
+	for i := range p.rules {
+		rule := &p.rules[i]
+		if rule.mType != "*" && rule.mType != key.MType {
+			continue
+		}
+		if !strings.HasPrefix(key.ID, rule.prefix) {
+			continue
+		}
+		if best == nil || len(rule.prefix) > len(best.prefix) ||
+			(len(rule.prefix) == len(best.prefix) && best.mType == "*") {
+			best = rule
+		}
+	}
+	if best == nil {
+		return 0
+	}
+	return best.ttl
+}
+
+// IsStale сообщает, устарела ли серия m к моменту now. Серии без времени обновления не устаревают.
+func (p *StalePolicy) IsStale(m models.Metrics, now time.Time) bool {
+	if m.UpdatedAt == nil {
+		return false
+	}
+	ttl := p.TTL(m.Key())
+	return ttl > 0 && now.Sub(*m.UpdatedAt) > ttl
+}
+
+// sweepInterval возвращает период проверки StaleSweeper: четверть наименьшего TTL
+// в пределах [minSweepInterval, maxSweepInterval].
+func (p *StalePolicy) sweepInterval() time.Duration {
+	interval := maxSweepInterval
+	for _, rule := range p.rules {
+		if rule.ttl > 0 {
+			interval = min(interval, rule.ttl/4)
+		}
+	}
+	return max(interval, minSweepInterval)
+}
+
+// StaleReader дополняет MetricReader признаком устаревания: у серий, которые не обновлялись
+// дольше TTL политики, при чтении выставляется Metrics.Stale.
+type StaleReader struct {
+	MetricReader
+	policy *StalePolicy
+	now    func() time.Time
+}
+
+// NewStaleReader создает StaleReader поверх reader.
+func NewStaleReader(reader MetricReader, policy *StalePolicy) *StaleReader {
+	return &StaleReader{MetricReader: reader, policy: policy, now: time.Now}
+}
+
+func (r *StaleReader) mark(metrics []models.Metrics) []models.Metrics {
+	now := r.now()
+	for i := range metrics {
+		metrics[i].Stale = r.policy.IsStale(metrics[i], now)
+	}
+	return metrics
+}
+
+// GetAllMetrics возвращает все метрики с признаком устаревания.
+func (r *StaleReader) GetAllMetrics() []models.Metrics {
+	return r.mark(r.MetricReader.GetAllMetrics())
+}
+
+// GetMetricByName возвращает метрику по имени и типу с признаком устаревания.
+func (r *StaleReader) GetMetricByName(name string, mType string) (models.Metrics, error) {
+	m, err := r.MetricReader.GetMetricByName(name, mType)
+	if err != nil {
+		return m, err
+	}
+	m.Stale = r.policy.IsStale(m, r.now())
+	return m, nil
+}
+
+// GetMetricsByKeys возвращает метрики с заданными ключами с признаком устаревания.
+func (r *StaleReader) GetMetricsByKeys(keys []models.MetricKey) ([]models.Metrics, error) {
+	metrics, err := r.MetricReader.GetMetricsByKeys(keys)
+	if err != nil {
+		return nil, err
+	}
+	return r.mark(metrics), nil
+}
+
+// QueryMetrics выполняет запрос q к исходному хранилищу и помечает устаревшие серии.
+func (r *StaleReader) QueryMetrics(ctx context.Context, q MetricQuery) (MetricPage, error) {
+	page, err := QueryReader(ctx, r.MetricReader, q)
+	if err != nil {
+		return MetricPage{}, err
+	}
+	page.Metrics = r.mark(page.Metrics)
+	return page, nil
+}
+
+// StaleSweeper периодически удаляет из хранилища устаревшие серии (действие StaleRemove).
+type StaleSweeper struct {
+	reader   MetricReader
+	remover  MetricRemover
+	policy   *StalePolicy
+	interval time.Duration
+}
+
+// NewStaleSweeper создает StaleSweeper. Период проверки выбирается по наименьшему TTL политики.
+func NewStaleSweeper(reader MetricReader, remover MetricRemover, policy *StalePolicy) *StaleSweeper {
+	return &StaleSweeper{
+		reader:   reader,
+		remover:  remover,
+		policy:   policy,
+		interval: policy.sweepInterval(),
+	}
+}
+
+// Run выполняет проверки до отмены ctx.
+func (s *StaleSweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Sweep(time.Now())
+		}
+	}
+}
+
+// Sweep удаляет серии, устаревшие к моменту now, и возвращает количество удаленных серий.
+// Перед удалением серия перечитывается, чтобы не удалить серию, обновленную после выборки;
+// запись, пришедшая между повторным чтением и удалением, создаст серию заново.
+func (s *StaleSweeper) Sweep(now time.Time) int {
+	removed := 0
+	for _, m := range s.reader.GetAllMetrics() {
+		if !s.policy.IsStale(m, now) {
+			continue
+		}
+		current, err := s.reader.GetMetricByName(m.ID, m.MType)
+		if err != nil || !s.policy.IsStale(current, now) {
+			continue
+		}
+		err = s.remover.DeleteMetric(m.ID, m.MType)
+		if errors.Is(err, ErrPrimaryUnavailable) {
+			// Без основного хранилища удаление невозможно: повторим при следующей проверке.
+			logger.Log.Debug("Stale metrics are kept until primary storage is available")
+			break
+		}
+		if err != nil {
+			logger.Log.Info("can not remove stale metric",
+				zap.String("type", m.MType), zap.String("name", m.ID), zap.Error(err))
+			continue
+		}
+		removed++
+	}
+	if removed > 0 {
+		logger.Log.Info("Stale metrics removed", zap.Int("count", removed))
+	}
+	return removed
+}
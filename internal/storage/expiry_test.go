@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	model "github.com/Fuonder/metriccoll.git/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStalePolicy(t *testing.T) {
+	policy, err := ParseStalePolicy("", StaleRemove)
+	require.NoError(t, err)
+	require.Nil(t, policy, "empty spec disables expiry")
+
+	policy, err = ParseStalePolicy("gauge=10m, gauge:Free=2m, *:host1_=1h, counter:Poll=0s", "")
+	require.NoError(t, err)
+	require.Equal(t, StaleMark, policy.Action())
+	tests := []struct {
+		key  model.MetricKey
+		want time.Duration
+	}{
+		{model.MetricKey{ID: "Alloc", MType: "gauge"}, 10 * time.Minute},
+		{model.MetricKey{ID: "FreeMemory", MType: "gauge"}, 2 * time.Minute},
+		{model.MetricKey{ID: "host1_cpu", MType: "gauge"}, time.Hour},
+		{model.MetricKey{ID: "host1_cpu", MType: "counter"}, time.Hour},
+		{model.MetricKey{ID: "PollCount", MType: "counter"}, 0},
+		{model.MetricKey{ID: "Requests", MType: "counter"}, 0},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, policy.TTL(tt.key), tt.key.ID)
+	}
+
+	policy, err = ParseStalePolicy("*=1h,gauge=1m", StaleMark)
+	require.NoError(t, err)
+	require.Equal(t, time.Minute, policy.TTL(model.MetricKey{ID: "x", MType: "gauge"}), "type-specific rule wins")
+
+	for _, spec := range []string{"gauge", "histogram=1m", "gauge=abc", "gauge=-1m"} {
+		_, err = ParseStalePolicy(spec, StaleMark)
+		require.ErrorIs(t, err, ErrInvalidStalePolicy, spec)
+	}
+	_, err = ParseStalePolicy("gauge=1m", "drop")
+	require.ErrorIs(t, err, ErrInvalidStalePolicy)
+}
+
+func TestStaleReader(t *testing.T) {
+	st, err := NewJSONStorage(NewFileStoreInfo(filepath.Join(t.TempDir(), "metrics.json"), time.Hour, false))
+	require.NoError(t, err)
+	require.NoError(t, st.AppendMetrics([]model.Metrics{
+		{ID: "FreeMemory", MType: "gauge", Value: model.Float64Ptr(1)},
+		{ID: "PollCount", MType: "counter", Delta: model.Int64Ptr(1)},
+	}))
+	policy, err := ParseStalePolicy("gauge=1m", StaleMark)
+	require.NoError(t, err)
+	reader := NewStaleReader(st, policy)
+
+	for _, m := range reader.GetAllMetrics() {
+		require.NotNil(t, m.UpdatedAt)
+		require.False(t, m.Stale)
+	}
+
+	reader.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	all := reader.GetAllMetrics()
+	require.Len(t, all, 2)
+	require.True(t, all[0].Stale, "gauge without updates must be stale")
+	require.False(t, all[1].Stale, "counters have no ttl")
+	m, err := reader.GetMetricByName("FreeMemory", "gauge")
+	require.NoError(t, err)
+	require.True(t, m.Stale)
+	ms, err := reader.GetMetricsByKeys([]model.MetricKey{{ID: "FreeMemory", MType: "gauge"}})
+	require.NoError(t, err)
+	require.True(t, ms[0].Stale)
+
+	// Новое значение снимает признак устаревания.
+	require.NoError(t, st.AppendMetric(model.Metrics{ID: "FreeMemory", MType: "gauge", Value: model.Float64Ptr(2)}))
+	reader.now = time.Now
+	m, err = reader.GetMetricByName("FreeMemory", "gauge")
+	require.NoError(t, err)
+	require.False(t, m.Stale)
+}
+
+func TestStaleSweeper(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	st, err := NewJSONStorage(NewFileStoreInfo(path, 0, false))
+	require.NoError(t, err)
+	require.NoError(t, st.AppendMetrics([]model.Metrics{
+		{ID: "FreeMemory", MType: "gauge", Value: model.Float64Ptr(1)},
+		{ID: "Alloc", MType: "gauge", Value: model.Float64Ptr(1)},
+		{ID: "PollCount", MType: "counter", Delta: model.Int64Ptr(1)},
+	}))
+	policy, err := ParseStalePolicy("gauge=1m,gauge:Alloc=0s", StaleRemove)
+	require.NoError(t, err)
+	sweeper := NewStaleSweeper(st, st, policy)
+	require.Equal(t, 15*time.Second, sweeper.interval)
+
+	require.Zero(t, sweeper.Sweep(time.Now()))
+	require.Equal(t, 1, sweeper.Sweep(time.Now().Add(2*time.Minute)))
+	_, err = st.GetMetricByName("FreeMemory", "gauge")
+	require.Error(t, err)
+	require.Len(t, st.GetAllMetrics(), 2)
+	require.NoError(t, st.Close())
+
+	// Удаление устаревшей серии сохраняется в журнале.
+	restored, err := NewJSONStorage(NewFileStoreInfo(path, 300, true))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, restored.Close())
+	}()
+	require.Len(t, restored.GetAllMetrics(), 2)
+	for _, m := range restored.GetAllMetrics() {
+		require.NotNil(t, m.UpdatedAt, "update time must survive restart")
+	}
+}
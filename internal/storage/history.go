@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Fuonder/metriccoll.git/internal/models"
+)
+
+// HistoryPoint — значение серии на момент обновления.
+type HistoryPoint struct {
+	At    time.Time
+	Value float64
+}
+
+// History хранит в памяти последние значения каждой серии (кольцевой буфер на серию).
+// Заполняется периодическим опросом хранилища (см. History.Run), поэтому работает
+// с любым хранилищем и любым протоколом записи; история теряется при перезапуске сервера.
+type History struct {
+	mu     sync.RWMutex
+	size   int
+	series map[models.MetricKey][]HistoryPoint
+}
+
+// NewHistory создает History, хранящую до size значений каждой серии.
+func NewHistory(size int) *History {
+	return &History{
+		size:   size,
+		series: make(map[models.MetricKey][]HistoryPoint),
+	}
+}
+
+// metricValue возвращает значение метрики в виде числа.
+func metricValue(m models.Metrics) (float64, bool) {
+	switch {
+	case m.MType == "gauge" && m.Value != nil:
+		return *m.Value, true
+	case m.MType == "counter" && m.Delta != nil:
+		return float64(*m.Delta), true
+	}
+	return 0, false
+}
+
+// Record добавляет в историю текущие значения metrics. Время точки — время обновления серии
+// (UpdatedAt) или now, если оно неизвестно; серия без обновлений с прошлого опроса не
+// получает новой точки. История серий, отсутствующих в metrics (удаленных), забывается.
+func (h *History) Record(metrics []models.Metrics, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	seen := make(map[models.MetricKey]struct{}, len(metrics))
+	for _, m := range metrics {
+		value, ok := metricValue(m)
+		if !ok {
+			continue
+		}
+		key := m.Key()
+		seen[key] = struct{}{}
+		at := now
+		if m.UpdatedAt != nil {
+			at = *m.UpdatedAt
+		}
+		points := h.series[key]
+		if n := len(points); n > 0 && !at.After(points[n-1].At) {
+			continue
+		}
+		if len(points) == h.size {
+			points = append(points[:0], points[1:]...)
+		}
+		h.series[key] = append(points, HistoryPoint{At: at, Value: value})
+	}
+	for key := range h.series {
+		if _, ok := seen[key]; !ok {
+			delete(h.series, key)
+		}
+	}
+}
+
+// Points возвращает копию истории серии key от старых значений к новым.
+func (h *History) Points(key models.MetricKey) []HistoryPoint {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	points := h.series[key]
+	if len(points) == 0 {
+		return nil
+	}
+	return append([]HistoryPoint(nil), points...)
+}
+
+// Run опрашивает reader с периодом interval и записывает значения в историю до отмены ctx.
+func (h *History) Run(ctx context.Context, reader MetricReader, interval time.Duration) {
+	h.Record(reader.GetAllMetrics(), time.Now().UTC())
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.Record(reader.GetAllMetrics(), time.Now().UTC())
+		}
+	}
+}
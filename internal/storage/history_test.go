@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	model "github.com/Fuonder/metriccoll.git/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	gauge := model.MetricKey{ID: "Alloc", MType: "gauge"}
+	counter := model.MetricKey{ID: "PollCount", MType: "counter"}
+	history := NewHistory(3)
+	sample := func(step int, alloc float64, withCounter bool) {
+		at := start.Add(time.Duration(step) * time.Second)
+		metrics := []model.Metrics{{ID: "Alloc", MType: "gauge", Value: model.Float64Ptr(alloc), UpdatedAt: &at}}
+		if withCounter {
+			metrics = append(metrics, model.Metrics{ID: "PollCount", MType: "counter", Delta: model.Int64Ptr(int64(step))})
+		}
+		metrics = append(metrics, model.Metrics{ID: "broken", MType: "gauge"})
+		history.Record(metrics, at)
+	}
+
+	require.Nil(t, history.Points(gauge))
+	sample(0, 1, true)
+	sample(0, 1, true) // без обновления серии точка не добавляется
+	sample(1, 2, true)
+	sample(2, 3, true)
+	sample(3, 4, true)
+
+	points := history.Points(gauge)
+	require.Equal(t, []HistoryPoint{
+		{At: start.Add(time.Second), Value: 2},
+		{At: start.Add(2 * time.Second), Value: 3},
+		{At: start.Add(3 * time.Second), Value: 4},
+	}, points, "only the last size points are kept")
+	points[0].Value = 100
+	require.Equal(t, 2.0, history.Points(gauge)[0].Value, "Points returns a copy")
+	require.Len(t, history.Points(counter), 3, "series without UpdatedAt use the sample time")
+	require.Nil(t, history.Points(model.MetricKey{ID: "broken", MType: "gauge"}))
+
+	sample(4, 5, false)
+	require.Nil(t, history.Points(counter), "history of removed series is forgotten")
+	require.Len(t, history.Points(gauge), 3)
+}
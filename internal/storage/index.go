@@ -0,0 +1,322 @@
+package storage
+
+import (
+	"hash/maphash"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Fuonder/metriccoll.git/internal/models"
+)
+
+// indexShards — количество сегментов индекса. Запись блокирует только сегменты
+// затронутых серий, поэтому пакеты с разными сериями применяются параллельно.
+const indexShards = 64
+
+// indexEntry — серия в индексе. seq задает порядок добавления, в котором
+// метрики возвращаются из all и сохраняются в снимок.
+type indexEntry struct {
+	metric models.Metrics
+	seq    uint64
+}
+
+type indexShard struct {
+	mu    sync.RWMutex
+	items map[models.MetricKey]*indexEntry
+}
+
+// metricIndex — сегментированный индекс метрик по ключу (тип, имя).
+// Индекс владеет значениями серий: при записи значения копируются из входных метрик,
+// а при чтении возвращаются копии, поэтому вызывающий код не может изменить
+// хранилище через полученные указатели и не конкурирует с писателями.
+type metricIndex struct {
+	seed   maphash.Seed
+	seq    atomic.Uint64
+	shards [indexShards]indexShard
+}
+
+func newMetricIndex() *metricIndex {
+	idx := &metricIndex{seed: maphash.MakeSeed()}
+	for i := range idx.shards {
+		idx.shards[i].items = make(map[models.MetricKey]*indexEntry)
+	}
+	return idx
+}
+
+// copyMetric возвращает копию метрики с собственными указателями на значения.
+// Признак Stale не копируется: его вычисляет StaleReader при чтении.
+func copyMetric(m models.Metrics) models.Metrics {
+	item := models.Metrics{ID: m.ID, MType: m.MType}
+	if m.Value != nil {
+		item.Value = models.Float64Ptr(*m.Value)
+	}
+	if m.Delta != nil {
+		item.Delta = models.Int64Ptr(*m.Delta)
+	}
+	if m.UpdatedAt != nil {
+		updatedAt := *m.UpdatedAt
+		item.UpdatedAt = &updatedAt
+	}
+	return item
+}
+
+func (idx *metricIndex) shardOf(key models.MetricKey) int {
+	var h maphash.Hash
+	h.SetSeed(idx.seed)
+	_, _ = h.WriteString(key.MType)
+	_ = h.WriteByte(0)
+	_, _ = h.WriteString(key.ID)
+	return int(h.Sum64() % indexShards)
+}
+
+// lockKeys блокирует на запись сегменты ключей keys и возвращает функцию снятия блокировок.
+// Сегменты блокируются в порядке возрастания номера, чтобы параллельные пакеты не взаимоблокировались.
+func (idx *metricIndex) lockKeys(keys []models.MetricKey) func() {
+	shardIDs := make([]int, 0, len(keys))
+	locked := make(map[int]struct{}, len(keys))
+	for _, key := range keys {
+		id := idx.shardOf(key)
+		if _, ok := locked[id]; !ok {
+			locked[id] = struct{}{}
+			shardIDs = append(shardIDs, id)
+		}
+	}
+	sort.Ints(shardIDs)
+	for _, id := range shardIDs {
+		idx.shards[id].mu.Lock()
+	}
+	return func() {
+		for _, id := range shardIDs {
+			idx.shards[id].mu.Unlock()
+		}
+	}
+}
+
+// get возвращает копию метрики с ключом key.
+func (idx *metricIndex) get(key models.MetricKey) (models.Metrics, bool) {
+	shard := &idx.shards[idx.shardOf(key)]
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	entry, ok := shard.items[key]
+	if !ok {
+		return models.Metrics{}, false
+	}
+	return copyMetric(entry.metric), true
+}
+
+// update применяет пакет проверенных метрик, удерживая блокировки всех затронутых сегментов,
+// поэтому другие читатели видят пакет либо целиком, либо не видят вовсе. Итоговые значения
+// затронутых серий сначала вычисляются во временной записи и передаются в commit, и только
+// после успешного commit применяются к индексу (под теми же блокировками, что сохраняет
+// порядок записей журнала для каждой серии). Если commit вернул ошибку, индекс не изменяется.
+func (idx *metricIndex) update(metrics []models.Metrics, commit func(record []models.Metrics) error) error {
+	keys := make([]models.MetricKey, 0, len(metrics))
+	for _, m := range metrics {
+		keys = append(keys, m.Key())
+	}
+	unlock := idx.lockKeys(keys)
+	defer unlock()
+
+	now := time.Now().UTC()
+	record := make([]models.Metrics, 0, len(metrics))
+	pos := make(map[models.MetricKey]int, len(metrics))
+	for _, m := range metrics {
+		key := m.Key()
+		i, ok := pos[key]
+		if !ok {
+			i = len(record)
+			pos[key] = i
+			entry, exists := idx.shards[idx.shardOf(key)].items[key]
+			if !exists {
+				item := copyMetric(m)
+				item.UpdatedAt = &now
+				record = append(record, item)
+				continue
+			}
+			record = append(record, copyMetric(entry.metric))
+		}
+		item := &record[i]
+		if m.MType == "gauge" {
+			*item.Value = *m.Value
+		} else {
+			*item.Delta += *m.Delta
+		}
+		item.UpdatedAt = &now
+	}
+	if commit != nil {
+		if err := commit(record); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range record {
+		key := m.Key()
+		shard := &idx.shards[idx.shardOf(key)]
+		if entry, ok := shard.items[key]; ok {
+			entry.metric = copyMetric(m)
+			continue
+		}
+		shard.items[key] = &indexEntry{metric: copyMetric(m), seq: idx.seq.Add(1)}
+	}
+	return nil
+}
+
+// put устанавливает значения серий пакета metrics целиком (для counter — без суммирования)
+// под блокировками затронутых сегментов. Как и в update, итоговые значения сначала передаются
+// в commit и применяются к индексу только после его успешного завершения.
+func (idx *metricIndex) put(metrics []models.Metrics, commit func(record []models.Metrics) error) error {
+	keys := make([]models.MetricKey, 0, len(metrics))
+	for _, m := range metrics {
+		keys = append(keys, m.Key())
+	}
+	unlock := idx.lockKeys(keys)
+	defer unlock()
+
+	now := time.Now().UTC()
+	record := make([]models.Metrics, 0, len(metrics))
+	for _, m := range metrics {
+		item := copyMetric(m)
+		item.UpdatedAt = &now
+		record = append(record, item)
+	}
+	if commit != nil {
+		if err := commit(record); err != nil {
+			return err
+		}
+	}
+	for _, m := range record {
+		key := m.Key()
+		shard := &idx.shards[idx.shardOf(key)]
+		if entry, ok := shard.items[key]; ok {
+			entry.metric = copyMetric(m)
+			continue
+		}
+		shard.items[key] = &indexEntry{metric: copyMetric(m), seq: idx.seq.Add(1)}
+	}
+	return nil
+}
+
+// remove удаляет серии с ключами keys под блокировками их сегментов. Ключи существующих серий
+// сначала передаются в commit и удаляются из индекса только после его успешного завершения.
+func (idx *metricIndex) remove(keys []models.MetricKey, commit func(removed []models.MetricKey) error) ([]models.MetricKey, error) {
+	unlock := idx.lockKeys(keys)
+	defer unlock()
+
+	removed := make([]models.MetricKey, 0, len(keys))
+	for _, key := range keys {
+		if _, ok := idx.shards[idx.shardOf(key)].items[key]; ok {
+			removed = append(removed, key)
+		}
+	}
+	if commit != nil && len(removed) > 0 {
+		if err := commit(removed); err != nil {
+			return nil, err
+		}
+	}
+	for _, key := range removed {
+		delete(idx.shards[idx.shardOf(key)].items, key)
+	}
+	return removed, nil
+}
+
+// resetCounter обнуляет counter с ключом key. Новое значение сначала передается в commit
+// и применяется к индексу только после его успешного завершения.
+// Возвращает false, если серия не найдена.
+func (idx *metricIndex) resetCounter(key models.MetricKey, commit func(record []models.Metrics) error) (bool, error) {
+	shard := &idx.shards[idx.shardOf(key)]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	entry, ok := shard.items[key]
+	if !ok {
+		return false, nil
+	}
+	item := copyMetric(entry.metric)
+	*item.Delta = 0
+	now := time.Now().UTC()
+	item.UpdatedAt = &now
+	if commit != nil {
+		if err := commit([]models.Metrics{copyMetric(item)}); err != nil {
+			return true, err
+		}
+	}
+	entry.metric = item
+	return true, nil
+}
+
+// keys возвращает ключи всех серий, подходящих под фильтр match.
+func (idx *metricIndex) keys(match func(models.MetricKey) bool) []models.MetricKey {
+	var result []models.MetricKey
+	for i := range idx.shards {
+		shard := &idx.shards[i]
+		shard.mu.RLock()
+		for key := range shard.items {
+			if match(key) {
+				result = append(result, key)
+			}
+		}
+		shard.mu.RUnlock()
+	}
+	return result
+}
+
+// set устанавливает значение серии целиком (используется при восстановлении из журнала).
+func (idx *metricIndex) set(m models.Metrics) {
+	key := m.Key()
+	shard := &idx.shards[idx.shardOf(key)]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if entry, ok := shard.items[key]; ok {
+		entry.metric = copyMetric(m)
+		return
+	}
+	shard.items[key] = &indexEntry{metric: copyMetric(m), seq: idx.seq.Add(1)}
+}
+
+// all возвращает согласованный снимок всех метрик в порядке добавления.
+// На время копирования блокируются все сегменты.
+func (idx *metricIndex) all() []models.Metrics {
+	for i := range idx.shards {
+		idx.shards[i].mu.RLock()
+	}
+	entries := make([]indexEntry, 0)
+	for i := range idx.shards {
+		for _, entry := range idx.shards[i].items {
+			entries = append(entries, indexEntry{metric: copyMetric(entry.metric), seq: entry.seq})
+		}
+	}
+	for i := range idx.shards {
+		idx.shards[i].mu.RUnlock()
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].seq < entries[j].seq })
+	result := make([]models.Metrics, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, entry.metric)
+	}
+	return result
+}
+
+// replace заменяет содержимое индекса копией metrics.
+func (idx *metricIndex) replace(metrics []models.Metrics) {
+	for i := range idx.shards {
+		idx.shards[i].mu.Lock()
+	}
+	defer func() {
+		for i := range idx.shards {
+			idx.shards[i].mu.Unlock()
+		}
+	}()
+	for i := range idx.shards {
+		idx.shards[i].items = make(map[models.MetricKey]*indexEntry)
+	}
+	for _, m := range metrics {
+		key := m.Key()
+		shard := &idx.shards[idx.shardOf(key)]
+		if entry, ok := shard.items[key]; ok {
+			entry.metric = copyMetric(m)
+			continue
+		}
+		shard.items[key] = &indexEntry{metric: copyMetric(m), seq: idx.seq.Add(1)}
+	}
+}
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	model "github.com/Fuonder/metriccoll.git/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONStorageCopyOnRead(t *testing.T) {
+	st, err := NewJSONStorage(NewFileStoreInfo(filepath.Join(t.TempDir(), "metrics.json"), 300, false))
+	require.NoError(t, err)
+	value := 1.0
+	require.NoError(t, st.AppendMetric(model.Metrics{ID: "g", MType: "gauge", Value: &value}))
+	value = 2
+	m, err := st.GetMetricByName("g", "gauge")
+	require.NoError(t, err)
+	require.Equal(t, 1.0, *m.Value, "storage must not alias caller values")
+
+	*m.Value = 3
+	*st.GetAllMetrics()[0].Value = 4
+	m, err = st.GetMetricByName("g", "gauge")
+	require.NoError(t, err)
+	require.Equal(t, 1.0, *m.Value, "readers must get copies")
+}
+
+func TestJSONStorageConcurrentAccess(t *testing.T) {
+	st, err := NewJSONStorage(NewFileStoreInfo(filepath.Join(t.TempDir(), "metrics.json"), 0, false))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, st.Close())
+	}()
+
+	const (
+		writers = 8
+		batches = 20
+		series  = 200
+	)
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for b := 0; b < batches; b++ {
+				batch := make([]model.Metrics, 0, series)
+				for i := 0; i < series; i++ {
+					batch = append(batch,
+						model.Metrics{ID: fmt.Sprintf("c%d", i), MType: "counter", Delta: model.Int64Ptr(1)},
+						model.Metrics{ID: fmt.Sprintf("g%d", i), MType: "gauge", Value: model.Float64Ptr(float64(w))},
+					)
+				}
+				if !assert.NoError(t, st.AppendMetrics(batch)) {
+					return
+				}
+			}
+		}(w)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := 0; b < batches; b++ {
+				all := st.GetAllMetrics()
+				if !assert.LessOrEqual(t, len(all), 2*series) {
+					return
+				}
+				if _, err := st.GetMetricByName("c0", "counter"); err == nil {
+					_, err = st.GetMetricsByKeys([]model.MetricKey{{ID: "c0", MType: "counter"}})
+					if !assert.NoError(t, err) {
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for b := 0; b < 5; b++ {
+			if !assert.NoError(t, st.DumpMetrics()) {
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	all := st.GetAllMetrics()
+	require.Len(t, all, 2*series)
+	for _, m := range all {
+		if m.MType == "counter" {
+			require.Equal(t, int64(writers*batches), *m.Delta, m.ID)
+		}
+	}
+
+	restored, err := NewJSONStorage(NewFileStoreInfo(st.fileInfo.fPath, 300, true))
+	require.NoError(t, err)
+	require.ElementsMatch(t, all, restored.GetAllMetrics())
+}
+
+func TestMetricIndexCommitFailureKeepsState(t *testing.T) {
+	idx := newMetricIndex()
+	require.NoError(t, idx.update([]model.Metrics{
+		{ID: "c", MType: "counter", Delta: model.Int64Ptr(5)},
+		{ID: "g", MType: "gauge", Value: model.Float64Ptr(1)},
+	}, nil))
+	before := idx.all()
+
+	errWAL := errors.New("wal write failed")
+	failCommit := func([]model.Metrics) error { return errWAL }
+
+	var record []model.Metrics
+	err := idx.update([]model.Metrics{
+		{ID: "c", MType: "counter", Delta: model.Int64Ptr(2)},
+		{ID: "c", MType: "counter", Delta: model.Int64Ptr(3)},
+		{ID: "g", MType: "gauge", Value: model.Float64Ptr(7)},
+		{ID: "n", MType: "gauge", Value: model.Float64Ptr(8)},
+	}, func(r []model.Metrics) error {
+		record = r
+		return errWAL
+	})
+	require.ErrorIs(t, err, errWAL)
+	require.Len(t, record, 3, "record must hold final values of touched series")
+	require.Equal(t, int64(10), *record[0].Delta)
+	require.Equal(t, 7.0, *record[1].Value)
+	require.Equal(t, before, idx.all(), "index must not change when commit fails")
+
+	found, err := idx.resetCounter(model.MetricKey{ID: "c", MType: "counter"}, failCommit)
+	require.True(t, found)
+	require.ErrorIs(t, err, errWAL)
+	require.Equal(t, before, idx.all())
+
+	_, err = idx.remove([]model.MetricKey{{ID: "g", MType: "gauge"}}, func([]model.MetricKey) error { return errWAL })
+	require.ErrorIs(t, err, errWAL)
+	require.Equal(t, before, idx.all())
+}
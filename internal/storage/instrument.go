@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/Fuonder/metriccoll.git/internal/selfmetrics"
+)
+
+// Имена хранилищ для метрик длительности операций.
+const (
+	BackendJSON     = "json"
+	BackendPostgres = "postgres"
+	BackendSQLite   = "sqlite"
+)
+
+// Операции хранилищ для метрик длительности операций.
+const (
+	OpAppend = "append"  // AppendMetric, AppendMetrics.
+	OpGet    = "get"     // GetMetricByName, GetMetricsByKeys.
+	OpGetAll = "get_all" // GetAllMetrics.
+	OpQuery  = "query"   // QueryMetrics.
+	OpDelete = "delete"  // DeleteMetric, DeleteMetrics.
+	OpReset  = "reset"   // ResetCounter.
+	OpDump   = "dump"    // Сохранение снимка JSONStorage.DumpMetrics.
+)
+
+// OpObserver получает длительность d операции op хранилища backend и ее результат.
+type OpObserver func(backend string, op string, d time.Duration, err error)
+
+// OpObservable — хранилище, сообщающее длительности своих операций получателю OpObserver.
+type OpObservable interface {
+	// SetOpObserver задает получателя длительностей операций хранилища (nil — выключить).
+	SetOpObserver(fn OpObserver)
+}
+
+// setOpObserver задает получателя длительностей st, если хранилище их сообщает.
+func setOpObserver(st any, fn OpObserver) {
+	if observable, ok := st.(OpObservable); ok {
+		observable.SetOpObserver(fn)
+	}
+}
+
+// OpRecorder хранит получателя длительностей операций одного хранилища. Нулевое значение
+// готово к работе и ничего не сообщает; получателя можно сменить во время работы.
+type OpRecorder struct {
+	fn atomic.Pointer[OpObserver]
+}
+
+// Set задает получателя длительностей (nil — выключить).
+func (r *OpRecorder) Set(fn OpObserver) {
+	if fn == nil {
+		r.fn.Store(nil)
+		return
+	}
+	r.fn.Store(&fn)
+}
+
+// Observe сообщает получателю длительность операции, начатой в start. Вызывается
+// отложенно в начале операции: defer st.ops.Observe(backend, op, time.Now(), &err),
+// поэтому err — указатель на именованный результат (nil для операций без ошибки).
+func (r *OpRecorder) Observe(backend string, op string, start time.Time, err *error) {
+	fn := r.fn.Load()
+	if fn == nil {
+		return
+	}
+	var opErr error
+	if err != nil {
+		opErr = *err
+	}
+	(*fn)(backend, op, time.Since(start), opErr)
+}
+
+// InstrumentOps регистрирует в reg гистограмму длительности операций хранилищ
+// storage_operation_duration_seconds{backend, op, result} и возвращает получателя,
+// который ее заполняет; его нужно передать хранилищу через SetOpObserver.
+// Отсутствие метрики (ErrMetricNotFound) считается успешным результатом.
+func InstrumentOps(reg *selfmetrics.Registry) OpObserver {
+	latency := reg.NewHistogram("storage_operation_duration_seconds",
+		"Duration of metric storage operations.",
+		selfmetrics.DefaultLatencyBuckets, "backend", "op", "result")
+	return func(backend string, op string, d time.Duration, err error) {
+		result := "ok"
+		if err != nil && !errors.Is(err, ErrMetricNotFound) {
+			result = "error"
+		}
+		latency.Observe(d.Seconds(), backend, op, result)
+	}
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Fuonder/metriccoll.git/internal/models"
+	"github.com/Fuonder/metriccoll.git/internal/selfmetrics"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstrumentOps(t *testing.T) {
+	reg := selfmetrics.NewRegistry("test")
+	var ops OpRecorder
+	ops.Set(InstrumentOps(reg))
+
+	ok := func() (err error) {
+		defer ops.Observe(BackendJSON, OpGet, time.Now(), &err)
+		return nil
+	}
+	notFound := func() (err error) {
+		defer ops.Observe(BackendJSON, OpGet, time.Now(), &err)
+		return ErrMetricNotFound
+	}
+	failed := func() (err error) {
+		defer ops.Observe(BackendJSON, OpAppend, time.Now(), &err)
+		return errors.New("disk full")
+	}
+	require.NoError(t, ok())
+	require.Error(t, notFound())
+	require.Error(t, failed())
+
+	var sb strings.Builder
+	require.NoError(t, reg.WriteText(&sb))
+	text := sb.String()
+	require.Contains(t, text, `test_storage_operation_duration_seconds_count{backend="json",op="get",result="ok"} 2`)
+	require.Contains(t, text, `test_storage_operation_duration_seconds_count{backend="json",op="append",result="error"} 1`)
+
+	ops.Set(nil)
+	require.NotPanics(t, func() { ops.Observe(BackendJSON, OpGet, time.Now(), nil) })
+}
+
+// opCounter считает сообщенные операции по хранилищу и имени операции.
+type opCounter map[string]int
+
+func (c opCounter) observe(backend string, op string, _ time.Duration, _ error) {
+	c[backend+"/"+op]++
+}
+
+func TestOpObserverIsPerStorage(t *testing.T) {
+	observed := opCounter{}
+	first, err := NewJSONStorage(NewFileStoreInfo(filepath.Join(t.TempDir(), "first.json"), time.Hour, false))
+	require.NoError(t, err)
+	second, err := NewJSONStorage(NewFileStoreInfo(filepath.Join(t.TempDir(), "second.json"), time.Hour, false))
+	require.NoError(t, err)
+	first.SetOpObserver(observed.observe)
+
+	metric := models.Metrics{ID: "PollCount", MType: "counter", Delta: models.Int64Ptr(1)}
+	require.NoError(t, first.AppendMetric(metric))
+	require.NoError(t, second.AppendMetric(metric))
+	require.Equal(t, opCounter{"json/append": 1}, observed)
+}
+
+func TestCompositeStorageReportsOpsOnce(t *testing.T) {
+	db, err := NewJSONStorage(NewFileStoreInfo(filepath.Join(t.TempDir(), "db.json"), time.Hour, false))
+	require.NoError(t, err)
+	cs, err := NewCompositeStorage(nil, NewFileStoreInfo(filepath.Join(t.TempDir(), "snapshot.json"), time.Hour, false))
+	require.NoError(t, err)
+	observed := opCounter{}
+	cs.SetOpObserver(observed.observe)
+
+	metric := models.Metrics{ID: "PollCount", MType: "counter", Delta: models.Int64Ptr(1)}
+	require.NoError(t, cs.AppendMetric(metric))
+	require.Equal(t, opCounter{"json/append": 1}, observed, "degraded write goes to the mirror only")
+
+	// Основное хранилище, подключенное после SetOpObserver, получает того же получателя.
+	require.NoError(t, cs.SetPrimary(fakePrimary{db}))
+	clear(observed)
+	require.NoError(t, cs.AppendMetric(metric))
+	require.NoError(t, cs.ResetCounter("PollCount"))
+	require.Equal(t, opCounter{"json/append": 1, "json/reset": 1}, observed,
+		"mirror updates that follow the primary must not be reported")
+}
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -34,16 +35,28 @@ func NewFileStoreInfo(fPath string, interval time.Duration, fLoadFromFile bool)
 	}
 }
 
+// JSONStorage хранит метрики в памяти и сохраняет их в файл fPath в формате JSON.
+// Метрики лежат в сегментированном индексе по ключу (тип, имя): поиск и запись серии
+// не зависят от общего числа метрик, а чтение возвращает копии значений.
+// Снимок всегда записывается атомарно (временный файл, fsync, переименование).
+// В синхронном режиме каждая запись дополнительно дописывается в журнал fPath+".wal",
+// который сворачивается в новый снимок после walCompactThreshold записей, поэтому
+// стоимость записи не зависит от общего числа метрик.
 type JSONStorage struct {
-	metrics  []models.Metrics
+	index    *metricIndex
 	fileInfo *FileStoreInfo
-	mu       sync.RWMutex
-	fileMu   sync.RWMutex
+	wal      *walLog // Журнал упреждающей записи, nil вне синхронного режима.
+	walMu    sync.Mutex
+	// mu разделяет запись и сохранение снимка: пакеты записываются параллельно под RLock,
+	// а сохранение снимка с очисткой журнала и замена содержимого выполняются под Lock.
+	mu     sync.RWMutex
+	fileMu sync.RWMutex
+	ops    OpRecorder
 }
 
 func NewJSONStorage(fileStoreInfo *FileStoreInfo) (*JSONStorage, error) {
 
-	st := JSONStorage{metrics: make([]models.Metrics, 0), fileInfo: fileStoreInfo}
+	st := JSONStorage{index: newMetricIndex(), fileInfo: fileStoreInfo}
 
 	if st.fileInfo.fLoadFromFile {
 		err := st.loadMetricsFromFile()
@@ -51,28 +64,128 @@ func NewJSONStorage(fileStoreInfo *FileStoreInfo) (*JSONStorage, error) {
 			return nil, err
 		}
 	}
+	if st.fileInfo.Sync {
+		// Без восстановления журнал прошлого запуска не относится к текущим данным.
+		wal, err := openWAL(st.walPath(), !st.fileInfo.fLoadFromFile)
+		if err != nil {
+			return nil, err
+		}
+		st.wal = wal
+	}
 	return &st, nil
 }
 
+// SetOpObserver задает получателя длительностей операций хранилища (nil — выключить).
+func (st *JSONStorage) SetOpObserver(fn OpObserver) {
+	st.ops.Set(fn)
+}
+
 func (st *JSONStorage) IsSyncFileMode() bool {
 	return st.fileInfo.Sync
 }
 
-func (st *JSONStorage) DumpMetrics() error {
+func (st *JSONStorage) walPath() string {
+	return st.fileInfo.fPath + walSuffix
+}
+
+// DumpMetrics сохраняет снимок всех метрик и очищает журнал.
+func (st *JSONStorage) DumpMetrics() (err error) {
+	defer st.ops.Observe(BackendJSON, OpDump, time.Now(), &err)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.dumpMetrics()
+}
+
+// dumpMetrics сохраняет снимок и очищает журнал. Вызывается под st.mu.Lock, поэтому
+// журнал не может пополниться записями, которых нет в снимке.
+func (st *JSONStorage) dumpMetrics() error {
 	st.fileMu.Lock()
 	defer st.fileMu.Unlock()
-	data, err := json.MarshalIndent(st.metrics, "", "    ")
+	data, err := json.MarshalIndent(st.index.all(), "", "    ")
 	if err != nil {
 		return err
 	}
-	err = os.WriteFile(st.fileInfo.fPath, data, OsAllRw)
-	if err != nil {
+	if err := writeFileAtomic(st.fileInfo.fPath, data, OsAllRw); err != nil {
 		return err
 	}
+	if st.wal != nil {
+		return st.wal.reset()
+	}
 	return nil
 }
 
+// Close закрывает журнал упреждающей записи.
+func (st *JSONStorage) Close() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.wal == nil {
+		return nil
+	}
+	err := st.wal.close()
+	st.wal = nil
+	return err
+}
+
+// loadMetricsFromFile загружает снимок и применяет к нему журнал. Если журнал содержал
+// записи, восстановленное состояние сразу сохраняется новым снимком, а журнал удаляется.
 func (st *JSONStorage) loadMetricsFromFile() error {
+	replayed, err := st.recoverState()
+	if err != nil {
+		return err
+	}
+	if replayed == 0 {
+		return nil
+	}
+	logger.Log.Info("Recovered metrics from wal",
+		zap.String("file", st.walPath()), zap.Int("records", replayed))
+	data, err := json.MarshalIndent(st.index.all(), "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(st.fileInfo.fPath, data, OsAllRw); err != nil {
+		return err
+	}
+	if err := os.Remove(st.walPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// recoverState загружает в индекс снимок и применяет к нему журнал, не изменяя файлы.
+// Возвращает количество примененных записей журнала.
+func (st *JSONStorage) recoverState() (int, error) {
+	if err := st.loadSnapshot(); err != nil {
+		return 0, err
+	}
+	return replayWAL(st.walPath(), func(items []models.Metrics) error {
+		for _, item := range items {
+			if isTombstone(item) {
+				_, _ = st.index.remove([]models.MetricKey{item.Key()}, nil)
+				continue
+			}
+			st.index.set(item)
+		}
+		return nil
+	})
+}
+
+// ReadJSONFile читает метрики файлового хранилища path (снимок и журнал) без изменения файлов,
+// поэтому файл можно читать, пока с ним работает сервер. Ошибкой считается отсутствие
+// и снимка, и журнала (до первого сохранения снимка данные есть только в журнале).
+func ReadJSONFile(path string) ([]models.Metrics, error) {
+	if _, err := os.Stat(path); err != nil {
+		if _, walErr := os.Stat(path + walSuffix); walErr != nil {
+			return nil, fmt.Errorf("can not read metrics file: %w", err)
+		}
+	}
+	st := JSONStorage{index: newMetricIndex(), fileInfo: NewFileStoreInfo(path, 0, true)}
+	if _, err := st.recoverState(); err != nil {
+		return nil, err
+	}
+	return st.index.all(), nil
+}
+
+func (st *JSONStorage) loadSnapshot() error {
 	statTest, err := os.Stat(st.fileInfo.fPath)
 	if os.IsNotExist(err) {
 		logger.Log.Info("can not find metrcis file",
@@ -105,92 +218,217 @@ func (st *JSONStorage) loadMetricsFromFile() error {
 	if err != nil {
 		return fmt.Errorf("not valid json data in file: %w", err)
 	}
-	st.metrics = items
+	// Снимки прежних версий не содержат времени обновления: такие серии считаются
+	// обновленными в момент восстановления, чтобы TTL отсчитывался от перезапуска.
+	now := time.Now().UTC()
+	for i, item := range items {
+		if err := CheckMetric(item); err != nil {
+			return fmt.Errorf("invalid metric %q in file: %w", item.ID, err)
+		}
+		if item.UpdatedAt == nil {
+			items[i].UpdatedAt = &now
+		}
+	}
+	st.index.replace(items)
 	return nil
 }
 
+// logWrite дописывает в журнал итоговые значения серий, измененных пакетом.
+// Вызывается индексом под блокировками затронутых сегментов. Возвращает true,
+// если журнал пора свернуть в снимок.
+func (st *JSONStorage) logWrite(record []models.Metrics) (bool, error) {
+	st.walMu.Lock()
+	defer st.walMu.Unlock()
+	if err := st.wal.append(record); err != nil {
+		return false, err
+	}
+	return st.wal.entries >= walCompactThreshold, nil
+}
+
 func (st *JSONStorage) AppendMetric(metric models.Metrics) error {
+	return st.AppendMetrics([]models.Metrics{metric})
+}
+
+func (st *JSONStorage) GetMetricByName(name string, mType string) (models.Metrics, error) {
+	defer st.ops.Observe(BackendJSON, OpGet, time.Now(), nil)
+	m, ok := st.index.get(models.MetricKey{ID: name, MType: mType})
+	if !ok {
+		return models.Metrics{}, fmt.Errorf("%v: %s", ErrMetricNotFound, name)
+	}
+	return m, nil
+}
+
+// replaceMetrics заменяет содержимое хранилища копией metrics.
+func (st *JSONStorage) replaceMetrics(metrics []models.Metrics) {
 	st.mu.Lock()
 	defer st.mu.Unlock()
-	for i, existingItem := range st.metrics {
-		if existingItem.ID == metric.ID && existingItem.MType == metric.MType {
-			if metric.MType == "gauge" {
-				if metric.Value == nil {
-					return ErrInvalidMetricValue
-				}
-				*st.metrics[i].Value = *metric.Value
-				if st.fileInfo.Sync {
-					err := st.DumpMetrics()
-					if err != nil {
-						return err
-					}
-				}
-				return nil
-			} else if metric.MType == "counter" {
-				if metric.Delta == nil {
-					return ErrInvalidMetricValue
-				}
-				*st.metrics[i].Delta += *metric.Delta
-				if st.fileInfo.Sync {
-					err := st.DumpMetrics()
-					if err != nil {
-						return err
-					}
-				}
-				return nil
-			} else {
-				return fmt.Errorf("metric type: %s is not supported", metric.MType)
-			}
+	st.index.replace(metrics)
+}
+
+// ExportMetrics возвращает согласованный снимок всех метрик в порядке добавления.
+func (st *JSONStorage) ExportMetrics(_ context.Context) ([]models.Metrics, error) {
+	return st.index.all(), nil
+}
+
+// QueryMetrics выполняет запрос q над копией индекса.
+func (st *JSONStorage) QueryMetrics(_ context.Context, q MetricQuery) (MetricPage, error) {
+	defer st.ops.Observe(BackendJSON, OpQuery, time.Now(), nil)
+	return FilterMetrics(st.index.all(), q), nil
+}
+
+// GetAllMetrics возвращает копию всех метрик в порядке добавления.
+func (st *JSONStorage) GetAllMetrics() []models.Metrics {
+	defer st.ops.Observe(BackendJSON, OpGetAll, time.Now(), nil)
+	return st.index.all()
+}
+
+// AppendMetrics добавляет пакет метрик по принципу «все или ничего»: сначала проверяются
+// все метрики пакета, и при ошибке хранилище не изменяется. Пакет применяется под
+// блокировками затронутых сегментов индекса, поэтому читатели не видят его частично.
+// В синхронном режиме пакет записывается в журнал одной записью.
+func (st *JSONStorage) AppendMetrics(metrics []models.Metrics) (err error) {
+	defer st.ops.Observe(BackendJSON, OpAppend, time.Now(), &err)
+	return st.appendMetrics(metrics)
+}
+
+// appendMetrics выполняет AppendMetrics без учета длительности операции.
+func (st *JSONStorage) appendMetrics(metrics []models.Metrics) (err error) {
+	for _, metric := range metrics {
+		if err := CheckMetric(metric); err != nil {
+			return err
 		}
 	}
-	if metric.MType == "gauge" {
-		if metric.Value == nil {
-			return ErrInvalidMetricValue
-		}
-		st.metrics = append(st.metrics, metric)
-		if st.fileInfo.Sync {
-			err := st.DumpMetrics()
-			if err != nil {
-				return err
-			}
-		}
+	if len(metrics) == 0 {
 		return nil
-	} else if metric.MType == "counter" {
-		if metric.Delta == nil {
-			return ErrInvalidMetricValue
-		}
-		st.metrics = append(st.metrics, metric)
-		if st.fileInfo.Sync {
-			err := st.DumpMetrics()
-			if err != nil {
-				return err
-			}
+	}
+	st.mu.RLock()
+	commit, compact := st.walCommit()
+	err = st.index.update(metrics, commit)
+	st.mu.RUnlock()
+	return st.afterWrite(err, *compact)
+}
+
+// ReplaceMetrics записывает пакет метрик по принципу «все или ничего», заменяя значения
+// существующих серий. В синхронном режиме пакет записывается в журнал одной записью.
+func (st *JSONStorage) ReplaceMetrics(_ context.Context, metrics []models.Metrics) (err error) {
+	defer st.ops.Observe(BackendJSON, OpAppend, time.Now(), &err)
+	for _, metric := range metrics {
+		if err := CheckMetric(metric); err != nil {
+			return err
 		}
+	}
+	if len(metrics) == 0 {
 		return nil
-	} else {
-		return fmt.Errorf("metric type: %s is not supported", metric.MType)
 	}
+	st.mu.RLock()
+	commit, compact := st.walCommit()
+	err = st.index.put(metrics, commit)
+	st.mu.RUnlock()
+	return st.afterWrite(err, *compact)
 }
 
-func (st *JSONStorage) GetMetricByName(name string, mType string) (models.Metrics, error) {
-	for i, existingItem := range st.metrics {
-		if existingItem.ID == name && existingItem.MType == mType {
-			return st.metrics[i], nil
+// walCommit возвращает функцию записи в журнал для индекса (nil вне синхронного режима)
+// и флаг, который она устанавливает, когда журнал пора свернуть. Вызывается под st.mu.RLock.
+func (st *JSONStorage) walCommit() (func([]models.Metrics) error, *bool) {
+	compact := new(bool)
+	if st.wal == nil {
+		return nil, compact
+	}
+	return func(record []models.Metrics) error {
+		var err error
+		*compact, err = st.logWrite(record)
+		return err
+	}, compact
+}
+
+// afterWrite сворачивает журнал в снимок, если это нужно после успешной записи.
+// Вызывается после снятия st.mu.RLock.
+func (st *JSONStorage) afterWrite(err error, compact bool) error {
+	if err != nil {
+		return err
+	}
+	if compact {
+		return st.DumpMetrics()
+	}
+	return nil
+}
+
+// DeleteMetric удаляет метрику по имени и типу.
+func (st *JSONStorage) DeleteMetric(name string, mType string) (err error) {
+	defer st.ops.Observe(BackendJSON, OpDelete, time.Now(), &err)
+	key := models.MetricKey{ID: name, MType: mType}
+	removed, err := st.removeKeys([]models.MetricKey{key})
+	if err != nil {
+		return err
+	}
+	if len(removed) == 0 {
+		return fmt.Errorf("%w: %s", ErrMetricNotFound, name)
+	}
+	return nil
+}
+
+// DeleteMetrics удаляет метрики, подходящие под шаблон имени pattern и тип mType.
+func (st *JSONStorage) DeleteMetrics(pattern string, mType string) (_ []models.MetricKey, err error) {
+	defer st.ops.Observe(BackendJSON, OpDelete, time.Now(), &err)
+	if err := ValidatePattern(pattern, mType); err != nil {
+		return nil, err
+	}
+	keys := st.index.keys(func(key models.MetricKey) bool {
+		return MatchKey(key, pattern, mType)
+	})
+	return st.removeKeys(keys)
+}
+
+// removeKeys удаляет серии keys и записывает удаление в журнал.
+func (st *JSONStorage) removeKeys(keys []models.MetricKey) ([]models.MetricKey, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	st.mu.RLock()
+	commit, compact := st.walCommit()
+	var removeCommit func([]models.MetricKey) error
+	if commit != nil {
+		removeCommit = func(removed []models.MetricKey) error {
+			record := make([]models.Metrics, 0, len(removed))
+			for _, key := range removed {
+				record = append(record, tombstone(key))
+			}
+			return commit(record)
 		}
 	}
-	return models.Metrics{}, fmt.Errorf("%v: %s", ErrMetricNotFound, name)
+	removed, err := st.index.remove(keys, removeCommit)
+	st.mu.RUnlock()
+	return removed, st.afterWrite(err, *compact)
 }
 
-func (st *JSONStorage) GetAllMetrics() []models.Metrics {
-	return st.metrics
+// ResetCounter обнуляет метрику типа Counter.
+func (st *JSONStorage) ResetCounter(name string) (err error) {
+	defer st.ops.Observe(BackendJSON, OpReset, time.Now(), &err)
+	return st.resetCounter(name)
 }
 
-func (st *JSONStorage) AppendMetrics(metrics []models.Metrics) error {
-	for _, metric := range metrics {
-		err := st.AppendMetric(metric)
-		if err != nil {
-			return err
+// resetCounter выполняет ResetCounter без учета длительности операции.
+func (st *JSONStorage) resetCounter(name string) error {
+	st.mu.RLock()
+	commit, compact := st.walCommit()
+	found, err := st.index.resetCounter(models.MetricKey{ID: name, MType: "counter"}, commit)
+	st.mu.RUnlock()
+	if err == nil && !found {
+		return fmt.Errorf("%w: %s", ErrMetricNotFound, name)
+	}
+	return st.afterWrite(err, *compact)
+}
+
+// GetMetricsByKeys возвращает копии метрик с заданными ключами в том же порядке.
+func (st *JSONStorage) GetMetricsByKeys(keys []models.MetricKey) (_ []models.Metrics, err error) {
+	defer st.ops.Observe(BackendJSON, OpGet, time.Now(), &err)
+	result := make([]models.Metrics, 0, len(keys))
+	for _, key := range keys {
+		m, ok := st.index.get(key)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrMetricNotFound, key.ID)
 		}
+		result = append(result, m)
 	}
-	return nil
+	return result, nil
 }
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	model "github.com/Fuonder/metriccoll.git/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONStorageAppendMetricsSync(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	st, err := NewJSONStorage(NewFileStoreInfo(path, 0, false))
+	require.NoError(t, err)
+
+	err = st.AppendMetrics([]model.Metrics{
+		{ID: "c", MType: "counter", Delta: model.Int64Ptr(2)},
+		{ID: "g", MType: "gauge", Value: model.Float64Ptr(1.5)},
+		{ID: "c", MType: "counter", Delta: model.Int64Ptr(3)},
+	})
+	require.NoError(t, err)
+
+	restored, err := NewJSONStorage(NewFileStoreInfo(path, 0, true))
+	require.NoError(t, err)
+	require.Len(t, restored.GetAllMetrics(), 2)
+	require.Equal(t, int64(5), *restored.GetAllMetrics()[0].Delta)
+	require.NoError(t, restored.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var dumped []model.Metrics
+	require.NoError(t, json.Unmarshal(data, &dumped))
+	require.Len(t, dumped, 2, "recovered wal must be compacted into the snapshot")
+
+	err = st.AppendMetrics([]model.Metrics{
+		{ID: "c", MType: "counter", Delta: model.Int64Ptr(100)},
+		{ID: "g", MType: "gauge"},
+	})
+	require.ErrorIs(t, err, ErrInvalidMetricValue)
+	c, err := st.GetMetricByName("c", "counter")
+	require.NoError(t, err)
+	require.Equal(t, int64(5), *c.Delta, "invalid batch must not be applied partially")
+}
+
+func TestJSONStorageGetMetricsByKeys(t *testing.T) {
+	st, err := NewJSONStorage(NewFileStoreInfo(filepath.Join(t.TempDir(), "metrics.json"), 300, false))
+	require.NoError(t, err)
+	require.NoError(t, st.AppendMetrics([]model.Metrics{
+		{ID: "a", MType: "gauge", Value: model.Float64Ptr(1)},
+		{ID: "a", MType: "counter", Delta: model.Int64Ptr(7)},
+		{ID: "b", MType: "gauge", Value: model.Float64Ptr(2)},
+	}))
+
+	got, err := st.GetMetricsByKeys([]model.MetricKey{
+		{ID: "b", MType: "gauge"},
+		{ID: "a", MType: "counter"},
+		{ID: "b", MType: "gauge"},
+	})
+	require.NoError(t, err)
+	require.Len(t, got, 3)
+	require.Equal(t, 2.0, *got[0].Value)
+	require.Equal(t, int64(7), *got[1].Delta)
+	require.Equal(t, got[0], got[2])
+
+	_, err = st.GetMetricsByKeys([]model.MetricKey{{ID: "missing", MType: "gauge"}})
+	require.ErrorIs(t, err, ErrMetricNotFound)
+}
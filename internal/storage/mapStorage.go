@@ -74,6 +74,18 @@ func (ms *memStorage) GetMetricByName(name string, mType string) (models.Metrics
 	}
 }
 
+func (ms *memStorage) GetMetricsByKeys(keys []models.MetricKey) ([]models.Metrics, error) {
+	result := make([]models.Metrics, 0, len(keys))
+	for _, key := range keys {
+		m, err := ms.GetMetricByName(key.ID, key.MType)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, m)
+	}
+	return result, nil
+}
+
 func (ms *memStorage) GetAllMetrics() []models.Metrics {
 	gMetrics := ms.getGaugeList()
 	cMetrics := ms.getCounterList()
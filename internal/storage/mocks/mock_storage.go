@@ -10,6 +10,7 @@ import (
 	time "time"
 
 	models "github.com/Fuonder/metriccoll.git/internal/models"
+	storage "github.com/Fuonder/metriccoll.git/internal/storage"
 	gomock "github.com/golang/mock/gomock"
 )
 
@@ -65,6 +66,59 @@ func (mr *MockMetricReaderMockRecorder) GetMetricByName(name, mType interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMetricByName", reflect.TypeOf((*MockMetricReader)(nil).GetMetricByName), name, mType)
 }
 
+// GetMetricsByKeys mocks base method.
+func (m *MockMetricReader) GetMetricsByKeys(keys []models.MetricKey) ([]models.Metrics, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetMetricsByKeys", keys)
+	ret0, _ := ret[0].([]models.Metrics)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetMetricsByKeys indicates an expected call of GetMetricsByKeys.
+func (mr *MockMetricReaderMockRecorder) GetMetricsByKeys(keys interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMetricsByKeys", reflect.TypeOf((*MockMetricReader)(nil).GetMetricsByKeys), keys)
+}
+
+// MockMetricQuerier is a mock of MetricQuerier interface.
+type MockMetricQuerier struct {
+	ctrl     *gomock.Controller
+	recorder *MockMetricQuerierMockRecorder
+}
+
+// MockMetricQuerierMockRecorder is the mock recorder for MockMetricQuerier.
+type MockMetricQuerierMockRecorder struct {
+	mock *MockMetricQuerier
+}
+
+// NewMockMetricQuerier creates a new mock instance.
+func NewMockMetricQuerier(ctrl *gomock.Controller) *MockMetricQuerier {
+	mock := &MockMetricQuerier{ctrl: ctrl}
+	mock.recorder = &MockMetricQuerierMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMetricQuerier) EXPECT() *MockMetricQuerierMockRecorder {
+	return m.recorder
+}
+
+// QueryMetrics mocks base method.
+func (m *MockMetricQuerier) QueryMetrics(ctx context.Context, q storage.MetricQuery) (storage.MetricPage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "QueryMetrics", ctx, q)
+	ret0, _ := ret[0].(storage.MetricPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QueryMetrics indicates an expected call of QueryMetrics.
+func (mr *MockMetricQuerierMockRecorder) QueryMetrics(ctx, q interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryMetrics", reflect.TypeOf((*MockMetricQuerier)(nil).QueryMetrics), ctx, q)
+}
+
 // MockMetricWriter is a mock of MetricWriter interface.
 type MockMetricWriter struct {
 	ctrl     *gomock.Controller
@@ -116,6 +170,147 @@ func (mr *MockMetricWriterMockRecorder) AppendMetrics(arg0 interface{}) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AppendMetrics", reflect.TypeOf((*MockMetricWriter)(nil).AppendMetrics), arg0)
 }
 
+// MockMetricReplacer is a mock of MetricReplacer interface.
+type MockMetricReplacer struct {
+	ctrl     *gomock.Controller
+	recorder *MockMetricReplacerMockRecorder
+}
+
+// MockMetricReplacerMockRecorder is the mock recorder for MockMetricReplacer.
+type MockMetricReplacerMockRecorder struct {
+	mock *MockMetricReplacer
+}
+
+// NewMockMetricReplacer creates a new mock instance.
+func NewMockMetricReplacer(ctrl *gomock.Controller) *MockMetricReplacer {
+	mock := &MockMetricReplacer{ctrl: ctrl}
+	mock.recorder = &MockMetricReplacerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMetricReplacer) EXPECT() *MockMetricReplacerMockRecorder {
+	return m.recorder
+}
+
+// ReplaceMetrics mocks base method.
+func (m *MockMetricReplacer) ReplaceMetrics(ctx context.Context, metrics []models.Metrics) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReplaceMetrics", ctx, metrics)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReplaceMetrics indicates an expected call of ReplaceMetrics.
+func (mr *MockMetricReplacerMockRecorder) ReplaceMetrics(ctx, metrics interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReplaceMetrics", reflect.TypeOf((*MockMetricReplacer)(nil).ReplaceMetrics), ctx, metrics)
+}
+
+// MockMetricRemover is a mock of MetricRemover interface.
+type MockMetricRemover struct {
+	ctrl     *gomock.Controller
+	recorder *MockMetricRemoverMockRecorder
+}
+
+// MockMetricRemoverMockRecorder is the mock recorder for MockMetricRemover.
+type MockMetricRemoverMockRecorder struct {
+	mock *MockMetricRemover
+}
+
+// NewMockMetricRemover creates a new mock instance.
+func NewMockMetricRemover(ctrl *gomock.Controller) *MockMetricRemover {
+	mock := &MockMetricRemover{ctrl: ctrl}
+	mock.recorder = &MockMetricRemoverMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMetricRemover) EXPECT() *MockMetricRemoverMockRecorder {
+	return m.recorder
+}
+
+// DeleteMetric mocks base method.
+func (m *MockMetricRemover) DeleteMetric(name, mType string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteMetric", name, mType)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteMetric indicates an expected call of DeleteMetric.
+func (mr *MockMetricRemoverMockRecorder) DeleteMetric(name, mType interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteMetric", reflect.TypeOf((*MockMetricRemover)(nil).DeleteMetric), name, mType)
+}
+
+// DeleteMetrics mocks base method.
+func (m *MockMetricRemover) DeleteMetrics(pattern, mType string) ([]models.MetricKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteMetrics", pattern, mType)
+	ret0, _ := ret[0].([]models.MetricKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteMetrics indicates an expected call of DeleteMetrics.
+func (mr *MockMetricRemoverMockRecorder) DeleteMetrics(pattern, mType interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteMetrics", reflect.TypeOf((*MockMetricRemover)(nil).DeleteMetrics), pattern, mType)
+}
+
+// ResetCounter mocks base method.
+func (m *MockMetricRemover) ResetCounter(name string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResetCounter", name)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ResetCounter indicates an expected call of ResetCounter.
+func (mr *MockMetricRemoverMockRecorder) ResetCounter(name interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetCounter", reflect.TypeOf((*MockMetricRemover)(nil).ResetCounter), name)
+}
+
+// MockMetricExporter is a mock of MetricExporter interface.
+type MockMetricExporter struct {
+	ctrl     *gomock.Controller
+	recorder *MockMetricExporterMockRecorder
+}
+
+// MockMetricExporterMockRecorder is the mock recorder for MockMetricExporter.
+type MockMetricExporterMockRecorder struct {
+	mock *MockMetricExporter
+}
+
+// NewMockMetricExporter creates a new mock instance.
+func NewMockMetricExporter(ctrl *gomock.Controller) *MockMetricExporter {
+	mock := &MockMetricExporter{ctrl: ctrl}
+	mock.recorder = &MockMetricExporterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMetricExporter) EXPECT() *MockMetricExporterMockRecorder {
+	return m.recorder
+}
+
+// ExportMetrics mocks base method.
+func (m *MockMetricExporter) ExportMetrics(ctx context.Context) ([]models.Metrics, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExportMetrics", ctx)
+	ret0, _ := ret[0].([]models.Metrics)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ExportMetrics indicates an expected call of ExportMetrics.
+func (mr *MockMetricExporterMockRecorder) ExportMetrics(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExportMetrics", reflect.TypeOf((*MockMetricExporter)(nil).ExportMetrics), ctx)
+}
+
 // MockMetricFileHandler is a mock of MetricFileHandler interface.
 type MockMetricFileHandler struct {
 	ctrl     *gomock.Controller
@@ -372,6 +567,21 @@ func (mr *MockDBConnectionMockRecorder) CreateTablesContext(ctx interface{}) *go
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateTablesContext", reflect.TypeOf((*MockDBConnection)(nil).CreateTablesContext), ctx)
 }
 
+// DeleteMetricsByNames mocks base method.
+func (m *MockDBConnection) DeleteMetricsByNames(ctx context.Context, gaugeNames, counterNames []string) ([]models.MetricKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteMetricsByNames", ctx, gaugeNames, counterNames)
+	ret0, _ := ret[0].([]models.MetricKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteMetricsByNames indicates an expected call of DeleteMetricsByNames.
+func (mr *MockDBConnectionMockRecorder) DeleteMetricsByNames(ctx, gaugeNames, counterNames interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteMetricsByNames", reflect.TypeOf((*MockDBConnection)(nil).DeleteMetricsByNames), ctx, gaugeNames, counterNames)
+}
+
 // GetAllMetrics mocks base method.
 func (m *MockDBConnection) GetAllMetrics(ctx context.Context) ([]models.Metrics, error) {
 	m.ctrl.T.Helper()
@@ -417,6 +627,65 @@ func (mr *MockDBConnectionMockRecorder) GetGaugeMetric(ctx, name interface{}) *g
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetGaugeMetric", reflect.TypeOf((*MockDBConnection)(nil).GetGaugeMetric), ctx, name)
 }
 
+// GetMetricsByNames mocks base method.
+func (m *MockDBConnection) GetMetricsByNames(ctx context.Context, gaugeNames, counterNames []string) ([]models.Metrics, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetMetricsByNames", ctx, gaugeNames, counterNames)
+	ret0, _ := ret[0].([]models.Metrics)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetMetricsByNames indicates an expected call of GetMetricsByNames.
+func (mr *MockDBConnectionMockRecorder) GetMetricsByNames(ctx, gaugeNames, counterNames interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMetricsByNames", reflect.TypeOf((*MockDBConnection)(nil).GetMetricsByNames), ctx, gaugeNames, counterNames)
+}
+
+// QueryMetrics mocks base method.
+func (m *MockDBConnection) QueryMetrics(ctx context.Context, q storage.MetricQuery) ([]models.Metrics, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "QueryMetrics", ctx, q)
+	ret0, _ := ret[0].([]models.Metrics)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QueryMetrics indicates an expected call of QueryMetrics.
+func (mr *MockDBConnectionMockRecorder) QueryMetrics(ctx, q interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryMetrics", reflect.TypeOf((*MockDBConnection)(nil).QueryMetrics), ctx, q)
+}
+
+// ReplaceBatch mocks base method.
+func (m *MockDBConnection) ReplaceBatch(ctx context.Context, metrics []models.Metrics) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReplaceBatch", ctx, metrics)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReplaceBatch indicates an expected call of ReplaceBatch.
+func (mr *MockDBConnectionMockRecorder) ReplaceBatch(ctx, metrics interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReplaceBatch", reflect.TypeOf((*MockDBConnection)(nil).ReplaceBatch), ctx, metrics)
+}
+
+// ResetCounterMetric mocks base method.
+func (m *MockDBConnection) ResetCounterMetric(ctx context.Context, name string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResetCounterMetric", ctx, name)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ResetCounterMetric indicates an expected call of ResetCounterMetric.
+func (mr *MockDBConnectionMockRecorder) ResetCounterMetric(ctx, name interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetCounterMetric", reflect.TypeOf((*MockDBConnection)(nil).ResetCounterMetric), ctx, name)
+}
+
 // TryConnectContext mocks base method.
 func (m *MockDBConnection) TryConnectContext(ctx context.Context) error {
 	m.ctrl.T.Helper()
@@ -499,6 +768,36 @@ func (mr *MockDBReaderMockRecorder) GetGaugeMetric(ctx, name interface{}) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetGaugeMetric", reflect.TypeOf((*MockDBReader)(nil).GetGaugeMetric), ctx, name)
 }
 
+// GetMetricsByNames mocks base method.
+func (m *MockDBReader) GetMetricsByNames(ctx context.Context, gaugeNames, counterNames []string) ([]models.Metrics, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetMetricsByNames", ctx, gaugeNames, counterNames)
+	ret0, _ := ret[0].([]models.Metrics)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetMetricsByNames indicates an expected call of GetMetricsByNames.
+func (mr *MockDBReaderMockRecorder) GetMetricsByNames(ctx, gaugeNames, counterNames interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMetricsByNames", reflect.TypeOf((*MockDBReader)(nil).GetMetricsByNames), ctx, gaugeNames, counterNames)
+}
+
+// QueryMetrics mocks base method.
+func (m *MockDBReader) QueryMetrics(ctx context.Context, q storage.MetricQuery) ([]models.Metrics, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "QueryMetrics", ctx, q)
+	ret0, _ := ret[0].([]models.Metrics)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QueryMetrics indicates an expected call of QueryMetrics.
+func (mr *MockDBReaderMockRecorder) QueryMetrics(ctx, q interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryMetrics", reflect.TypeOf((*MockDBReader)(nil).QueryMetrics), ctx, q)
+}
+
 // MockDBWriter is a mock of DBWriter interface.
 type MockDBWriter struct {
 	ctrl     *gomock.Controller
@@ -563,3 +862,47 @@ func (mr *MockDBWriterMockRecorder) AppendGaugeMetric(ctx, metric interface{}) *
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AppendGaugeMetric", reflect.TypeOf((*MockDBWriter)(nil).AppendGaugeMetric), ctx, metric)
 }
+
+// DeleteMetricsByNames mocks base method.
+func (m *MockDBWriter) DeleteMetricsByNames(ctx context.Context, gaugeNames, counterNames []string) ([]models.MetricKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteMetricsByNames", ctx, gaugeNames, counterNames)
+	ret0, _ := ret[0].([]models.MetricKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteMetricsByNames indicates an expected call of DeleteMetricsByNames.
+func (mr *MockDBWriterMockRecorder) DeleteMetricsByNames(ctx, gaugeNames, counterNames interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteMetricsByNames", reflect.TypeOf((*MockDBWriter)(nil).DeleteMetricsByNames), ctx, gaugeNames, counterNames)
+}
+
+// ReplaceBatch mocks base method.
+func (m *MockDBWriter) ReplaceBatch(ctx context.Context, metrics []models.Metrics) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReplaceBatch", ctx, metrics)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReplaceBatch indicates an expected call of ReplaceBatch.
+func (mr *MockDBWriterMockRecorder) ReplaceBatch(ctx, metrics interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReplaceBatch", reflect.TypeOf((*MockDBWriter)(nil).ReplaceBatch), ctx, metrics)
+}
+
+// ResetCounterMetric mocks base method.
+func (m *MockDBWriter) ResetCounterMetric(ctx context.Context, name string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResetCounterMetric", ctx, name)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ResetCounterMetric indicates an expected call of ResetCounterMetric.
+func (mr *MockDBWriterMockRecorder) ResetCounterMetric(ctx, name interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetCounterMetric", reflect.TypeOf((*MockDBWriter)(nil).ResetCounterMetric), ctx, name)
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/Fuonder/metriccoll.git/internal/logger"
+	"go.uber.org/zap"
+)
+
+// Параметры HealthMonitor по умолчанию.
+const (
+	DefaultHealthCheckInterval = 5 * time.Second
+	DefaultMinReconnectBackoff = time.Second
+	DefaultMaxReconnectBackoff = time.Minute
+)
+
+// ConnectFunc создает новое подключение к основному хранилищу.
+type ConnectFunc func(ctx context.Context) (PrimaryStorage, error)
+
+// HealthMonitor следит за основным хранилищем CompositeStorage: периодически проверяет
+// соединение и при его потере переводит хранилище в режим ModeDegraded, а затем
+// пытается переподключиться с экспоненциально растущей паузой.
+type HealthMonitor struct {
+	cs         *CompositeStorage
+	connect    ConnectFunc // Создание нового подключения, если прежнего нет; может быть nil.
+	interval   time.Duration
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// NewHealthMonitor создает монитор хранилища cs с параметрами по умолчанию.
+// Функция connect используется, если основное хранилище ни разу не было подключено.
+func NewHealthMonitor(cs *CompositeStorage, connect ConnectFunc) *HealthMonitor {
+	return &HealthMonitor{
+		cs:         cs,
+		connect:    connect,
+		interval:   DefaultHealthCheckInterval,
+		minBackoff: DefaultMinReconnectBackoff,
+		maxBackoff: DefaultMaxReconnectBackoff,
+	}
+}
+
+// Run выполняет проверки до отмены ctx.
+func (m *HealthMonitor) Run(ctx context.Context) {
+	backoff := m.minBackoff
+	for {
+		wait := m.interval
+		if m.cs.IsPrimaryActive() {
+			if err := m.cs.CheckConnection(); err != nil {
+				m.cs.Degrade(err)
+				wait = backoff
+			}
+		} else if err := m.reconnect(ctx); err != nil {
+			logger.Log.Debug("Primary storage is still unavailable",
+				zap.Duration("retry-after", backoff),
+				zap.Error(err))
+			wait = backoff
+			backoff = min(2*backoff, m.maxBackoff)
+		} else {
+			logger.Log.Info("Primary storage connection restored, pending metrics replayed")
+			backoff = m.minBackoff
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// reconnect проверяет отключенное основное хранилище (или создает новое подключение)
+// и возвращает его в работу.
+func (m *HealthMonitor) reconnect(ctx context.Context) error {
+	candidate := m.cs.standbyPrimary()
+	if candidate != nil {
+		if err := candidate.CheckConnection(); err != nil {
+			return err
+		}
+	} else {
+		if m.connect == nil {
+			return ErrPrimaryUnavailable
+		}
+		var err error
+		candidate, err = m.connect(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return m.cs.SetPrimary(candidate)
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Fuonder/metriccoll.git/internal/models"
+)
+
+// Ограничения размера страницы MetricQuery.
+const (
+	DefaultQueryLimit = 100
+	MaxQueryLimit     = 1000
+)
+
+// MetricSort — поле сортировки результата MetricQuery.
+type MetricSort string
+
+const (
+	// SortByName упорядочивает метрики по имени, а при равных именах — по типу.
+	SortByName MetricSort = "name"
+	// SortByType упорядочивает метрики по типу, а внутри типа — по имени.
+	SortByType MetricSort = "type"
+)
+
+// MetricQuery описывает выборку метрик с фильтрацией и постраничным чтением.
+// Страницы задаются курсором After (keyset pagination): следующая страница начинается
+// с первой метрики после After в порядке сортировки, поэтому запись новых метрик
+// между запросами не приводит к пропускам и повторам.
+type MetricQuery struct {
+	MType  string            // Тип метрики (gauge | counter), пустой — любой.
+	Prefix string            // Префикс имени.
+	Regex  string            // Регулярное выражение для имени (синтаксис RE2 во всех хранилищах).
+	Sort   MetricSort        // Поле сортировки, по умолчанию SortByName.
+	Desc   bool              // Сортировка по убыванию.
+	Limit  int               // Размер страницы, по умолчанию DefaultQueryLimit.
+	After  *models.MetricKey // Последняя метрика предыдущей страницы.
+}
+
+// MetricPage — страница результата MetricQuery.
+type MetricPage struct {
+	Metrics []models.Metrics
+	// Next — курсор следующей страницы (последняя метрика страницы) или nil, если страница последняя.
+	Next *models.MetricKey
+}
+
+// Normalize проверяет запрос и подставляет значения по умолчанию.
+// Ошибки оборачивают ErrInvalidQuery.
+func (q *MetricQuery) Normalize() error {
+	if q.MType != "" && q.MType != "gauge" && q.MType != "counter" {
+		return fmt.Errorf("%w: metric type: %s is not supported", ErrInvalidQuery, q.MType)
+	}
+	if q.Regex != "" {
+		if _, err := regexp.Compile(q.Regex); err != nil {
+			return fmt.Errorf("%w: regex: %v", ErrInvalidQuery, err)
+		}
+	}
+	switch q.Sort {
+	case "":
+		q.Sort = SortByName
+	case SortByName, SortByType:
+	default:
+		return fmt.Errorf("%w: unknown sort field %q", ErrInvalidQuery, q.Sort)
+	}
+	switch {
+	case q.Limit == 0:
+		q.Limit = DefaultQueryLimit
+	case q.Limit < 0 || q.Limit > MaxQueryLimit:
+		return fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidQuery, MaxQueryLimit)
+	}
+	return nil
+}
+
+// SortKey возвращает пару значений, по которой сортируется метрика с ключом key.
+func (q *MetricQuery) SortKey(key models.MetricKey) (string, string) {
+	if q.Sort == SortByType {
+		return key.MType, key.ID
+	}
+	return key.ID, key.MType
+}
+
+// less сообщает, идет ли метрика a раньше b в порядке сортировки запроса.
+func (q *MetricQuery) less(a models.MetricKey, b models.MetricKey) bool {
+	a1, a2 := q.SortKey(a)
+	b1, b2 := q.SortKey(b)
+	if a1 != b1 {
+		return (a1 < b1) != q.Desc
+	}
+	if a2 != b2 {
+		return (a2 < b2) != q.Desc
+	}
+	return false
+}
+
+// Page формирует страницу из отобранных и упорядоченных метрик: если метрик больше
+// q.Limit, лишние отбрасываются, а курсор указывает на последнюю метрику страницы.
+// Хранилищам достаточно выбрать q.Limit+1 метрику.
+func (q *MetricQuery) Page(metrics []models.Metrics) MetricPage {
+	if len(metrics) <= q.Limit {
+		return MetricPage{Metrics: metrics}
+	}
+	metrics = metrics[:q.Limit]
+	last := metrics[len(metrics)-1].Key()
+	return MetricPage{Metrics: metrics, Next: &last}
+}
+
+// FilterMetrics выполняет запрос q над набором metrics в памяти. Используется хранилищами,
+// у которых нет собственного языка запросов. Запрос должен быть нормализован.
+func FilterMetrics(metrics []models.Metrics, q MetricQuery) MetricPage {
+	var re *regexp.Regexp
+	if q.Regex != "" {
+		re = regexp.MustCompile(q.Regex)
+	}
+	result := make([]models.Metrics, 0, min(len(metrics), q.Limit+1))
+	for _, m := range metrics {
+		key := m.Key()
+		if q.MType != "" && m.MType != q.MType {
+			continue
+		}
+		if !strings.HasPrefix(m.ID, q.Prefix) {
+			continue
+		}
+		if re != nil && !re.MatchString(m.ID) {
+			continue
+		}
+		if q.After != nil && !q.less(*q.After, key) {
+			continue
+		}
+		result = append(result, m)
+	}
+	sort.Slice(result, func(i, j int) bool { return q.less(result[i].Key(), result[j].Key()) })
+	if len(result) > q.Limit+1 {
+		result = result[:q.Limit+1]
+	}
+	return q.Page(result)
+}
+
+// QueryReader выполняет запрос q к хранилищу reader: средствами хранилища, если оно
+// реализует MetricQuerier, иначе — в памяти над GetAllMetrics. Запрос должен быть нормализован.
+func QueryReader(ctx context.Context, reader MetricReader, q MetricQuery) (MetricPage, error) {
+	if querier, ok := reader.(MetricQuerier); ok {
+		return querier.QueryMetrics(ctx, q)
+	}
+	return FilterMetrics(reader.GetAllMetrics(), q), nil
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	model "github.com/Fuonder/metriccoll.git/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricQueryNormalize(t *testing.T) {
+	q := MetricQuery{}
+	require.NoError(t, q.Normalize())
+	require.Equal(t, SortByName, q.Sort)
+	require.Equal(t, DefaultQueryLimit, q.Limit)
+
+	for _, q := range []MetricQuery{
+		{MType: "histogram"},
+		{Regex: "cpu_("},
+		{Sort: "value"},
+		{Limit: -1},
+		{Limit: MaxQueryLimit + 1},
+	} {
+		require.ErrorIs(t, q.Normalize(), ErrInvalidQuery, "%+v", q)
+	}
+}
+
+func queryIDs(page MetricPage) []string {
+	ids := make([]string, 0, len(page.Metrics))
+	for _, m := range page.Metrics {
+		ids = append(ids, m.MType+"/"+m.ID)
+	}
+	return ids
+}
+
+func TestFilterMetrics(t *testing.T) {
+	metrics := []model.Metrics{
+		{ID: "cpu_2", MType: "gauge", Value: model.Float64Ptr(2)},
+		{ID: "cpu_1", MType: "gauge", Value: model.Float64Ptr(1)},
+		{ID: "cpu_1", MType: "counter", Delta: model.Int64Ptr(1)},
+		{ID: "Alloc", MType: "gauge", Value: model.Float64Ptr(5)},
+		{ID: "PollCount", MType: "counter", Delta: model.Int64Ptr(10)},
+	}
+	query := func(q MetricQuery) MetricPage {
+		require.NoError(t, q.Normalize())
+		return FilterMetrics(metrics, q)
+	}
+
+	page := query(MetricQuery{})
+	require.Equal(t, []string{"gauge/Alloc", "counter/PollCount", "counter/cpu_1", "gauge/cpu_1", "gauge/cpu_2"}, queryIDs(page))
+	require.Nil(t, page.Next)
+
+	page = query(MetricQuery{Sort: SortByType, Desc: true})
+	require.Equal(t, []string{"gauge/cpu_2", "gauge/cpu_1", "gauge/Alloc", "counter/cpu_1", "counter/PollCount"}, queryIDs(page))
+
+	require.Equal(t, []string{"gauge/cpu_1", "gauge/cpu_2"}, queryIDs(query(MetricQuery{MType: "gauge", Prefix: "cpu_"})))
+	require.Equal(t, []string{"counter/PollCount", "counter/cpu_1"}, queryIDs(query(MetricQuery{MType: "counter", Regex: "(?i)^p|1$"})))
+
+	var got []string
+	q := MetricQuery{Limit: 2}
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, 3)
+		page := query(q)
+		require.LessOrEqual(t, len(page.Metrics), 2)
+		got = append(got, queryIDs(page)...)
+		if page.Next == nil {
+			break
+		}
+		q.After = page.Next
+	}
+	require.Equal(t, queryIDs(query(MetricQuery{})), got, "pages must cover all metrics exactly once")
+
+	got = nil
+	q = MetricQuery{Limit: 2, Desc: true}
+	for page := query(q); ; page = query(q) {
+		got = append(got, queryIDs(page)...)
+		if page.Next == nil {
+			break
+		}
+		q.After = page.Next
+	}
+	require.Equal(t, queryIDs(query(MetricQuery{Desc: true})), got, "descending pages must not repeat the cursor")
+}
+
+func TestJSONStorageQueryMetrics(t *testing.T) {
+	st, err := NewJSONStorage(NewFileStoreInfo(filepath.Join(t.TempDir(), "metrics.json"), time.Hour, false))
+	require.NoError(t, err)
+	require.NoError(t, st.AppendMetrics([]model.Metrics{
+		{ID: "cpu_1", MType: "gauge", Value: model.Float64Ptr(1)},
+		{ID: "cpu_2", MType: "gauge", Value: model.Float64Ptr(2)},
+		{ID: "PollCount", MType: "counter", Delta: model.Int64Ptr(3)},
+	}))
+
+	q := MetricQuery{Prefix: "cpu_", Limit: 1}
+	require.NoError(t, q.Normalize())
+	page, err := QueryReader(context.Background(), st, q)
+	require.NoError(t, err)
+	require.Equal(t, []string{"gauge/cpu_1"}, queryIDs(page))
+	require.Equal(t, &model.MetricKey{ID: "cpu_1", MType: "gauge"}, page.Next)
+	require.NotNil(t, page.Metrics[0].UpdatedAt)
+
+	q.After = page.Next
+	page, err = QueryReader(context.Background(), st, q)
+	require.NoError(t, err)
+	require.Equal(t, []string{"gauge/cpu_2"}, queryIDs(page))
+	require.Nil(t, page.Next)
+}
@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/Fuonder/metriccoll.git/internal/models"
+)
+
+// ValidatePattern проверяет шаблон имени pattern и тип mType для DeleteMetrics.
+func ValidatePattern(pattern string, mType string) error {
+	if pattern == "" {
+		return fmt.Errorf("%w: empty pattern", ErrInvalidPattern)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("%w: %q: %v", ErrInvalidPattern, pattern, err)
+	}
+	if mType != "" && mType != "gauge" && mType != "counter" {
+		return fmt.Errorf("%w: metric type: %s is not supported", ErrInvalidPattern, mType)
+	}
+	return nil
+}
+
+// MatchKey сообщает, подходит ли ключ под шаблон имени pattern и тип mType (пустой — любой).
+// Шаблон должен быть предварительно проверен ValidatePattern.
+func MatchKey(key models.MetricKey, pattern string, mType string) bool {
+	if mType != "" && key.MType != mType {
+		return false
+	}
+	ok, _ := path.Match(pattern, key.ID)
+	return ok
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	model "github.com/Fuonder/metriccoll.git/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidatePattern(t *testing.T) {
+	require.NoError(t, ValidatePattern("cpu_*", ""))
+	require.NoError(t, ValidatePattern("*", "counter"))
+	require.ErrorIs(t, ValidatePattern("", ""), ErrInvalidPattern)
+	require.ErrorIs(t, ValidatePattern("cpu_[", "gauge"), ErrInvalidPattern)
+	require.ErrorIs(t, ValidatePattern("*", "histogram"), ErrInvalidPattern)
+
+	require.True(t, MatchKey(model.MetricKey{ID: "cpu_1", MType: "gauge"}, "cpu_*", ""))
+	require.False(t, MatchKey(model.MetricKey{ID: "cpu_1", MType: "gauge"}, "cpu_*", "counter"))
+	require.False(t, MatchKey(model.MetricKey{ID: "mem", MType: "gauge"}, "cpu_*", "gauge"))
+}
+
+func TestJSONStorageRemove(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	st, err := NewJSONStorage(NewFileStoreInfo(path, 0, false))
+	require.NoError(t, err)
+	require.NoError(t, st.AppendMetrics([]model.Metrics{
+		{ID: "cpu_1", MType: "gauge", Value: model.Float64Ptr(1)},
+		{ID: "cpu_2", MType: "gauge", Value: model.Float64Ptr(2)},
+		{ID: "cpu_3", MType: "counter", Delta: model.Int64Ptr(3)},
+		{ID: "PollCount", MType: "counter", Delta: model.Int64Ptr(10)},
+		{ID: "Alloc", MType: "gauge", Value: model.Float64Ptr(5)},
+	}))
+
+	require.NoError(t, st.DeleteMetric("Alloc", "gauge"))
+	require.ErrorIs(t, st.DeleteMetric("Alloc", "gauge"), ErrMetricNotFound)
+
+	_, err = st.DeleteMetrics("cpu_[", "")
+	require.ErrorIs(t, err, ErrInvalidPattern)
+	removed, err := st.DeleteMetrics("cpu_*", "gauge")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []model.MetricKey{{ID: "cpu_1", MType: "gauge"}, {ID: "cpu_2", MType: "gauge"}}, removed)
+
+	require.NoError(t, st.ResetCounter("PollCount"))
+	require.ErrorIs(t, st.ResetCounter("missing"), ErrMetricNotFound)
+	require.NoError(t, st.AppendMetric(model.Metrics{ID: "PollCount", MType: "counter", Delta: model.Int64Ptr(2)}))
+	require.NoError(t, st.Close())
+
+	// Удаления и сброс должны пережить перезапуск через журнал.
+	restored, err := NewJSONStorage(NewFileStoreInfo(path, 300, true))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, restored.Close())
+	}()
+	all := restored.GetAllMetrics()
+	require.Len(t, all, 2)
+	c, err := restored.GetMetricByName("PollCount", "counter")
+	require.NoError(t, err)
+	require.Equal(t, int64(2), *c.Delta)
+	c, err = restored.GetMetricByName("cpu_3", "counter")
+	require.NoError(t, err)
+	require.Equal(t, int64(3), *c.Delta)
+}
+
+func TestCompositeStorageRemove(t *testing.T) {
+	db, err := NewJSONStorage(NewFileStoreInfo(filepath.Join(t.TempDir(), "db.json"), time.Hour, false))
+	require.NoError(t, err)
+	primary := &flakyPrimary{JSONStorage: db}
+	cs, err := NewCompositeStorage(primary, NewFileStoreInfo(filepath.Join(t.TempDir(), "metrics.json"), time.Hour, false))
+	require.NoError(t, err)
+	require.NoError(t, cs.AppendMetrics([]model.Metrics{
+		{ID: "a", MType: "gauge", Value: model.Float64Ptr(1)},
+		{ID: "b", MType: "counter", Delta: model.Int64Ptr(4)},
+	}))
+
+	require.NoError(t, cs.DeleteMetric("a", "gauge"))
+	_, err = cs.GetMetricByName("a", "gauge")
+	require.Error(t, err)
+	_, err = db.GetMetricByName("a", "gauge")
+	require.Error(t, err)
+
+	require.NoError(t, cs.ResetCounter("b"))
+	c, err := cs.GetMetricByName("b", "counter")
+	require.NoError(t, err)
+	require.Equal(t, int64(0), *c.Delta)
+
+	// Без основного хранилища удаление отклоняется: иначе серия вернется при синхронизации.
+	primary.down.Store(true)
+	require.NoError(t, cs.AppendMetric(model.Metrics{ID: "b", MType: "counter", Delta: model.Int64Ptr(1)}))
+	require.False(t, cs.IsPrimaryActive())
+	_, err = cs.DeleteMetrics("*", "")
+	require.ErrorIs(t, err, ErrPrimaryUnavailable)
+}
@@ -11,12 +11,22 @@ import (
 )
 
 // MetricReader интерфейс для чтения метрик.
-// Позволяет получить все метрики или метрику по имени и типу.
+// Позволяет получить все метрики, метрику по имени и типу или несколько метрик за один запрос.
 type MetricReader interface {
 	// GetAllMetrics возвращает все метрики.
 	GetAllMetrics() []models.Metrics
 	// GetMetricByName возвращает метрику по имени и типу.
 	GetMetricByName(name string, mType string) (models.Metrics, error)
+	// GetMetricsByKeys возвращает метрики с заданными ключами в том же порядке.
+	// Если хотя бы одна метрика не найдена, возвращает ошибку.
+	GetMetricsByKeys(keys []models.MetricKey) ([]models.Metrics, error)
+}
+
+// MetricQuerier интерфейс для выборки метрик с фильтрацией, сортировкой и постраничным чтением.
+// Хранилища реализуют его, чтобы выполнять фильтрацию на своей стороне (например, в SQL).
+type MetricQuerier interface {
+	// QueryMetrics возвращает страницу метрик, подходящих под нормализованный запрос q.
+	QueryMetrics(ctx context.Context, q MetricQuery) (MetricPage, error)
 }
 
 // MetricWriter интерфейс для записи метрик.
@@ -24,10 +34,38 @@ type MetricReader interface {
 type MetricWriter interface {
 	// AppendMetric добавляет одну метрику.
 	AppendMetric(metric models.Metrics) error
-	// AppendMetrics добавляет несколько метрик.
+	// AppendMetrics добавляет несколько метрик. Пакет применяется целиком или не применяется
+	// вовсе (одна транзакция БД или одна запись журнала), поэтому ошибка не оставляет его
+	// записанным частично.
 	AppendMetrics([]models.Metrics) error
 }
 
+// MetricReplacer интерфейс для записи метрик с заменой значений существующих серий.
+type MetricReplacer interface {
+	// ReplaceMetrics записывает пакет метрик по принципу «все или ничего», заменяя значения
+	// существующих серий: для counter устанавливается дельта из пакета, а не прибавляется к текущей.
+	// Пакет не должен содержать повторов одной серии.
+	ReplaceMetrics(ctx context.Context, metrics []models.Metrics) error
+}
+
+// MetricRemover интерфейс для удаления метрик и сброса счетчиков.
+type MetricRemover interface {
+	// DeleteMetric удаляет метрику по имени и типу. Если метрика не найдена, возвращает ErrMetricNotFound.
+	DeleteMetric(name string, mType string) error
+	// DeleteMetrics удаляет метрики, имя которых соответствует шаблону pattern (синтаксис path.Match),
+	// а тип равен mType (пустой mType — любой тип). Возвращает ключи удаленных метрик.
+	DeleteMetrics(pattern string, mType string) ([]models.MetricKey, error)
+	// ResetCounter обнуляет метрику типа Counter. Если метрика не найдена, возвращает ErrMetricNotFound.
+	ResetCounter(name string) error
+}
+
+// MetricExporter интерфейс для выгрузки всех метрик хранилища (резервное копирование, перенос).
+type MetricExporter interface {
+	// ExportMetrics возвращает согласованный снимок всех метрик. В отличие от
+	// MetricReader.GetAllMetrics, ошибка чтения возвращается вызывающему коду.
+	ExportMetrics(ctx context.Context) ([]models.Metrics, error)
+}
+
 // MetricFileHandler интерфейс для работы с файлами.
 // Позволяет выгружать метрики в файл или загружать метрики из файла.
 type MetricFileHandler interface {
@@ -77,6 +115,11 @@ type DBReader interface {
 	GetCounterMetric(ctx context.Context, name string) (models.Metrics, error)
 	// GetAllMetrics получает все метрики из базы данных.
 	GetAllMetrics(ctx context.Context) ([]models.Metrics, error)
+	// GetMetricsByNames получает метрики типа Gauge и Counter с заданными именами.
+	GetMetricsByNames(ctx context.Context, gaugeNames []string, counterNames []string) ([]models.Metrics, error)
+	// QueryMetrics получает до q.Limit+1 метрик, подходящих под нормализованный запрос q,
+	// в порядке сортировки запроса.
+	QueryMetrics(ctx context.Context, q MetricQuery) ([]models.Metrics, error)
 }
 
 // DBWriter интерфейс для записи метрик в базу данных.
@@ -87,4 +130,12 @@ type DBWriter interface {
 	AppendCounterMetric(ctx context.Context, metric models.Metrics) error
 	// AppendBatch добавляет несколько метрик в базу данных.
 	AppendBatch(ctx context.Context, metrics []models.Metrics) error
+	// ReplaceBatch записывает несколько метрик в базу данных в одной транзакции,
+	// заменяя значения существующих серий (для counter — без суммирования с текущим значением).
+	ReplaceBatch(ctx context.Context, metrics []models.Metrics) error
+	// DeleteMetricsByNames удаляет метрики типа Gauge и Counter с заданными именами
+	// и возвращает ключи удаленных метрик.
+	DeleteMetricsByNames(ctx context.Context, gaugeNames []string, counterNames []string) ([]models.MetricKey, error)
+	// ResetCounterMetric обнуляет метрику типа Counter. Возвращает false, если метрика не найдена.
+	ResetCounterMetric(ctx context.Context, name string) (bool, error)
 }
@@ -0,0 +1,569 @@
+// Package sqlite реализует встроенное хранилище метрик поверх SQLite.
+// Хранилище не требует отдельного сервера БД: данные записываются в один файл
+// инкрементально (по строке на серию) и переживают перезапуск сервера.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Fuonder/metriccoll.git/internal/logger"
+	"github.com/Fuonder/metriccoll.git/internal/models"
+	"github.com/Fuonder/metriccoll.git/internal/storage"
+	"go.uber.org/zap"
+	_ "modernc.org/sqlite"
+)
+
+// DSNPrefix — префикс DSN, по которому выбирается хранилище SQLite, например sqlite:///var/lib/metriccoll.db.
+const DSNPrefix = "sqlite://"
+
+// busyTimeout — время ожидания блокировки файла БД другим процессом.
+const busyTimeout = 5 * time.Second
+
+// maxQueryArgs — максимальное количество имен в одном запросе IN (...).
+const maxQueryArgs = 500
+
+// ErrInvalidDSN возвращается, если DSN не описывает путь к файлу SQLite.
+var ErrInvalidDSN = errors.New("invalid sqlite dsn")
+
+const schema = `
+	CREATE TABLE IF NOT EXISTS gauge_metrics (
+		id TEXT PRIMARY KEY,
+		type TEXT NOT NULL,
+		value REAL,
+		updated_at INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE TABLE IF NOT EXISTS counter_metrics (
+		id TEXT PRIMARY KEY,
+		type TEXT NOT NULL,
+		delta INTEGER,
+		updated_at INTEGER NOT NULL DEFAULT 0
+	);
+`
+
+const (
+	upsertGaugeQuery = `
+		INSERT INTO gauge_metrics (id, type, value, updated_at)
+		VALUES (?, 'gauge', ?, ?)
+		ON CONFLICT (id)
+		DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at;
+	`
+	upsertCounterQuery = `
+		INSERT INTO counter_metrics (id, type, delta, updated_at)
+		VALUES (?, 'counter', ?, ?)
+		ON CONFLICT (id)
+		DO UPDATE SET delta = counter_metrics.delta + excluded.delta, updated_at = excluded.updated_at;
+	`
+	replaceCounterQuery = `
+		INSERT INTO counter_metrics (id, type, delta, updated_at)
+		VALUES (?, 'counter', ?, ?)
+		ON CONFLICT (id)
+		DO UPDATE SET delta = excluded.delta, updated_at = excluded.updated_at;
+	`
+)
+
+// IsDSN сообщает, указывает ли dsn на хранилище SQLite.
+func IsDSN(dsn string) bool {
+	return strings.HasPrefix(dsn, DSNPrefix)
+}
+
+// PathFromDSN возвращает путь к файлу БД из DSN вида sqlite:///abs/path.db или sqlite://rel/path.db.
+func PathFromDSN(dsn string) (string, error) {
+	if !IsDSN(dsn) {
+		return "", fmt.Errorf("%w: %q must start with %s", ErrInvalidDSN, dsn, DSNPrefix)
+	}
+	path := strings.TrimPrefix(dsn, DSNPrefix)
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	if path == "" {
+		return "", fmt.Errorf("%w: %q has no file path", ErrInvalidDSN, dsn)
+	}
+	return path, nil
+}
+
+// Storage — хранилище метрик в файле SQLite. Реализует storage.MetricReader,
+// storage.MetricWriter, storage.MetricReplacer, storage.MetricRemover, storage.MetricExporter,
+// storage.MetricQuerier, storage.MetricDatabaseHandler и storage.OpObservable.
+type Storage struct {
+	db   *sql.DB
+	path string
+	ops  storage.OpRecorder
+}
+
+// SetOpObserver задает получателя длительностей операций хранилища (nil — выключить).
+func (st *Storage) SetOpObserver(fn storage.OpObserver) {
+	st.ops.Set(fn)
+}
+
+// NewStorage открывает (или создает) файл БД по DSN, включает журнал WAL и создает таблицы.
+func NewStorage(ctx context.Context, dsn string) (*Storage, error) {
+	path, err := PathFromDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{}
+	query.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", busyTimeout.Milliseconds()))
+	query.Add("_pragma", "journal_mode(WAL)")
+	query.Add("_pragma", "synchronous(NORMAL)")
+	db, err := sql.Open("sqlite", "file:"+path+"?"+query.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("can not open sqlite database: %v", err)
+	}
+	// SQLite допускает только одного писателя: единственное соединение исключает
+	// ошибки SQLITE_BUSY между горутинами одного процесса.
+	db.SetMaxOpenConns(1)
+
+	st := &Storage{db: db, path: path}
+	if err := st.init(ctx); err != nil {
+		if closeErr := db.Close(); closeErr != nil {
+			logger.Log.Debug("can not close sqlite database", zap.Error(closeErr))
+		}
+		return nil, err
+	}
+	logger.Log.Info("SQLite storage opened", zap.String("path", path))
+	return st, nil
+}
+
+func (st *Storage) init(ctx context.Context) error {
+	if err := st.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("access to sqlite database: %v", err)
+	}
+	if _, err := st.db.ExecContext(ctx, schema); err != nil {
+		return fmt.Errorf("can not create sqlite tables: %v", err)
+	}
+	for _, table := range []string{"gauge_metrics", "counter_metrics"} {
+		if err := addUpdatedAt(ctx, st.db, table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addUpdatedAt добавляет столбец updated_at в таблицу, созданную прежней версией схемы.
+// Существующие серии считаются обновленными в момент миграции.
+func addUpdatedAt(ctx context.Context, db *sql.DB, table string) error {
+	var n int
+	err := db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = 'updated_at'`, table).Scan(&n)
+	if err != nil {
+		return fmt.Errorf("can not inspect sqlite table %s: %v", table, err)
+	}
+	if n > 0 {
+		return nil
+	}
+	_, err = db.ExecContext(ctx, fmt.Sprintf(`ALTER TABLE %s ADD COLUMN updated_at INTEGER NOT NULL DEFAULT 0`, table))
+	if err != nil {
+		return fmt.Errorf("can not migrate sqlite table %s: %v", table, err)
+	}
+	_, err = db.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET updated_at = ?`, table), time.Now().UnixNano())
+	if err != nil {
+		return fmt.Errorf("can not migrate sqlite table %s: %v", table, err)
+	}
+	return nil
+}
+
+// unixTime преобразует время обновления из БД (наносекунды Unix) в значение поля Metrics.UpdatedAt.
+func unixTime(ns int64) *time.Time {
+	if ns == 0 {
+		return nil
+	}
+	t := time.Unix(0, ns).UTC()
+	return &t
+}
+
+// CheckConnection проверяет доступность файла БД.
+func (st *Storage) CheckConnection() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return st.db.PingContext(ctx)
+}
+
+// Close закрывает БД.
+func (st *Storage) Close() error {
+	logger.Log.Info("Closing sqlite database gracefully")
+	return st.db.Close()
+}
+
+// GetMetricByName возвращает метрику по имени и типу.
+func (st *Storage) GetMetricByName(name string, mType string) (_ models.Metrics, err error) {
+	defer st.ops.Observe(storage.BackendSQLite, storage.OpGet, time.Now(), &err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var (
+		m         = models.Metrics{ID: name, MType: mType}
+		updatedAt int64
+	)
+	switch mType {
+	case "gauge":
+		err = st.db.QueryRowContext(ctx, `SELECT value, updated_at FROM gauge_metrics WHERE id = ?`, name).
+			Scan(&m.Value, &updatedAt)
+	case "counter":
+		err = st.db.QueryRowContext(ctx, `SELECT delta, updated_at FROM counter_metrics WHERE id = ?`, name).
+			Scan(&m.Delta, &updatedAt)
+	default:
+		return models.Metrics{}, fmt.Errorf("metric type: %s is not supported", mType)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Metrics{}, fmt.Errorf("%w: %s", storage.ErrMetricNotFound, name)
+	}
+	if err != nil {
+		return models.Metrics{}, fmt.Errorf("GetMetricByName: %v", err)
+	}
+	m.UpdatedAt = unixTime(updatedAt)
+	return m, nil
+}
+
+// GetMetricsByKeys возвращает метрики с заданными ключами в том же порядке.
+func (st *Storage) GetMetricsByKeys(keys []models.MetricKey) (_ []models.Metrics, err error) {
+	defer st.ops.Observe(storage.BackendSQLite, storage.OpGet, time.Now(), &err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var gaugeNames, counterNames []string
+	seen := make(map[models.MetricKey]struct{}, len(keys))
+	for _, key := range keys {
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		switch key.MType {
+		case "gauge":
+			gaugeNames = append(gaugeNames, key.ID)
+		case "counter":
+			counterNames = append(counterNames, key.ID)
+		default:
+			return nil, fmt.Errorf("metric type: %s is not supported", key.MType)
+		}
+	}
+
+	byKey := make(map[models.MetricKey]models.Metrics, len(seen))
+	for _, q := range []struct {
+		table string
+		names []string
+	}{
+		{"gauge_metrics", gaugeNames},
+		{"counter_metrics", counterNames},
+	} {
+		for start := 0; start < len(q.names); start += maxQueryArgs {
+			end := min(start+maxQueryArgs, len(q.names))
+			found, err := queryMetrics(ctx, st.db, q.table, q.names[start:end])
+			if err != nil {
+				return nil, fmt.Errorf("GetMetricsByKeys: %v", err)
+			}
+			for _, m := range found {
+				byKey[m.Key()] = m
+			}
+		}
+	}
+
+	result := make([]models.Metrics, 0, len(keys))
+	for _, key := range keys {
+		m, ok := byKey[key]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", storage.ErrMetricNotFound, key.ID)
+		}
+		result = append(result, m)
+	}
+	return result, nil
+}
+
+// GetAllMetrics возвращает все метрики: сначала counter, затем gauge, каждую группу по имени.
+func (st *Storage) GetAllMetrics() []models.Metrics {
+	var err error
+	defer st.ops.Observe(storage.BackendSQLite, storage.OpGetAll, time.Now(), &err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	metrics, err := st.ExportMetrics(ctx)
+	if err != nil {
+		logger.Log.Warn("", zap.Error(err))
+		return nil
+	}
+	return metrics
+}
+
+// ExportMetrics возвращает все метрики в том же порядке, что и GetAllMetrics. Обе таблицы
+// читаются в одной транзакции, поэтому результат — согласованный снимок.
+func (st *Storage) ExportMetrics(ctx context.Context) ([]models.Metrics, error) {
+	tx, err := st.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("can not begin transaction: %v", err)
+	}
+	defer func(tx *sql.Tx) {
+		err := tx.Rollback()
+		if err != nil && !errors.Is(err, sql.ErrTxDone) {
+			logger.Log.Info("can not rollback transaction", zap.Error(err))
+		}
+	}(tx)
+
+	var metrics []models.Metrics
+	for _, table := range []string{"counter_metrics", "gauge_metrics"} {
+		found, err := queryMetrics(ctx, tx, table, nil)
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, found...)
+	}
+	return metrics, nil
+}
+
+// QueryMetrics выполняет запрос q в памяти над согласованным снимком таблиц: в SQLite нет
+// встроенного оператора регулярных выражений, а объем встроенного хранилища невелик.
+func (st *Storage) QueryMetrics(ctx context.Context, q storage.MetricQuery) (_ storage.MetricPage, err error) {
+	defer st.ops.Observe(storage.BackendSQLite, storage.OpQuery, time.Now(), &err)
+	metrics, err := st.ExportMetrics(ctx)
+	if err != nil {
+		return storage.MetricPage{}, err
+	}
+	return storage.FilterMetrics(metrics, q), nil
+}
+
+// querier — общий интерфейс *sql.DB и *sql.Tx для чтения.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// queryMetrics читает метрики из таблицы table. Если names не пуст, читаются только серии с этими именами.
+func queryMetrics(ctx context.Context, q querier, table string, names []string) ([]models.Metrics, error) {
+	column := "value"
+	if table == "counter_metrics" {
+		column = "delta"
+	}
+	query := fmt.Sprintf(`SELECT id, type, %s, updated_at FROM %s`, column, table)
+	args := make([]any, 0, len(names))
+	if len(names) > 0 {
+		query += ` WHERE id IN (?` + strings.Repeat(`, ?`, len(names)-1) + `)`
+		for _, name := range names {
+			args = append(args, name)
+		}
+	}
+	query += ` ORDER BY id`
+
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("can not query metrics: %w", err)
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			logger.Log.Info("Rows can not be closed", zap.Error(err))
+		}
+	}(rows)
+
+	var metrics []models.Metrics
+	for rows.Next() {
+		var (
+			m         models.Metrics
+			updatedAt int64
+		)
+		if column == "delta" {
+			err = rows.Scan(&m.ID, &m.MType, &m.Delta, &updatedAt)
+		} else {
+			err = rows.Scan(&m.ID, &m.MType, &m.Value, &updatedAt)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("can not scan metrics: %w", err)
+		}
+		m.UpdatedAt = unixTime(updatedAt)
+		metrics = append(metrics, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("metrics has errors: %w", err)
+	}
+	return metrics, nil
+}
+
+// AppendMetric добавляет одну метрику.
+func (st *Storage) AppendMetric(metric models.Metrics) error {
+	return st.AppendMetrics([]models.Metrics{metric})
+}
+
+// AppendMetrics записывает пакет метрик в одной транзакции по принципу «все или ничего»:
+// сначала проверяются все метрики пакета, и при ошибке БД не изменяется.
+func (st *Storage) AppendMetrics(metrics []models.Metrics) (err error) {
+	defer st.ops.Observe(storage.BackendSQLite, storage.OpAppend, time.Now(), &err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return st.writeMetrics(ctx, metrics, upsertCounterQuery)
+}
+
+// ReplaceMetrics записывает пакет метрик в одной транзакции, заменяя значения существующих серий.
+func (st *Storage) ReplaceMetrics(ctx context.Context, metrics []models.Metrics) (err error) {
+	defer st.ops.Observe(storage.BackendSQLite, storage.OpAppend, time.Now(), &err)
+	return st.writeMetrics(ctx, metrics, replaceCounterQuery)
+}
+
+// writeMetrics проверяет пакет, объединяет повторы серий так же, как хранилище Postgres,
+// и записывает его в одной транзакции; counterQuery задает запись counter (прибавление или замена значения).
+func (st *Storage) writeMetrics(ctx context.Context, metrics []models.Metrics, counterQuery string) error {
+	gauges, counters, err := storage.AggregateBatch(metrics)
+	if err != nil {
+		return err
+	}
+	if len(gauges)+len(counters) == 0 {
+		return nil
+	}
+
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("can not begin transaction: %v", err)
+	}
+	defer func(tx *sql.Tx) {
+		err := tx.Rollback()
+		if err != nil && !errors.Is(err, sql.ErrTxDone) {
+			logger.Log.Info("can not rollback transaction", zap.Error(err))
+		}
+	}(tx)
+
+	gaugeStmt, err := tx.PrepareContext(ctx, upsertGaugeQuery)
+	if err != nil {
+		return fmt.Errorf("can not prepare gauge query: %v", err)
+	}
+	counterStmt, err := tx.PrepareContext(ctx, counterQuery)
+	if err != nil {
+		return fmt.Errorf("can not prepare counter query: %v", err)
+	}
+	now := time.Now().UnixNano()
+	for _, m := range gauges {
+		if _, err = gaugeStmt.ExecContext(ctx, m.ID, *m.Value, now); err != nil {
+			return fmt.Errorf("can not append %s metric %s: %v", m.MType, m.ID, err)
+		}
+	}
+	for _, m := range counters {
+		if _, err = counterStmt.ExecContext(ctx, m.ID, *m.Delta, now); err != nil {
+			return fmt.Errorf("can not append %s metric %s: %v", m.MType, m.ID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// DeleteMetric удаляет метрику по имени и типу.
+func (st *Storage) DeleteMetric(name string, mType string) (err error) {
+	defer st.ops.Observe(storage.BackendSQLite, storage.OpDelete, time.Now(), &err)
+	table, err := tableOf(mType)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := st.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE id = ?`, table), name)
+	if err != nil {
+		return fmt.Errorf("can not delete metric: %v", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("can not delete metric: %v", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %s", storage.ErrMetricNotFound, name)
+	}
+	return nil
+}
+
+// DeleteMetrics удаляет метрики, подходящие под шаблон имени pattern и тип mType, в одной транзакции.
+func (st *Storage) DeleteMetrics(pattern string, mType string) (_ []models.MetricKey, err error) {
+	defer st.ops.Observe(storage.BackendSQLite, storage.OpDelete, time.Now(), &err)
+	if err := storage.ValidatePattern(pattern, mType); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("can not begin transaction: %v", err)
+	}
+	defer func(tx *sql.Tx) {
+		err := tx.Rollback()
+		if err != nil && !errors.Is(err, sql.ErrTxDone) {
+			logger.Log.Info("can not rollback transaction", zap.Error(err))
+		}
+	}(tx)
+
+	var removed []models.MetricKey
+	for _, t := range []string{"gauge", "counter"} {
+		if mType != "" && mType != t {
+			continue
+		}
+		table, _ := tableOf(t)
+		names, err := matchingNames(ctx, tx, table, t, pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE id = ?`, table), name); err != nil {
+				return nil, fmt.Errorf("can not delete metric %s: %v", name, err)
+			}
+			removed = append(removed, models.MetricKey{ID: name, MType: t})
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// matchingNames возвращает имена серий таблицы table, подходящие под шаблон pattern.
+func matchingNames(ctx context.Context, tx *sql.Tx, table string, mType string, pattern string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`SELECT id FROM %s ORDER BY id`, table))
+	if err != nil {
+		return nil, fmt.Errorf("can not query metrics: %w", err)
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			logger.Log.Info("Rows can not be closed", zap.Error(err))
+		}
+	}(rows)
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("can not scan metrics: %w", err)
+		}
+		if storage.MatchKey(models.MetricKey{ID: name, MType: mType}, pattern, mType) {
+			names = append(names, name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("metrics has errors: %w", err)
+	}
+	return names, nil
+}
+
+// ResetCounter обнуляет метрику типа Counter.
+func (st *Storage) ResetCounter(name string) (err error) {
+	defer st.ops.Observe(storage.BackendSQLite, storage.OpReset, time.Now(), &err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := st.db.ExecContext(ctx, `UPDATE counter_metrics SET delta = 0, updated_at = ? WHERE id = ?`,
+		time.Now().UnixNano(), name)
+	if err != nil {
+		return fmt.Errorf("can not reset counter metric: %v", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("can not reset counter metric: %v", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %s", storage.ErrMetricNotFound, name)
+	}
+	return nil
+}
+
+func tableOf(mType string) (string, error) {
+	switch mType {
+	case "gauge":
+		return "gauge_metrics", nil
+	case "counter":
+		return "counter_metrics", nil
+	}
+	return "", fmt.Errorf("metric type: %s is not supported", mType)
+}
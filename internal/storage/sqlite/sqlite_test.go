@@ -0,0 +1,170 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Fuonder/metriccoll.git/internal/models"
+	"github.com/Fuonder/metriccoll.git/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathFromDSN(t *testing.T) {
+	tests := []struct {
+		dsn     string
+		want    string
+		wantErr bool
+	}{
+		{"sqlite:///var/lib/metriccoll.db", "/var/lib/metriccoll.db", false},
+		{"sqlite://data/metrics.db", "data/metrics.db", false},
+		{"sqlite:///tmp/m.db?mode=rwc", "/tmp/m.db", false},
+		{"sqlite://", "", true},
+		{"postgres://localhost/db", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dsn, func(t *testing.T) {
+			got, err := PathFromDSN(tt.dsn)
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrInvalidDSN)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestStorage(t *testing.T) {
+	dsn := DSNPrefix + filepath.Join(t.TempDir(), "metrics.db")
+	st, err := NewStorage(context.Background(), dsn)
+	require.NoError(t, err)
+	require.NoError(t, st.CheckConnection())
+
+	require.NoError(t, st.AppendMetric(models.Metrics{ID: "c", MType: "counter", Delta: models.Int64Ptr(5)}))
+	require.NoError(t, st.AppendMetrics([]models.Metrics{
+		{ID: "c", MType: "counter", Delta: models.Int64Ptr(2)},
+		{ID: "g", MType: "gauge", Value: models.Float64Ptr(1.5)},
+		{ID: "g", MType: "gauge", Value: models.Float64Ptr(2.5)},
+	}))
+
+	c, err := st.GetMetricByName("c", "counter")
+	require.NoError(t, err)
+	require.Equal(t, int64(7), *c.Delta)
+	require.NotNil(t, c.UpdatedAt)
+	require.WithinDuration(t, time.Now(), *c.UpdatedAt, time.Minute)
+	_, err = st.GetMetricByName("missing", "gauge")
+	require.ErrorIs(t, err, storage.ErrMetricNotFound)
+
+	err = st.AppendMetrics([]models.Metrics{
+		{ID: "c", MType: "counter", Delta: models.Int64Ptr(100)},
+		{ID: "g", MType: "gauge"},
+	})
+	require.ErrorIs(t, err, storage.ErrInvalidMetricValue)
+
+	got, err := st.GetMetricsByKeys([]models.MetricKey{{ID: "g", MType: "gauge"}, {ID: "c", MType: "counter"}})
+	require.NoError(t, err)
+	require.Equal(t, 2.5, *got[0].Value)
+	require.Equal(t, int64(7), *got[1].Delta, "rejected batch must not be applied")
+	_, err = st.GetMetricsByKeys([]models.MetricKey{{ID: "c", MType: "gauge"}})
+	require.ErrorIs(t, err, storage.ErrMetricNotFound)
+
+	require.NoError(t, st.Close())
+
+	reopened, err := NewStorage(context.Background(), dsn)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, reopened.Close())
+	}()
+	all := reopened.GetAllMetrics()
+	require.Len(t, all, 2)
+	require.Equal(t, "c", all[0].ID)
+	require.Equal(t, 2.5, *all[1].Value)
+}
+
+func TestStorageRemove(t *testing.T) {
+	st, err := NewStorage(context.Background(), DSNPrefix+filepath.Join(t.TempDir(), "metrics.db"))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, st.Close())
+	}()
+	require.NoError(t, st.AppendMetrics([]models.Metrics{
+		{ID: "cpu_1", MType: "gauge", Value: models.Float64Ptr(1)},
+		{ID: "cpu_2", MType: "gauge", Value: models.Float64Ptr(2)},
+		{ID: "cpu_3", MType: "counter", Delta: models.Int64Ptr(3)},
+		{ID: "PollCount", MType: "counter", Delta: models.Int64Ptr(10)},
+	}))
+
+	require.NoError(t, st.DeleteMetric("cpu_1", "gauge"))
+	require.ErrorIs(t, st.DeleteMetric("cpu_1", "gauge"), storage.ErrMetricNotFound)
+
+	removed, err := st.DeleteMetrics("cpu_*", "")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []models.MetricKey{{ID: "cpu_2", MType: "gauge"}, {ID: "cpu_3", MType: "counter"}}, removed)
+
+	require.NoError(t, st.ResetCounter("PollCount"))
+	require.ErrorIs(t, st.ResetCounter("missing"), storage.ErrMetricNotFound)
+	all := st.GetAllMetrics()
+	require.Len(t, all, 1)
+	require.Equal(t, int64(0), *all[0].Delta)
+}
+
+func TestStorageReplaceMetrics(t *testing.T) {
+	st, err := NewStorage(context.Background(), DSNPrefix+filepath.Join(t.TempDir(), "metrics.db"))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, st.Close())
+	}()
+	require.NoError(t, st.AppendMetrics([]models.Metrics{
+		{ID: "PollCount", MType: "counter", Delta: models.Int64Ptr(10)},
+		{ID: "Alloc", MType: "gauge", Value: models.Float64Ptr(1)},
+	}))
+
+	err = st.ReplaceMetrics(context.Background(), []models.Metrics{
+		{ID: "PollCount", MType: "counter", Delta: models.Int64Ptr(3)},
+		{ID: "Alloc", MType: "gauge"},
+	})
+	require.ErrorIs(t, err, storage.ErrInvalidMetricValue)
+	m, err := st.GetMetricByName("PollCount", "counter")
+	require.NoError(t, err)
+	require.Equal(t, int64(10), *m.Delta, "invalid batch must not be applied")
+
+	require.NoError(t, st.ReplaceMetrics(context.Background(), []models.Metrics{
+		{ID: "PollCount", MType: "counter", Delta: models.Int64Ptr(3)},
+		{ID: "Alloc", MType: "gauge", Value: models.Float64Ptr(2)},
+		{ID: "New", MType: "counter", Delta: models.Int64Ptr(1)},
+	}))
+	m, err = st.GetMetricByName("PollCount", "counter")
+	require.NoError(t, err)
+	require.Equal(t, int64(3), *m.Delta, "counter must be replaced, not incremented")
+	m, err = st.GetMetricByName("Alloc", "gauge")
+	require.NoError(t, err)
+	require.Equal(t, 2.0, *m.Value)
+	require.Len(t, st.GetAllMetrics(), 3)
+}
+
+func TestStorageMigratesUpdatedAt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.db")
+	db, err := sql.Open("sqlite", "file:"+path)
+	require.NoError(t, err)
+	_, err = db.Exec(`
+		CREATE TABLE gauge_metrics (id TEXT PRIMARY KEY, type TEXT NOT NULL, value REAL);
+		CREATE TABLE counter_metrics (id TEXT PRIMARY KEY, type TEXT NOT NULL, delta INTEGER);
+		INSERT INTO gauge_metrics (id, type, value) VALUES ('g', 'gauge', 1.5);
+	`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	st, err := NewStorage(context.Background(), DSNPrefix+path)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, st.Close())
+	}()
+	g, err := st.GetMetricByName("g", "gauge")
+	require.NoError(t, err)
+	require.Equal(t, 1.5, *g.Value)
+	require.NotNil(t, g.UpdatedAt, "existing series are treated as updated at migration time")
+	require.NoError(t, st.AppendMetric(models.Metrics{ID: "c", MType: "counter", Delta: models.Int64Ptr(1)}))
+}
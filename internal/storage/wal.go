@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/Fuonder/metriccoll.git/internal/logger"
+	"github.com/Fuonder/metriccoll.git/internal/models"
+	"go.uber.org/zap"
+)
+
+// walSuffix — суффикс файла журнала упреждающей записи рядом с файлом снимка.
+const walSuffix = ".wal"
+
+// walCompactThreshold — количество записей журнала, после которого журнал
+// сворачивается в новый снимок.
+const walCompactThreshold = 1000
+
+// walLog — журнал упреждающей записи файлового хранилища. Каждая запись — строка JSON
+// с итоговыми значениями серий, измененных одним пакетом (для counter — накопленная сумма,
+// а не дельта). Удаленная серия записывается без значения (см. isTombstone). Поэтому
+// повторное применение журнала к снимку, уже содержащему эти записи, не меняет результат,
+// а оборванная при сбое последняя строка просто отбрасывается.
+type walLog struct {
+	path    string
+	file    *os.File
+	entries int
+}
+
+// openWAL открывает журнал для дозаписи. Если truncate равен true, содержимое журнала удаляется.
+func openWAL(path string, truncate bool) (*walLog, error) {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if truncate {
+		flags |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(path, flags, OsAllRw)
+	if err != nil {
+		return nil, fmt.Errorf("can not open wal file: %w", err)
+	}
+	return &walLog{path: path, file: file}, nil
+}
+
+// append дописывает в журнал одну запись и сбрасывает ее на диск.
+func (w *walLog) append(metrics []models.Metrics) error {
+	data, err := json.Marshal(metrics)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if _, err := w.file.Write(data); err != nil {
+		return fmt.Errorf("can not write wal record: %w", err)
+	}
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("can not sync wal file: %w", err)
+	}
+	w.entries++
+	return nil
+}
+
+// reset очищает журнал после сохранения снимка, который включает все его записи.
+func (w *walLog) reset() error {
+	if err := w.file.Truncate(0); err != nil {
+		return fmt.Errorf("can not truncate wal file: %w", err)
+	}
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("can not sync wal file: %w", err)
+	}
+	w.entries = 0
+	return nil
+}
+
+func (w *walLog) close() error {
+	return w.file.Close()
+}
+
+// replayWAL читает журнал path и передает каждую запись в apply. Чтение останавливается
+// на первой оборванной или поврежденной записи: такая запись могла появиться только
+// при сбое во время дозаписи, и все следующие за ней данные отбрасываются.
+// Возвращает количество примененных записей. Отсутствие журнала не считается ошибкой.
+func replayWAL(path string, apply func([]models.Metrics) error) (int, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("can not open wal file: %w", err)
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			logger.Log.Debug("failed to close file", zap.Error(err))
+		}
+	}(file)
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	applied := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(bytes.TrimSpace(line)) > 0 {
+				logger.Log.Warn("Discarding torn wal record",
+					zap.String("file", path), zap.Int64("offset", offset))
+			}
+			return applied, nil
+		} else if err != nil {
+			return applied, fmt.Errorf("can not read wal file: %w", err)
+		}
+		var items []models.Metrics
+		if err := json.Unmarshal(line, &items); err != nil {
+			logger.Log.Warn("Discarding corrupted wal tail",
+				zap.String("file", path), zap.Int64("offset", offset), zap.Error(err))
+			return applied, nil
+		}
+		for _, item := range items {
+			if isTombstone(item) {
+				continue
+			}
+			if err := CheckMetric(item); err != nil {
+				logger.Log.Warn("Discarding corrupted wal tail",
+					zap.String("file", path), zap.Int64("offset", offset), zap.Error(err))
+				return applied, nil
+			}
+		}
+		if err := apply(items); err != nil {
+			return applied, err
+		}
+		offset += int64(len(line))
+		applied++
+	}
+}
+
+// tombstone возвращает запись журнала об удалении серии key.
+func tombstone(key models.MetricKey) models.Metrics {
+	return models.Metrics{ID: key.ID, MType: key.MType}
+}
+
+// isTombstone сообщает, является ли запись журнала записью об удалении серии.
+func isTombstone(m models.Metrics) bool {
+	return m.Value == nil && m.Delta == nil && (m.MType == "gauge" || m.MType == "counter")
+}
+
+// writeFileAtomic записывает data во временный файл в каталоге path, сбрасывает его на диск
+// и переименовывает в path. При сбое на диске остается либо прежний, либо новый файл целиком.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, base+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("can not create temporary file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer func() {
+		if err != nil {
+			if removeErr := os.Remove(tmpPath); removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) {
+				logger.Log.Debug("failed to remove temporary file", zap.Error(removeErr))
+			}
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("can not write temporary file: %w", err)
+	}
+	if err = tmp.Chmod(perm); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("can not change temporary file mode: %w", err)
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("can not sync temporary file: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("can not close temporary file: %w", err)
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("can not replace file: %w", err)
+	}
+	return syncDir(dir)
+}
+
+// syncDir сбрасывает на диск каталог, чтобы переименование файла пережило сбой питания.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("can not open directory: %w", err)
+	}
+	defer func(d *os.File) {
+		err := d.Close()
+		if err != nil {
+			logger.Log.Debug("failed to close directory", zap.Error(err))
+		}
+	}(d)
+	if err := d.Sync(); err != nil && !errors.Is(err, os.ErrInvalid) {
+		return fmt.Errorf("can not sync directory: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	model "github.com/Fuonder/metriccoll.git/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONStorageRecoversTornWAL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	st, err := NewJSONStorage(NewFileStoreInfo(path, 0, false))
+	require.NoError(t, err)
+	require.NoError(t, st.AppendMetric(model.Metrics{ID: "c", MType: "counter", Delta: model.Int64Ptr(2)}))
+	require.NoError(t, st.AppendMetrics([]model.Metrics{
+		{ID: "c", MType: "counter", Delta: model.Int64Ptr(3)},
+		{ID: "g", MType: "gauge", Value: model.Float64Ptr(1.5)},
+	}))
+	require.NoError(t, st.Close())
+
+	// Сбой во время дозаписи оставляет в конце журнала оборванную строку.
+	f, err := os.OpenFile(path+walSuffix, os.O_APPEND|os.O_WRONLY, OsAllRw)
+	require.NoError(t, err)
+	_, err = f.WriteString(`[{"id":"c","type":"counter","delta":10`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	restored, err := NewJSONStorage(NewFileStoreInfo(path, 0, true))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, restored.Close())
+	}()
+	c, err := restored.GetMetricByName("c", "counter")
+	require.NoError(t, err)
+	require.Equal(t, int64(5), *c.Delta)
+	g, err := restored.GetMetricByName("g", "gauge")
+	require.NoError(t, err)
+	require.Equal(t, 1.5, *g.Value)
+
+	info, err := os.Stat(path + walSuffix)
+	require.NoError(t, err)
+	require.Zero(t, info.Size(), "recovered wal must be compacted into the snapshot")
+
+	require.NoError(t, restored.AppendMetric(model.Metrics{ID: "c", MType: "counter", Delta: model.Int64Ptr(1)}))
+	again, err := NewJSONStorage(NewFileStoreInfo(path, 300, true))
+	require.NoError(t, err)
+	c, err = again.GetMetricByName("c", "counter")
+	require.NoError(t, err)
+	require.Equal(t, int64(6), *c.Delta)
+}
+
+func TestJSONStorageWALReplayIsIdempotent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	// Снимок уже содержит запись журнала: сбой произошел между сохранением снимка и очисткой журнала.
+	require.NoError(t, os.WriteFile(path, []byte(`[{"id":"c","type":"counter","delta":5}]`), OsAllRw))
+	require.NoError(t, os.WriteFile(path+walSuffix, []byte(`[{"id":"c","type":"counter","delta":5}]`+"\n"), OsAllRw))
+
+	st, err := NewJSONStorage(NewFileStoreInfo(path, 300, true))
+	require.NoError(t, err)
+	c, err := st.GetMetricByName("c", "counter")
+	require.NoError(t, err)
+	require.Equal(t, int64(5), *c.Delta)
+	_, err = os.Stat(path + walSuffix)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestJSONStorageCompactsWAL(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "metrics.json")
+	st, err := NewJSONStorage(NewFileStoreInfo(path, 0, false))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, st.Close())
+	}()
+	for i := 0; i < walCompactThreshold+1; i++ {
+		require.NoError(t, st.AppendMetric(model.Metrics{ID: "c", MType: "counter", Delta: model.Int64Ptr(1)}))
+	}
+	require.Equal(t, 1, st.wal.entries)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"delta": 1000`)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2, "temporary snapshot files must not be left behind")
+}
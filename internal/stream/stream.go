@@ -0,0 +1,158 @@
+// Package stream рассылает подписчикам принятые сервером обновления метрик.
+//
+// Издатель (обработчики записи) никогда не ждет подписчиков: у каждого подписчика
+// ограниченная очередь, и если он не успевает ее разбирать, новые события для него
+// отбрасываются, а их количество сообщается подписчику вместе со следующим событием.
+package stream
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Fuonder/metriccoll.git/internal/models"
+	"github.com/Fuonder/metriccoll.git/internal/storage"
+)
+
+// DefaultBufferSize — размер очереди событий одного подписчика.
+const DefaultBufferSize = 256
+
+// ErrClosed возвращается при подписке на остановленный Broker.
+var ErrClosed = errors.New("stream broker is closed")
+
+// Event — обновление метрики.
+type Event struct {
+	Seq    uint64         // Порядковый номер события в пределах Broker.
+	At     time.Time      // Время приема обновления.
+	Metric models.Metrics // Значение метрики после обновления.
+	// Dropped — количество событий подписчика, отброшенных перед этим событием
+	// из-за переполнения его очереди.
+	Dropped uint64
+}
+
+// Subscription — подписка на обновления метрик, имена которых соответствуют шаблону.
+type Subscription struct {
+	events  chan Event
+	pattern string
+	mType   string
+	dropped atomic.Uint64
+	once    sync.Once
+}
+
+// Events возвращает канал событий подписки. Канал закрывается при отписке
+// и при остановке Broker.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+func (s *Subscription) matches(key models.MetricKey) bool {
+	return storage.MatchKey(key, s.pattern, s.mType)
+}
+
+func (s *Subscription) close() {
+	s.once.Do(func() { close(s.events) })
+}
+
+// Broker рассылает события подписчикам.
+type Broker struct {
+	mu         sync.RWMutex
+	subs       map[*Subscription]struct{}
+	bufferSize int
+	closed     bool
+	seq        atomic.Uint64
+	now        func() time.Time
+}
+
+// NewBroker создает Broker с очередью bufferSize событий на подписчика
+// (DefaultBufferSize, если bufferSize <= 0).
+func NewBroker(bufferSize int) *Broker {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+	return &Broker{
+		subs:       make(map[*Subscription]struct{}),
+		bufferSize: bufferSize,
+		now:        func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// Subscribe подписывает на обновления метрик типа mType (пустой — любого типа),
+// имена которых соответствуют шаблону pattern (синтаксис path.Match, пустой — все метрики).
+// Ошибки проверки шаблона оборачивают storage.ErrInvalidPattern.
+func (b *Broker) Subscribe(pattern string, mType string) (*Subscription, error) {
+	if pattern == "" {
+		pattern = "*"
+	}
+	if err := storage.ValidatePattern(pattern, mType); err != nil {
+		return nil, err
+	}
+	s := &Subscription{
+		events:  make(chan Event, b.bufferSize),
+		pattern: pattern,
+		mType:   mType,
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
+	b.subs[s] = struct{}{}
+	return s, nil
+}
+
+// Unsubscribe отменяет подписку и закрывает ее канал событий. Повторный вызов безопасен.
+func (b *Broker) Unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	delete(b.subs, s)
+	b.mu.Unlock()
+	s.close()
+}
+
+// HasSubscribers сообщает, есть ли активные подписки. Позволяет издателю не готовить
+// события, которые некому отправить.
+func (b *Broker) HasSubscribers() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subs) > 0
+}
+
+// Publish рассылает обновления metrics подходящим подписчикам. Не блокируется:
+// если очередь подписчика заполнена, событие для него отбрасывается и учитывается в Event.Dropped.
+func (b *Broker) Publish(metrics ...models.Metrics) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if len(b.subs) == 0 {
+		return
+	}
+	at := b.now()
+	for _, m := range metrics {
+		key := m.Key()
+		ev := Event{Seq: b.seq.Add(1), At: at, Metric: m}
+		for s := range b.subs {
+			if !s.matches(key) {
+				continue
+			}
+			// Счетчик забирается целиком, чтобы параллельный издатель не сообщил
+			// те же потери повторно; если отправить не удалось, он возвращается.
+			ev.Dropped = s.dropped.Swap(0)
+			select {
+			case s.events <- ev:
+			default:
+				s.dropped.Add(ev.Dropped + 1)
+			}
+		}
+	}
+}
+
+// Close отменяет все подписки; последующие Subscribe возвращают ErrClosed.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for s := range b.subs {
+		delete(b.subs, s)
+		s.close()
+	}
+}
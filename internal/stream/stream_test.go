@@ -0,0 +1,117 @@
+package stream
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/Fuonder/metriccoll.git/internal/models"
+	"github.com/Fuonder/metriccoll.git/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func gauge(id string, v float64) models.Metrics {
+	return models.Metrics{ID: id, MType: "gauge", Value: models.Float64Ptr(v)}
+}
+
+func TestBrokerFilters(t *testing.T) {
+	b := NewBroker(10)
+	require.False(t, b.HasSubscribers())
+	_, err := b.Subscribe("cpu[", "")
+	require.ErrorIs(t, err, storage.ErrInvalidPattern)
+	_, err = b.Subscribe("*", "histogram")
+	require.ErrorIs(t, err, storage.ErrInvalidPattern)
+
+	cpu, err := b.Subscribe("CPUutilization*", "")
+	require.NoError(t, err)
+	counters, err := b.Subscribe("", "counter")
+	require.NoError(t, err)
+	require.True(t, b.HasSubscribers())
+
+	b.Publish(gauge("CPUutilization1", 1), gauge("FreeMemory", 2),
+		models.Metrics{ID: "PollCount", MType: "counter", Delta: models.Int64Ptr(3)})
+
+	ev := <-cpu.Events()
+	require.Equal(t, "CPUutilization1", ev.Metric.ID)
+	require.Equal(t, uint64(1), ev.Seq)
+	require.Empty(t, cpu.Events())
+	ev = <-counters.Events()
+	require.Equal(t, "PollCount", ev.Metric.ID)
+	require.Equal(t, uint64(3), ev.Seq)
+
+	b.Unsubscribe(cpu)
+	b.Unsubscribe(cpu)
+	_, ok := <-cpu.Events()
+	require.False(t, ok, "events channel is closed on unsubscribe")
+}
+
+func TestBrokerDropsForSlowSubscriber(t *testing.T) {
+	b := NewBroker(2)
+	slow, err := b.Subscribe("*", "")
+	require.NoError(t, err)
+
+	for i := range 5 {
+		b.Publish(gauge("Alloc", float64(i)))
+	}
+	require.Equal(t, 0.0, *(<-slow.Events()).Metric.Value)
+	require.Equal(t, 1.0, *(<-slow.Events()).Metric.Value)
+
+	b.Publish(gauge("Alloc", 5))
+	ev := <-slow.Events()
+	require.Equal(t, 5.0, *ev.Metric.Value)
+	require.Equal(t, uint64(3), ev.Dropped, "skipped updates are reported with the next event")
+
+	b.Publish(gauge("Alloc", 6))
+	require.Zero(t, (<-slow.Events()).Dropped)
+}
+
+func TestBrokerClose(t *testing.T) {
+	b := NewBroker(0)
+	s, err := b.Subscribe("*", "")
+	require.NoError(t, err)
+	b.Close()
+	_, ok := <-s.Events()
+	require.False(t, ok)
+	b.Unsubscribe(s)
+	b.Publish(gauge("Alloc", 1))
+	_, err = b.Subscribe("*", "")
+	require.ErrorIs(t, err, ErrClosed)
+}
+
+func TestBrokerDroppedParallelPublish(t *testing.T) {
+	const (
+		publishers = 8
+		events     = 500
+	)
+	b := NewBroker(1)
+	sub, err := b.Subscribe("", "")
+	require.NoError(t, err)
+
+	// Читатель не успевает за издателями: очередь из одного события почти всегда заполнена.
+	var delivered, reported uint64
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for ev := range sub.Events() {
+			delivered++
+			reported += ev.Dropped
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for p := 0; p < publishers; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < events; i++ {
+				b.Publish(gauge("g", float64(i)))
+			}
+		}()
+	}
+	wg.Wait()
+	pending := sub.dropped.Load()
+	b.Unsubscribe(sub)
+	<-done
+
+	require.Equal(t, uint64(publishers*events), delivered+reported+pending,
+		"every event must be either delivered or counted as dropped exactly once")
+}
@@ -0,0 +1,105 @@
+// Package subnet реализует проверку принадлежности IP-адреса агента доверенным подсетям
+// и определение исходящего IP-адреса агента.
+package subnet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// HeaderRealIP — заголовок, в котором агент передает свой исходящий IP-адрес.
+const HeaderRealIP = "X-Real-IP"
+
+var (
+	ErrInvalidCIDR = errors.New("invalid CIDR")
+	ErrInvalidIP   = errors.New("invalid client ip")
+)
+
+// Trusted — список доверенных подсетей. Пустой список означает, что фильтрация выключена.
+type Trusted []netip.Prefix
+
+// ParseTrusted разбирает список подсетей в нотации CIDR, разделенных запятыми.
+func ParseTrusted(value string) (Trusted, error) {
+	var t Trusted
+	for _, cidr := range strings.Split(value, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidCIDR, cidr)
+		}
+		t = append(t, prefix.Masked())
+	}
+	return t, nil
+}
+
+// Enabled сообщает, задан ли хотя бы один доверенный диапазон.
+func (t Trusted) Enabled() bool {
+	return len(t) > 0
+}
+
+// Contains проверяет, входит ли адрес ip в одну из доверенных подсетей.
+func (t Trusted) Contains(ip string) (bool, error) {
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if err != nil {
+		return false, fmt.Errorf("%w: %q", ErrInvalidIP, ip)
+	}
+	addr = addr.Unmap()
+	for _, prefix := range t {
+		if prefix.Contains(addr) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Allow проверяет запрос агента, пришедший с адреса соединения remoteIP с заголовком
+// X-Real-IP, равным realIP. Заголовок задает сам клиент, поэтому без проверки адреса
+// соединения ему доверяют, только если соединение установлено доверенным прокси из proxies,
+// который подставляет в заголовок адрес агента. Иначе в доверенные подсети должны входить
+// и адрес соединения, и адрес из заголовка, если он передан.
+// Возвращает адрес, по которому принято решение.
+func (t Trusted) Allow(remoteIP, realIP string, proxies Trusted) (string, bool, error) {
+	if realIP != "" && proxies.Enabled() {
+		if fromProxy, err := proxies.Contains(remoteIP); err == nil && fromProxy {
+			ok, err := t.Contains(realIP)
+			return realIP, ok, err
+		}
+	}
+	ok, err := t.Contains(remoteIP)
+	if err != nil || !ok || realIP == "" {
+		return remoteIP, ok, err
+	}
+	ok, err = t.Contains(realIP)
+	return realIP, ok, err
+}
+
+func (t Trusted) String() string {
+	cidrs := make([]string, 0, len(t))
+	for _, prefix := range t {
+		cidrs = append(cidrs, prefix.String())
+	}
+	return strings.Join(cidrs, ",")
+}
+
+// OutboundIP возвращает IP-адрес, с которого агент отправляет запросы на сервер remoteAddr
+// (в формате <host>:<port>). Для UDP-сокета Dial не отправляет пакетов, а только выбирает маршрут.
+func OutboundIP(remoteAddr string) (string, error) {
+	conn, err := net.Dial("udp", remoteAddr)
+	if err != nil {
+		return "", fmt.Errorf("can not determine outbound ip: %w", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	addr, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return "", fmt.Errorf("can not determine outbound ip: unexpected address %s", conn.LocalAddr())
+	}
+	return addr.IP.String(), nil
+}
@@ -0,0 +1,86 @@
+package subnet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTrusted(t *testing.T) {
+	trusted, err := ParseTrusted("192.168.1.0/24, 10.0.0.1/8,,fd00::/8")
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.1.0/24,10.0.0.0/8,fd00::/8", trusted.String())
+	assert.True(t, trusted.Enabled())
+
+	empty, err := ParseTrusted("")
+	require.NoError(t, err)
+	assert.False(t, empty.Enabled())
+
+	_, err = ParseTrusted("192.168.1.0")
+	assert.ErrorIs(t, err, ErrInvalidCIDR)
+}
+
+func TestTrustedContains(t *testing.T) {
+	trusted, err := ParseTrusted("192.168.1.0/24,fd00::/8")
+	require.NoError(t, err)
+
+	tests := []struct {
+		ip      string
+		want    bool
+		wantErr error
+	}{
+		{"192.168.1.15", true, nil},
+		{"::ffff:192.168.1.15", true, nil},
+		{"192.168.2.15", false, nil},
+		{"fd00::1", true, nil},
+		{"not-an-ip", false, ErrInvalidIP},
+		{"", false, ErrInvalidIP},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			got, err := trusted.Contains(tt.ip)
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestTrustedAllow(t *testing.T) {
+	trusted, err := ParseTrusted("192.168.1.0/24")
+	require.NoError(t, err)
+	proxies, err := ParseTrusted("10.0.0.5/32")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		remote  string
+		realIP  string
+		proxies Trusted
+		wantIP  string
+		want    bool
+	}{
+		{"TrustedRemote", "192.168.1.10", "", nil, "192.168.1.10", true},
+		{"UntrustedRemote", "10.0.0.1", "", nil, "10.0.0.1", false},
+		{"HeaderWithoutProxy", "10.0.0.1", "192.168.1.10", nil, "10.0.0.1", false},
+		{"HeaderAndRemoteTrusted", "192.168.1.20", "192.168.1.10", nil, "192.168.1.10", true},
+		{"UntrustedHeaderTrustedRemote", "192.168.1.20", "10.0.0.1", nil, "10.0.0.1", false},
+		{"HeaderFromProxy", "10.0.0.5", "192.168.1.10", proxies, "192.168.1.10", true},
+		{"UntrustedHeaderFromProxy", "10.0.0.5", "10.0.0.1", proxies, "10.0.0.1", false},
+		{"HeaderFromOtherHost", "10.0.0.1", "192.168.1.10", proxies, "10.0.0.1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ip, ok, err := trusted.Allow(tt.remote, tt.realIP, tt.proxies)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantIP, ip)
+			assert.Equal(t, tt.want, ok)
+		})
+	}
+}
+
+func TestOutboundIP(t *testing.T) {
+	ip, err := OutboundIP("127.0.0.1:8080")
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", ip)
+}
@@ -55,6 +55,31 @@ func CheckPathWritable(path string) error {
 	return nil
 }
 
+// CheckDirWritable проверяет, что в каталоге файла path можно создать файл. Хранилище
+// записывает файл во временный файл рядом с ним и переименовывает его в path, поэтому
+// проверяется именно каталог, а сам path не создается и не открывается.
+func CheckDirWritable(path string) error {
+	if path == "" {
+		return fmt.Errorf("path can not be empty")
+	}
+
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	file, err := os.CreateTemp(dir, base+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("can not create file in directory \"%s\": %w", dir, err)
+	}
+	if err := file.Close(); err != nil {
+		fmt.Printf("failed to close file \"%s\": %v\n", file.Name(), err)
+	}
+	if err := os.Remove(file.Name()); err != nil {
+		return fmt.Errorf("can not remove file in directory \"%s\": %w", dir, err)
+	}
+	return nil
+}
+
 func findFile(root string, fileName string) (string, error) {
 	var result string
 